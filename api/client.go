@@ -0,0 +1,121 @@
+// Package api builds the URL of and the HTTP client for the Releem API.
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// BaseURL returns the base URL of the Releem API, ending with a slash: api_url
+// when it is set, else the URL of the env and releem_region.
+func BaseURL(configuration *config.Config) string {
+	if configuration == nil {
+		return envBaseURL("prod", "")
+	}
+	if configuration.ApiURL != "" {
+		return strings.TrimSuffix(configuration.ApiURL, "/") + "/"
+	}
+	return envBaseURL(configuration.Env, configuration.ReleemRegion)
+}
+
+func envBaseURL(env string, region string) string {
+	var subdomain, domain string
+	switch env {
+	case "dev2":
+		subdomain = "dev2."
+	case "dev":
+		subdomain = "dev."
+	case "stage":
+		subdomain = "stage."
+	default:
+		subdomain = ""
+	}
+	if region == "EU" || region == "eu" {
+		domain = "eu.releem.com"
+	} else {
+		domain = "releem.com"
+	}
+	return "https://api.queries." + subdomain + domain + "/v2/"
+}
+
+// NewClient returns the HTTP client of the Releem API. Requests go through
+// api_proxy, else the HTTP_PROXY and HTTPS_PROXY environment variables, except
+// for the hosts of api_no_proxy (or NO_PROXY). Servers are verified against the
+// system roots and api_ca_file, and api_client_cert_file is presented when the
+// server asks for a client certificate. Redirects to another host or scheme are
+// refused, so the API key is never sent elsewhere.
+func NewClient(configuration *config.Config) (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if configuration.ApiCaFile != "" {
+		pem, err := os.ReadFile(configuration.ApiCaFile)
+		if err != nil {
+			return nil, fmt.Errorf("api_ca_file: %w", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("api_ca_file: no PEM certificate found in %s", configuration.ApiCaFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+	if configuration.ApiClientCertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(configuration.ApiClientCertFile, configuration.ApiClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("api_client_cert_file: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	proxyConfig := httpproxy.FromEnvironment()
+	if configuration.ApiProxy != "" {
+		proxyConfig.HTTPProxy = configuration.ApiProxy
+		proxyConfig.HTTPSProxy = configuration.ApiProxy
+	}
+	if configuration.ApiNoProxy != "" {
+		proxyConfig.NoProxy = configuration.ApiNoProxy
+	}
+	proxy := proxyConfig.ProxyFunc()
+
+	connectTimeout := configuration.ApiConnectTimeout * time.Second
+	transport := &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			return proxy(req.URL)
+		},
+		DialContext: (&net.Dialer{
+			Timeout:   connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   connectTimeout,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   configuration.ApiTimeout * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if req.URL.Host != via[0].URL.Host || req.URL.Scheme != via[0].URL.Scheme {
+				return fmt.Errorf("refusing redirect to %s://%s", req.URL.Scheme, req.URL.Host)
+			}
+			return nil
+		},
+	}, nil
+}
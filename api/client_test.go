@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Releem/mysqlconfigurer/config"
+)
+
+func TestBaseURL(t *testing.T) {
+	for _, test := range []struct {
+		configuration *config.Config
+		want          string
+	}{
+		{nil, "https://api.queries.releem.com/v2/"},
+		{&config.Config{Env: "prod", ReleemRegion: "EU"}, "https://api.queries.eu.releem.com/v2/"},
+		{&config.Config{Env: "dev"}, "https://api.queries.dev.releem.com/v2/"},
+		{&config.Config{Env: "dev", ApiURL: "http://127.0.0.1:8080/v2"}, "http://127.0.0.1:8080/v2/"},
+	} {
+		if got := BaseURL(test.configuration); got != test.want {
+			t.Errorf("BaseURL(%+v) = %s, want %s", test.configuration, got, test.want)
+		}
+	}
+}
+
+func TestNewClientTrustsApiCaFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	configuration := &config.Config{ApiTimeout: 5, ApiConnectTimeout: 5}
+	client, err := NewClient(configuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Fatal("a server signed by an unknown CA should be rejected")
+	}
+
+	configuration.ApiCaFile = filepath.Join(t.TempDir(), "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(configuration.ApiCaFile, certificate, 0600); err != nil {
+		t.Fatal(err)
+	}
+	client, err = NewClient(configuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if err := os.WriteFile(configuration.ApiCaFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewClient(configuration); err == nil {
+		t.Fatal("an api_ca_file without certificates should be rejected")
+	}
+}
+
+func TestNewClientRefusesRedirectsToAnotherHost(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the redirect was followed")
+	}))
+	defer other.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other.URL, http.StatusFound)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&config.Config{ApiTimeout: 5, ApiConnectTimeout: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Fatal("a redirect to another host should fail")
+	}
+}
+
+func TestNewClientProxy(t *testing.T) {
+	client, err := NewClient(&config.Config{ApiProxy: "http://proxy.internal:3128", ApiNoProxy: "mock.internal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := client.Transport.(*http.Transport).Proxy
+	for url, want := range map[string]string{
+		"https://api.queries.releem.com/v2/": "http://proxy.internal:3128",
+		"http://mock.internal/v2/":           "",
+	} {
+		req, _ := http.NewRequest(http.MethodPost, url, nil)
+		proxyURL, err := proxy(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if proxyURL != nil {
+			got = proxyURL.String()
+		}
+		if got != want {
+			t.Errorf("proxy of %s = %q, want %q", url, got, want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Releem/mysqlconfigurer/models"
+	"github.com/Releem/mysqlconfigurer/redact"
+	"github.com/Releem/mysqlconfigurer/utils"
+	logging "github.com/google/logger"
+)
+
+// runCollect runs the selected gatherer groups once and writes the metrics
+// payload the agent would send, without sending it.
+func runCollect(args []string) (status string, err error) {
+	flags := flag.NewFlagSet("collect", flag.ContinueOnError)
+	groups := flags.String("groups", "default,metrics", "Comma-separated gatherer groups: default, metrics, configuration, query_optimization, sample_queries")
+	instanceName := flags.String("instance", "", "Name of the instance to collect, required when several instances are configured")
+	output := flags.String("output", "", "File to write the payload to instead of the standard output")
+	compact := flags.Bool("compact", false, "Write compact instead of indented JSON")
+	sizes := flags.Bool("sizes", false, "Print the size of every payload section to the standard error")
+	if err := flags.Parse(args); err != nil {
+		return "", err
+	}
+	// The gatherers log through the agent logger, keep the payload readable.
+	logger = *logging.Init("releem-agent", false, false, io.Discard)
+	defer func() {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+	}()
+
+	instanceConfiguration, err := loadInstance(*instanceName)
+	if err != nil {
+		return "", err
+	}
+	pipeline, closers, err := newPipeline(instanceConfiguration, nil)
+	defer closeAll(closers)
+	if err != nil {
+		return "", err
+	}
+	var gatherers []models.MetricsGatherer
+	for _, group := range strings.Split(*groups, ",") {
+		group = strings.TrimSpace(group)
+		list, ok := pipeline.Gatherers[group]
+		if !ok {
+			return "", fmt.Errorf("unknown gatherer group %q, available groups: %s", group, strings.Join(groupNames(pipeline.Gatherers), ", "))
+		}
+		gatherers = append(gatherers, list...)
+	}
+
+	metrics := utils.CollectMetricsContext(context.Background(), gatherers, logger, instanceConfiguration)
+	if metrics == nil {
+		return "", errors.New("a required gatherer failed, the payload would not be sent")
+	}
+	for _, result := range metrics.ReleemAgent.Gatherers {
+		if result.Error != "" {
+			fmt.Fprintf(os.Stderr, "gatherer %s failed: %s\n", result.Name, result.Error)
+		}
+	}
+	redactor, err := redact.New(instanceConfiguration)
+	if err != nil {
+		return "", err
+	}
+	if redactor.Enabled() {
+		redacted := redactor.Metrics(*metrics)
+		metrics = &redacted
+	}
+
+	var payload []byte
+	if *compact {
+		payload, err = json.Marshal(metrics)
+	} else {
+		payload, err = json.MarshalIndent(metrics, "", "  ")
+	}
+	if err != nil {
+		return "", err
+	}
+	payload = append(payload, '\n')
+	if *output == "" {
+		_, err = os.Stdout.Write(payload)
+	} else {
+		err = os.WriteFile(*output, payload, 0600)
+	}
+	if err != nil {
+		return "", err
+	}
+	if *sizes {
+		sections, err := sectionSizes(metrics)
+		if err != nil {
+			return "", err
+		}
+		writeSectionSizes(os.Stderr, sections)
+	}
+	return "", nil
+}
+
+func groupNames(gatherers map[string][]models.MetricsGatherer) []string {
+	names := make([]string, 0, len(gatherers))
+	for name := range gatherers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sectionSize is the size of a section of the compact JSON payload.
+type sectionSize struct {
+	Section string
+	Bytes   int
+}
+
+// sectionSizes returns the size of every top-level section of the payload and
+// of the sections they contain, largest first.
+func sectionSizes(metrics *models.Metrics) ([]sectionSize, error) {
+	payload, err := json.Marshal(metrics)
+	if err != nil {
+		return nil, err
+	}
+	sections := []sectionSize{{"total", len(payload)}}
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &top); err != nil {
+		return nil, err
+	}
+	for name, raw := range top {
+		sections = append(sections, sectionSize{name, len(raw)})
+		var nested map[string]json.RawMessage
+		if json.Unmarshal(raw, &nested) != nil {
+			continue
+		}
+		for child, childRaw := range nested {
+			sections = append(sections, sectionSize{name + "." + child, len(childRaw)})
+		}
+	}
+	sort.Slice(sections, func(i, j int) bool {
+		if sections[i].Bytes != sections[j].Bytes {
+			return sections[i].Bytes > sections[j].Bytes
+		}
+		return sections[i].Section < sections[j].Section
+	})
+	return sections, nil
+}
+
+func writeSectionSizes(w io.Writer, sections []sectionSize) {
+	fmt.Fprintf(w, "%-40s %12s\n", "SECTION", "BYTES")
+	for _, section := range sections {
+		fmt.Fprintf(w, "%-40s %12d\n", section.Section, section.Bytes)
+	}
+}
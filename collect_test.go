@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Releem/mysqlconfigurer/models"
+	logging "github.com/google/logger"
+)
+
+func TestLoadInstance(t *testing.T) {
+	logger = *logging.Init("releem-agent-test", false, false, io.Discard)
+	previous := ConfigFile
+	t.Cleanup(func() { ConfigFile = previous })
+
+	configFile := filepath.Join(t.TempDir(), "releem.conf")
+	ConfigFile = &configFile
+	if err := os.WriteFile(*ConfigFile, []byte(`apikey="key"`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if instance, err := loadInstance(""); err != nil || instance.InstanceName != "" {
+		t.Fatalf("the only instance should be selected by default, got %v, %v", instance, err)
+	}
+
+	several := `apikey="key"
+instance "primary" {}
+instance "reporting" {}
+`
+	if err := os.WriteFile(*ConfigFile, []byte(several), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadInstance(""); err == nil {
+		t.Fatal("an instance must be named when several are configured")
+	}
+	if instance, err := loadInstance("reporting"); err != nil || instance.InstanceName != "reporting" {
+		t.Fatalf("loadInstance(reporting) = %v, %v", instance, err)
+	}
+	if _, err := loadInstance("missing"); err == nil {
+		t.Fatal("an unknown instance should be rejected")
+	}
+}
+
+func TestSectionSizes(t *testing.T) {
+	metrics := &models.Metrics{}
+	metrics.DB.Queries = []models.MetricGroupValue{{"digest": "SELECT ?"}}
+	metrics.DB.Conf.Variables = models.MetricGroupValue{"max_connections": "151"}
+
+	sections, err := sectionSizes(metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := json.Marshal(metrics)
+	sizes := make(map[string]int)
+	for _, section := range sections {
+		sizes[section.Section] = section.Bytes
+	}
+	if sections[0].Section != "total" || sizes["total"] != len(payload) {
+		t.Fatalf("the total should come first and match the payload size: %+v", sections)
+	}
+	queries, _ := json.Marshal(metrics.DB.Queries)
+	if sizes["DB.Queries"] != len(queries) {
+		t.Fatalf("DB.Queries = %d bytes, want %d", sizes["DB.Queries"], len(queries))
+	}
+	if sizes["DB"] <= sizes["DB.Queries"] || sizes["DB.Conf"] == 0 {
+		t.Fatalf("unexpected section sizes %v", sizes)
+	}
+	for i := 1; i < len(sections); i++ {
+		if sections[i].Bytes > sections[i-1].Bytes {
+			t.Fatalf("sections are not sorted by size: %+v", sections)
+		}
+	}
+}
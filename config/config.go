@@ -1,12 +1,22 @@
 package config
 
 import (
+	"context"
+	"fmt"
+	"maps"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"slices"
+	"strconv"
 	"time"
 
+	"github.com/Releem/mysqlconfigurer/maintenance"
 	logging "github.com/google/logger"
 	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
 )
 
 const (
@@ -14,46 +24,90 @@ const (
 )
 
 type Config struct {
-	Debug                       bool          `hcl:"debug"`
-	Env                         string        `hcl:"env"`
-	Hostname                    string        `hcl:"hostname"`
-	ApiKey                      string        `hcl:"apikey"`
-	MetricsPeriod               time.Duration `hcl:"interval_seconds"`
-	ReadConfigPeriod            time.Duration `hcl:"interval_read_config_seconds"`
-	GenerateConfigPeriod        time.Duration `hcl:"interval_generate_config_seconds"`
-	QueryOptimizationPeriod     time.Duration `hcl:"interval_query_optimization_seconds"`
-	CollectSampleQueriesPeriod  time.Duration `hcl:"interval_collect_sample_queries_seconds"`
-	MysqlPassword               string        `hcl:"mysql_password" json:"-"`
-	MysqlUser                   string        `hcl:"mysql_user"`
-	MysqlHost                   string        `hcl:"mysql_host"`
-	MysqlPort                   string        `hcl:"mysql_port"`
-	MysqlSslMode                bool          `hcl:"mysql_ssl_mode"`
-	MysqlConfDir                string        `hcl:"mysql_cnf_dir"`
-	MysqlRestartService         string        `hcl:"mysql_restart_service"`
-	PgPassword                  string        `hcl:"pg_password" json:"-"`
-	PgUser                      string        `hcl:"pg_user"`
-	PgHost                      string        `hcl:"pg_host"`
-	PgPort                      string        `hcl:"pg_port"`
-	PgSslMode                   bool          `hcl:"pg_ssl_mode"`
-	PgConfDir                   string        `hcl:"pg_cnf_dir"`
-	PgRestartService            string        `hcl:"pg_restart_service"`
-	ReleemConfDir               string        `hcl:"releem_cnf_dir"`
-	ReleemDir                   string        `hcl:"releem_dir"`
-	MemoryLimit                 int           `hcl:"memory_limit"`
-	InstanceType                string        `hcl:"instance_type"`
-	AwsRegion                   string        `hcl:"aws_region"`
-	AwsRDSDB                    string        `hcl:"aws_rds_db"`
-	AwsRDSParameterGroup        string        `hcl:"aws_rds_parameter_group"`
-	GcpProjectId                string        `hcl:"gcp_project_id"`
-	GcpRegion                   string        `hcl:"gcp_region"`
-	GcpCloudSqlInstance         string        `hcl:"gcp_cloudsql_instance"`
-	GcpCloudSqlPublicConnection bool          `hcl:"gcp_cloudsql_public_connection"`
-	AzureSubscriptionID         string        `hcl:"azure_subscription_id"`
-	AzureResourceGroup          string        `hcl:"azure_resource_group"`
-	AzureMySQLServer            string        `hcl:"azure_mysql_server"`
-	QueryOptimization           bool          `hcl:"query_optimization"`
-	DatabasesQueryOptimization  string        `hcl:"databases_query_optimization"`
-	ReleemRegion                string        `hcl:"releem_region"`
+	Debug                       bool                     `hcl:"debug"`
+	Env                         string                   `hcl:"env"`
+	Hostname                    string                   `hcl:"hostname"`
+	ApiKey                      string                   `hcl:"apikey"`
+	MetricsPeriod               time.Duration            `hcl:"interval_seconds"`
+	ReadConfigPeriod            time.Duration            `hcl:"interval_read_config_seconds"`
+	GenerateConfigPeriod        time.Duration            `hcl:"interval_generate_config_seconds"`
+	QueryOptimizationPeriod     time.Duration            `hcl:"interval_query_optimization_seconds"`
+	CollectSampleQueriesPeriod  time.Duration            `hcl:"interval_collect_sample_queries_seconds"`
+	MysqlPassword               string                   `hcl:"mysql_password" json:"-"`
+	MysqlUser                   string                   `hcl:"mysql_user"`
+	MysqlHost                   string                   `hcl:"mysql_host"`
+	MysqlPort                   string                   `hcl:"mysql_port"`
+	MysqlSslMode                bool                     `hcl:"mysql_ssl_mode"`
+	MysqlConfDir                string                   `hcl:"mysql_cnf_dir"`
+	MysqlRestartService         string                   `hcl:"mysql_restart_service"`
+	PgPassword                  string                   `hcl:"pg_password" json:"-"`
+	PgUser                      string                   `hcl:"pg_user"`
+	PgHost                      string                   `hcl:"pg_host"`
+	PgPort                      string                   `hcl:"pg_port"`
+	PgSslMode                   bool                     `hcl:"pg_ssl_mode"`
+	PgConfDir                   string                   `hcl:"pg_cnf_dir"`
+	PgRestartService            string                   `hcl:"pg_restart_service"`
+	ReleemConfDir               string                   `hcl:"releem_cnf_dir"`
+	ReleemDir                   string                   `hcl:"releem_dir"`
+	MemoryLimit                 int                      `hcl:"memory_limit"`
+	InstanceType                string                   `hcl:"instance_type"`
+	AwsRegion                   string                   `hcl:"aws_region"`
+	AwsRDSDB                    string                   `hcl:"aws_rds_db"`
+	AwsRDSParameterGroup        string                   `hcl:"aws_rds_parameter_group"`
+	GcpProjectId                string                   `hcl:"gcp_project_id"`
+	GcpRegion                   string                   `hcl:"gcp_region"`
+	GcpCloudSqlInstance         string                   `hcl:"gcp_cloudsql_instance"`
+	GcpCloudSqlPublicConnection bool                     `hcl:"gcp_cloudsql_public_connection"`
+	AzureSubscriptionID         string                   `hcl:"azure_subscription_id"`
+	AzureResourceGroup          string                   `hcl:"azure_resource_group"`
+	AzureMySQLServer            string                   `hcl:"azure_mysql_server"`
+	QueryOptimization           bool                     `hcl:"query_optimization"`
+	DatabasesQueryOptimization  string                   `hcl:"databases_query_optimization"`
+	ReleemRegion                string                   `hcl:"releem_region"`
+	SpoolMaxSize                int                      `hcl:"spool_max_size_mb"`
+	SpoolMaxAge                 time.Duration            `hcl:"spool_max_age_seconds"`
+	Repeaters                   string                   `hcl:"repeaters"`
+	ExportDir                   string                   `hcl:"export_dir"`
+	ExportMaxFileSize           int                      `hcl:"export_max_file_size_mb"`
+	ExportMaxFiles              int                      `hcl:"export_max_files"`
+	PrometheusListen            string                   `hcl:"prometheus_listen"`
+	StatusListen                string                   `hcl:"status_listen"`
+	GathererTimeout             time.Duration            `hcl:"gatherer_timeout_seconds"`
+	ShutdownTimeout             time.Duration            `hcl:"shutdown_timeout_seconds"`
+	ConfigWatchPeriod           time.Duration            `hcl:"interval_config_watch_seconds"`
+	ApiKeySource                string                   `hcl:"apikey_source"`
+	MysqlPasswordSource         string                   `hcl:"mysql_password_source"`
+	PgPasswordSource            string                   `hcl:"pg_password_source"`
+	SecretsRefreshPeriod        time.Duration            `hcl:"interval_secrets_refresh_seconds"`
+	QueryRedaction              string                   `hcl:"query_redaction"`
+	QueryRedactionRules         []string                 `hcl:"query_redaction_rules"`
+	QueryRedactionColumns       map[string]string        `hcl:"query_redaction_columns"`
+	QueryRedactionSchemas       map[string]string        `hcl:"query_redaction_schemas"`
+	PayloadCompression          string                   `hcl:"payload_compression"`
+	PayloadChunkSize            int                      `hcl:"payload_chunk_size_mb"`
+	ApiURL                      string                   `hcl:"api_url"`
+	ApiProxy                    string                   `hcl:"api_proxy"`
+	ApiNoProxy                  string                   `hcl:"api_no_proxy"`
+	ApiCaFile                   string                   `hcl:"api_ca_file"`
+	ApiClientCertFile           string                   `hcl:"api_client_cert_file"`
+	ApiClientKeyFile            string                   `hcl:"api_client_key_file"`
+	ApiTimeout                  time.Duration            `hcl:"api_timeout_seconds"`
+	ApiConnectTimeout           time.Duration            `hcl:"api_connect_timeout_seconds"`
+	SignatureVerification       string                   `hcl:"signature_verification"`
+	SignatureKeys               map[string]string        `hcl:"signature_keys"`
+	SignatureMaxValidity        time.Duration            `hcl:"signature_max_validity_seconds"`
+	ReadOnlyAgent               bool                     `hcl:"read_only_agent"`
+	TaskTimeouts                map[string]time.Duration `hcl:"task_timeout_seconds"`
+	TaskCancelPollPeriod        time.Duration            `hcl:"interval_task_cancel_poll_seconds"`
+	TaskProgressPeriod          time.Duration            `hcl:"interval_task_progress_seconds"`
+	ConfigHistorySize           int                      `hcl:"config_history_size"`
+	MaintenanceWindows          []string                 `hcl:"maintenance_windows"`
+	MaintenanceTimezone         string                   `hcl:"maintenance_timezone"`
+	ApplyVerifyPeriod           time.Duration            `hcl:"apply_verify_seconds"`
+	ApplyVerifyThresholds       map[string]float64       `hcl:"apply_verify_thresholds"`
+	ApplySetPersist             bool                     `hcl:"apply_set_persist"`
+	InstanceName                string                   `hcl:"-"`
+	Instances                   []*Config                `hcl:"-" json:"-"`
 }
 
 func LoadConfig(filename string, logger logging.Logger) (*Config, error) {
@@ -62,7 +116,14 @@ func LoadConfig(filename string, logger logging.Logger) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	return LoadConfigFromString(string(configBytes), logger)
+	config, err := LoadConfigFromString(string(configBytes), logger)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.ResolveSecrets(context.Background()); err != nil {
+		return nil, err
+	}
+	return config, nil
 }
 
 func LoadConfigFromString(data string, logger logging.Logger) (*Config, error) {
@@ -71,6 +132,54 @@ func LoadConfigFromString(data string, logger logging.Logger) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	instances, err := decodeInstances(*config, data)
+	if err != nil {
+		return nil, err
+	}
+	config.setDefaults()
+	config.Instances = instances
+	return config, nil
+}
+
+// decodeInstances decodes every `instance` block of the configuration. Each block
+// starts from a copy of the top-level settings, so only the fields that differ
+// between instances (credentials, hosts, intervals...) have to be repeated.
+func decodeInstances(parent Config, data string) ([]*Config, error) {
+	root, err := hcl.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := root.Node.(*ast.ObjectList)
+	if !ok {
+		return nil, nil
+	}
+	var instances []*Config
+	for _, item := range list.Filter("instance").Items {
+		instance := parent
+		// Decoding merges into the maps of the top-level settings otherwise.
+		instance.QueryRedactionRules = slices.Clone(parent.QueryRedactionRules)
+		instance.QueryRedactionColumns = maps.Clone(parent.QueryRedactionColumns)
+		instance.QueryRedactionSchemas = maps.Clone(parent.QueryRedactionSchemas)
+		instance.SignatureKeys = maps.Clone(parent.SignatureKeys)
+		instance.TaskTimeouts = maps.Clone(parent.TaskTimeouts)
+		instance.MaintenanceWindows = slices.Clone(parent.MaintenanceWindows)
+		instance.ApplyVerifyThresholds = maps.Clone(parent.ApplyVerifyThresholds)
+		if err := hcl.DecodeObject(&instance, item.Val); err != nil {
+			return nil, err
+		}
+		if len(item.Keys) > 0 {
+			instance.InstanceName = item.Keys[0].Token.Value().(string)
+		}
+		if instance.InstanceName == "" {
+			instance.InstanceName = "instance" + strconv.Itoa(len(instances)+1)
+		}
+		instance.setDefaults()
+		instances = append(instances, &instance)
+	}
+	return instances, nil
+}
+
+func (config *Config) setDefaults() {
 	if config.MetricsPeriod == 0 {
 		config.MetricsPeriod = 60
 	}
@@ -104,7 +213,211 @@ func LoadConfigFromString(data string, logger logging.Logger) (*Config, error) {
 	if config.InstanceType == "" {
 		config.InstanceType = "local"
 	}
-	return config, nil
+	if config.SpoolMaxSize == 0 {
+		config.SpoolMaxSize = 100
+	}
+	if config.SpoolMaxAge == 0 {
+		config.SpoolMaxAge = 86400
+	}
+	if config.GathererTimeout == 0 {
+		config.GathererTimeout = 60
+	}
+	if config.ShutdownTimeout == 0 {
+		config.ShutdownTimeout = 30
+	}
+	if config.ConfigWatchPeriod == 0 {
+		config.ConfigWatchPeriod = 10
+	}
+	if config.SecretsRefreshPeriod == 0 {
+		config.SecretsRefreshPeriod = 300
+	}
+	if config.QueryRedaction == "" {
+		config.QueryRedaction = "keep"
+	}
+	if config.ApiTimeout == 0 {
+		config.ApiTimeout = 600
+	}
+	if config.ApiConnectTimeout == 0 {
+		config.ApiConnectTimeout = 30
+	}
+	if config.SignatureVerification == "" {
+		config.SignatureVerification = "off"
+	}
+	if config.SignatureMaxValidity == 0 {
+		config.SignatureMaxValidity = 3600
+	}
+	if config.TaskCancelPollPeriod == 0 {
+		config.TaskCancelPollPeriod = 30
+	}
+	if config.TaskProgressPeriod == 0 {
+		config.TaskProgressPeriod = 5
+	}
+	if config.ConfigHistorySize == 0 {
+		config.ConfigHistorySize = 100
+	}
+	if config.PayloadCompression == "" {
+		config.PayloadCompression = "none"
+	}
+	if config.Repeaters == "" {
+		config.Repeaters = "releem"
+	}
+	if config.ExportMaxFileSize == 0 {
+		config.ExportMaxFileSize = 50
+	}
+	if config.ExportMaxFiles == 0 {
+		config.ExportMaxFiles = 10
+	}
+}
+
+// GetInstances returns the configuration of every monitored database instance.
+// A configuration without `instance` blocks describes a single instance.
+func (config *Config) GetInstances() []*Config {
+	if len(config.Instances) == 0 {
+		return []*Config{config}
+	}
+	return config.Instances
+}
+
+// InstanceDir returns the directory subdir of ReleemDir, with a directory per
+// instance when the configuration describes several instances.
+func InstanceDir(configuration *Config, subdir string) string {
+	dir := filepath.Join(configuration.ReleemDir, subdir)
+	if configuration.InstanceName != "" {
+		dir = filepath.Join(dir, configuration.InstanceName)
+	}
+	return dir
+}
+
+// Validate reports configuration values the agent cannot run with. It is
+// checked before a reloaded configuration replaces the running one.
+func (config *Config) Validate() error {
+	names := make(map[string]bool)
+	for _, instance := range config.GetInstances() {
+		if names[instance.InstanceName] {
+			return fmt.Errorf("instance %q is defined more than once", instance.InstanceName)
+		}
+		names[instance.InstanceName] = true
+		for name, period := range map[string]time.Duration{
+			"interval_seconds":                        instance.MetricsPeriod,
+			"interval_generate_config_seconds":        instance.GenerateConfigPeriod,
+			"interval_query_optimization_seconds":     instance.QueryOptimizationPeriod,
+			"interval_collect_sample_queries_seconds": instance.CollectSampleQueriesPeriod,
+			"gatherer_timeout_seconds":                instance.GathererTimeout,
+			"shutdown_timeout_seconds":                instance.ShutdownTimeout,
+			"api_timeout_seconds":                     instance.ApiTimeout,
+			"api_connect_timeout_seconds":             instance.ApiConnectTimeout,
+			"signature_max_validity_seconds":          instance.SignatureMaxValidity,
+			"interval_task_cancel_poll_seconds":       instance.TaskCancelPollPeriod,
+			"interval_task_progress_seconds":          instance.TaskProgressPeriod,
+			"apply_verify_seconds":                    instance.ApplyVerifyPeriod,
+		} {
+			if period < 0 {
+				return fmt.Errorf("%s must be positive, got %d", name, period)
+			}
+		}
+		switch instance.InstanceType {
+		case "local", "aws/rds", "gcp/cloudsql", "azure/mysql":
+		default:
+			return fmt.Errorf("unknown instance_type %q", instance.InstanceType)
+		}
+		switch instance.PayloadCompression {
+		case "none", "gzip", "zstd":
+		default:
+			return fmt.Errorf("payload_compression must be none, gzip or zstd, got %q", instance.PayloadCompression)
+		}
+		if instance.ApiURL != "" {
+			apiURL, err := url.Parse(instance.ApiURL)
+			if err != nil || (apiURL.Scheme != "https" && apiURL.Scheme != "http") || apiURL.Host == "" {
+				return fmt.Errorf("api_url must be an http or https URL, got %q", instance.ApiURL)
+			}
+		}
+		if instance.ApiProxy != "" {
+			if proxyURL, err := url.Parse(instance.ApiProxy); err != nil || proxyURL.Host == "" {
+				return fmt.Errorf("api_proxy must be a URL, got %q", instance.ApiProxy)
+			}
+		}
+		if (instance.ApiClientCertFile == "") != (instance.ApiClientKeyFile == "") {
+			return fmt.Errorf("api_client_cert_file and api_client_key_file must be set together")
+		}
+		switch instance.SignatureVerification {
+		case "off":
+		case "warn", "enforce":
+			if len(instance.SignatureKeys) == 0 {
+				return fmt.Errorf("signature_verification is %s but signature_keys is empty", instance.SignatureVerification)
+			}
+		default:
+			return fmt.Errorf("signature_verification must be off, warn or enforce, got %q", instance.SignatureVerification)
+		}
+		for typeID, timeout := range instance.TaskTimeouts {
+			if _, err := strconv.Atoi(typeID); err != nil {
+				return fmt.Errorf("task_timeout_seconds keys must be task type ids, got %q", typeID)
+			}
+			if timeout < 0 {
+				return fmt.Errorf("task_timeout_seconds.%s must be positive, got %d", typeID, timeout)
+			}
+		}
+		if instance.PayloadChunkSize < 0 {
+			return fmt.Errorf("payload_chunk_size_mb must be positive, got %d", instance.PayloadChunkSize)
+		}
+		if instance.ConfigHistorySize < 0 {
+			return fmt.Errorf("config_history_size must be positive, got %d", instance.ConfigHistorySize)
+		}
+		for signal, threshold := range instance.ApplyVerifyThresholds {
+			switch signal {
+			case "threads_running", "aborted_connects", "digest_errors", "digest_latency", "swap_used_mb":
+			default:
+				return fmt.Errorf("unknown apply_verify_thresholds signal %q", signal)
+			}
+			if threshold < 0 {
+				return fmt.Errorf("apply_verify_thresholds.%s must be positive, got %g", signal, threshold)
+			}
+		}
+		if _, err := maintenance.Parse(instance.MaintenanceWindows, instance.MaintenanceTimezone); err != nil {
+			return err
+		}
+		if err := instance.validateRedaction(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (config *Config) validateRedaction() error {
+	policies := map[string]string{"query_redaction": config.QueryRedaction}
+	for column, policy := range config.QueryRedactionColumns {
+		policies["query_redaction_columns."+column] = policy
+	}
+	for schema, policy := range config.QueryRedactionSchemas {
+		policies["query_redaction_schemas."+schema] = policy
+	}
+	for name, policy := range policies {
+		switch policy {
+		case "keep", "literals", "drop":
+		default:
+			return fmt.Errorf("%s must be keep, literals or drop, got %q", name, policy)
+		}
+	}
+	for _, rule := range config.QueryRedactionRules {
+		if _, err := regexp.Compile(rule); err != nil {
+			return fmt.Errorf("query_redaction_rules: %w", err)
+		}
+	}
+	return nil
+}
+
+// ConnectionChanged reports whether other connects to a different database
+// server than config, so the database connection must be reopened.
+func (config *Config) ConnectionChanged(other *Config) bool {
+	return config.InstanceType != other.InstanceType ||
+		config.MysqlUser != other.MysqlUser || config.MysqlPassword != other.MysqlPassword ||
+		config.MysqlHost != other.MysqlHost || config.MysqlPort != other.MysqlPort || config.MysqlSslMode != other.MysqlSslMode ||
+		config.PgUser != other.PgUser || config.PgPassword != other.PgPassword ||
+		config.PgHost != other.PgHost || config.PgPort != other.PgPort || config.PgSslMode != other.PgSslMode ||
+		config.AwsRegion != other.AwsRegion || config.AwsRDSDB != other.AwsRDSDB ||
+		config.GcpProjectId != other.GcpProjectId || config.GcpRegion != other.GcpRegion ||
+		config.GcpCloudSqlInstance != other.GcpCloudSqlInstance || config.GcpCloudSqlPublicConnection != other.GcpCloudSqlPublicConnection ||
+		config.AzureSubscriptionID != other.AzureSubscriptionID || config.AzureResourceGroup != other.AzureResourceGroup ||
+		config.AzureMySQLServer != other.AzureMySQLServer
 }
 
 func (config *Config) GetApiKey() string {
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+
+	logging "github.com/google/logger"
+)
+
+func TestLoadConfigInstancesInheritTopLevelSettings(t *testing.T) {
+	logger := *logging.Init("releem-agent-test", false, false, io.Discard)
+	configuration, err := LoadConfigFromString(`
+apikey="key"
+releem_dir="/opt/releem"
+mysql_user="releem"
+mysql_password="releem"
+interval_seconds=30
+
+instance "primary" {
+  hostname="db-primary"
+  mysql_port="3306"
+}
+
+instance "reporting" {
+  hostname="db-reporting"
+  mysql_port="3307"
+  mysql_password="other"
+  interval_seconds=120
+}
+`, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instances := configuration.GetInstances()
+	if len(instances) != 2 {
+		t.Fatalf("GetInstances() returned %d instances, want 2", len(instances))
+	}
+
+	primary, reporting := instances[0], instances[1]
+	if primary.InstanceName != "primary" || reporting.InstanceName != "reporting" {
+		t.Fatalf("unexpected instance names %q, %q", primary.InstanceName, reporting.InstanceName)
+	}
+	if primary.ApiKey != "key" || reporting.ApiKey != "key" {
+		t.Fatal("instances should inherit apikey from the top-level configuration")
+	}
+	if primary.MysqlPassword != "releem" || reporting.MysqlPassword != "other" {
+		t.Fatal("instances should only override the fields they declare")
+	}
+	if primary.MetricsPeriod != 30 || reporting.MetricsPeriod != 120 {
+		t.Fatalf("unexpected intervals %d, %d", primary.MetricsPeriod, reporting.MetricsPeriod)
+	}
+	if reporting.MysqlHost != "127.0.0.1" || reporting.GenerateConfigPeriod != 43200 {
+		t.Fatal("defaults should be applied to every instance")
+	}
+}
+
+func TestLoadConfigWithoutInstancesDescribesSingleInstance(t *testing.T) {
+	logger := *logging.Init("releem-agent-test", false, false, io.Discard)
+	configuration, err := LoadConfigFromString(`mysql_user="releem"`, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instances := configuration.GetInstances()
+	if len(instances) != 1 || instances[0] != configuration {
+		t.Fatal("a configuration without instance blocks should describe itself")
+	}
+}
+
+func TestInstanceDir(t *testing.T) {
+	single := &Config{ReleemDir: "/opt/releem"}
+	if dir := InstanceDir(single, "tasks"); dir != filepath.Join("/opt/releem", "tasks") {
+		t.Fatalf("InstanceDir = %q for a single instance", dir)
+	}
+	named := &Config{ReleemDir: "/opt/releem", InstanceName: "primary"}
+	if dir := InstanceDir(named, "tasks"); dir != filepath.Join("/opt/releem", "tasks", "primary") {
+		t.Fatalf("InstanceDir = %q for the instance primary", dir)
+	}
+}
+
+func TestValidateRejectsInvalidConfiguration(t *testing.T) {
+	logger := *logging.Init("releem-agent-test", false, false, io.Discard)
+	for name, data := range map[string]string{
+		"negative interval":         `interval_seconds=-1`,
+		"unknown type":              `instance_type="oracle"`,
+		"duplicate instance":        "instance \"a\" {\n}\ninstance \"a\" {\n}\n",
+		"unknown policy":            `query_redaction="mask"`,
+		"invalid rule":              `query_redaction_rules=["("]`,
+		"unknown compression":       `payload_compression="brotli"`,
+		"relative api url":          `api_url="/v2/"`,
+		"cert without key":          `api_client_cert_file="/etc/releem/client.pem"`,
+		"verification without keys": `signature_verification="enforce"`,
+		"unknown task type":         `task_timeout_seconds={ apply = 60 }`,
+		"negative task timeout":     `task_timeout_seconds={ "4" = -1 }`,
+		"negative history size":     `config_history_size=-1`,
+		"invalid window":            `maintenance_windows=["Sat 02:00"]`,
+		"unknown time zone":         `maintenance_timezone="Mars/Olympus"`,
+		"negative verify window":    `apply_verify_seconds=-60`,
+		"unknown health signal":     `apply_verify_thresholds={ qps = 2 }`,
+		"negative threshold":        `apply_verify_thresholds={ digest_latency = -1 }`,
+	} {
+		configuration, err := LoadConfigFromString(data, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if configuration.Validate() == nil {
+			t.Errorf("%s: Validate() accepted the configuration", name)
+		}
+	}
+
+	configuration, err := LoadConfigFromString(`instance_type="aws/rds"`+"\n"+`task_timeout_seconds={ "5" = 7200 }`, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := configuration.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if configuration.TaskTimeouts["5"] != 7200 {
+		t.Fatalf("task_timeout_seconds decoded as %v", configuration.TaskTimeouts)
+	}
+}
+
+func TestLoadConfigInstancesDoNotShareRedactionPolicies(t *testing.T) {
+	logger := *logging.Init("releem-agent-test", false, false, io.Discard)
+	configuration, err := LoadConfigFromString(`
+query_redaction_columns={ INFO = "drop" }
+
+instance "primary" {
+  query_redaction_columns={ query = "literals" }
+}
+
+instance "reporting" {
+}
+`, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary, reporting := configuration.Instances[0], configuration.Instances[1]
+	if primary.QueryRedactionColumns["INFO"] != "drop" || primary.QueryRedactionColumns["query"] != "literals" {
+		t.Fatalf("unexpected primary policies %v", primary.QueryRedactionColumns)
+	}
+	if len(reporting.QueryRedactionColumns) != 1 || len(configuration.QueryRedactionColumns) != 1 {
+		t.Fatalf("instance policies leaked: %v, %v", reporting.QueryRedactionColumns, configuration.QueryRedactionColumns)
+	}
+	if reporting.QueryRedaction != "keep" {
+		t.Fatalf("query_redaction defaults to %q, want keep", reporting.QueryRedaction)
+	}
+}
+
+func TestConnectionChanged(t *testing.T) {
+	current := &Config{MysqlUser: "releem", MysqlPassword: "secret", MysqlHost: "127.0.0.1", MetricsPeriod: 60}
+	other := *current
+	other.MetricsPeriod = 30
+	if current.ConnectionChanged(&other) {
+		t.Fatal("changing an interval should keep the connection")
+	}
+	other.MysqlPassword = "rotated"
+	if !current.ConnectionChanged(&other) {
+		t.Fatal("changing the password should reopen the connection")
+	}
+}
@@ -0,0 +1,393 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"google.golang.org/api/secretmanager/v1"
+)
+
+// SecretSource returns the secret a configuration refers to. The reference is
+// the part of a `*_source` setting after the source name, e.g. `MYSQL_PASSWORD`
+// for `env:MYSQL_PASSWORD`.
+type SecretSource func(ctx context.Context, configuration *Config, reference string) (string, error)
+
+var secretSources = struct {
+	sync.RWMutex
+	sources map[string]SecretSource
+}{sources: map[string]SecretSource{
+	"env":                envSecret,
+	"file":               fileSecret,
+	"command":            commandSecret,
+	"mycnf":              myCnfSecret,
+	"pgpass":             pgPassSecret,
+	"aws-secretsmanager": awsSecret,
+	"gcp-secretmanager":  gcpSecret,
+	"azure-keyvault":     azureSecret,
+}}
+
+// RegisterSecretSource makes a secret source available under name.
+func RegisterSecretSource(name string, source SecretSource) {
+	secretSources.Lock()
+	defer secretSources.Unlock()
+	secretSources.sources[name] = source
+}
+
+// secretTimeout bounds the resolution of every secret of a configuration.
+const secretTimeout = time.Minute
+
+// HasSecretSources reports whether a secret of any instance is read from a source.
+func (config *Config) HasSecretSources() bool {
+	for _, instance := range config.GetInstances() {
+		if instance.ApiKeySource != "" || instance.MysqlPasswordSource != "" || instance.PgPasswordSource != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveSecrets replaces the secrets of the configuration and of every
+// instance with the values read from their sources.
+func (config *Config) ResolveSecrets(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, secretTimeout)
+	defer cancel()
+	resolved := make(map[string]string)
+	resolve := func(configuration *Config, setting string, source string, secret *string) error {
+		if source == "" {
+			return nil
+		}
+		key := source
+		if strings.HasPrefix(source, "pgpass") {
+			// The password file entry depends on the instance.
+			key += "|" + configuration.PgUser + "@" + configuration.PgHost + ":" + configuration.PgPort
+		}
+		if value, ok := resolved[key]; ok {
+			*secret = value
+			return nil
+		}
+		value, err := resolveSecret(ctx, configuration, source)
+		if err != nil {
+			return fmt.Errorf("%s %q: %w", setting, source, err)
+		}
+		resolved[key] = value
+		*secret = value
+		return nil
+	}
+	configurations := append([]*Config{config}, config.Instances...)
+	for _, configuration := range configurations {
+		if err := resolve(configuration, "apikey_source", configuration.ApiKeySource, &configuration.ApiKey); err != nil {
+			return err
+		}
+		if err := resolve(configuration, "pg_password_source", configuration.PgPasswordSource, &configuration.PgPassword); err != nil {
+			return err
+		}
+		if strings.HasPrefix(configuration.MysqlPasswordSource, "mycnf") && configuration.MysqlUser == "" {
+			user, err := myCnfOption(myCnfPath(strings.TrimPrefix(strings.TrimPrefix(configuration.MysqlPasswordSource, "mycnf"), ":")), "user")
+			if err != nil {
+				return fmt.Errorf("mysql_password_source %q: %w", configuration.MysqlPasswordSource, err)
+			}
+			configuration.MysqlUser = user
+		}
+		if err := resolve(configuration, "mysql_password_source", configuration.MysqlPasswordSource, &configuration.MysqlPassword); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SecretsChanged reports whether the secrets of other differ from those of config.
+func (config *Config) SecretsChanged(other *Config) bool {
+	instances, others := config.GetInstances(), other.GetInstances()
+	if len(instances) != len(others) {
+		return true
+	}
+	for i, instance := range instances {
+		if instance.ApiKey != others[i].ApiKey || instance.MysqlUser != others[i].MysqlUser ||
+			instance.MysqlPassword != others[i].MysqlPassword || instance.PgPassword != others[i].PgPassword {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveSecret reads the secret source `name[:reference][#key]`. With #key the
+// secret is a JSON object and the value of key is returned.
+func resolveSecret(ctx context.Context, configuration *Config, source string) (string, error) {
+	name, reference, _ := strings.Cut(source, ":")
+	var key string
+	if i := strings.LastIndex(reference, "#"); i >= 0 {
+		reference, key = reference[:i], reference[i+1:]
+	}
+	secretSources.RLock()
+	resolve, ok := secretSources.sources[name]
+	secretSources.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unknown secret source %q", name)
+	}
+	value, err := resolve(ctx, configuration, reference)
+	if err != nil {
+		return "", err
+	}
+	if key == "" {
+		return value, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object: %w", err)
+	}
+	field, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("secret has no key %q", key)
+	}
+	if text, ok := field.(string); ok {
+		return text, nil
+	}
+	return fmt.Sprint(field), nil
+}
+
+func envSecret(ctx context.Context, configuration *Config, reference string) (string, error) {
+	value, ok := os.LookupEnv(reference)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", reference)
+	}
+	return value, nil
+}
+
+// fileSecret reads a secret file such as a Docker or Kubernetes secret.
+func fileSecret(ctx context.Context, configuration *Config, reference string) (string, error) {
+	data, err := os.ReadFile(reference)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// commandSecret runs a command and returns its standard output.
+func commandSecret(ctx context.Context, configuration *Config, reference string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "powershell.exe", "-NoProfile", "-NonInteractive", "-Command", reference)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", reference)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
+
+func homeFile(name string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return name
+	}
+	return filepath.Join(home, name)
+}
+
+func myCnfPath(reference string) string {
+	if reference != "" {
+		return reference
+	}
+	return homeFile(".my.cnf")
+}
+
+// myCnfSecret reads the password of the [client] group of a MySQL option
+// file, ~/.my.cnf by default.
+func myCnfSecret(ctx context.Context, configuration *Config, reference string) (string, error) {
+	return myCnfOption(myCnfPath(reference), "password")
+}
+
+func myCnfOption(path string, option string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	var group string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			group = strings.ToLower(strings.Trim(line, "[] "))
+			continue
+		}
+		if group != "client" {
+			continue
+		}
+		name, value, _ := strings.Cut(line, "=")
+		if strings.TrimSpace(name) != option {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		return value, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%s has no %s in the [client] group", path, option)
+}
+
+// pgPassSecret reads the password matching the PostgreSQL host, port and user
+// of the instance from a password file, $PGPASSFILE or ~/.pgpass by default.
+func pgPassSecret(ctx context.Context, configuration *Config, reference string) (string, error) {
+	path := reference
+	if path == "" {
+		path = os.Getenv("PGPASSFILE")
+	}
+	if path == "" {
+		if runtime.GOOS == "windows" {
+			path = filepath.Join(os.Getenv("APPDATA"), "postgresql", "pgpass.conf")
+		} else {
+			path = homeFile(".pgpass")
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	want := []string{configuration.PgHost, configuration.PgPort, "postgres", configuration.PgUser}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := splitPgPass(line)
+		if len(fields) != 5 {
+			continue
+		}
+		matched := true
+		for i, value := range want {
+			if fields[i] != "*" && fields[i] != value {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return fields[4], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%s has no entry for %s@%s:%s", path, configuration.PgUser, configuration.PgHost, configuration.PgPort)
+}
+
+// splitPgPass splits a .pgpass line on the colons that are not escaped.
+func splitPgPass(line string) []string {
+	var fields []string
+	var field strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line):
+			i++
+			field.WriteByte(line[i])
+		case line[i] == ':':
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(line[i])
+		}
+	}
+	return append(fields, field.String())
+}
+
+// awsSecret reads a secret of AWS Secrets Manager by name or ARN.
+func awsSecret(ctx context.Context, configuration *Config, reference string) (string, error) {
+	awscfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(configuration.AwsRegion))
+	if err != nil {
+		return "", err
+	}
+	output, err := secretsmanager.NewFromConfig(awscfg).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: &reference})
+	if err != nil {
+		return "", err
+	}
+	if output.SecretString != nil {
+		return *output.SecretString, nil
+	}
+	return string(output.SecretBinary), nil
+}
+
+// gcpSecret reads a secret version of GCP Secret Manager. The reference is
+// either a secret name of gcp_project_id or a projects/.../secrets/... path;
+// the latest version is read unless one is given.
+func gcpSecret(ctx context.Context, configuration *Config, reference string) (string, error) {
+	name := reference
+	if !strings.HasPrefix(name, "projects/") {
+		name = "projects/" + configuration.GcpProjectId + "/secrets/" + name
+	}
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	service, err := secretmanager.NewService(ctx)
+	if err != nil {
+		return "", err
+	}
+	response, err := service.Projects.Secrets.Versions.Access(name).Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(response.Payload.Data)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// azureSecret reads a secret of Azure Key Vault given its identifier,
+// https://<vault>.vault.azure.net/secrets/<name>[/<version>].
+func azureSecret(ctx context.Context, configuration *Config, reference string) (string, error) {
+	identifier, err := url.Parse(reference)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(strings.Trim(identifier.Path, "/"), "/")
+	if identifier.Host == "" || len(parts) < 2 || parts[0] != "secrets" {
+		return "", fmt.Errorf("%q is not a Key Vault secret identifier", reference)
+	}
+	var version string
+	if len(parts) > 2 {
+		version = parts[2]
+	}
+	credential, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return "", err
+	}
+	client, err := azsecrets.NewClient(identifier.Scheme+"://"+identifier.Host, credential, nil)
+	if err != nil {
+		return "", err
+	}
+	response, err := client.GetSecret(ctx, parts[1], version, nil)
+	if err != nil {
+		return "", err
+	}
+	if response.Value == nil {
+		return "", fmt.Errorf("secret %s has no value", parts[1])
+	}
+	return *response.Value, nil
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	logging "github.com/google/logger"
+)
+
+func TestResolveSecretsFromLocalSources(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	if err := os.WriteFile(passwordFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	myCnf := filepath.Join(dir, "my.cnf")
+	if err := os.WriteFile(myCnf, []byte("[mysqld]\npassword=server\n\n[client]\nuser = releem\npassword = \"from-mycnf\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pgPass := filepath.Join(dir, "pgpass")
+	if err := os.WriteFile(pgPass, []byte("# comment\nother:5432:*:releem:wrong\ndb\\:1:*:*:releem:from\\:pgpass\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RELEEM_TEST_SECRET", `{"apikey":"from-env"}`)
+
+	logger := *logging.Init("releem-agent-test", false, false, io.Discard)
+	configuration, err := LoadConfigFromString(`
+apikey_source="env:RELEEM_TEST_SECRET#apikey"
+
+instance "file" {
+  mysql_user="releem"
+  mysql_password_source="file:`+filepath.ToSlash(passwordFile)+`"
+}
+
+instance "mycnf" {
+  mysql_password_source="mycnf:`+filepath.ToSlash(myCnf)+`"
+}
+
+instance "pgpass" {
+  pg_user="releem"
+  pg_host="db:1"
+  pg_password_source="pgpass:`+filepath.ToSlash(pgPass)+`"
+}
+`, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := configuration.ResolveSecrets(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	instances := configuration.GetInstances()
+	for _, instance := range instances {
+		if instance.ApiKey != "from-env" {
+			t.Fatalf("instance %s: ApiKey = %q, want the key of the JSON secret", instance.InstanceName, instance.ApiKey)
+		}
+	}
+	if instances[0].MysqlPassword != "from-file" {
+		t.Fatalf("file source returned %q", instances[0].MysqlPassword)
+	}
+	if instances[1].MysqlPassword != "from-mycnf" || instances[1].MysqlUser != "releem" {
+		t.Fatalf("mycnf source returned %q for user %q", instances[1].MysqlPassword, instances[1].MysqlUser)
+	}
+	if instances[2].PgPassword != "from:pgpass" {
+		t.Fatalf("pgpass source returned %q", instances[2].PgPassword)
+	}
+}
+
+func TestResolveSecretsFromCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	configuration := &Config{MysqlPasswordSource: "command:echo rotated"}
+	if err := configuration.ResolveSecrets(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if configuration.MysqlPassword != "rotated" {
+		t.Fatalf("command source returned %q", configuration.MysqlPassword)
+	}
+}
+
+func TestResolveSecretsRejectsUnknownSource(t *testing.T) {
+	configuration := &Config{PgPasswordSource: "vault:secret/pg"}
+	if err := configuration.ResolveSecrets(context.Background()); err == nil {
+		t.Fatal("ResolveSecrets() accepted an unknown source")
+	}
+
+	RegisterSecretSource("vault", func(ctx context.Context, configuration *Config, reference string) (string, error) {
+		return reference, nil
+	})
+	if err := configuration.ResolveSecrets(context.Background()); err != nil || configuration.PgPassword != "secret/pg" {
+		t.Fatalf("registered source returned %q, %v", configuration.PgPassword, err)
+	}
+}
+
+func TestSecretsChanged(t *testing.T) {
+	current := &Config{MysqlPassword: "old", MetricsPeriod: 60}
+	other := *current
+	other.MetricsPeriod = 30
+	if current.SecretsChanged(&other) {
+		t.Fatal("an interval is not a secret")
+	}
+	other.MysqlPassword = "new"
+	if !current.SecretsChanged(&other) {
+		t.Fatal("a rotated password should be reported")
+	}
+}
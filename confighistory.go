@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/history"
+	logging "github.com/google/logger"
+)
+
+// runConfigHistory lists the versions of the configuration history.
+func runConfigHistory(args []string) (status string, err error) {
+	flags := flag.NewFlagSet("config-history", flag.ContinueOnError)
+	instanceName := flags.String("instance", "", "Name of the instance, required when several instances are configured")
+	jsonOutput := flags.Bool("json", false, "Print the versions as JSON")
+	if err := flags.Parse(args); err != nil {
+		return "", err
+	}
+	logger = *logging.Init("releem-agent", false, false, io.Discard)
+	defer func() {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+	}()
+
+	instanceConfiguration, err := loadInstance(*instanceName)
+	if err != nil {
+		return "", err
+	}
+	versions, err := history.NewStore(instanceConfiguration).List()
+	if err != nil {
+		return "", err
+	}
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return "", encoder.Encode(versions)
+	}
+	fmt.Printf("%-8s %-20s %-9s %-8s %-12s %s\n", "VERSION", "TIME", "SOURCE", "TASK", "ROLLBACK_OF", "FILE")
+	for _, version := range versions {
+		fmt.Printf("%-8d %-20s %-9s %-8s %-12s %s\n", version.Number, version.Time.Format(time.DateTime), version.Source,
+			optionalNumber(version.TaskID), optionalNumber(version.RollbackOf), version.File)
+	}
+	return "", nil
+}
+
+// runConfigDiff compares two versions of the configuration history, or a
+// version with the live variables of the database: config-diff FROM [TO],
+// FROM and TO being version numbers or "live", TO defaulting to live.
+func runConfigDiff(args []string) (status string, err error) {
+	flags := flag.NewFlagSet("config-diff", flag.ContinueOnError)
+	instanceName := flags.String("instance", "", "Name of the instance, required when several instances are configured")
+	jsonOutput := flags.Bool("json", false, "Print the changes as JSON")
+	if err := flags.Parse(args); err != nil {
+		return "", err
+	}
+	logger = *logging.Init("releem-agent", false, false, io.Discard)
+	defer func() {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+	}()
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return "", fmt.Errorf("usage: config-diff [-instance name] [-json] FROM [TO], FROM and TO being version numbers or live")
+	}
+	from, to := flags.Arg(0), "live"
+	if flags.NArg() == 2 {
+		to = flags.Arg(1)
+	}
+	if from == "live" && to == "live" {
+		return "", fmt.Errorf("at least one side of the comparison must be a version")
+	}
+
+	instanceConfiguration, err := loadInstance(*instanceName)
+	if err != nil {
+		return "", err
+	}
+	store := history.NewStore(instanceConfiguration)
+	fromVariables, err := versionVariables(store, from)
+	if err != nil {
+		return "", err
+	}
+	toVariables, err := versionVariables(store, to)
+	if err != nil {
+		return "", err
+	}
+	if from == "live" || to == "live" {
+		live, err := liveVariables(instanceConfiguration)
+		if err != nil {
+			return "", err
+		}
+		if from == "live" {
+			fromVariables = history.LiveValues(live, toVariables)
+		} else {
+			toVariables = history.LiveValues(live, fromVariables)
+		}
+	}
+
+	changes := history.Diff(fromVariables, toVariables)
+	if *jsonOutput {
+		if changes == nil {
+			changes = []history.Change{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return "", encoder.Encode(changes)
+	}
+	history.WriteText(os.Stdout, changes)
+	return "", nil
+}
+
+// loadInstance loads the agent configuration and selects the instance named by
+// -instance, which may be left out when a single instance is configured.
+func loadInstance(name string) (*config.Config, error) {
+	configuration, err := config.LoadConfig(*ConfigFile, logger)
+	if err != nil {
+		return nil, err
+	}
+	instances, err := selectInstances(configuration, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(instances) > 1 {
+		names := make([]string, 0, len(instances))
+		for _, instance := range instances {
+			names = append(names, instance.InstanceName)
+		}
+		return nil, fmt.Errorf("several instances are configured, select one with -instance: %s", strings.Join(names, ", "))
+	}
+	return instances[0], nil
+}
+
+// versionVariables returns the variables of a version, nil for live.
+func versionVariables(store *history.Store, argument string) (map[string]string, error) {
+	if argument == "live" {
+		return nil, nil
+	}
+	number, err := strconv.Atoi(argument)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version %q, expected a version number or live", argument)
+	}
+	version, err := store.Get(number)
+	if err != nil {
+		return nil, err
+	}
+	return history.Parse(version.Content)
+}
+
+// liveVariables reads the global variables of the instance database.
+func liveVariables(configuration *config.Config) (map[string]string, error) {
+	pipeline, closers, err := newPipeline(configuration, nil)
+	defer closeAll(closers)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return history.LiveVariables(ctx, pipeline.GetInstance().DB, configuration.GetDatabaseType())
+}
+
+func optionalNumber(number int) string {
+	if number == 0 {
+		return "-"
+	}
+	return strconv.Itoa(number)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/api"
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/doctor"
+	logging "github.com/google/logger"
+)
+
+// runDoctor runs every check the gatherers and tasks depend on and prints a
+// report. An error is returned when a check failed.
+func runDoctor(args []string) (status string, err error) {
+	flags := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "Print the report as JSON")
+	if err := flags.Parse(args); err != nil {
+		return "", err
+	}
+	// The checks log through the agent logger, keep the report readable.
+	logger = *logging.Init("releem-agent", false, false, io.Discard)
+	defer func() {
+		if err != nil && err != errDoctorFailed {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	report := doctor.NewReport()
+	configuration, err := config.LoadConfig(*ConfigFile, logger)
+	if err != nil {
+		report.Add("", doctor.Result{Check: "configuration", Status: doctor.Fail, Message: err.Error(), Remediation: "Fix " + *ConfigFile + " or pass its path with -config."})
+	} else {
+		if client, err := api.NewClient(configuration); err != nil {
+			report.Add("", doctor.Result{Check: "api", Status: doctor.Fail, Message: err.Error(), Remediation: "Fix the api_ca_file and api_client_cert_file settings."})
+		} else {
+			client.Timeout = 15 * time.Second
+			report.Add("", doctor.CheckApi(ctx, client, api.BaseURL(configuration))...)
+		}
+		for _, instanceConfiguration := range configuration.GetInstances() {
+			name := instanceConfiguration.InstanceName
+			report.Add(name, doctor.CheckConfiguration(instanceConfiguration)...)
+			report.Add(name, doctor.CheckFiles(instanceConfiguration)...)
+			pipeline, closers, err := newPipeline(instanceConfiguration, nil)
+			report.Add(name, doctor.CheckPipeline(instanceConfiguration, err)...)
+			if err == nil {
+				if instanceConfiguration.GetDatabaseType() == "postgresql" {
+					report.Add(name, doctor.CheckPostgreSQL(ctx, pipeline.GetInstance().DB, instanceConfiguration)...)
+				} else {
+					report.Add(name, doctor.CheckMySQL(ctx, pipeline.GetInstance().DB, instanceConfiguration)...)
+				}
+			}
+			closeAll(closers)
+		}
+	}
+
+	if *jsonOutput {
+		err = report.WriteJSON(os.Stdout)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		return "", err
+	}
+	if report.Failed() {
+		return "", errDoctorFailed
+	}
+	return "", nil
+}
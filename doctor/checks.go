@@ -0,0 +1,155 @@
+package doctor
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/Releem/mysqlconfigurer/config"
+)
+
+// CheckConfiguration checks the settings every gatherer and repeater relies on.
+func CheckConfiguration(configuration *config.Config) []Result {
+	var results []Result
+	if err := configuration.Validate(); err != nil {
+		results = append(results, fail("configuration", err.Error(), "Fix the setting in releem.conf."))
+	} else {
+		results = append(results, pass("configuration", "releem.conf is valid"))
+	}
+	if configuration.ApiKey == "" {
+		results = append(results, fail("apikey", "apikey is empty", "Set apikey or apikey_source to the API key shown in the Releem dashboard."))
+	} else {
+		results = append(results, pass("apikey", "apikey is set"))
+	}
+
+	var missing []string
+	required := map[string]string{}
+	if configuration.GetDatabaseType() == "postgresql" {
+		required["pg_user"], required["pg_password"] = configuration.PgUser, configuration.PgPassword
+	} else {
+		required["mysql_user"], required["mysql_password"] = configuration.MysqlUser, configuration.MysqlPassword
+	}
+	switch configuration.InstanceType {
+	case "aws/rds":
+		required["aws_region"], required["aws_rds_db"] = configuration.AwsRegion, configuration.AwsRDSDB
+	case "gcp/cloudsql":
+		required["gcp_project_id"], required["gcp_region"], required["gcp_cloudsql_instance"] = configuration.GcpProjectId, configuration.GcpRegion, configuration.GcpCloudSqlInstance
+	case "azure/mysql":
+		required["azure_subscription_id"], required["azure_resource_group"], required["azure_mysql_server"] = configuration.AzureSubscriptionID, configuration.AzureResourceGroup, configuration.AzureMySQLServer
+	}
+	for name, value := range required {
+		if value == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		results = append(results, fail("credentials", "missing "+strings.Join(missing, ", "), "Set the missing settings in releem.conf for instance_type \""+configuration.InstanceType+"\"."))
+	} else {
+		results = append(results, pass("credentials", "connection settings are set for instance_type \""+configuration.InstanceType+"\""))
+	}
+	return results
+}
+
+// CheckFiles checks the directories and scripts the agent and its tasks write
+// to or run.
+func CheckFiles(configuration *config.Config) []Result {
+	var results []Result
+	if err := checkWritable(configuration.ReleemDir); err != nil {
+		results = append(results, fail("releem_dir", err.Error(), "Create "+configuration.ReleemDir+" and make it writable by the user running releem-agent."))
+	} else {
+		results = append(results, pass("releem_dir", configuration.ReleemDir+" is writable"))
+	}
+	if configuration.ReleemConfDir == "" {
+		results = append(results, fail("releem_cnf_dir", "releem_cnf_dir is empty", "Set releem_cnf_dir, the directory recommended configurations are downloaded to."))
+	} else if err := checkWritable(configuration.ReleemConfDir); err != nil {
+		results = append(results, fail("releem_cnf_dir", err.Error(), "Create "+configuration.ReleemConfDir+" and make it writable by the user running releem-agent."))
+	} else {
+		results = append(results, pass("releem_cnf_dir", configuration.ReleemConfDir+" is writable"))
+	}
+
+	if configuration.InstanceType != "local" {
+		return results
+	}
+	confDir, restartService, confSetting, restartSetting := configuration.MysqlConfDir, configuration.MysqlRestartService, "mysql_cnf_dir", "mysql_restart_service"
+	if configuration.GetDatabaseType() == "postgresql" {
+		confDir, restartService, confSetting, restartSetting = configuration.PgConfDir, configuration.PgRestartService, "pg_cnf_dir", "pg_restart_service"
+	}
+	if confDir == "" {
+		results = append(results, warn(confSetting, confSetting+" is empty", "Set "+confSetting+" to the include directory of the database configuration to apply recommendations."))
+	} else if info, err := os.Stat(confDir); err != nil || !info.IsDir() {
+		results = append(results, warn(confSetting, confDir+" is not a directory", "Create "+confDir+" and include it from the database configuration file."))
+	} else {
+		results = append(results, pass(confSetting, confDir+" exists"))
+	}
+	if restartService == "" {
+		results = append(results, warn(restartSetting, restartSetting+" is empty", "Set "+restartSetting+" to the command restarting the database, e.g. \"systemctl restart mysql\"."))
+	} else {
+		results = append(results, pass(restartSetting, restartService))
+	}
+	if runtime.GOOS != "windows" {
+		script := filepath.Join(configuration.ReleemDir, "mysqlconfigurer.sh")
+		if _, err := os.Stat(script); err != nil {
+			results = append(results, warn("mysqlconfigurer.sh", script+" is missing", "Reinstall the agent, configuration tasks run this script."))
+		} else {
+			results = append(results, pass("mysqlconfigurer.sh", script+" exists"))
+		}
+	}
+	return results
+}
+
+// checkWritable checks that a file can be created in dir.
+func checkWritable(dir string) error {
+	file, err := os.CreateTemp(dir, ".releem-doctor-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// CheckApi checks that the Releem API at baseURL answers over HTTPS.
+func CheckApi(ctx context.Context, client *http.Client, baseURL string) []Result {
+	host := baseURL
+	if parsed, err := url.Parse(baseURL); err == nil {
+		host = parsed.Host
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL, nil)
+	if err != nil {
+		return []Result{fail("api", err.Error(), "Check env and releem_region in releem.conf.")}
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return []Result{fail("api", "cannot reach "+host+": "+err.Error(), "Allow outbound HTTPS to "+host+":443 and check the proxy and DNS settings.")}
+	}
+	response.Body.Close()
+	if response.StatusCode >= 500 {
+		return []Result{warn("api", host+" answered "+response.Status, "The Releem API is unavailable, metrics are spooled until it recovers.")}
+	}
+	return []Result{pass("api", host+" is reachable")}
+}
+
+// CheckPipeline records the outcome of building the gatherers and repeaters
+// of an instance, which looks up cloud database instances.
+func CheckPipeline(configuration *config.Config, err error) []Result {
+	if configuration.InstanceType == "local" {
+		if err != nil {
+			return []Result{fail("pipeline", err.Error(), "Fix the setting in releem.conf.")}
+		}
+		return nil
+	}
+	if err == nil {
+		return []Result{pass("cloud", configuration.InstanceType+" instance found")}
+	}
+	remediation := map[string]string{
+		"aws/rds":      "Allow rds:DescribeDBInstances, rds:DescribeDBParameters, cloudwatch and logs read access to the IAM role of the agent.",
+		"gcp/cloudsql": "Grant roles/cloudsql.viewer and roles/monitoring.viewer to the service account of the agent.",
+		"azure/mysql":  "Assign the Reader and Monitoring Reader roles on the server to the identity of the agent.",
+	}[configuration.InstanceType]
+	return []Result{fail("cloud", err.Error(), remediation)}
+}
@@ -0,0 +1,150 @@
+package doctor
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+
+	"github.com/Releem/mysqlconfigurer/config"
+)
+
+// CheckMySQL checks the connection, the grants and the performance_schema
+// setup the MySQL gatherers depend on.
+func CheckMySQL(ctx context.Context, db *sql.DB, configuration *config.Config) []Result {
+	if err := db.PingContext(ctx); err != nil {
+		return []Result{fail("connection", err.Error(), "Check mysql_host, mysql_port, mysql_user and mysql_password, e.g. with mysqladmin ping.")}
+	}
+	var version string
+	if err := db.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version); err != nil {
+		return []Result{fail("connection", err.Error(), "Check that the server accepts queries from "+configuration.MysqlUser+".")}
+	}
+	results := []Result{pass("connection", "connected to "+version)}
+
+	grants, err := queryStrings(ctx, db, "SHOW GRANTS")
+	if err != nil {
+		results = append(results, warn("grants", err.Error(), "Check that "+configuration.MysqlUser+" may run SHOW GRANTS."))
+	} else {
+		privileges := globalPrivileges(grants)
+		var missing []string
+		for _, privilege := range []string{"PROCESS", "REPLICATION CLIENT", "SHOW VIEW"} {
+			if !privileges[privilege] {
+				missing = append(missing, privilege)
+			}
+		}
+		if len(missing) > 0 {
+			results = append(results, fail("grants", "missing "+strings.Join(missing, ", ")+" on *.*", "GRANT "+strings.Join(missing, ", ")+" ON *.* TO the agent user."))
+		} else {
+			results = append(results, pass("grants", "PROCESS, REPLICATION CLIENT and SHOW VIEW are granted"))
+		}
+		if !privileges["SYSTEM_VARIABLES_ADMIN"] && !privileges["SUPER"] {
+			results = append(results, warn("grants_apply", "neither SYSTEM_VARIABLES_ADMIN nor SUPER is granted", "GRANT SYSTEM_VARIABLES_ADMIN ON *.* (or SUPER before MySQL 8) to apply configuration without a restart."))
+		}
+		if configuration.QueryOptimization && !privileges["SELECT"] {
+			results = append(results, warn("grants_query_optimization", "SELECT on *.* is not granted", "GRANT SELECT ON *.* to the agent user, query optimization reads table schemas and explains queries."))
+		}
+	}
+
+	if _, err := queryStrings(ctx, db, "SELECT 1 FROM mysql.user LIMIT 1"); err != nil {
+		results = append(results, fail("grants_mysql", err.Error(), "GRANT SELECT ON mysql.* to the agent user."))
+	}
+
+	var enabled string
+	if err := db.QueryRowContext(ctx, "SELECT @@performance_schema").Scan(&enabled); err != nil || (enabled != "1" && !strings.EqualFold(enabled, "ON")) {
+		results = append(results, warn("performance_schema", "performance_schema is disabled", "Set performance_schema=ON in the server configuration and restart it to collect query metrics."))
+		return results
+	}
+	results = append(results, pass("performance_schema", "performance_schema is enabled"))
+
+	for _, table := range []string{"events_statements_summary_by_digest", "table_io_waits_summary_by_index_usage", "file_summary_by_instance"} {
+		if _, err := queryStrings(ctx, db, "SELECT 1 FROM performance_schema."+table+" LIMIT 1"); err != nil {
+			results = append(results, warn("performance_schema_grants", err.Error(), "GRANT SELECT ON performance_schema."+table+" to the agent user."))
+		}
+	}
+
+	var consumers uint64
+	if err := db.QueryRowContext(ctx, "SELECT count(name) FROM performance_schema.setup_consumers WHERE enabled = 'YES' AND name LIKE 'events_statements_%' AND name != 'events_statements_cpu'").Scan(&consumers); err != nil {
+		results = append(results, warn("statement_consumers", err.Error(), "GRANT SELECT ON performance_schema.setup_consumers to the agent user."))
+	} else if consumers < 3 {
+		remediation := "Enable them with UPDATE performance_schema.setup_consumers SET enabled = 'YES' WHERE name LIKE 'events_statements_%' and persist performance-schema-consumer-events-statements-history=ON in the server configuration."
+		if configuration.InstanceType == "aws/rds" {
+			remediation = "The agent enables them with mysql.rds_enable_perf_schema_consumers; set performance_schema=1 in the parameter group if it is not."
+		}
+		results = append(results, warn("statement_consumers", "events_statements consumers are disabled, query examples are not collected", remediation))
+	} else {
+		results = append(results, pass("statement_consumers", "events_statements consumers are enabled"))
+	}
+	return results
+}
+
+// CheckPostgreSQL checks the connection, the monitoring role and the
+// pg_stat_statements setup the PostgreSQL gatherers depend on.
+func CheckPostgreSQL(ctx context.Context, db *sql.DB, configuration *config.Config) []Result {
+	if err := db.PingContext(ctx); err != nil {
+		return []Result{fail("connection", err.Error(), "Check pg_host, pg_port, pg_user and pg_password and pg_hba.conf, e.g. with pg_isready.")}
+	}
+	var version string
+	if err := db.QueryRowContext(ctx, "SHOW server_version").Scan(&version); err != nil {
+		return []Result{fail("connection", err.Error(), "Check that the server accepts queries from "+configuration.PgUser+".")}
+	}
+	results := []Result{pass("connection", "connected to PostgreSQL "+version)}
+
+	var monitor bool
+	if err := db.QueryRowContext(ctx, "SELECT pg_has_role(current_user, 'pg_monitor', 'MEMBER') OR (SELECT rolsuper FROM pg_roles WHERE rolname = current_user)").Scan(&monitor); err != nil || !monitor {
+		results = append(results, warn("grants", configuration.PgUser+" is not a member of pg_monitor", "GRANT pg_monitor TO "+configuration.PgUser+" to read the statistics of every session."))
+	} else {
+		results = append(results, pass("grants", configuration.PgUser+" is a member of pg_monitor"))
+	}
+
+	var libraries string
+	if err := db.QueryRowContext(ctx, "SHOW shared_preload_libraries").Scan(&libraries); err != nil || !strings.Contains(libraries, "pg_stat_statements") {
+		results = append(results, warn("pg_stat_statements_preload", "pg_stat_statements is not in shared_preload_libraries", "Add pg_stat_statements to shared_preload_libraries and restart PostgreSQL."))
+	}
+	var installed bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM pg_extension WHERE extname = 'pg_stat_statements')").Scan(&installed); err != nil || !installed {
+		results = append(results, warn("pg_stat_statements", "the pg_stat_statements extension is not installed, query metrics are not collected", "Run CREATE EXTENSION pg_stat_statements; in the postgres database."))
+	} else {
+		results = append(results, pass("pg_stat_statements", "pg_stat_statements is installed"))
+	}
+	return results
+}
+
+func queryStrings(ctx context.Context, db *sql.DB, query string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var value sql.RawBytes
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, string(value))
+	}
+	return values, rows.Err()
+}
+
+var grantPattern = regexp.MustCompile(`(?i)^GRANT (.+?) ON (\S+) TO `)
+
+// globalPrivileges returns the privileges granted ON *.* by SHOW GRANTS.
+func globalPrivileges(grants []string) map[string]bool {
+	privileges := make(map[string]bool)
+	for _, grant := range grants {
+		match := grantPattern.FindStringSubmatch(grant)
+		if match == nil || strings.Trim(match[2], "`") != "*.*" {
+			continue
+		}
+		for _, privilege := range strings.Split(match[1], ",") {
+			privilege = strings.ToUpper(strings.TrimSpace(privilege))
+			if privilege == "ALL" || privilege == "ALL PRIVILEGES" {
+				for _, all := range []string{"PROCESS", "REPLICATION CLIENT", "SHOW VIEW", "SUPER", "SELECT"} {
+					privileges[all] = true
+				}
+			}
+			privileges[privilege] = true
+		}
+	}
+	return privileges
+}
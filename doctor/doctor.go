@@ -0,0 +1,109 @@
+// Package doctor implements the preflight checks of `releem-agent doctor`.
+package doctor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Releem/mysqlconfigurer/config"
+)
+
+// Status is the outcome of a check.
+type Status string
+
+const (
+	Pass Status = "pass"
+	Warn Status = "warn"
+	Fail Status = "fail"
+)
+
+// severity orders the statuses from best to worst.
+func (status Status) severity() int {
+	switch status {
+	case Warn:
+		return 1
+	case Fail:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// Result is the outcome of one check, with a remediation hint unless it passed.
+type Result struct {
+	Instance    string `json:"instance,omitempty"`
+	Check       string `json:"check"`
+	Status      Status `json:"status"`
+	Message     string `json:"message"`
+	Remediation string `json:"remediation,omitempty"`
+}
+
+// Report collects the results of every check.
+type Report struct {
+	Version string   `json:"version"`
+	Status  Status   `json:"status"`
+	Results []Result `json:"results"`
+}
+
+func NewReport() *Report {
+	return &Report{Version: config.ReleemAgentVersion, Status: Pass, Results: []Result{}}
+}
+
+// Add records the results of the checks of an instance.
+func (report *Report) Add(instance string, results ...Result) {
+	for _, result := range results {
+		result.Instance = instance
+		if result.Status.severity() > report.Status.severity() {
+			report.Status = result.Status
+		}
+		report.Results = append(report.Results, result)
+	}
+}
+
+// Failed reports whether a check failed.
+func (report *Report) Failed() bool {
+	return report.Status == Fail
+}
+
+// WriteJSON writes the report as a JSON document.
+func (report *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// WriteText writes the report for a terminal, one check per line followed by
+// the remediation hint of the checks that did not pass.
+func (report *Report) WriteText(w io.Writer) error {
+	var instance string
+	var counts = make(map[Status]int)
+	for i, result := range report.Results {
+		if i == 0 || result.Instance != instance {
+			instance = result.Instance
+			if instance != "" {
+				fmt.Fprintf(w, "\n[%s]\n", instance)
+			}
+		}
+		counts[result.Status]++
+		fmt.Fprintf(w, "%-5s %-24s %s\n", strings.ToUpper(string(result.Status)), result.Check, result.Message)
+		if result.Remediation != "" {
+			fmt.Fprintf(w, "      -> %s\n", result.Remediation)
+		}
+	}
+	_, err := fmt.Fprintf(w, "\n%d passed, %d warnings, %d failed\n", counts[Pass], counts[Warn], counts[Fail])
+	return err
+}
+
+func pass(check string, message string) Result {
+	return Result{Check: check, Status: Pass, Message: message}
+}
+
+func warn(check string, message string, remediation string) Result {
+	return Result{Check: check, Status: Warn, Message: message, Remediation: remediation}
+}
+
+func fail(check string, message string, remediation string) Result {
+	return Result{Check: check, Status: Fail, Message: message, Remediation: remediation}
+}
@@ -0,0 +1,118 @@
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Releem/mysqlconfigurer/config"
+)
+
+func TestReportStatusIsTheWorstResult(t *testing.T) {
+	report := NewReport()
+	report.Add("", pass("api", "ok"))
+	if report.Status != Pass || report.Failed() {
+		t.Fatalf("status = %s, want pass", report.Status)
+	}
+	report.Add("primary", warn("grants_apply", "missing", "grant it"), pass("connection", "ok"))
+	if report.Status != Warn || report.Failed() {
+		t.Fatalf("status = %s, want warn", report.Status)
+	}
+	report.Add("primary", fail("connection", "refused", "check it"), warn("x", "y", "z"))
+	if report.Status != Fail || !report.Failed() {
+		t.Fatalf("status = %s, want fail", report.Status)
+	}
+
+	var text bytes.Buffer
+	if err := report.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"[primary]", "FAIL  connection", "-> check it", "2 passed, 2 warnings, 1 failed"} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text report misses %q:\n%s", want, text.String())
+		}
+	}
+
+	var document bytes.Buffer
+	if err := report.WriteJSON(&document); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(document.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Status != Fail || len(decoded.Results) != 5 || decoded.Results[1].Instance != "primary" {
+		t.Fatalf("unexpected JSON report %s", document.String())
+	}
+}
+
+func TestGlobalPrivileges(t *testing.T) {
+	privileges := globalPrivileges([]string{
+		"GRANT PROCESS, REPLICATION CLIENT ON *.* TO `releem`@`127.0.0.1`",
+		"GRANT SYSTEM_VARIABLES_ADMIN ON *.* TO `releem`@`127.0.0.1`",
+		"GRANT SELECT ON `mysql`.* TO `releem`@`127.0.0.1`",
+	})
+	if !privileges["PROCESS"] || !privileges["REPLICATION CLIENT"] || !privileges["SYSTEM_VARIABLES_ADMIN"] {
+		t.Fatalf("missing global privileges in %v", privileges)
+	}
+	if privileges["SELECT"] || privileges["SHOW VIEW"] {
+		t.Fatalf("privileges on a schema are not global: %v", privileges)
+	}
+	if all := globalPrivileges([]string{"GRANT ALL PRIVILEGES ON *.* TO 'root'@'localhost' WITH GRANT OPTION"}); !all["SHOW VIEW"] || !all["SUPER"] {
+		t.Fatalf("ALL PRIVILEGES should grant everything: %v", all)
+	}
+}
+
+func TestCheckFiles(t *testing.T) {
+	dir := t.TempDir()
+	confDir := filepath.Join(dir, "conf")
+	if err := os.Mkdir(confDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	results := CheckFiles(&config.Config{ReleemDir: dir, ReleemConfDir: confDir, InstanceType: "local", MysqlConfDir: filepath.Join(dir, "missing")})
+	statuses := make(map[string]Status)
+	for _, result := range results {
+		statuses[result.Check] = result.Status
+	}
+	if statuses["releem_dir"] != Pass || statuses["releem_cnf_dir"] != Pass {
+		t.Fatalf("writable directories should pass: %v", statuses)
+	}
+	if statuses["mysql_cnf_dir"] != Warn || statuses["mysql_restart_service"] != Warn {
+		t.Fatalf("a missing apply setup should warn: %v", statuses)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("the writable check left files behind: %v", entries)
+	}
+
+	results = CheckFiles(&config.Config{ReleemDir: filepath.Join(dir, "missing"), ReleemConfDir: confDir, InstanceType: "aws/rds"})
+	if results[0].Status != Fail || len(results) != 2 {
+		t.Fatalf("a missing releem_dir should fail: %+v", results)
+	}
+}
+
+func TestCheckApi(t *testing.T) {
+	status := http.StatusNotFound
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	if results := CheckApi(context.Background(), server.Client(), server.URL+"/v2/"); results[0].Status != Pass {
+		t.Fatalf("a reachable API should pass: %+v", results)
+	}
+	status = http.StatusBadGateway
+	if results := CheckApi(context.Background(), server.Client(), server.URL+"/v2/"); results[0].Status != Warn {
+		t.Fatalf("an unavailable API should warn: %+v", results)
+	}
+	server.Close()
+	if results := CheckApi(context.Background(), server.Client(), server.URL+"/v2/"); results[0].Status != Fail || results[0].Remediation == "" {
+		t.Fatalf("an unreachable API should fail with a remediation: %+v", results)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/Releem/mysqlconfigurer/api"
 	"github.com/Releem/mysqlconfigurer/config"
 	logging "github.com/google/logger"
 
@@ -16,44 +17,30 @@ type ReleemErrorsRepeater struct {
 }
 
 func (repeater ReleemErrorsRepeater) ProcessErrors(message string) interface{} {
-	var env string
 	bodyReader := strings.NewReader(message)
 
 	repeater.logger.V(5).Info("Result Send data: ", message)
-	var api_domain, domain, subdomain string
-	if repeater.configuration != nil {
-		env = repeater.configuration.Env
-	} else {
-		env = "prod"
-	}
-	switch env {
-	case "dev2":
-		subdomain = "dev2."
-	case "dev":
-		subdomain = "dev."
-	case "stage":
-		subdomain = "stage."
-	default:
-		subdomain = ""
-	}
-	if repeater.configuration.ReleemRegion == "EU" {
-		domain = "eu.releem.com"
-	} else {
-		domain = "releem.com"
-	}
-	api_domain = "https://api.queries." + subdomain + domain + "/v2/events/agent_errors_log"
+	api_domain := api.BaseURL(repeater.configuration) + "events/agent_errors_log"
 
 	req, err := http.NewRequest(http.MethodPost, api_domain, bodyReader)
 	if err != nil {
 		repeater.logger.Error("Request: could not create request: ", err)
 		return nil
 	}
+	var client *http.Client
 	if repeater.configuration != nil {
 		req.Header.Set("x-releem-api-key", repeater.configuration.ApiKey)
+		client, err = api.NewClient(repeater.configuration)
+		if err != nil {
+			repeater.logger.Error("Request: could not create client: ", err)
+			return nil
+		}
+	} else {
+		client = &http.Client{}
 	}
-
-	client := http.Client{
-		Timeout: 30 * time.Second,
+	// Errors are reported synchronously, do not wait as long as for metrics.
+	if client.Timeout == 0 || client.Timeout > 30*time.Second {
+		client.Timeout = 30 * time.Second
 	}
 
 	res, err := client.Do(req)
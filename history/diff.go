@@ -0,0 +1,184 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Change is a variable whose value differs between two configurations. From
+// or To is empty when the variable is only set in the other one.
+type Change struct {
+	Name string `json:"name"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Parse returns the variables of a configuration: a my.cnf or postgresql.conf
+// file, or the JSON object of variables applied to cloud instances. Names are
+// lowercased with dashes replaced by underscores, as SHOW GLOBAL VARIABLES
+// reports them.
+func Parse(content string) (map[string]string, error) {
+	variables := make(map[string]string)
+	if trimmed := strings.TrimSpace(content); strings.HasPrefix(trimmed, "{") {
+		decoder := json.NewDecoder(strings.NewReader(trimmed))
+		decoder.UseNumber()
+		var values map[string]any
+		if err := decoder.Decode(&values); err != nil {
+			return nil, fmt.Errorf("invalid configuration: %w", err)
+		}
+		for name, value := range values {
+			variables[variableName(name)] = fmt.Sprint(value)
+		}
+		return variables, nil
+	}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "[") || strings.HasPrefix(line, "!") {
+			continue
+		}
+		name, value, found := strings.Cut(line, "=")
+		if !found {
+			// Options without a value, e.g. skip-name-resolve, enable a flag.
+			variables[variableName(name)] = "ON"
+			continue
+		}
+		variables[variableName(name)] = optionValue(value)
+	}
+	return variables, nil
+}
+
+func variableName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), "-", "_")
+}
+
+// optionValue returns an option value without its quotes and trailing comment.
+func optionValue(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) > 0 && (value[0] == '\'' || value[0] == '"') {
+		if end := strings.IndexByte(value[1:], value[0]); end >= 0 {
+			return value[1 : end+1]
+		}
+		return value[1:]
+	}
+	if comment := strings.IndexByte(value, '#'); comment >= 0 {
+		value = value[:comment]
+	}
+	return strings.TrimSpace(value)
+}
+
+// Diff returns the variables changed from one configuration to the other,
+// sorted by name.
+func Diff(from, to map[string]string) []Change {
+	names := make(map[string]bool)
+	for name := range from {
+		names[name] = true
+	}
+	for name := range to {
+		names[name] = true
+	}
+	var changes []Change
+	for name := range names {
+		fromValue, inFrom := from[name]
+		toValue, inTo := to[name]
+		if inFrom && inTo && SameValue(fromValue, toValue) {
+			continue
+		}
+		changes = append(changes, Change{Name: name, From: fromValue, To: toValue})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
+
+// LiveValues returns the live values of the variables a configuration sets,
+// to be compared with it: the other live variables are left to their
+// defaults by the configuration.
+func LiveValues(live, configured map[string]string) map[string]string {
+	values := make(map[string]string)
+	for name := range configured {
+		if value, ok := live[name]; ok {
+			values[name] = value
+		}
+	}
+	return values
+}
+
+var sizeValue = regexp.MustCompile(`^([0-9]+)\s*([kmgt])i?b?$`)
+
+// SameValue compares two values as the server would: sizes with a suffix are
+// expanded, numbers compared by value and booleans in any spelling.
+func SameValue(a, b string) bool {
+	return normalizeValue(a) == normalizeValue(b)
+}
+
+func normalizeValue(value string) string {
+	value = strings.ToLower(strings.Trim(strings.TrimSpace(value), `'"`))
+	switch value {
+	case "on", "true", "yes":
+		return "1"
+	case "off", "false", "no":
+		return "0"
+	}
+	if match := sizeValue.FindStringSubmatch(value); match != nil {
+		number, err := strconv.ParseUint(match[1], 10, 64)
+		if err == nil {
+			shift := map[string]uint{"k": 10, "m": 20, "g": 30, "t": 40}[match[2]]
+			return strconv.FormatUint(number<<shift, 10)
+		}
+	}
+	if number, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return strconv.FormatUint(number, 10)
+	}
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		return strconv.FormatFloat(number, 'g', -1, 64)
+	}
+	return value
+}
+
+// LiveVariables returns the global variables of the database server.
+func LiveVariables(ctx context.Context, db *sql.DB, databaseType string) (map[string]string, error) {
+	query := "SHOW GLOBAL VARIABLES"
+	if databaseType == "postgresql" {
+		query = "SELECT name, current_setting(name) FROM pg_settings"
+	}
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	variables := make(map[string]string)
+	for rows.Next() {
+		var name string
+		var value sql.NullString
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		variables[variableName(name)] = value.String
+	}
+	return variables, rows.Err()
+}
+
+// WriteText writes the changes as a table.
+func WriteText(w io.Writer, changes []Change) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No differences.")
+		return
+	}
+	fmt.Fprintf(w, "%-48s %-24s %-24s\n", "VARIABLE", "FROM", "TO")
+	for _, change := range changes {
+		fmt.Fprintf(w, "%-48s %-24s %-24s\n", change.Name, orNone(change.From), orNone(change.To))
+	}
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
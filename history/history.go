@@ -0,0 +1,255 @@
+// Package history keeps every configuration downloaded from the Releem
+// platform and every configuration applied to the database as numbered
+// versions, so that they can be compared and rolled back to.
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+)
+
+// TaskIDEnv is set in the environment of the task commands to the id of the
+// task, so that the configurations they download and apply are recorded with it.
+const TaskIDEnv = "RELEEM_TASK_ID"
+
+// RollbackVersionEnv is set in the environment of the apply script run by a
+// rollback task to the version it applies again.
+const RollbackVersionEnv = "RELEEM_ROLLBACK_VERSION"
+
+// Sources of a version.
+const (
+	// SourceDownload is a configuration downloaded from the platform.
+	SourceDownload = "download"
+	// SourceApply is a configuration applied to the database.
+	SourceApply = "apply"
+	// SourceRollback is a configuration applied again by a rollback task.
+	SourceRollback = "rollback"
+)
+
+// ErrVersionNotFound is returned by Get for a version not in the history.
+var ErrVersionNotFound = errors.New("the configuration version is not in the history")
+
+// Version is a configuration recorded in the history.
+type Version struct {
+	Number int       `json:"version"`
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	// TaskID is the task that downloaded or applied the configuration, 0
+	// when it was not run by a task.
+	TaskID int `json:"task_id,omitempty"`
+	// RollbackOf is the version applied again by a rollback.
+	RollbackOf int    `json:"rollback_of,omitempty"`
+	File       string `json:"file"`
+	Content    string `json:"content"`
+}
+
+// Store keeps the versions of an instance on disk, one file per version.
+type Store struct {
+	dir  string
+	size int
+	now  func() time.Time
+}
+
+// NewStore returns the configuration history of an instance under ReleemDir.
+func NewStore(configuration *config.Config) *Store {
+	return &Store{dir: Dir(configuration), size: configuration.ConfigHistorySize, now: time.Now}
+}
+
+// Dir returns the configuration history directory of an instance under ReleemDir.
+func Dir(configuration *config.Config) string {
+	return config.InstanceDir(configuration, "config_history")
+}
+
+// ConfigFileName returns the name of the configuration file recommended for
+// the database type of an instance.
+func ConfigFileName(configuration *config.Config) string {
+	if configuration.GetDatabaseType() == "postgresql" {
+		return "z_aiops_postgresql.conf"
+	}
+	return "z_aiops_mysql.cnf"
+}
+
+// IsCloudInstance reports whether the configuration of an instance is applied
+// through the API of its cloud provider rather than a configuration file.
+func IsCloudInstance(configuration *config.Config) bool {
+	switch configuration.InstanceType {
+	case "aws/rds", "gcp/cloudsql", "azure/mysql":
+		return true
+	}
+	return false
+}
+
+// AppliedFile returns the configuration applied to the database: the file
+// copied to mysql_cnf_dir or pg_cnf_dir for local instances, the variables
+// downloaded to releem_cnf_dir for cloud instances.
+func AppliedFile(configuration *config.Config) string {
+	dir := configuration.MysqlConfDir
+	switch {
+	case IsCloudInstance(configuration):
+		dir = configuration.ReleemConfDir
+	case configuration.GetDatabaseType() == "postgresql":
+		dir = configuration.PgConfDir
+	}
+	return filepath.Join(dir, ConfigFileName(configuration))
+}
+
+func (store *Store) path(number int) string {
+	return filepath.Join(store.dir, strconv.Itoa(number)+".json")
+}
+
+// Record adds a version numbered after the latest one. A download identical
+// to the latest version is not recorded again: the latest version is returned.
+// The oldest versions beyond config_history_size are dropped.
+func (store *Store) Record(version Version) (Version, error) {
+	if err := os.MkdirAll(store.dir, 0700); err != nil {
+		return Version{}, fmt.Errorf("failed to create the configuration history: %w", err)
+	}
+	numbers, err := store.numbers()
+	if err != nil {
+		return Version{}, err
+	}
+	next := 1
+	if len(numbers) > 0 {
+		latest, err := store.Get(numbers[len(numbers)-1])
+		if err != nil {
+			return Version{}, err
+		}
+		if version.Source == SourceDownload && latest.Content == version.Content && latest.File == version.File {
+			return latest, nil
+		}
+		next = latest.Number + 1
+	}
+	version.Time = store.now().UTC()
+	tmp, err := os.CreateTemp(store.dir, "version-*.tmp")
+	if err != nil {
+		return Version{}, fmt.Errorf("failed to record the configuration: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Close()
+	// Versions recorded by another agent process at the same time take the
+	// next numbers: the link fails when the number is taken.
+	for ; ; next++ {
+		version.Number = next
+		data, err := json.Marshal(version)
+		if err != nil {
+			return Version{}, err
+		}
+		if err := os.WriteFile(tmp.Name(), data, 0600); err != nil {
+			return Version{}, fmt.Errorf("failed to record the configuration: %w", err)
+		}
+		err = os.Link(tmp.Name(), store.path(next))
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return Version{}, fmt.Errorf("failed to record the configuration: %w", err)
+		}
+	}
+	store.prune()
+	return version, nil
+}
+
+// RecordApplied adds the configuration applied to the database to the
+// history as version, whose source and task are set by the caller. A
+// configuration file removed by a rollback is recorded with no content.
+func (store *Store) RecordApplied(configuration *config.Config, version Version) (Version, error) {
+	file := AppliedFile(configuration)
+	if filepath.Dir(file) == "." {
+		return Version{}, errors.New("the configuration directory of the database is not set")
+	}
+	content, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Version{}, err
+	}
+	version.File, version.Content = ConfigFileName(configuration), string(content)
+	return store.Record(version)
+}
+
+// EnvironmentVersion returns a version from source applied by a task command.
+// The task and the version rolled back to are read from the environment the
+// task commands run in.
+func EnvironmentVersion(source string) Version {
+	version := Version{Source: source}
+	version.TaskID, _ = strconv.Atoi(os.Getenv(TaskIDEnv))
+	if rollbackOf, err := strconv.Atoi(os.Getenv(RollbackVersionEnv)); err == nil {
+		version.Source, version.RollbackOf = SourceRollback, rollbackOf
+	}
+	return version
+}
+
+// Get returns a version.
+func (store *Store) Get(number int) (Version, error) {
+	data, err := os.ReadFile(store.path(number))
+	if errors.Is(err, os.ErrNotExist) {
+		return Version{}, fmt.Errorf("version %d: %w", number, ErrVersionNotFound)
+	}
+	if err != nil {
+		return Version{}, err
+	}
+	var version Version
+	if err := json.Unmarshal(data, &version); err != nil {
+		return Version{}, fmt.Errorf("corrupt configuration version %d: %w", number, err)
+	}
+	return version, nil
+}
+
+// List returns the versions, oldest first.
+func (store *Store) List() ([]Version, error) {
+	numbers, err := store.numbers()
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]Version, 0, len(numbers))
+	for _, number := range numbers {
+		version, err := store.Get(number)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+func (store *Store) numbers() ([]int, error) {
+	files, err := os.ReadDir(store.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var numbers []int
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file.Name(), ".json")
+		number, err := strconv.Atoi(name)
+		if !ok || err != nil {
+			continue
+		}
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+func (store *Store) prune() {
+	if store.size <= 0 {
+		return
+	}
+	numbers, err := store.numbers()
+	if err != nil {
+		return
+	}
+	for len(numbers) > store.size {
+		os.Remove(store.path(numbers[0]))
+		numbers = numbers[1:]
+	}
+}
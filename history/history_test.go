@@ -0,0 +1,92 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Releem/mysqlconfigurer/config"
+)
+
+func TestStoreNumbersVersions(t *testing.T) {
+	store := NewStore(&config.Config{ReleemDir: t.TempDir(), ConfigHistorySize: 3})
+	first, err := store.Record(Version{Source: SourceDownload, TaskID: 4, File: "z_aiops_mysql.cnf", Content: "[mysqld]\nmax_connections=200\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := store.Record(Version{Source: SourceDownload, File: "z_aiops_mysql.cnf", Content: "[mysqld]\nmax_connections=200\n"})
+	if err != nil || again.Number != first.Number {
+		t.Fatalf("Record = %+v, %v, want the identical download not recorded again", again, err)
+	}
+	applied, err := store.Record(Version{Source: SourceApply, TaskID: 4, File: "z_aiops_mysql.cnf", Content: "[mysqld]\nmax_connections=200\n"})
+	if err != nil || applied.Number != first.Number+1 {
+		t.Fatalf("Record = %+v, %v, want every apply recorded", applied, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := store.Record(Version{Source: SourceApply, File: "z_aiops_mysql.cnf"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	versions, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || versions[0].Number != 3 || versions[2].Number != 5 {
+		t.Fatalf("List = %+v, want the 3 latest versions", versions)
+	}
+	if _, err := store.Get(first.Number); !errors.Is(err, ErrVersionNotFound) {
+		t.Fatalf("Get = %v, want the oldest version dropped", err)
+	}
+}
+
+func TestRecordAppliedReadsTheAppliedFile(t *testing.T) {
+	configuration := &config.Config{ReleemDir: t.TempDir(), MysqlConfDir: t.TempDir()}
+	if err := os.WriteFile(filepath.Join(configuration.MysqlConfDir, "z_aiops_mysql.cnf"), []byte("[mysqld]\nthread_cache_size=16\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(TaskIDEnv, "12")
+	t.Setenv(RollbackVersionEnv, "2")
+	version, err := NewStore(configuration).RecordApplied(configuration, EnvironmentVersion(SourceApply))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version.Source != SourceRollback || version.TaskID != 12 || version.RollbackOf != 2 || version.Content != "[mysqld]\nthread_cache_size=16\n" {
+		t.Fatalf("unexpected version %+v", version)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for content, want := range map[string]map[string]string{
+		"### Releem\n[mysqld]\ninnodb-buffer-pool-size = 1G\nskip-name-resolve\nsql_mode = 'STRICT_TRANS_TABLES' # strict\n": {
+			"innodb_buffer_pool_size": "1G", "skip_name_resolve": "ON", "sql_mode": "STRICT_TRANS_TABLES",
+		},
+		"shared_buffers = '128MB'\nwork_mem = 4MB # per sort\n": {"shared_buffers": "128MB", "work_mem": "4MB"},
+		`{"max_connections": 200, "slow_query_log": "ON"}`:      {"max_connections": "200", "slow_query_log": "ON"},
+	} {
+		got, err := Parse(content)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("Parse(%q) = %v, %v, want %v", content, got, err, want)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	from := map[string]string{"innodb_buffer_pool_size": "1G", "slow_query_log": "ON", "max_connections": "151", "tmp_table_size": "16M"}
+	to := map[string]string{"innodb_buffer_pool_size": "1073741824", "slow_query_log": "1", "max_connections": "200", "thread_cache_size": "16"}
+	want := []Change{
+		{Name: "max_connections", From: "151", To: "200"},
+		{Name: "thread_cache_size", To: "16"},
+		{Name: "tmp_table_size", From: "16M"},
+	}
+	if got := Diff(from, to); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Diff = %+v, want %+v", got, want)
+	}
+
+	live := map[string]string{"max_connections": "151", "innodb_buffer_pool_size": "134217728", "version": "8.0.36"}
+	if got := LiveValues(live, to); !reflect.DeepEqual(got, map[string]string{"max_connections": "151", "innodb_buffer_pool_size": "134217728"}) {
+		t.Fatalf("LiveValues = %v", got)
+	}
+}
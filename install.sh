@@ -1095,7 +1095,9 @@ then
     if [ -n "$RELEEM_API_KEY" ]; then
         apikey=$RELEEM_API_KEY
     elif test -f "$RELEEM_CONF_FILE" ; then
-        . "$RELEEM_CONF_FILE"
+        # Only the top-level settings are shell assignments, not the instance
+        # blocks and maps.
+        eval "$(awk '/^[[:space:]]*#/ { next } { opens = gsub(/\{/, "{"); closes = gsub(/\}/, "}"); if (depth == 0 && opens == 0) print; depth += opens - closes }' "$RELEEM_CONF_FILE")"
     fi
     if [ -n "$apikey" ] && [ -x "$RELEEM_WORKDIR/releem-agent" ]; then
         $RELEEM_WORKDIR/releem-agent --event=agent_uninstall > /dev/null || true
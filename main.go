@@ -3,11 +3,17 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"runtime"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Releem/daemon"
@@ -18,6 +24,7 @@ import (
 	"github.com/Releem/mysqlconfigurer/metrics/system"
 	"github.com/Releem/mysqlconfigurer/models"
 	r "github.com/Releem/mysqlconfigurer/repeater"
+	"github.com/Releem/mysqlconfigurer/tasks"
 	"github.com/Releem/mysqlconfigurer/utils"
 
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
@@ -38,13 +45,20 @@ const (
 
 var logger logging.Logger
 var SetConfigRun, GetConfigRun, InitialConfigRun *bool
-var ConfigFile, AgentEvent, AgentTask *string
+var ConfigFile, AgentEvent, AgentTask, AgentInstance *string
 
 // Service has embedded daemon
 type Service struct {
 	daemon.Daemon
 }
-type Programm struct{}
+type Programm struct {
+	mutex           sync.Mutex
+	cancel          context.CancelFunc
+	done            chan struct{}
+	shutdownTimeout time.Duration
+}
+
+var errDoctorFailed = errors.New("a doctor check failed")
 
 func exitRunWithError(args ...interface{}) {
 	logger.Error(args...)
@@ -53,6 +67,19 @@ func exitRunWithError(args ...interface{}) {
 
 func (programm *Programm) Stop() {
 	// Stop should not block. Return with a few seconds.
+	// The agent is given its shutdown grace period to finish in-flight work.
+	programm.mutex.Lock()
+	cancel, done, timeout := programm.cancel, programm.done, programm.shutdownTimeout
+	programm.mutex.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(timeout + 10*time.Second):
+		logger.Warning("The agent did not stop in time")
+	}
 }
 
 func (programm *Programm) Start() {
@@ -61,8 +88,6 @@ func (programm *Programm) Start() {
 }
 
 func (programm *Programm) Run() {
-	// var gatherers map[string][]models.MetricsGatherer
-	gatherers := make(map[string][]models.MetricsGatherer)
 	var Mode models.ModeType
 
 	// Do something, call your goroutines, etc
@@ -73,6 +98,14 @@ func (programm *Programm) Run() {
 	}
 	defer utils.HandlePanic(configuration, logger)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	defer close(done)
+	programm.mutex.Lock()
+	programm.cancel, programm.done, programm.shutdownTimeout = cancel, done, configuration.ShutdownTimeout*time.Second
+	programm.mutex.Unlock()
+
 	if configuration.Debug {
 		logger.SetLevel(10)
 	} else {
@@ -97,6 +130,110 @@ func (programm *Programm) Run() {
 			Mode.Type = "Default"
 		}
 	}
+
+	instances, err := selectInstances(configuration, *AgentInstance)
+	if err != nil {
+		exitRunWithError(err)
+	}
+	if *AgentInstance != "" && Mode.Name == "Configurations" && Mode.Type == "Default" {
+		exitRunWithError("--instance is for one-shot runs, the agent service runs for every instance")
+	}
+
+	if Mode.Name == "TaskByName" || (Mode.Name == "Configurations" && Mode.Type != "Default") {
+		locks, err := lockTasks(ctx, instances, Mode)
+		if err != nil {
+			exitRunWithError("The agent cannot run while another task is running: ", err)
+		}
+		defer func() {
+			for _, lock := range locks {
+				lock.Release()
+			}
+		}()
+	}
+
+	reloader := newReloader(*ConfigFile, configuration)
+	defer reloader.close()
+	for _, instanceConfiguration := range instances {
+		if instanceConfiguration.InstanceName != "" {
+			logger.Info("Initializing pipeline for instance ", instanceConfiguration.InstanceName)
+		}
+		loaded := *instanceConfiguration
+		pipeline, closers, err := newPipeline(instanceConfiguration, nil)
+		if err != nil {
+			closeAll(closers)
+			reloader.close()
+			exitRunWithError(err)
+		}
+		reloader.add(pipeline, &loaded, closers)
+	}
+	pipelines := reloader.pipelines
+	var servers []*http.Server
+	if configuration.PrometheusListen != "" && Mode.Type == "Default" {
+		servers = append(servers, metrics.ServePrometheus(configuration.PrometheusListen, pipelines, logger))
+	}
+	if configuration.StatusListen != "" && Mode.Type == "Default" {
+		if server, err := metrics.ServeStatus(configuration.StatusListen, pipelines, logger); err != nil {
+			logger.Error(err)
+		} else {
+			servers = append(servers, server)
+		}
+	}
+	if Mode.Name == "Configurations" && Mode.Type == "Default" {
+		go reloader.watch(ctx)
+	}
+	err = metrics.RunWorker(ctx, pipelines, logger, Mode, configuration.ShutdownTimeout*time.Second)
+	for _, server := range servers {
+		server.Close()
+	}
+	if err != nil {
+		exitRunWithError(err)
+	}
+}
+
+// selectInstances returns the instances the agent runs for: the one named by
+// --instance, or every instance of the configuration.
+func selectInstances(configuration *config.Config, name string) ([]*config.Config, error) {
+	if name == "" {
+		return configuration.GetInstances(), nil
+	}
+	var names []string
+	for _, instance := range configuration.GetInstances() {
+		if instance.InstanceName == name {
+			return []*config.Config{instance}, nil
+		}
+		names = append(names, instance.InstanceName)
+	}
+	return nil, fmt.Errorf("unknown instance %q, configured instances: %s", name, strings.Join(names, ", "))
+}
+
+// lockTasks takes the task lock of every instance for a one-shot run changing
+// the configuration, so that it does not race with a task of the agent. The
+// runs started by a task, which already holds the lock, do not take it.
+func lockTasks(ctx context.Context, instances []*config.Config, Mode models.ModeType) ([]*tasks.TaskLock, error) {
+	if os.Getenv(tasks.TaskLockEnv) != "" {
+		return nil, nil
+	}
+	var locks []*tasks.TaskLock
+	for _, instanceConfiguration := range instances {
+		lock, err := tasks.AcquireTaskLock(ctx, instanceConfiguration, Mode.Name+" "+Mode.Type)
+		if err != nil {
+			for _, lock := range locks {
+				lock.Release()
+			}
+			return nil, err
+		}
+		locks = append(locks, lock)
+	}
+	return locks, nil
+}
+
+// newPipeline builds the gatherers and repeaters of one database instance,
+// connecting to it unless an instance is given. The returned closers must be
+// closed once the pipeline is stopped, also when an error is returned.
+func newPipeline(configuration *config.Config, instance *models.Instance) (*metrics.Pipeline, []io.Closer, error) {
+	gatherers := make(map[string][]models.MetricsGatherer)
+	var closers []io.Closer
+
 	// if Mode.Name != "Event" {
 	// Select how we collect instance metrics depending on InstanceType
 	switch configuration.InstanceType {
@@ -106,7 +243,7 @@ func (programm *Programm) Run() {
 
 		awscfg, err := awsconfig.LoadDefaultConfig(context.TODO(), awsconfig.WithRegion(configuration.AwsRegion))
 		if err != nil {
-			exitRunWithError("Load AWS configuration FAILED", err)
+			return nil, closers, fmt.Errorf("Load AWS configuration FAILED: %w", err)
 		} else {
 			logger.Info("AWS configuration loaded SUCCESS")
 		}
@@ -125,7 +262,7 @@ func (programm *Programm) Run() {
 		result, err := rdsclient.DescribeDBInstances(context.TODO(), input)
 
 		if err != nil {
-			exitRunWithError(err.Error())
+			return nil, closers, err
 		}
 
 		// Request detailed instance info
@@ -135,9 +272,9 @@ func (programm *Programm) Run() {
 			gatherers["default"] = append(gatherers["default"], system.NewAWSRDSEnhancedMetricsGatherer(logger, result.DBInstances[0], cwlogsclient, configuration))
 			logger.Info("AWS RDS DB instance found: ", configuration.AwsRDSDB)
 		} else if result != nil && len(result.DBInstances) > 1 {
-			exitRunWithError("RDS.DescribeDBInstances: Database has ", len(result.DBInstances), " instances. Clusters are not supported")
+			return nil, closers, fmt.Errorf("RDS.DescribeDBInstances: Database has %d instances. Clusters are not supported", len(result.DBInstances))
 		} else {
-			exitRunWithError("RDS.DescribeDBInstances: No instances")
+			return nil, closers, errors.New("RDS.DescribeDBInstances: No instances")
 		}
 	case "gcp/cloudsql":
 		logger.Info("InstanceType is gcp/cloudsql")
@@ -149,20 +286,20 @@ func (programm *Programm) Run() {
 		// Create monitoring client
 		monitoringClient, err := monitoring.NewMetricClient(ctx)
 		if err != nil {
-			exitRunWithError("Failed to create GCP monitoring client", err)
+			return nil, closers, fmt.Errorf("Failed to create GCP monitoring client: %w", err)
 		}
-		defer monitoringClient.Close()
+		closers = append(closers, monitoringClient)
 
 		// Create SQL Admin client
 		sqlAdminService, err := sqladmin.NewService(ctx)
 		if err != nil {
-			exitRunWithError("Failed to create GCP SQL Admin client", err)
+			return nil, closers, fmt.Errorf("Failed to create GCP SQL Admin client: %w", err)
 		}
 		logger.Info("GSP configuration loaded SUCCESS")
 		// Get instance details
 		instance, err := sqlAdminService.Instances.Get(configuration.GcpProjectId, configuration.GcpCloudSqlInstance).Do()
 		if err != nil {
-			exitRunWithError("Failed to get Cloud SQL instance details", err)
+			return nil, closers, fmt.Errorf("Failed to get Cloud SQL instance details: %w", err)
 		}
 
 		logger.Info("GCP Cloud SQL instance found: ", instance.Name)
@@ -187,7 +324,7 @@ func (programm *Programm) Run() {
 			configuration.MysqlHost = connectionIP
 			logger.Info("Using following IP for Cloud SQL connection: ", connectionIP)
 		} else {
-			exitRunWithError("No IP addresses found for Cloud SQL instance")
+			return nil, closers, errors.New("No IP addresses found for Cloud SQL instance")
 		}
 
 		// Add GCP gatherer
@@ -199,17 +336,17 @@ func (programm *Programm) Run() {
 
 		credential, err := azidentity.NewDefaultAzureCredential(nil)
 		if err != nil {
-			exitRunWithError("Failed to create Azure credential", err)
+			return nil, closers, fmt.Errorf("Failed to create Azure credential: %w", err)
 		}
 
 		azureGatherer := system.NewAzureMySQLEnhancedMetricsGatherer(logger, credential, configuration)
 		instance, err := azureGatherer.GetServer(context.Background())
 		if err != nil {
-			exitRunWithError("Failed to get Azure Database for MySQL server details", err)
+			return nil, closers, fmt.Errorf("Failed to get Azure Database for MySQL server details: %w", err)
 		}
 
 		if instance.Properties == nil || instance.Properties.FullyQualifiedDomainName == nil || *instance.Properties.FullyQualifiedDomainName == "" {
-			exitRunWithError("Azure Database for MySQL server has no fully qualified domain name")
+			return nil, closers, errors.New("Azure Database for MySQL server has no fully qualified domain name")
 		}
 		configuration.Hostname = configuration.AzureMySQLServer
 		configuration.MysqlHost = *instance.Properties.FullyQualifiedDomainName
@@ -226,8 +363,10 @@ func (programm *Programm) Run() {
 
 	// Initialize database connection based on database type
 	dbType := configuration.GetDatabaseType()
-	models.DB = utils.ConnectionDatabase(configuration, logger, "")
-	defer models.DB.Close()
+	if instance == nil {
+		instance = models.NewInstance(utils.ConnectionDatabase(configuration, logger, ""))
+		closers = append(closers, instance.DB)
+	}
 
 	//Init repeaters
 	// repeaters := make(map[string]models.MetricsRepeater)
@@ -241,23 +380,26 @@ func (programm *Programm) Run() {
 	// repeaters["QueryOptimization"] = models.MetricsRepeater(r.NewReleemConfigurationsRepeater(configuration, models.Mode{Name: "Metrics", Type: "QuerysOptimization"}))
 	// repeaters["DatabaseSchema"] = models.MetricsRepeater(r.NewReleemConfigurationsRepeater(configuration, models.Mode{Name: "TaskSet", Type: "queries_optimization"}))
 	//var repeaters models.MetricsRepeater
-	repeaters := models.MetricsRepeater(r.NewReleemConfigurationsRepeater(configuration, logger))
+	repeaters, err := r.NewMetricsRepeater(configuration, logger)
+	if err != nil {
+		return nil, closers, errors.New("Failed to initialize repeaters: " + err.Error())
+	}
 
 	//Init gatherers based on database type
 	switch dbType {
 	case "postgresql":
 		gatherers["default"] = append(gatherers["default"],
-			postgresql.NewDBConfGatherer(logger, configuration),
-			postgresql.NewDBInfoBaseGatherer(logger, configuration),
-			postgresql.NewDBInfoGatherer(logger, configuration),
-			postgresql.NewDBMetricsBaseGatherer(logger, configuration),
-			metrics.NewAgentMetricsGatherer(logger, configuration))
+			postgresql.NewDBConfGatherer(logger, instance, configuration),
+			postgresql.NewDBInfoBaseGatherer(logger, instance, configuration),
+			postgresql.NewDBInfoGatherer(logger, instance, configuration),
+			postgresql.NewDBMetricsBaseGatherer(logger, instance, configuration),
+			metrics.NewAgentMetricsGatherer(logger, instance, configuration))
 
 		gatherers["metrics"] = []models.MetricsGatherer{}
 
-		gatherers["configuration"] = append(gatherers["configuration"], postgresql.NewDBMetricsConfigGatherer(logger, configuration))
+		gatherers["configuration"] = append(gatherers["configuration"], postgresql.NewDBMetricsConfigGatherer(logger, instance, configuration))
 
-		gatherers["query_optimization"] = append(gatherers["query_optimization"], postgresql.NewDBCollectQueriesOptimization(logger, configuration))
+		gatherers["query_optimization"] = append(gatherers["query_optimization"], postgresql.NewDBCollectQueriesOptimization(logger, instance, configuration))
 
 		gatherers["sample_queries"] = []models.MetricsGatherer{}
 
@@ -265,26 +407,25 @@ func (programm *Programm) Run() {
 		fallthrough
 	default:
 		gatherers["default"] = append(gatherers["default"],
-			mysql.NewDBConfGatherer(logger, configuration),
-			mysql.NewDBInfoGatherer(logger, configuration),
-			mysql.NewDBMetricsBaseGatherer(logger, configuration),
-			metrics.NewAgentMetricsGatherer(logger, configuration))
+			mysql.NewDBConfGatherer(logger, instance, configuration),
+			mysql.NewDBInfoGatherer(logger, instance, configuration),
+			mysql.NewDBMetricsBaseGatherer(logger, instance, configuration),
+			metrics.NewAgentMetricsGatherer(logger, instance, configuration))
 
-		gatherers["metrics"] = append(gatherers["metrics"], mysql.NewDBMetricsGatherer(logger, configuration))
+		gatherers["metrics"] = append(gatherers["metrics"], mysql.NewDBMetricsGatherer(logger, instance, configuration))
 
-		gatherers["configuration"] = append(gatherers["configuration"], mysql.NewDBMetricsConfigGatherer(logger, configuration))
+		gatherers["configuration"] = append(gatherers["configuration"], mysql.NewDBMetricsConfigGatherer(logger, instance, configuration))
 
-		gatherers["query_optimization"] = append(gatherers["query_optimization"], mysql.NewDBCollectQueriesOptimization(logger, configuration))
+		gatherers["query_optimization"] = append(gatherers["query_optimization"], mysql.NewDBCollectQueriesOptimization(logger, instance, configuration))
 
-		gatherers["sample_queries"] = append(gatherers["sample_queries"], mysql.NewDBCollectSampleQueriesGatherer(logger, configuration))
+		gatherers["sample_queries"] = append(gatherers["sample_queries"], mysql.NewDBCollectSampleQueriesGatherer(logger, instance, configuration))
 	}
-	metrics.RunWorker(gatherers, repeaters, logger, configuration, Mode)
-
+	return metrics.NewPipeline(instance, gatherers, repeaters, configuration), closers, nil
 }
 
 // Manage by daemon commands or run the daemon
 func (service *Service) Manage(command []string) (string, error) {
-	usage := "Usage: myservice install | remove | start | stop | status"
+	usage := "Usage: myservice install | remove | start | stop | status | doctor [-json] | collect [-groups default,metrics] [-instance name] [-output file] [-compact] [-sizes] | config-history [-instance name] [-json] | config-diff [-instance name] [-json] FROM [TO]"
 	// if received any kind of command, do it
 	if len(command) >= 1 {
 		switch command[0] {
@@ -298,6 +439,14 @@ func (service *Service) Manage(command []string) (string, error) {
 			return service.Stop()
 		case "status":
 			return service.Status()
+		case "doctor":
+			return runDoctor(command[1:])
+		case "collect":
+			return runCollect(command[1:])
+		case "config-history":
+			return runConfigHistory(command[1:])
+		case "config-diff":
+			return runConfigDiff(command[1:])
 		default:
 			return usage, nil
 		}
@@ -353,6 +502,7 @@ func main() {
 	ConfigFile = flag.String("config", defaultPath, "Path to the configuration file (default: \""+defaultPath+"\")")
 	AgentEvent = flag.String("event", "", "Run Releem agent to handle event")
 	AgentTask = flag.String("task", "", "Run Releem agent to execute task")
+	AgentInstance = flag.String("instance", os.Getenv(tasks.InstanceEnv), "Run Releem agent for one instance of the configuration")
 	flag.Parse()
 	command := flag.Args()
 
@@ -1,6 +1,10 @@
 package main
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/Releem/mysqlconfigurer/config"
+)
 
 func TestShouldRunOneShotMode(t *testing.T) {
 	tests := []struct {
@@ -65,3 +69,16 @@ func TestShouldRunOneShotMode(t *testing.T) {
 		})
 	}
 }
+
+func TestSelectInstances(t *testing.T) {
+	configuration := &config.Config{Instances: []*config.Config{{InstanceName: "primary"}, {InstanceName: "mysql-3307"}}}
+	if instances, err := selectInstances(configuration, ""); err != nil || len(instances) != 2 {
+		t.Fatalf("selectInstances = %d instances, %v, want every instance", len(instances), err)
+	}
+	if instances, err := selectInstances(configuration, "mysql-3307"); err != nil || len(instances) != 1 || instances[0].InstanceName != "mysql-3307" {
+		t.Fatalf("selectInstances = %v, %v, want the named instance", instances, err)
+	}
+	if _, err := selectInstances(configuration, "mysql-3308"); err == nil {
+		t.Fatal("selectInstances accepted an unknown instance")
+	}
+}
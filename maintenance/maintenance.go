@@ -0,0 +1,170 @@
+// Package maintenance parses the maintenance windows restarts are deferred to.
+package maintenance
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	// Time zones are looked up on hosts without a zoneinfo database as well.
+	_ "time/tzdata"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Window is a weekly time range: it opens on its days at start and lasts
+// until end, the next day when end is not after start.
+type Window struct {
+	days  [7]bool
+	start int // minutes after midnight
+	end   int
+}
+
+// Schedule is a set of weekly windows in a time zone.
+type Schedule struct {
+	windows  []Window
+	location *time.Location
+}
+
+// Parse returns the schedule of the windows, each written as DAYS HH:MM-HH:MM:
+// DAYS is * for every day, a day (Sat), a range (Mon-Fri) or a list of them
+// (Sat,Sun). The times are in timezone, an IANA time zone name, or in the
+// local time of the host when it is empty.
+func Parse(windows []string, timezone string) (*Schedule, error) {
+	location := time.Local
+	if timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %w", timezone, err)
+		}
+	}
+	schedule := &Schedule{location: location}
+	for _, spec := range windows {
+		window, err := parseWindow(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+		}
+		schedule.windows = append(schedule.windows, window)
+	}
+	return schedule, nil
+}
+
+func parseWindow(spec string) (Window, error) {
+	var window Window
+	fields := strings.Fields(spec)
+	if len(fields) != 2 {
+		return window, fmt.Errorf("expected DAYS HH:MM-HH:MM")
+	}
+	for _, days := range strings.Split(strings.ToLower(fields[0]), ",") {
+		if days == "*" {
+			window.days = [7]bool{true, true, true, true, true, true, true}
+			continue
+		}
+		first, last, isRange := strings.Cut(days, "-")
+		from, ok := weekdays[first]
+		if !ok {
+			return window, fmt.Errorf("unknown day %q", first)
+		}
+		to := from
+		if isRange {
+			if to, ok = weekdays[last]; !ok {
+				return window, fmt.Errorf("unknown day %q", last)
+			}
+		}
+		for day := from; ; day = (day + 1) % 7 {
+			window.days[day] = true
+			if day == to {
+				break
+			}
+		}
+	}
+	start, end, ok := strings.Cut(fields[1], "-")
+	if !ok {
+		return window, fmt.Errorf("expected a time range HH:MM-HH:MM")
+	}
+	var err error
+	if window.start, err = parseClock(start); err != nil {
+		return window, err
+	}
+	if window.end, err = parseClock(end); err != nil {
+		return window, err
+	}
+	return window, nil
+}
+
+func parseClock(clock string) (int, error) {
+	hours, minutes, ok := strings.Cut(clock, ":")
+	h, err := strconv.Atoi(hours)
+	if !ok || err != nil || h < 0 || h > 24 {
+		return 0, fmt.Errorf("invalid time %q", clock)
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q", clock)
+	}
+	return h*60 + m, nil
+}
+
+// Empty reports whether the schedule has no window, restarts being never
+// deferred then.
+func (schedule *Schedule) Empty() bool {
+	return len(schedule.windows) == 0
+}
+
+// opening returns when a window opens and closes on the day of date.
+func (schedule *Schedule) opening(window Window, date time.Time) (time.Time, time.Time) {
+	year, month, day := date.Date()
+	end := window.end
+	if end <= window.start {
+		end += 24 * 60
+	}
+	return time.Date(year, month, day, 0, window.start, 0, 0, schedule.location),
+		time.Date(year, month, day, 0, end, 0, 0, schedule.location)
+}
+
+// Contains reports whether t is inside a window.
+func (schedule *Schedule) Contains(t time.Time) bool {
+	local := t.In(schedule.location)
+	for _, window := range schedule.windows {
+		// A window opened the day before may still be open.
+		for _, date := range []time.Time{local.AddDate(0, 0, -1), local} {
+			if !window.days[date.Weekday()] {
+				continue
+			}
+			start, end := schedule.opening(window, date)
+			if !t.Before(start) && t.Before(end) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Next returns t when it is inside a window, otherwise when the next window
+// opens. It returns the zero time for an empty schedule.
+func (schedule *Schedule) Next(t time.Time) time.Time {
+	if schedule.Contains(t) {
+		return t
+	}
+	var next time.Time
+	local := t.In(schedule.location)
+	for _, window := range schedule.windows {
+		for days := 0; days <= 7; days++ {
+			date := local.AddDate(0, 0, days)
+			if !window.days[date.Weekday()] {
+				continue
+			}
+			if start, _ := schedule.opening(window, date); start.After(t) {
+				if next.IsZero() || start.Before(next) {
+					next = start
+				}
+				break
+			}
+		}
+	}
+	return next
+}
@@ -0,0 +1,60 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleWindows(t *testing.T) {
+	schedule, err := Parse([]string{"Sat,Sun 02:00-04:00", "Mon-Fri 23:30-00:30"}, "Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.October, day, hour, minute, 0, 0, berlin)
+	}
+
+	// 2026-10-17 is a Saturday.
+	for when, want := range map[time.Time]bool{
+		at(17, 2, 0):   true,
+		at(17, 3, 59):  true,
+		at(17, 4, 0):   false,
+		at(16, 23, 45): true,
+		at(17, 0, 15):  true, // opened on Friday
+		at(18, 0, 15):  false,
+		at(19, 12, 0):  false,
+	} {
+		if got := schedule.Contains(when); got != want {
+			t.Errorf("Contains(%s) = %t, want %t", when, got, want)
+		}
+	}
+
+	for when, want := range map[time.Time]time.Time{
+		at(17, 3, 0):  at(17, 3, 0),
+		at(17, 12, 0): at(18, 2, 0),
+		at(18, 5, 0):  at(19, 23, 30),
+		at(19, 8, 0):  at(19, 23, 30),
+	} {
+		if got := schedule.Next(when); !got.Equal(want) {
+			t.Errorf("Next(%s) = %s, want %s", when, got, want)
+		}
+	}
+	if utc := time.Date(2026, time.October, 17, 1, 30, 0, 0, time.UTC); !schedule.Contains(utc) {
+		t.Errorf("Contains(%s) = false, want the window checked in its time zone", utc)
+	}
+}
+
+func TestParseRejectsInvalidWindows(t *testing.T) {
+	for _, window := range []string{"Sat", "Funday 02:00-04:00", "Sat 2-4", "Sat 25:00-26:00", "Sat 02:00-04:60"} {
+		if _, err := Parse([]string{window}, ""); err == nil {
+			t.Errorf("Parse(%q) succeeded", window)
+		}
+	}
+	if _, err := Parse(nil, "Mars/Olympus"); err == nil {
+		t.Error("Parse accepted an unknown time zone")
+	}
+	if schedule, err := Parse(nil, ""); err != nil || !schedule.Empty() || !schedule.Next(time.Now()).IsZero() {
+		t.Errorf("Parse(nil) = %v, %v, want an empty schedule", schedule, err)
+	}
+}
@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"context"
 	"runtime"
 
 	"github.com/Releem/mysqlconfigurer/config"
@@ -11,17 +12,19 @@ import (
 
 type AgentMetricsGatherer struct {
 	logger        logging.Logger
+	instance      *models.Instance
 	configuration *config.Config
 }
 
-func NewAgentMetricsGatherer(logger logging.Logger, configuration *config.Config) *AgentMetricsGatherer {
+func NewAgentMetricsGatherer(logger logging.Logger, instance *models.Instance, configuration *config.Config) *AgentMetricsGatherer {
 	return &AgentMetricsGatherer{
 		logger:        logger,
+		instance:      instance,
 		configuration: configuration,
 	}
 }
 
-func (Agent *AgentMetricsGatherer) GetMetrics(metrics *models.Metrics) error {
+func (Agent *AgentMetricsGatherer) GetMetrics(ctx context.Context, metrics *models.Metrics) error {
 	defer utils.HandlePanic(Agent.configuration, Agent.logger)
 
 	output := make(map[string]interface{})
@@ -30,10 +33,11 @@ func (Agent *AgentMetricsGatherer) GetMetrics(metrics *models.Metrics) error {
 		output["Hostname"] = Agent.configuration.Hostname
 	}
 	output["QueryOptimization"] = Agent.configuration.QueryOptimization
+	output["ReadOnlyAgent"] = Agent.configuration.ReadOnlyAgent
 
-	models.SampleQueriesMutex.RLock()
-	output["SampleQueriesCount"] = len(models.SampleQueries)
-	models.SampleQueriesMutex.RUnlock()
+	Agent.instance.SampleQueriesMutex.RLock()
+	output["SampleQueriesCount"] = len(Agent.instance.SampleQueries)
+	Agent.instance.SampleQueriesMutex.RUnlock()
 
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
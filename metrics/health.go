@@ -0,0 +1,253 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/models"
+)
+
+// GathererHealth describes the recent activity of one gatherer.
+type GathererHealth struct {
+	Runs         uint64
+	Errors       uint64
+	LastRun      time.Time
+	LastDuration time.Duration
+	LastError    string
+	LastErrorAt  time.Time
+}
+
+// SendHealth describes the recent activity of one repeater mode.
+type SendHealth struct {
+	Attempts    uint64
+	Errors      uint64
+	LastAttempt time.Time
+	LastSuccess time.Time
+	LastError   string
+}
+
+// TimerHealth describes when a RunWorker timer last fired and last completed
+// its collection and delivery without error.
+type TimerHealth struct {
+	LastFired     time.Time
+	LastSucceeded time.Time
+}
+
+// Health records what a pipeline has been doing, for local observability.
+type Health struct {
+	mutex       sync.RWMutex
+	metrics     *models.Metrics
+	collectedAt time.Time
+	gatherers   map[string]*GathererHealth
+	sends       map[string]*SendHealth
+	timers      map[string]*TimerHealth
+	task        models.Task
+	taskAt      time.Time
+	currentTask *models.Task
+	lastTask    *models.Task
+}
+
+// HealthSnapshot is a consistent copy of Health.
+type HealthSnapshot struct {
+	Metrics     *models.Metrics
+	CollectedAt time.Time
+	Gatherers   map[string]GathererHealth
+	Sends       map[string]SendHealth
+	Timers      map[string]TimerHealth
+	// Task is the last task status reported, CurrentTask the task in
+	// progress, if any, and LastTask the last finished one.
+	Task        models.Task
+	TaskAt      time.Time
+	CurrentTask *models.Task
+	LastTask    *models.Task
+}
+
+func NewHealth() *Health {
+	return &Health{
+		gatherers: make(map[string]*GathererHealth),
+		sends:     make(map[string]*SendHealth),
+		timers:    make(map[string]*TimerHealth),
+	}
+}
+
+func (health *Health) Snapshot() HealthSnapshot {
+	health.mutex.RLock()
+	defer health.mutex.RUnlock()
+	snapshot := HealthSnapshot{
+		Metrics:     health.metrics,
+		CollectedAt: health.collectedAt,
+		Gatherers:   make(map[string]GathererHealth, len(health.gatherers)),
+		Sends:       make(map[string]SendHealth, len(health.sends)),
+		Timers:      make(map[string]TimerHealth, len(health.timers)),
+		Task:        health.task,
+		TaskAt:      health.taskAt,
+		CurrentTask: health.currentTask,
+		LastTask:    health.lastTask,
+	}
+	for name, timer := range health.timers {
+		snapshot.Timers[name] = *timer
+	}
+	for name, gatherer := range health.gatherers {
+		snapshot.Gatherers[name] = *gatherer
+	}
+	for mode, send := range health.sends {
+		snapshot.Sends[mode] = *send
+	}
+	return snapshot
+}
+
+func (health *Health) recordGatherer(name string, started time.Time, err error) {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	gatherer, ok := health.gatherers[name]
+	if !ok {
+		gatherer = &GathererHealth{}
+		health.gatherers[name] = gatherer
+	}
+	gatherer.Runs++
+	gatherer.LastRun = started
+	gatherer.LastDuration = time.Since(started)
+	if err != nil {
+		gatherer.Errors++
+		gatherer.LastError = err.Error()
+		gatherer.LastErrorAt = started
+	}
+}
+
+func (health *Health) recordSend(metrics models.Metrics, Mode models.ModeType, started time.Time, err error) {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	mode := ModeName(Mode)
+	send, ok := health.sends[mode]
+	if !ok {
+		send = &SendHealth{}
+		health.sends[mode] = send
+	}
+	send.Attempts++
+	send.LastAttempt = started
+	if err != nil {
+		send.Errors++
+		send.LastError = err.Error()
+	} else {
+		send.LastSuccess = started
+	}
+
+	switch {
+	case Mode.Name == "Metrics" && Mode.Type == "":
+		health.metrics = &metrics
+		health.collectedAt = started
+	case Mode.Name == "Task" && Mode.Type == "Status":
+		task := metrics.ReleemAgent.Tasks
+		health.task = task
+		health.taskAt = started
+		if task.Status == 3 {
+			health.currentTask = &task
+		} else {
+			health.lastTask = &task
+			health.currentTask = nil
+		}
+	}
+}
+
+// timerFired records that a RunWorker timer fired and returns the time it did.
+func (health *Health) timerFired(name string) time.Time {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	fired := time.Now()
+	timer, ok := health.timers[name]
+	if !ok {
+		timer = &TimerHealth{}
+		health.timers[name] = timer
+	}
+	timer.LastFired = fired
+	return fired
+}
+
+// timerSucceeded records that the cycle started by a timer at fired completed.
+func (health *Health) timerSucceeded(name string, fired time.Time) {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	if timer, ok := health.timers[name]; ok && fired.After(timer.LastSucceeded) {
+		timer.LastSucceeded = fired
+	}
+}
+
+// sentSince reports whether a payload of the mode was delivered after the given time.
+func (health *Health) sentSince(Mode models.ModeType, since time.Time) bool {
+	health.mutex.RLock()
+	defer health.mutex.RUnlock()
+	send, ok := health.sends[ModeName(Mode)]
+	return ok && !send.LastSuccess.Before(since)
+}
+
+// ModeName returns the "Name/Type" form of a repeater mode.
+func ModeName(Mode models.ModeType) string {
+	return Mode.Name + "/" + Mode.Type
+}
+
+type instrumentedGatherer struct {
+	name     string
+	gatherer models.MetricsGatherer
+	health   *Health
+}
+
+func (gatherer instrumentedGatherer) GetMetrics(ctx context.Context, metrics *models.Metrics) error {
+	started := time.Now()
+	err := gatherer.gatherer.GetMetrics(ctx, metrics)
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	gatherer.health.recordGatherer(gatherer.name, started, err)
+	return err
+}
+
+func (gatherer instrumentedGatherer) Name() string {
+	return gatherer.name
+}
+
+func (gatherer instrumentedGatherer) Required() bool {
+	return models.IsRequiredGatherer(gatherer.gatherer)
+}
+
+func (gatherer instrumentedGatherer) Timeout() time.Duration {
+	if timeout, ok := gatherer.gatherer.(models.TimeoutMetricsGatherer); ok {
+		return timeout.Timeout()
+	}
+	return 0
+}
+
+type instrumentedRepeater struct {
+	repeater models.MetricsRepeater
+	health   *Health
+}
+
+func (repeater instrumentedRepeater) ProcessMetrics(metricContext models.MetricContext, metrics models.Metrics, Mode models.ModeType) (string, error) {
+	return repeater.ProcessMetricsContext(context.Background(), metricContext, metrics, Mode)
+}
+
+func (repeater instrumentedRepeater) ProcessMetricsContext(ctx context.Context, metricContext models.MetricContext, metrics models.Metrics, Mode models.ModeType) (string, error) {
+	started := time.Now()
+	var result string
+	var err error
+	if contextRepeater, ok := repeater.repeater.(models.ContextMetricsRepeater); ok {
+		result, err = contextRepeater.ProcessMetricsContext(ctx, metricContext, metrics, Mode)
+	} else {
+		result, err = repeater.repeater.ProcessMetrics(metricContext, metrics, Mode)
+	}
+	repeater.health.recordSend(metrics, Mode, started, err)
+	return result, err
+}
+
+func (repeater instrumentedRepeater) SpoolDepth() int {
+	return SpoolDepth(repeater.repeater)
+}
+
+// SpoolDepth returns the number of payloads waiting in the spool of a
+// repeater, or 0 when the repeater does not spool.
+func SpoolDepth(repeater models.MetricsRepeater) int {
+	if spooler, ok := repeater.(interface{ SpoolDepth() int }); ok {
+		return spooler.SpoolDepth()
+	}
+	return 0
+}
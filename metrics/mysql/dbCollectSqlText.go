@@ -1,6 +1,7 @@
 package mysql
 
 import (
+	"context"
 	"maps"
 
 	"github.com/Releem/mysqlconfigurer/config"
@@ -16,14 +17,15 @@ const (
 
 type DBCollectSampleQueriesGatherer struct {
 	logger        logging.Logger
+	instance      *models.Instance
 	configuration *config.Config
 }
 
-func NewDBCollectSampleQueriesGatherer(logger logging.Logger, configuration *config.Config) *DBCollectSampleQueriesGatherer {
-	return &DBCollectSampleQueriesGatherer{logger: logger, configuration: configuration}
+func NewDBCollectSampleQueriesGatherer(logger logging.Logger, instance *models.Instance, configuration *config.Config) *DBCollectSampleQueriesGatherer {
+	return &DBCollectSampleQueriesGatherer{logger: logger, instance: instance, configuration: configuration}
 }
 
-func (DBCollectSampleQueries *DBCollectSampleQueriesGatherer) GetMetrics(metrics *models.Metrics) error {
+func (DBCollectSampleQueries *DBCollectSampleQueriesGatherer) GetMetrics(ctx context.Context, metrics *models.Metrics) error {
 	defer utils.HandlePanic(DBCollectSampleQueries.configuration, DBCollectSampleQueries.logger)
 	// Only collect SQL text for MySQL
 	CollectSampleQueries := CollectSampleQueriesCurrent
@@ -36,7 +38,7 @@ func (DBCollectSampleQueries *DBCollectSampleQueriesGatherer) GetMetrics(metrics
 		DBCollectSampleQueries.logger.Info("* SQL text collection is disabled...")
 		return nil
 	}
-	rows, err := models.DB.Query(CollectSampleQueries)
+	rows, err := DBCollectSampleQueries.instance.DB.QueryContext(ctx, CollectSampleQueries)
 	if err != nil {
 		DBCollectSampleQueries.logger.Error(err)
 		return err
@@ -58,9 +60,9 @@ func (DBCollectSampleQueries *DBCollectSampleQueriesGatherer) GetMetrics(metrics
 
 		// Single mutex lock for batch update
 		if len(tempData) > 0 {
-			models.SampleQueriesMutex.Lock()
-			maps.Copy(models.SampleQueries, tempData)
-			models.SampleQueriesMutex.Unlock()
+			DBCollectSampleQueries.instance.SampleQueriesMutex.Lock()
+			maps.Copy(DBCollectSampleQueries.instance.SampleQueries, tempData)
+			DBCollectSampleQueries.instance.SampleQueriesMutex.Unlock()
 		}
 	}
 	return nil
@@ -1,6 +1,7 @@
 package mysql
 
 import (
+	"context"
 	"github.com/Releem/mysqlconfigurer/config"
 	"github.com/Releem/mysqlconfigurer/models"
 	"github.com/Releem/mysqlconfigurer/utils"
@@ -9,22 +10,29 @@ import (
 
 type DbConfGatherer struct {
 	logger        logging.Logger
+	instance      *models.Instance
 	configuration *config.Config
 }
 
-func NewDBConfGatherer(logger logging.Logger, configuration *config.Config) *DbConfGatherer {
+func NewDBConfGatherer(logger logging.Logger, instance *models.Instance, configuration *config.Config) *DbConfGatherer {
 	return &DbConfGatherer{
 		logger:        logger,
+		instance:      instance,
 		configuration: configuration,
 	}
 }
 
-func (DbConf *DbConfGatherer) GetMetrics(metrics *models.Metrics) error {
+// Required reports that the metrics are discarded when DbConfGatherer fails.
+func (DbConf *DbConfGatherer) Required() bool {
+	return true
+}
+
+func (DbConf *DbConfGatherer) GetMetrics(ctx context.Context, metrics *models.Metrics) error {
 	defer utils.HandlePanic(DbConf.configuration, DbConf.logger)
 
 	output := make(models.MetricGroupValue)
 
-	rows, err := models.DB.Query("SHOW VARIABLES")
+	rows, err := DbConf.instance.DB.QueryContext(ctx, "SHOW VARIABLES")
 	if err != nil {
 		DbConf.logger.Error(err)
 		return nil
@@ -41,7 +49,7 @@ func (DbConf *DbConfGatherer) GetMetrics(metrics *models.Metrics) error {
 	}
 	rows.Close()
 
-	rows, err = models.DB.Query("SHOW GLOBAL VARIABLES")
+	rows, err = DbConf.instance.DB.QueryContext(ctx, "SHOW GLOBAL VARIABLES")
 	if err != nil {
 		DbConf.logger.Error(err)
 		return nil
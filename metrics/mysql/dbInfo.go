@@ -1,6 +1,7 @@
 package mysql
 
 import (
+	"context"
 	"os"
 	"regexp"
 	"strings"
@@ -14,24 +15,31 @@ import (
 
 type DBInfoGatherer struct {
 	logger        logging.Logger
+	instance      *models.Instance
 	configuration *config.Config
 }
 
-func NewDBInfoGatherer(logger logging.Logger, configuration *config.Config) *DBInfoGatherer {
+func NewDBInfoGatherer(logger logging.Logger, instance *models.Instance, configuration *config.Config) *DBInfoGatherer {
 	return &DBInfoGatherer{
 		logger:        logger,
+		instance:      instance,
 		configuration: configuration,
 	}
 }
 
-func (DBInfo *DBInfoGatherer) GetMetrics(metrics *models.Metrics) error {
+// Required reports that the metrics are discarded when DBInfoGatherer fails.
+func (DBInfo *DBInfoGatherer) Required() bool {
+	return true
+}
+
+func (DBInfo *DBInfoGatherer) GetMetrics(ctx context.Context, metrics *models.Metrics) error {
 	defer utils.HandlePanic(DBInfo.configuration, DBInfo.logger)
 
 	var row models.MetricValue
 	var mysql_version string
 	metrics.DB.Info = make(models.MetricGroupValue)
 	// Mysql version
-	err := models.DB.QueryRow("select VERSION()").Scan(&row.Value)
+	err := DBInfo.instance.DB.QueryRowContext(ctx, "select VERSION()").Scan(&row.Value)
 	if err != nil {
 		DBInfo.logger.Error(err)
 		return nil
@@ -53,7 +61,7 @@ func (DBInfo *DBInfoGatherer) GetMetrics(metrics *models.Metrics) error {
 	metrics.DB.Info["Type"] = "mysql"
 
 	var output []string
-	rows, err := models.DB.Query("SHOW GRANTS")
+	rows, err := DBInfo.instance.DB.QueryContext(ctx, "SHOW GRANTS")
 	if err != nil {
 		DBInfo.logger.Error(err)
 		return err
@@ -69,14 +77,14 @@ func (DBInfo *DBInfoGatherer) GetMetrics(metrics *models.Metrics) error {
 	rows.Close()
 	metrics.DB.Info["Grants"] = output
 
-	metrics.DB.Info["UsersSecurityCheck"] = users_security_check(DBInfo, metrics)
+	metrics.DB.Info["UsersSecurityCheck"] = users_security_check(ctx, DBInfo, metrics)
 
 	DBInfo.logger.V(5).Info("CollectMetrics DBInfo ", metrics.DB.Info)
 
 	return nil
 }
 
-func users_security_check(DBInfo *DBInfoGatherer, metrics *models.Metrics) []models.MetricGroupValue {
+func users_security_check(ctx context.Context, DBInfo *DBInfoGatherer, metrics *models.Metrics) []models.MetricGroupValue {
 	var output_users, users_check []models.MetricGroupValue
 
 	var password_column_exists, authstring_column_exists int
@@ -85,8 +93,8 @@ func users_security_check(DBInfo *DBInfoGatherer, metrics *models.Metrics) []mod
 
 	// New table schema available since mysql-5.7 and mariadb-10.2
 	// But need to be checked
-	models.DB.QueryRow("SELECT 1 FROM information_schema.columns WHERE TABLE_SCHEMA = 'mysql' AND TABLE_NAME = 'user' AND COLUMN_NAME = 'password'").Scan(&password_column_exists)
-	models.DB.QueryRow("SELECT 1 FROM information_schema.columns WHERE TABLE_SCHEMA = 'mysql' AND TABLE_NAME = 'user' AND COLUMN_NAME = 'authentication_string'").Scan(&authstring_column_exists)
+	DBInfo.instance.DB.QueryRowContext(ctx, "SELECT 1 FROM information_schema.columns WHERE TABLE_SCHEMA = 'mysql' AND TABLE_NAME = 'user' AND COLUMN_NAME = 'password'").Scan(&password_column_exists)
+	DBInfo.instance.DB.QueryRowContext(ctx, "SELECT 1 FROM information_schema.columns WHERE TABLE_SCHEMA = 'mysql' AND TABLE_NAME = 'user' AND COLUMN_NAME = 'authentication_string'").Scan(&authstring_column_exists)
 	PASS_COLUMN_NAME := "password"
 	ver_current, err := version.NewVersion(versionValue)
 	ver_mariadb, _ := version.NewVersion("10.2.0")
@@ -107,7 +115,7 @@ func users_security_check(DBInfo *DBInfoGatherer, metrics *models.Metrics) []mod
 	DBInfo.logger.V(5).Info("DEBUG: Password column = ", PASS_COLUMN_NAME)
 
 	var Username, User, Host, Password_As_User string
-	rows_users, err := models.DB.Query("SELECT CONCAT(QUOTE(user), '@', QUOTE(host)), user, host, (CAST(" + PASS_COLUMN_NAME + " as Binary) = PASSWORD(user) OR CAST(" + PASS_COLUMN_NAME + " as Binary) = PASSWORD(UPPER(user)) ) as Password_As_User FROM mysql.user")
+	rows_users, err := DBInfo.instance.DB.QueryContext(ctx, "SELECT CONCAT(QUOTE(user), '@', QUOTE(host)), user, host, (CAST("+PASS_COLUMN_NAME+" as Binary) = PASSWORD(user) OR CAST("+PASS_COLUMN_NAME+" as Binary) = PASSWORD(UPPER(user)) ) as Password_As_User FROM mysql.user")
 	if err != nil || !rows_users.Next() {
 		if err != nil {
 			if strings.Contains(err.Error(), "Error 1064 (42000): You have an error in your SQL syntax") {
@@ -118,7 +126,7 @@ func users_security_check(DBInfo *DBInfoGatherer, metrics *models.Metrics) []mod
 		} else {
 			DBInfo.logger.V(5).Info("DEBUG: Plugin validate_password is activated. Try another query...")
 		}
-		rows_users, err = models.DB.Query("SELECT CONCAT(QUOTE(user), '@', QUOTE(host)), user, host, (CAST(" + PASS_COLUMN_NAME + " as Binary) = CONCAT('*',UPPER(SHA1(UNHEX(SHA1(user))))) OR CAST(" + PASS_COLUMN_NAME + " as Binary) = CONCAT('*',UPPER(SHA1(UNHEX(SHA1(UPPER(user)))))) ) as Password_As_User FROM mysql.user")
+		rows_users, err = DBInfo.instance.DB.QueryContext(ctx, "SELECT CONCAT(QUOTE(user), '@', QUOTE(host)), user, host, (CAST("+PASS_COLUMN_NAME+" as Binary) = CONCAT('*',UPPER(SHA1(UNHEX(SHA1(user))))) OR CAST("+PASS_COLUMN_NAME+" as Binary) = CONCAT('*',UPPER(SHA1(UNHEX(SHA1(UPPER(user)))))) ) as Password_As_User FROM mysql.user")
 		if err != nil {
 			DBInfo.logger.Error(err)
 		} else {
@@ -151,14 +159,14 @@ func users_security_check(DBInfo *DBInfoGatherer, metrics *models.Metrics) []mod
 	}
 
 	output_user_blank_password := make(models.MetricGroupValue)
-	rows_users, err = models.DB.Query("SELECT CONCAT(QUOTE(user), '@', QUOTE(host)) FROM mysql.global_priv WHERE ( user != '' AND JSON_CONTAINS(Priv, '\"mysql_native_password\"', '$.plugin') AND JSON_CONTAINS(Priv, '\"\"', '$.authentication_string') AND NOT JSON_CONTAINS(Priv, 'true', '$.account_locked'))")
+	rows_users, err = DBInfo.instance.DB.QueryContext(ctx, "SELECT CONCAT(QUOTE(user), '@', QUOTE(host)) FROM mysql.global_priv WHERE ( user != '' AND JSON_CONTAINS(Priv, '\"mysql_native_password\"', '$.plugin') AND JSON_CONTAINS(Priv, '\"\"', '$.authentication_string') AND NOT JSON_CONTAINS(Priv, 'true', '$.account_locked'))")
 	if err != nil {
 		if strings.Contains(err.Error(), "Error 1146 (42S02): Table 'mysql.global_priv' doesn't exist") {
 			DBInfo.logger.V(5).Info("DEBUG: Not MariaDB, try another query...")
 		} else {
 			DBInfo.logger.Error(err)
 		}
-		rows_users, err = models.DB.Query("SELECT CONCAT(QUOTE(user), '@', QUOTE(host)) FROM mysql.user WHERE (" + PASS_COLUMN_NAME + " = '' OR " + PASS_COLUMN_NAME + " IS NULL) AND user != '' /*!50501 AND plugin NOT IN ('auth_socket', 'unix_socket', 'win_socket', 'auth_pam_compat') */  /*!80000 AND account_locked = 'N' AND password_expired = 'N' */")
+		rows_users, err = DBInfo.instance.DB.QueryContext(ctx, "SELECT CONCAT(QUOTE(user), '@', QUOTE(host)) FROM mysql.user WHERE ("+PASS_COLUMN_NAME+" = '' OR "+PASS_COLUMN_NAME+" IS NULL) AND user != '' /*!50501 AND plugin NOT IN ('auth_socket', 'unix_socket', 'win_socket', 'auth_pam_compat') */  /*!80000 AND account_locked = 'N' AND password_expired = 'N' */")
 		if err != nil {
 			DBInfo.logger.Error(err)
 		} else {
@@ -1,6 +1,7 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	"strings"
 	"time"
@@ -13,23 +14,30 @@ import (
 
 type DBMetricsBaseGatherer struct {
 	logger        logging.Logger
+	instance      *models.Instance
 	configuration *config.Config
 }
 
-func NewDBMetricsBaseGatherer(logger logging.Logger, configuration *config.Config) *DBMetricsBaseGatherer {
+func NewDBMetricsBaseGatherer(logger logging.Logger, instance *models.Instance, configuration *config.Config) *DBMetricsBaseGatherer {
 	return &DBMetricsBaseGatherer{
 		logger:        logger,
+		instance:      instance,
 		configuration: configuration,
 	}
 }
 
-func (DBMetricsBase *DBMetricsBaseGatherer) GetMetrics(metrics *models.Metrics) error {
+// Required reports that the metrics are discarded when DBMetricsBaseGatherer fails.
+func (DBMetricsBase *DBMetricsBaseGatherer) Required() bool {
+	return true
+}
+
+func (DBMetricsBase *DBMetricsBaseGatherer) GetMetrics(ctx context.Context, metrics *models.Metrics) error {
 	defer utils.HandlePanic(DBMetricsBase.configuration, DBMetricsBase.logger)
 	// Mysql Status
 	output := make(models.MetricGroupValue)
 	{
 		var row models.MetricValue
-		rows, err := models.DB.Query("SHOW STATUS")
+		rows, err := DBMetricsBase.instance.DB.QueryContext(ctx, "SHOW STATUS")
 
 		if err != nil {
 			DBMetricsBase.logger.Error(err)
@@ -45,7 +53,7 @@ func (DBMetricsBase *DBMetricsBaseGatherer) GetMetrics(metrics *models.Metrics)
 		}
 		rows.Close()
 
-		rows, err = models.DB.Query("SHOW GLOBAL STATUS")
+		rows, err = DBMetricsBase.instance.DB.QueryContext(ctx, "SHOW GLOBAL STATUS")
 		if err != nil {
 			DBMetricsBase.logger.Error(err)
 			return err
@@ -64,7 +72,7 @@ func (DBMetricsBase *DBMetricsBaseGatherer) GetMetrics(metrics *models.Metrics)
 	//status innodb engine
 	{
 		var engine, name, status string
-		err := models.DB.QueryRow("show engine innodb status").Scan(&engine, &name, &status)
+		err := DBMetricsBase.instance.DB.QueryRowContext(ctx, "show engine innodb status").Scan(&engine, &name, &status)
 		if err != nil {
 			DBMetricsBase.logger.Error(err)
 		} else {
@@ -75,7 +83,7 @@ func (DBMetricsBase *DBMetricsBaseGatherer) GetMetrics(metrics *models.Metrics)
 	{
 		var database string
 		var output []string
-		rows, err := models.DB.Query("SELECT table_schema FROM INFORMATION_SCHEMA.tables group BY table_schema")
+		rows, err := DBMetricsBase.instance.DB.QueryContext(ctx, "SELECT table_schema FROM INFORMATION_SCHEMA.tables group BY table_schema")
 		if err != nil {
 			DBMetricsBase.logger.Error(err)
 			return err
@@ -94,7 +102,7 @@ func (DBMetricsBase *DBMetricsBaseGatherer) GetMetrics(metrics *models.Metrics)
 	//Total table
 	{
 		var row uint64
-		err := models.DB.QueryRow("SELECT COUNT(*) as count FROM information_schema.tables").Scan(&row)
+		err := DBMetricsBase.instance.DB.QueryRowContext(ctx, "SELECT COUNT(*) as count FROM information_schema.tables").Scan(&row)
 		if err != nil {
 			DBMetricsBase.logger.Error(err)
 			return err
@@ -105,7 +113,7 @@ func (DBMetricsBase *DBMetricsBaseGatherer) GetMetrics(metrics *models.Metrics)
 	{
 		var count_events_statements_summary_by_digest uint64
 
-		err := models.DB.QueryRow("SELECT count(*) FROM performance_schema.events_statements_summary_by_digest").Scan(&count_events_statements_summary_by_digest)
+		err := DBMetricsBase.instance.DB.QueryRowContext(ctx, "SELECT count(*) FROM performance_schema.events_statements_summary_by_digest").Scan(&count_events_statements_summary_by_digest)
 		if err != nil {
 			if err != sql.ErrNoRows {
 				DBMetricsBase.logger.Error(err)
@@ -114,7 +122,7 @@ func (DBMetricsBase *DBMetricsBaseGatherer) GetMetrics(metrics *models.Metrics)
 			metrics.DB.Metrics.CountQueriesLatency = count_events_statements_summary_by_digest
 		}
 	}
-	metrics.DB.Metrics.CountEnabledEventsStatementsConsumers = models.CountEnabledConsumers
+	metrics.DB.Metrics.CountEnabledEventsStatementsConsumers = DBMetricsBase.instance.CountEnabledConsumers
 	DBMetricsBase.logger.V(5).Info("CollectMetrics DBMetricsBase ", metrics.DB.Metrics)
 
 	return nil
@@ -122,17 +130,19 @@ func (DBMetricsBase *DBMetricsBaseGatherer) GetMetrics(metrics *models.Metrics)
 
 type DBMetricsGatherer struct {
 	logger        logging.Logger
+	instance      *models.Instance
 	configuration *config.Config
 }
 
-func NewDBMetricsGatherer(logger logging.Logger, configuration *config.Config) *DBMetricsGatherer {
+func NewDBMetricsGatherer(logger logging.Logger, instance *models.Instance, configuration *config.Config) *DBMetricsGatherer {
 	return &DBMetricsGatherer{
 		logger:        logger,
+		instance:      instance,
 		configuration: configuration,
 	}
 }
 
-func (DBMetrics *DBMetricsGatherer) GetMetrics(metrics *models.Metrics) error {
+func (DBMetrics *DBMetricsGatherer) GetMetrics(ctx context.Context, metrics *models.Metrics) error {
 	defer utils.HandlePanic(DBMetrics.configuration, DBMetrics.logger)
 
 	// Latency
@@ -141,7 +151,7 @@ func (DBMetrics *DBMetricsGatherer) GetMetrics(metrics *models.Metrics) error {
 		var schema_name, query_id string
 		var calls, avg_time_us, sum_time_us int
 
-		rows, err := models.DB.Query("SELECT IFNULL(schema_name, 'NULL') as schema_name, IFNULL(digest, 'NULL') as query_id, count_star as calls, round(avg_timer_wait/1000000, 0) as avg_time_us, round(SUM_TIMER_WAIT/1000000, 0) as sum_time_us FROM performance_schema.events_statements_summary_by_digest")
+		rows, err := DBMetrics.instance.DB.QueryContext(ctx, "SELECT IFNULL(schema_name, 'NULL') as schema_name, IFNULL(digest, 'NULL') as query_id, count_star as calls, round(avg_timer_wait/1000000, 0) as avg_time_us, round(SUM_TIMER_WAIT/1000000, 0) as sum_time_us FROM performance_schema.events_statements_summary_by_digest")
 		if err != nil {
 			if err != sql.ErrNoRows {
 				DBMetrics.logger.Error(err)
@@ -206,7 +216,7 @@ func (DBMetrics *DBMetricsGatherer) GetMetrics(metrics *models.Metrics) error {
 		var total_info_length uint64
 		total_info_length = 0
 		information_schema_processlist_fields := []string{"ID", "USER", "HOST", "DB", "COMMAND", "TIME", "STATE", "INFO"}
-		rows, err := models.DB.Query("SHOW FULL PROCESSLIST")
+		rows, err := DBMetrics.instance.DB.QueryContext(ctx, "SHOW FULL PROCESSLIST")
 
 		if err != nil {
 			DBMetrics.logger.Error(err)
@@ -295,17 +305,19 @@ func (DBMetrics *DBMetricsGatherer) GetMetrics(metrics *models.Metrics) error {
 
 type DBMetricsConfigGatherer struct {
 	logger        logging.Logger
+	instance      *models.Instance
 	configuration *config.Config
 }
 
-func NewDBMetricsConfigGatherer(logger logging.Logger, configuration *config.Config) *DBMetricsConfigGatherer {
+func NewDBMetricsConfigGatherer(logger logging.Logger, instance *models.Instance, configuration *config.Config) *DBMetricsConfigGatherer {
 	return &DBMetricsConfigGatherer{
 		logger:        logger,
+		instance:      instance,
 		configuration: configuration,
 	}
 }
 
-func (DBMetricsConfig *DBMetricsConfigGatherer) GetMetrics(metrics *models.Metrics) error {
+func (DBMetricsConfig *DBMetricsConfigGatherer) GetMetrics(ctx context.Context, metrics *models.Metrics) error {
 	defer utils.HandlePanic(DBMetricsConfig.configuration, DBMetricsConfig.logger)
 
 	//Stat mysql Engine
@@ -315,7 +327,7 @@ func (DBMetricsConfig *DBMetricsConfigGatherer) GetMetrics(metrics *models.Metri
 		output := make(map[string]models.MetricGroupValue)
 		engine_elem := make(map[string]models.MetricGroupValue)
 
-		rows, err := models.DB.Query("SELECT ENGINE,SUPPORT FROM information_schema.ENGINES ORDER BY ENGINE ASC")
+		rows, err := DBMetricsConfig.instance.DB.QueryContext(ctx, "SELECT ENGINE,SUPPORT FROM information_schema.ENGINES ORDER BY ENGINE ASC")
 		if err != nil {
 			DBMetricsConfig.logger.Error(err)
 			return err
@@ -332,7 +344,7 @@ func (DBMetricsConfig *DBMetricsConfigGatherer) GetMetrics(metrics *models.Metri
 		rows.Close()
 		i := 0
 		for _, database := range metrics.DB.Metrics.Databases {
-			rows, err = models.DB.Query(`SELECT ENGINE, IFNULL(SUM(DATA_LENGTH+INDEX_LENGTH), 0), IFNULL(COUNT(ENGINE), 0), IFNULL(SUM(DATA_LENGTH), 0), IFNULL(SUM(INDEX_LENGTH), 0) FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND ENGINE IS NOT NULL  GROUP BY ENGINE ORDER BY ENGINE ASC`, database)
+			rows, err = DBMetricsConfig.instance.DB.QueryContext(ctx, `SELECT ENGINE, IFNULL(SUM(DATA_LENGTH+INDEX_LENGTH), 0), IFNULL(COUNT(ENGINE), 0), IFNULL(SUM(DATA_LENGTH), 0), IFNULL(SUM(INDEX_LENGTH), 0) FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND ENGINE IS NOT NULL  GROUP BY ENGINE ORDER BY ENGINE ASC`, database)
 			if err != nil {
 				DBMetricsConfig.logger.Error(err)
 				return err
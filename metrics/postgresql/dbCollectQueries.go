@@ -20,20 +20,27 @@ import (
 
 type DBCollectQueriesOptimization struct {
 	logger        logging.Logger
+	instance      *models.Instance
 	configuration *config.Config
 }
 
-func NewDBCollectQueriesOptimization(logger logging.Logger, configuration *config.Config) *DBCollectQueriesOptimization {
+func NewDBCollectQueriesOptimization(logger logging.Logger, instance *models.Instance, configuration *config.Config) *DBCollectQueriesOptimization {
 	return &DBCollectQueriesOptimization{
 		logger:        logger,
+		instance:      instance,
 		configuration: configuration,
 	}
 }
 
-func (DBCollectQueriesOptimization *DBCollectQueriesOptimization) GetMetrics(metrics *models.Metrics) error {
+// Timeout lets the collection run for up to the query optimization period.
+func (DBCollectQueriesOptimization *DBCollectQueriesOptimization) Timeout() time.Duration {
+	return DBCollectQueriesOptimization.configuration.QueryOptimizationPeriod * time.Second
+}
+
+func (DBCollectQueriesOptimization *DBCollectQueriesOptimization) GetMetrics(ctx context.Context, metrics *models.Metrics) error {
 	defer u.HandlePanic(DBCollectQueriesOptimization.configuration, DBCollectQueriesOptimization.logger)
 
-	if !models.PgStatStatementsEnabled {
+	if !DBCollectQueriesOptimization.instance.PgStatStatementsEnabled {
 		DBCollectQueriesOptimization.logger.Info("pg_stat_statements extension is not installed, skipping query collection")
 		metrics.DB.Queries = nil
 		return nil
@@ -56,7 +63,7 @@ func (DBCollectQueriesOptimization *DBCollectQueriesOptimization) GetMetrics(met
 	}
 
 	// Collect query statistics from pg_stat_statements
-	rows, err := models.DB.Query(pgStatStatements)
+	rows, err := DBCollectQueriesOptimization.instance.DB.QueryContext(ctx, pgStatStatements)
 
 	if err != nil {
 		DBCollectQueriesOptimization.logger.Error(err)
@@ -86,8 +93,8 @@ func (DBCollectQueriesOptimization *DBCollectQueriesOptimization) GetMetrics(met
 	}
 
 	if DBCollectQueriesOptimization.configuration.QueryOptimization {
-		CollectExplain(output_digest, "total_exec_time_us", supportsParameterizedExplain, DBCollectQueriesOptimization.logger, DBCollectQueriesOptimization.configuration)
-		CollectExplain(output_digest, "mean_exec_time_us", supportsParameterizedExplain, DBCollectQueriesOptimization.logger, DBCollectQueriesOptimization.configuration)
+		CollectExplain(ctx, output_digest, "total_exec_time_us", supportsParameterizedExplain, DBCollectQueriesOptimization.logger, DBCollectQueriesOptimization.configuration)
+		CollectExplain(ctx, output_digest, "mean_exec_time_us", supportsParameterizedExplain, DBCollectQueriesOptimization.logger, DBCollectQueriesOptimization.configuration)
 	}
 	for _, value := range output_digest {
 		output = append(output, value)
@@ -104,7 +111,7 @@ func (DBCollectQueriesOptimization *DBCollectQueriesOptimization) GetMetrics(met
 		if u.IsSchemaNameExclude(database, DBCollectQueriesOptimization.configuration.DatabasesQueryOptimization) {
 			continue
 		}
-		CollectDbSchema(database, DBCollectQueriesOptimization.logger, metrics)
+		CollectDbSchema(ctx, DBCollectQueriesOptimization.instance.DB, database, DBCollectQueriesOptimization.logger, metrics)
 
 		i += 1
 		if i%25 == 0 {
@@ -117,7 +124,7 @@ func (DBCollectQueriesOptimization *DBCollectQueriesOptimization) GetMetrics(met
 	return nil
 }
 
-func CollectDbSchema(database string, logger logging.Logger, metrics *models.Metrics) error {
+func CollectDbSchema(ctx context.Context, db *sql.DB, database string, logger logging.Logger, metrics *models.Metrics) error {
 	// Collect table information from information_schema
 	type information_schema_table_type struct {
 		TABLE_SCHEMA string
@@ -126,7 +133,7 @@ func CollectDbSchema(database string, logger logging.Logger, metrics *models.Met
 	}
 	var information_schema_table information_schema_table_type
 
-	rows, err := models.DB.Query(`
+	rows, err := db.QueryContext(ctx, `
 		SELECT table_schema, table_name, table_type
 		FROM information_schema.tables 
 		WHERE table_catalog = $1
@@ -163,7 +170,7 @@ func CollectDbSchema(database string, logger logging.Logger, metrics *models.Met
 	}
 	var information_schema_column information_schema_column_type
 
-	rows, err = models.DB.Query(`
+	rows, err = db.QueryContext(ctx, `
 		SELECT table_schema, table_name, column_name, ordinal_position::text, 
 		       COALESCE(column_default, ''), is_nullable, data_type
 		FROM information_schema.columns 
@@ -203,7 +210,7 @@ func CollectDbSchema(database string, logger logging.Logger, metrics *models.Met
 }
 
 // PostgreSQL version of CollectionExplain - uses EXPLAIN (FORMAT JSON)
-func CollectExplain(digests map[string]models.MetricGroupValue, field_sorting string, supportsParameterizedExplain bool, logger logging.Logger, configuration *config.Config) {
+func CollectExplain(ctx context.Context, digests map[string]models.MetricGroupValue, field_sorting string, supportsParameterizedExplain bool, logger logging.Logger, configuration *config.Config) {
 	var schema_name_conn string
 	var i int
 	var db *sql.DB
@@ -249,11 +256,11 @@ func CollectExplain(digests map[string]models.MetricGroupValue, field_sorting st
 			if db != nil {
 				db.Close()
 			}
-			db = u.ConnectionDatabase(configuration, logger, digests[k]["datname"].(string))
+			db = u.ConnectionDatabaseContext(ctx, configuration, logger, digests[k]["datname"].(string))
 			defer db.Close()
 			schema_name_conn = digests[k]["datname"].(string)
 		}
-		query_explain, err := ExecuteExplain(db, digests[k]["queryid"].(string), digests[k]["query_text"].(string), supportsParameterizedExplain, logger)
+		query_explain, err := ExecuteExplain(ctx, db, digests[k]["queryid"].(string), digests[k]["query_text"].(string), supportsParameterizedExplain, logger)
 		if err != nil {
 			digests[k]["explain_error"] = err.Error()
 		}
@@ -266,13 +273,13 @@ func CollectExplain(digests map[string]models.MetricGroupValue, field_sorting st
 	}
 }
 
-func ExecuteExplain(db *sql.DB, queryId string, queryText string, supportsParameterizedExplain bool, logger logging.Logger) (string, error) {
+func ExecuteExplain(ctx context.Context, db *sql.DB, queryId string, queryText string, supportsParameterizedExplain bool, logger logging.Logger) (string, error) {
 	var explain, query_text string
 	var explain_error error
 	explain_error = nil
 
 	if supportsParameterizedExplain && containsUnquotedPgParameter(queryText) {
-		explainPrepared, errPrepared := executePreparedExplain(db, queryId, queryText)
+		explainPrepared, errPrepared := executePreparedExplain(ctx, db, queryId, queryText)
 		if errPrepared != nil {
 			logger.Error("Explain prepared statement error: ", errPrepared, "; queryText: ", queryText)
 			if isExplainPermissionError(errPrepared) {
@@ -285,7 +292,7 @@ func ExecuteExplain(db *sql.DB, queryId string, queryText string, supportsParame
 	}
 
 	//Try exec EXPLAIN for origin query
-	err := db.QueryRow("EXPLAIN (FORMAT JSON) " + queryText).Scan(&explain)
+	err := db.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+queryText).Scan(&explain)
 	if err != nil {
 		logger.Error("Explain Error: ", err)
 		if isExplainPermissionError(err) {
@@ -300,7 +307,7 @@ func ExecuteExplain(db *sql.DB, queryId string, queryText string, supportsParame
 
 	//Try exec EXPLAIN for  query with replace "\"" on "'"
 	query_text = strings.Replace(queryText, "\"", "'", -1)
-	err_1 := db.QueryRow("EXPLAIN (FORMAT JSON) " + query_text).Scan(&explain)
+	err_1 := db.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+query_text).Scan(&explain)
 	if err_1 != nil {
 		logger.Error("Explain Error: ", err_1)
 		if isExplainPermissionError(err_1) {
@@ -315,7 +322,7 @@ func ExecuteExplain(db *sql.DB, queryId string, queryText string, supportsParame
 
 	//Try exec EXPLAIN for  query with replace "\"" on "`"
 	query_text = strings.Replace(queryText, "\"", "`", -1)
-	err_2 := db.QueryRow("EXPLAIN (FORMAT JSON) " + query_text).Scan(&explain)
+	err_2 := db.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+query_text).Scan(&explain)
 	if err_2 != nil {
 		logger.Error("Explain Error: ", err_2)
 		if isExplainPermissionError(err_2) {
@@ -364,8 +371,7 @@ func containsUnquotedPgParameter(query string) bool {
 	return false
 }
 
-func executePreparedExplain(db *sql.DB, queryId string, queryText string) (string, error) {
-	ctx := context.Background()
+func executePreparedExplain(ctx context.Context, db *sql.DB, queryId string, queryText string) (string, error) {
 	conn, err := db.Conn(ctx)
 	if err != nil {
 		return "", err
@@ -381,7 +387,7 @@ func executePreparedExplain(db *sql.DB, queryId string, queryText string) (strin
 	if _, err = conn.ExecContext(ctx, query); err != nil {
 		return "", err
 	}
-	defer conn.ExecContext(ctx, "DEALLOCATE PREPARE "+stmtName)
+	defer conn.ExecContext(context.WithoutCancel(ctx), "DEALLOCATE PREPARE "+stmtName)
 
 	var paramsCount int
 	if err = conn.QueryRowContext(ctx, "SELECT COALESCE(cardinality(parameter_types), 0) FROM pg_prepared_statements WHERE name = $1", stmtName).Scan(&paramsCount); err != nil {
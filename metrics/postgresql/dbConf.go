@@ -1,6 +1,7 @@
 package postgresql
 
 import (
+	"context"
 	"github.com/Releem/mysqlconfigurer/config"
 	"github.com/Releem/mysqlconfigurer/models"
 	"github.com/Releem/mysqlconfigurer/utils"
@@ -9,23 +10,30 @@ import (
 
 type DBConfGatherer struct {
 	logger        logging.Logger
+	instance      *models.Instance
 	configuration *config.Config
 }
 
-func NewDBConfGatherer(logger logging.Logger, configuration *config.Config) *DBConfGatherer {
+func NewDBConfGatherer(logger logging.Logger, instance *models.Instance, configuration *config.Config) *DBConfGatherer {
 	return &DBConfGatherer{
 		logger:        logger,
+		instance:      instance,
 		configuration: configuration,
 	}
 }
 
-func (DBConf *DBConfGatherer) GetMetrics(metrics *models.Metrics) error {
+// Required reports that the metrics are discarded when DBConfGatherer fails.
+func (DBConf *DBConfGatherer) Required() bool {
+	return true
+}
+
+func (DBConf *DBConfGatherer) GetMetrics(ctx context.Context, metrics *models.Metrics) error {
 	defer utils.HandlePanic(DBConf.configuration, DBConf.logger)
 
 	output := make(models.MetricGroupValue)
 
 	// Get PostgreSQL settings from pg_settings
-	rows, err := models.DB.Query(`
+	rows, err := DBConf.instance.DB.QueryContext(ctx, `
 		SELECT name, 
 			case when source = 'session' then reset_val else setting end as setting, 
 			COALESCE(unit, 'NULL') as unit, 
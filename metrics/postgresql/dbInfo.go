@@ -1,6 +1,7 @@
 package postgresql
 
 import (
+	"context"
 	"os"
 	"strings"
 
@@ -12,24 +13,31 @@ import (
 
 type DBInfoBaseGatherer struct {
 	logger        logging.Logger
+	instance      *models.Instance
 	configuration *config.Config
 }
 
-func NewDBInfoBaseGatherer(logger logging.Logger, configuration *config.Config) *DBInfoBaseGatherer {
+func NewDBInfoBaseGatherer(logger logging.Logger, instance *models.Instance, configuration *config.Config) *DBInfoBaseGatherer {
 	return &DBInfoBaseGatherer{
 		logger:        logger,
+		instance:      instance,
 		configuration: configuration,
 	}
 }
 
-func (DBInfoBase *DBInfoBaseGatherer) GetMetrics(metrics *models.Metrics) error {
+// Required reports that the metrics are discarded when DBInfoBaseGatherer fails.
+func (DBInfoBase *DBInfoBaseGatherer) Required() bool {
+	return true
+}
+
+func (DBInfoBase *DBInfoBaseGatherer) GetMetrics(ctx context.Context, metrics *models.Metrics) error {
 	defer utils.HandlePanic(DBInfoBase.configuration, DBInfoBase.logger)
 
 	var dbversion string
 	info := make(models.MetricGroupValue)
 
 	// PostgreSQL version
-	err := models.DB.QueryRow("SELECT version()").Scan(&dbversion)
+	err := DBInfoBase.instance.DB.QueryRowContext(ctx, "SELECT version()").Scan(&dbversion)
 	if err != nil {
 		DBInfoBase.logger.Error(err)
 		return err
@@ -1,6 +1,7 @@
 package postgresql
 
 import (
+	"context"
 	"strings"
 
 	"github.com/Releem/mysqlconfigurer/config"
@@ -11,42 +12,44 @@ import (
 
 type DBInfoGatherer struct {
 	logger        logging.Logger
+	instance      *models.Instance
 	configuration *config.Config
 }
 
-func NewDBInfoGatherer(logger logging.Logger, configuration *config.Config) *DBInfoGatherer {
+func NewDBInfoGatherer(logger logging.Logger, instance *models.Instance, configuration *config.Config) *DBInfoGatherer {
 	return &DBInfoGatherer{
 		logger:        logger,
+		instance:      instance,
 		configuration: configuration,
 	}
 }
 
-func (DBInfo *DBInfoGatherer) GetMetrics(metrics *models.Metrics) error {
+func (DBInfo *DBInfoGatherer) GetMetrics(ctx context.Context, metrics *models.Metrics) error {
 	defer utils.HandlePanic(DBInfo.configuration, DBInfo.logger)
 
 	if metrics.DB.Info == nil {
 		metrics.DB.Info = make(models.MetricGroupValue)
 	}
 
-	if pgHBA := DBInfo.collectPgHBA(); pgHBA != nil {
+	if pgHBA := DBInfo.collectPgHBA(ctx); pgHBA != nil {
 		metrics.DB.Info["pg_hba"] = pgHBA
 
 	}
 
-	metrics.DB.Info["Extensions"] = DBInfo.collectExtensions()
-	metrics.DB.Info["Users"] = DBInfo.collectUsers()
-	metrics.DB.Info["UsersSecurityCheck"] = DBInfo.collectUsersSecurityCheck(metrics)
-	metrics.DB.Info["PublicSchemaPermissions"] = DBInfo.collectPublicSchemaPermissions()
-	metrics.DB.Info["RLS"] = DBInfo.collectRLSInfo()
+	metrics.DB.Info["Extensions"] = DBInfo.collectExtensions(ctx)
+	metrics.DB.Info["Users"] = DBInfo.collectUsers(ctx)
+	metrics.DB.Info["UsersSecurityCheck"] = DBInfo.collectUsersSecurityCheck(ctx, metrics)
+	metrics.DB.Info["PublicSchemaPermissions"] = DBInfo.collectPublicSchemaPermissions(ctx)
+	metrics.DB.Info["RLS"] = DBInfo.collectRLSInfo(ctx)
 
 	DBInfo.logger.V(5).Info("CollectMetrics PostgreSQL DBInfo ", metrics.DB.Info)
 	return nil
 }
 
-func (DBInfo *DBInfoGatherer) collectExtensions() []models.MetricGroupValue {
+func (DBInfo *DBInfoGatherer) collectExtensions(ctx context.Context) []models.MetricGroupValue {
 	output := []models.MetricGroupValue{}
 
-	rows, err := models.DB.Query(`
+	rows, err := DBInfo.instance.DB.QueryContext(ctx, `
 		SELECT
 			extname,
 			COALESCE(extversion, 'NULL') AS extversion,
@@ -76,10 +79,10 @@ func (DBInfo *DBInfoGatherer) collectExtensions() []models.MetricGroupValue {
 	return output
 }
 
-func (DBInfo *DBInfoGatherer) collectUsers() []models.MetricGroupValue {
+func (DBInfo *DBInfoGatherer) collectUsers(ctx context.Context) []models.MetricGroupValue {
 	output := []models.MetricGroupValue{}
 
-	rows, err := models.DB.Query(`
+	rows, err := DBInfo.instance.DB.QueryContext(ctx, `
 		SELECT
 			rolname,
 			rolsuper,
@@ -147,7 +150,7 @@ func (DBInfo *DBInfoGatherer) collectUsers() []models.MetricGroupValue {
 	return output
 }
 
-func (DBInfo *DBInfoGatherer) collectUsersSecurityCheck(metrics *models.Metrics) []models.MetricGroupValue {
+func (DBInfo *DBInfoGatherer) collectUsersSecurityCheck(ctx context.Context, metrics *models.Metrics) []models.MetricGroupValue {
 	usersCheck := []models.MetricGroupValue{}
 
 	users, ok := metrics.DB.Info["Users"].([]models.MetricGroupValue)
@@ -181,10 +184,10 @@ func (DBInfo *DBInfoGatherer) collectUsersSecurityCheck(metrics *models.Metrics)
 	return usersCheck
 }
 
-func (DBInfo *DBInfoGatherer) collectPublicSchemaPermissions() models.MetricGroupValue {
+func (DBInfo *DBInfoGatherer) collectPublicSchemaPermissions(ctx context.Context) models.MetricGroupValue {
 	var privileges string
 
-	err := models.DB.QueryRow(`
+	err := DBInfo.instance.DB.QueryRowContext(ctx, `
 		SELECT CONCAT_WS(',',
 			CASE WHEN has_schema_privilege('public', 'public', 'USAGE') THEN 'USAGE' END,
 			CASE WHEN has_schema_privilege('public', 'public', 'CREATE') THEN 'CREATE' END
@@ -197,10 +200,10 @@ func (DBInfo *DBInfoGatherer) collectPublicSchemaPermissions() models.MetricGrou
 	return models.MetricGroupValue{"public": privileges}
 }
 
-func (DBInfo *DBInfoGatherer) collectRLSInfo() bool {
+func (DBInfo *DBInfoGatherer) collectRLSInfo(ctx context.Context) bool {
 	var enabled bool
 
-	err := models.DB.QueryRow(`
+	err := DBInfo.instance.DB.QueryRowContext(ctx, `
 		SELECT EXISTS (
 			SELECT 1
 			FROM pg_class c
@@ -217,10 +220,10 @@ func (DBInfo *DBInfoGatherer) collectRLSInfo() bool {
 	return enabled
 }
 
-func (DBInfo *DBInfoGatherer) collectPgHBA() []models.MetricGroupValue {
+func (DBInfo *DBInfoGatherer) collectPgHBA(ctx context.Context) []models.MetricGroupValue {
 	output := []models.MetricGroupValue{}
 
-	rows, err := models.DB.Query(`
+	rows, err := DBInfo.instance.DB.QueryContext(ctx, `
 		SELECT
 			COALESCE(type, '') AS type,
 			COALESCE(array_to_string(database, ','), '') AS database,
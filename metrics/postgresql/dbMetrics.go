@@ -1,6 +1,7 @@
 package postgresql
 
 import (
+	"context"
 	"database/sql"
 	"strings"
 	"time"
@@ -15,33 +16,42 @@ import (
 
 type DBMetricsBaseGatherer struct {
 	logger        logging.Logger
+	instance      *models.Instance
 	configuration *config.Config
 }
 
-func NewDBMetricsBaseGatherer(logger logging.Logger, configuration *config.Config) *DBMetricsBaseGatherer {
+func NewDBMetricsBaseGatherer(logger logging.Logger, instance *models.Instance, configuration *config.Config) *DBMetricsBaseGatherer {
 	return &DBMetricsBaseGatherer{
 		logger:        logger,
+		instance:      instance,
 		configuration: configuration,
 	}
 }
 
 type DBMetricsConfigGatherer struct {
 	logger        logging.Logger
+	instance      *models.Instance
 	configuration *config.Config
 }
 
-func NewDBMetricsConfigGatherer(logger logging.Logger, configuration *config.Config) *DBMetricsConfigGatherer {
+func NewDBMetricsConfigGatherer(logger logging.Logger, instance *models.Instance, configuration *config.Config) *DBMetricsConfigGatherer {
 	return &DBMetricsConfigGatherer{
 		logger:        logger,
+		instance:      instance,
 		configuration: configuration,
 	}
 }
 
-func (DBMetricsBase *DBMetricsBaseGatherer) GetMetrics(metrics *models.Metrics) error {
+// Required reports that the metrics are discarded when DBMetricsBaseGatherer fails.
+func (DBMetricsBase *DBMetricsBaseGatherer) Required() bool {
+	return true
+}
+
+func (DBMetricsBase *DBMetricsBaseGatherer) GetMetrics(ctx context.Context, metrics *models.Metrics) error {
 	defer utils.HandlePanic(DBMetricsBase.configuration, DBMetricsBase.logger)
 	{
 		// Check if pg_stat_statements extension is available
-		err := models.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM pg_extension WHERE extname = 'pg_stat_statements')").Scan(&models.PgStatStatementsEnabled)
+		err := DBMetricsBase.instance.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM pg_extension WHERE extname = 'pg_stat_statements')").Scan(&DBMetricsBase.instance.PgStatStatementsEnabled)
 		if err != nil {
 			DBMetricsBase.logger.Error("Error checking pg_stat_statements extension: ", err)
 		}
@@ -56,8 +66,8 @@ func (DBMetricsBase *DBMetricsBaseGatherer) GetMetrics(metrics *models.Metrics)
 		// 	pgStatViews = PG_STAT_VIEWS_OLD_VERSION
 		// }
 		for _, view := range PG_STAT_VIEWS {
-			rows, err := models.DB.Query(`
-			SELECT * FROM ` + view)
+			rows, err := DBMetricsBase.instance.DB.QueryContext(ctx, `
+			SELECT * FROM `+view)
 			if err != nil {
 				if !strings.Contains(err.Error(), "relation \""+view+"\" does not exist") {
 					DBMetricsBase.logger.Error(err)
@@ -75,7 +85,7 @@ func (DBMetricsBase *DBMetricsBaseGatherer) GetMetrics(metrics *models.Metrics)
 		// PostgreSQL Uptime Statistics
 		{
 			var uptime, timestamp string
-			err := models.DB.QueryRow("SELECT EXTRACT(EPOCH FROM (now() - pg_postmaster_start_time()))::bigint AS uptime, EXTRACT(EPOCH FROM (now()) )::bigint AS timestamp").Scan(&uptime, &timestamp)
+			err := DBMetricsBase.instance.DB.QueryRowContext(ctx, "SELECT EXTRACT(EPOCH FROM (now() - pg_postmaster_start_time()))::bigint AS uptime, EXTRACT(EPOCH FROM (now()) )::bigint AS timestamp").Scan(&uptime, &timestamp)
 			if err != nil {
 				DBMetricsBase.logger.Error(err)
 			}
@@ -88,7 +98,7 @@ func (DBMetricsBase *DBMetricsBaseGatherer) GetMetrics(metrics *models.Metrics)
 	{
 		var database string
 		var output []string
-		rows, err := models.DB.Query("SELECT datname FROM pg_database WHERE datistemplate = false ORDER BY datname")
+		rows, err := DBMetricsBase.instance.DB.QueryContext(ctx, "SELECT datname FROM pg_database WHERE datistemplate = false ORDER BY datname")
 		if err != nil {
 			DBMetricsBase.logger.Error(err)
 			return err
@@ -115,11 +125,11 @@ func (DBMetricsBase *DBMetricsBaseGatherer) GetMetrics(metrics *models.Metrics)
 		if ver_current.LessThan(ver_postgresql) {
 			pgStatStatements = PG_STAT_STATEMENTS_OLD_VERSION
 		}
-		if models.PgStatStatementsEnabled {
+		if DBMetricsBase.instance.PgStatStatementsEnabled {
 			var dealloc uint64
 			var stats_reset string
 
-			err := models.DB.QueryRow("SELECT dealloc, stats_reset FROM pg_stat_statements_info").Scan(&dealloc, &stats_reset)
+			err := DBMetricsBase.instance.DB.QueryRowContext(ctx, "SELECT dealloc, stats_reset FROM pg_stat_statements_info").Scan(&dealloc, &stats_reset)
 			if err != nil {
 				if !strings.Contains(err.Error(), "relation \"pg_stat_statements_info\" does not exist") {
 					DBMetricsBase.logger.Error(err)
@@ -132,7 +142,7 @@ func (DBMetricsBase *DBMetricsBaseGatherer) GetMetrics(metrics *models.Metrics)
 
 			var count_statements uint64
 
-			err = models.DB.QueryRow("SELECT COUNT(*) FROM pg_stat_statements").Scan(&count_statements)
+			err = DBMetricsBase.instance.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM pg_stat_statements").Scan(&count_statements)
 			if err != nil {
 				if err != sql.ErrNoRows {
 					DBMetricsBase.logger.Error(err)
@@ -145,7 +155,7 @@ func (DBMetricsBase *DBMetricsBaseGatherer) GetMetrics(metrics *models.Metrics)
 			var calls int
 			var total_exec_time, mean_exec_time float64
 			// Collect query statistics from pg_stat_statements
-			rows, err := models.DB.Query(pgStatStatements)
+			rows, err := DBMetricsBase.instance.DB.QueryContext(ctx, pgStatStatements)
 
 			if err != nil {
 				if err != sql.ErrNoRows {
@@ -180,7 +190,7 @@ func (DBMetricsBase *DBMetricsBaseGatherer) GetMetrics(metrics *models.Metrics)
 	// Process list from pg_stat_activity
 	{
 		var output []models.MetricGroupValue
-		rows, err := models.DB.Query(`
+		rows, err := DBMetricsBase.instance.DB.QueryContext(ctx, `
 			SELECT pid,
 			datname,
 			usename,
@@ -213,7 +223,7 @@ func (DBMetricsBase *DBMetricsBaseGatherer) GetMetrics(metrics *models.Metrics)
 	return nil
 }
 
-func (DBMetricsConfig *DBMetricsConfigGatherer) GetMetrics(metrics *models.Metrics) error {
+func (DBMetricsConfig *DBMetricsConfigGatherer) GetMetrics(ctx context.Context, metrics *models.Metrics) error {
 	defer utils.HandlePanic(DBMetricsConfig.configuration, DBMetricsConfig.logger)
 
 	output := make(map[string]models.MetricGroupValue)
@@ -222,7 +232,7 @@ func (DBMetricsConfig *DBMetricsConfigGatherer) GetMetrics(metrics *models.Metri
 	i := 0
 
 	// // Total tables count
-	// err := models.DB.QueryRow("SELECT COUNT(*) FROM information_schema.tables WHERE table_schema NOT IN ('information_schema', 'pg_catalog')").Scan(&row)
+	// err := DBMetricsConfig.instance.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema NOT IN ('information_schema', 'pg_catalog')").Scan(&row)
 	// if err != nil {
 	// 	DBMetricsConfig.logger.Error(err)
 	// }
@@ -230,7 +240,7 @@ func (DBMetricsConfig *DBMetricsConfigGatherer) GetMetrics(metrics *models.Metri
 
 	// // PostgreSQL table engine statistics (PostgreSQL doesn't have engines like MySQL, but we can collect table types)
 	// // Switch to each database to get table statistics
-	// rows, err := models.DB.Query(`
+	// rows, err := DBMetricsConfig.instance.DB.QueryContext(ctx, `
 	// 				SELECT
 	// 					t.table_type,
 	// 					COUNT(*) as table_count,
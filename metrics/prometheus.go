@@ -0,0 +1,355 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/models"
+	logging "github.com/google/logger"
+)
+
+const (
+	prometheusCounter = "counter"
+	prometheusGauge   = "gauge"
+)
+
+// Global status variables that are gauges; every other numeric status
+// variable is exported as a counter.
+var statusGaugePrefixes = []string{
+	"threads_", "open_", "innodb_buffer_pool_pages_", "innodb_buffer_pool_bytes_",
+	"innodb_row_lock_current_waits", "innodb_num_open_files", "innodb_page_size",
+	"innodb_data_pending_", "innodb_os_log_pending_", "innodb_history_list_length",
+	"max_used_connections", "qcache_free_", "qcache_queries_in_cache", "qcache_total_blocks",
+	"key_blocks_unused", "key_blocks_used", "key_blocks_not_flushed",
+	"slave_open_temp_tables", "replica_open_temp_tables", "performance_schema_",
+	"uptime", "numbackends", "connections_max",
+}
+
+type prometheusSample struct {
+	labels map[string]string
+	value  float64
+}
+
+type prometheusFamily struct {
+	help    string
+	typ     string
+	samples []prometheusSample
+}
+
+// prometheusRegistry collects the families of one scrape.
+type prometheusRegistry map[string]*prometheusFamily
+
+func (registry prometheusRegistry) add(name, typ, help string, value float64, labels map[string]string) {
+	if math.IsNaN(value) {
+		return
+	}
+	family, ok := registry[name]
+	if !ok {
+		family = &prometheusFamily{help: help, typ: typ}
+		registry[name] = family
+	}
+	family.samples = append(family.samples, prometheusSample{labels, value})
+}
+
+func (registry prometheusRegistry) write(w io.Writer) error {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := bufio.NewWriter(w)
+	for _, name := range names {
+		family := registry[name]
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, family.help, name, family.typ)
+		for _, sample := range family.samples {
+			out.WriteString(name)
+			writePrometheusLabels(out, sample.labels)
+			out.WriteString(" ")
+			out.WriteString(strconv.FormatFloat(sample.value, 'g', -1, 64))
+			out.WriteString("\n")
+		}
+	}
+	return out.Flush()
+}
+
+func writePrometheusLabels(out *bufio.Writer, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	out.WriteString("{")
+	for i, key := range keys {
+		if i > 0 {
+			out.WriteString(",")
+		}
+		out.WriteString(key)
+		out.WriteString(`="`)
+		out.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[key]))
+		out.WriteString(`"`)
+	}
+	out.WriteString("}")
+}
+
+// prometheusName turns a metric name into a valid Prometheus metric name.
+func prometheusName(parts ...string) string {
+	var name strings.Builder
+	for i, part := range parts {
+		if i > 0 {
+			name.WriteByte('_')
+		}
+		for _, r := range strings.ToLower(part) {
+			if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' {
+				name.WriteRune(r)
+			} else {
+				name.WriteByte('_')
+			}
+		}
+	}
+	return name.String()
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// normalize converts gatherer output, which may hold structs of the cloud
+// SDKs, into plain maps, slices and scalars.
+func normalize(value interface{}) interface{} {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}, string, float64, bool, nil:
+		return value
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil
+	}
+	return normalized
+}
+
+func withLabel(labels map[string]string, key, value string) map[string]string {
+	result := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		result[k] = v
+	}
+	result[key] = value
+	return result
+}
+
+func isStatusGauge(name string) bool {
+	for _, prefix := range statusGaugePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func addStatusMetrics(registry prometheusRegistry, status models.MetricGroupValue, labels map[string]string) {
+	for variable, value := range status {
+		number, ok := toFloat(value)
+		if !ok {
+			continue
+		}
+		name := prometheusName(variable)
+		if isStatusGauge(name) {
+			registry.add("releem_db_global_status_"+name, prometheusGauge, "Global status variable "+variable+".", number, labels)
+		} else {
+			registry.add("releem_db_global_status_"+name+"_total", prometheusCounter, "Global status variable "+variable+".", number, labels)
+		}
+	}
+}
+
+func addQueryMetrics(registry prometheusRegistry, queries []models.MetricGroupValue, labels map[string]string) {
+	for _, query := range queries {
+		queryLabels := withLabel(withLabel(labels, "schema", fmt.Sprint(query["schema_name"])), "digest", fmt.Sprint(query["query_id"]))
+		if calls, ok := toFloat(query["calls"]); ok {
+			registry.add("releem_db_query_calls_total", prometheusCounter, "Number of executions of the statement digest.", calls, queryLabels)
+		}
+		if sum, ok := toFloat(query["sum_time_us"]); ok {
+			registry.add("releem_db_query_time_microseconds_total", prometheusCounter, "Total execution time of the statement digest.", sum, queryLabels)
+		}
+		if avg, ok := toFloat(query["avg_time_us"]); ok {
+			registry.add("releem_db_query_avg_latency_microseconds", prometheusGauge, "Average latency of the statement digest.", avg, queryLabels)
+		}
+	}
+}
+
+func addSystemMetrics(registry prometheusRegistry, system models.MetricGroupValue, cumulativeIO bool, labels map[string]string) {
+	for group, value := range system {
+		typ := prometheusGauge
+		if cumulativeIO && (group == "DiskIO" || group == "IOP") {
+			typ = prometheusCounter
+		}
+		switch v := normalize(value).(type) {
+		case map[string]interface{}:
+			addSystemGroup(registry, prometheusName("releem_system", group), typ, v, labels)
+		case []interface{}:
+			for _, item := range v {
+				entry, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				// DiskIO entries are keyed by the device name, FileSystem
+				// entries carry the mount point in "path".
+				if len(entry) == 1 {
+					for device, counters := range entry {
+						if counters, ok := counters.(map[string]interface{}); ok {
+							addSystemGroup(registry, prometheusName("releem_system", group), typ, counters, withLabel(labels, "device", device))
+						}
+					}
+					continue
+				}
+				device := fmt.Sprint(entry["path"])
+				if path, ok := entry["path"].(string); !ok || path == "" {
+					device = fmt.Sprint(entry["name"])
+				}
+				addSystemGroup(registry, prometheusName("releem_system", group), typ, entry, withLabel(labels, "device", device))
+			}
+		}
+	}
+}
+
+func addSystemGroup(registry prometheusRegistry, prefix, typ string, values map[string]interface{}, labels map[string]string) {
+	for key, value := range values {
+		number, ok := toFloat(value)
+		if !ok {
+			if nested, isMap := value.(map[string]interface{}); isMap {
+				addSystemGroup(registry, prometheusName(prefix, key), typ, nested, labels)
+			}
+			continue
+		}
+		if _, isString := value.(string); isString {
+			continue
+		}
+		name := prometheusName(prefix, key)
+		if typ == prometheusCounter {
+			name += "_total"
+		}
+		registry.add(name, typ, "System metric "+key+".", number, labels)
+	}
+}
+
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
+
+func addHealthMetrics(registry prometheusRegistry, snapshot HealthSnapshot, labels map[string]string) {
+	registry.add("releem_agent_up", prometheusGauge, "Whether the agent pipeline is running.", 1, labels)
+	registry.add("releem_agent_last_collection_timestamp_seconds", prometheusGauge, "Time of the last metrics collection sent to the repeaters.", unixSeconds(snapshot.CollectedAt), labels)
+	for mode, send := range snapshot.Sends {
+		modeLabels := withLabel(labels, "mode", mode)
+		registry.add("releem_agent_sends_total", prometheusCounter, "Number of payloads handed to the repeaters.", float64(send.Attempts), modeLabels)
+		registry.add("releem_agent_send_errors_total", prometheusCounter, "Number of payloads the repeaters failed to deliver.", float64(send.Errors), modeLabels)
+		registry.add("releem_agent_last_successful_send_timestamp_seconds", prometheusGauge, "Time of the last successful delivery.", unixSeconds(send.LastSuccess), modeLabels)
+	}
+	for name, gatherer := range snapshot.Gatherers {
+		gathererLabels := withLabel(labels, "gatherer", name)
+		registry.add("releem_agent_gatherer_runs_total", prometheusCounter, "Number of gatherer runs.", float64(gatherer.Runs), gathererLabels)
+		registry.add("releem_agent_gatherer_errors_total", prometheusCounter, "Number of failed gatherer runs.", float64(gatherer.Errors), gathererLabels)
+		registry.add("releem_agent_gatherer_duration_seconds", prometheusGauge, "Duration of the last gatherer run.", gatherer.LastDuration.Seconds(), gathererLabels)
+	}
+	if !snapshot.TaskAt.IsZero() {
+		taskLabels := withLabel(withLabel(labels, "task_id", strconv.Itoa(snapshot.Task.ID)), "task_type_id", strconv.Itoa(snapshot.Task.TypeID))
+		registry.add("releem_agent_task_status", prometheusGauge, "Status of the last task reported by the agent.", float64(snapshot.Task.Status), taskLabels)
+		registry.add("releem_agent_task_exit_code", prometheusGauge, "Exit code of the last task reported by the agent.", float64(snapshot.Task.ExitCode), taskLabels)
+		registry.add("releem_agent_task_timestamp_seconds", prometheusGauge, "Time of the last task status report.", unixSeconds(snapshot.TaskAt), taskLabels)
+	}
+}
+
+// WritePrometheusMetrics writes the latest collection cycle and the agent
+// health of every pipeline in the Prometheus text exposition format.
+func WritePrometheusMetrics(w io.Writer, pipelines []*Pipeline) error {
+	registry := make(prometheusRegistry)
+	for _, pipeline := range pipelines {
+		labels := map[string]string{"db_instance": PipelineName(pipeline)}
+		_, _, repeaters, configuration := pipeline.parts()
+		snapshot := pipeline.Health.Snapshot()
+		addHealthMetrics(registry, snapshot, labels)
+		registry.add("releem_agent_spool_depth", prometheusGauge, "Number of payloads waiting in the spool.", float64(SpoolDepth(repeaters)), labels)
+		if snapshot.Metrics == nil {
+			continue
+		}
+		addStatusMetrics(registry, snapshot.Metrics.DB.Metrics.Status, labels)
+		addQueryMetrics(registry, snapshot.Metrics.DB.Queries, labels)
+		addSystemMetrics(registry, snapshot.Metrics.System.Metrics, configuration.InstanceType == "local", labels)
+	}
+	return registry.write(w)
+}
+
+// PipelineName returns the name identifying a pipeline in local endpoints.
+func PipelineName(pipeline *Pipeline) string {
+	configuration := pipeline.GetConfiguration()
+	if configuration.InstanceName != "" {
+		return configuration.InstanceName
+	}
+	if configuration.Hostname != "" {
+		return configuration.Hostname
+	}
+	return "default"
+}
+
+// ServePrometheus starts the /metrics listener in the background.
+func ServePrometheus(address string, pipelines []*Pipeline, logger logging.Logger) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := WritePrometheusMetrics(w, pipelines); err != nil {
+			logger.Error("Prometheus: failed to write metrics: ", err)
+		}
+	})
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		logger.Info("Prometheus metrics are served on ", address, "/metrics")
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("Prometheus: listener failed: ", err)
+		}
+	}()
+	return server
+}
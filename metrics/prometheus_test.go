@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/models"
+	logging "github.com/google/logger"
+)
+
+type stubGatherer struct{ err error }
+
+func (gatherer stubGatherer) GetMetrics(ctx context.Context, metrics *models.Metrics) error {
+	return gatherer.err
+}
+
+type stubRepeater struct{}
+
+func (stubRepeater) ProcessMetrics(context models.MetricContext, metrics models.Metrics, Mode models.ModeType) (string, error) {
+	return "", nil
+}
+
+func TestWritePrometheusMetrics(t *testing.T) {
+	configuration := &config.Config{InstanceName: "primary", InstanceType: "local"}
+	pipeline := NewPipeline(models.NewInstance(nil), map[string][]models.MetricsGatherer{
+		"default": {stubGatherer{errors.New("connection refused")}},
+	}, stubRepeater{}, configuration)
+
+	pipeline.Gatherers["default"][0].GetMetrics(context.Background(), &models.Metrics{})
+
+	var metrics models.Metrics
+	metrics.DB.Metrics.Status = models.MetricGroupValue{"Questions": "42", "Threads_running": "3", "Innodb_buffer_pool_resize_status": "done"}
+	metrics.DB.Queries = []models.MetricGroupValue{{"schema_name": "shop", "query_id": "abc", "calls": 5, "avg_time_us": 10, "sum_time_us": 50}}
+	metrics.System.Metrics = models.MetricGroupValue{
+		"CPU":    models.MetricGroupValue{"load1": 0.5},
+		"DiskIO": []models.MetricGroupValue{{"sda": models.MetricGroupValue{"readCount": 7}}},
+	}
+	pipeline.Repeaters.ProcessMetrics(configuration, metrics, models.ModeType{Name: "Metrics", Type: ""})
+
+	var out bytes.Buffer
+	if err := WritePrometheusMetrics(&out, []*Pipeline{pipeline}); err != nil {
+		t.Fatalf("WritePrometheusMetrics: %v", err)
+	}
+	text := out.String()
+	for _, want := range []string{
+		"# TYPE releem_db_global_status_questions_total counter",
+		`releem_db_global_status_questions_total{db_instance="primary"} 42`,
+		"# TYPE releem_db_global_status_threads_running gauge",
+		`releem_db_query_calls_total{db_instance="primary",digest="abc",schema="shop"} 5`,
+		`releem_system_cpu_load1{db_instance="primary"} 0.5`,
+		`releem_system_diskio_readcount_total{db_instance="primary",device="sda"} 7`,
+		`releem_agent_gatherer_errors_total{db_instance="primary",gatherer="metrics.stubGatherer"} 1`,
+		`releem_agent_send_errors_total{db_instance="primary",mode="Metrics/"} 0`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("output is missing %q:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "resize_status") {
+		t.Errorf("non-numeric status variables must be skipped:\n%s", text)
+	}
+}
+
+func logger() logging.Logger {
+	return *logging.Init("releem-agent-test", false, false, io.Discard)
+}
@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"context"
 	"os"
 	"os/signal"
 	"sync"
@@ -8,6 +9,7 @@ import (
 	"time"
 
 	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/history"
 	"github.com/Releem/mysqlconfigurer/models"
 	"github.com/Releem/mysqlconfigurer/tasks"
 	"github.com/Releem/mysqlconfigurer/utils"
@@ -19,111 +21,379 @@ var Ready bool
 // Set up channel on which to send signal notifications.
 // We must use a buffered channel or risk missing the signal
 // if we're not ready to receive when the signal is sent.
-func makeTerminateChannel() <-chan os.Signal {
+func makeTerminateChannel() chan os.Signal {
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 	return ch
 }
 
-func RunWorker(gatherers map[string][]models.MetricsGatherer, repeaters models.MetricsRepeater, logger logging.Logger,
-	configuration *config.Config, Mode models.ModeType) {
-	defer utils.HandlePanic(configuration, logger)
+// Pipeline is the set of gatherers and repeaters that collect and send the
+// metrics of one monitored database instance.
+type Pipeline struct {
+	mutex         sync.RWMutex
+	Instance      *models.Instance
+	Gatherers     map[string][]models.MetricsGatherer
+	Repeaters     models.MetricsRepeater
+	Configuration *config.Config
+	Health        *Health
+	updated       chan struct{}
+}
+
+// NewPipeline wraps the gatherers and repeaters so that their activity is
+// recorded in the pipeline Health.
+func NewPipeline(instance *models.Instance, gatherers map[string][]models.MetricsGatherer, repeaters models.MetricsRepeater, configuration *config.Config) *Pipeline {
+	pipeline := &Pipeline{Health: NewHealth(), updated: make(chan struct{}, 1)}
+	pipeline.set(instance, gatherers, repeaters, configuration)
+	return pipeline
+}
+
+func (pipeline *Pipeline) set(instance *models.Instance, gatherers map[string][]models.MetricsGatherer, repeaters models.MetricsRepeater, configuration *config.Config) {
+	instrumented := make(map[string][]models.MetricsGatherer, len(gatherers))
+	for group, list := range gatherers {
+		instrumented[group] = make([]models.MetricsGatherer, 0, len(list))
+		for _, gatherer := range list {
+			instrumented[group] = append(instrumented[group], instrumentedGatherer{models.GathererName(gatherer), gatherer, pipeline.Health})
+		}
+	}
+	pipeline.Instance = instance
+	pipeline.Gatherers = instrumented
+	pipeline.Repeaters = instrumentedRepeater{repeaters, pipeline.Health}
+	pipeline.Configuration = configuration
+}
+
+// Update swaps in the parts of next, built from a reloaded configuration, and
+// reschedules the timers. Work in flight finishes with the previous parts.
+func (pipeline *Pipeline) Update(next *Pipeline) {
+	instance, instrumented, repeaters, configuration := next.parts()
+	gatherers := make(map[string][]models.MetricsGatherer, len(instrumented))
+	for group, list := range instrumented {
+		for _, gatherer := range list {
+			if wrapped, ok := gatherer.(instrumentedGatherer); ok {
+				gatherer = wrapped.gatherer
+			}
+			gatherers[group] = append(gatherers[group], gatherer)
+		}
+	}
+	if wrapped, ok := repeaters.(instrumentedRepeater); ok {
+		repeaters = wrapped.repeater
+	}
+
+	pipeline.mutex.Lock()
+	pipeline.set(instance, gatherers, repeaters, configuration)
+	pipeline.mutex.Unlock()
+	select {
+	case pipeline.updated <- struct{}{}:
+	default:
+	}
+}
+
+func (pipeline *Pipeline) parts() (*models.Instance, map[string][]models.MetricsGatherer, models.MetricsRepeater, *config.Config) {
+	pipeline.mutex.RLock()
+	defer pipeline.mutex.RUnlock()
+	return pipeline.Instance, pipeline.Gatherers, pipeline.Repeaters, pipeline.Configuration
+}
+
+// GetConfiguration returns the configuration the pipeline currently runs with.
+func (pipeline *Pipeline) GetConfiguration() *config.Config {
+	_, _, _, configuration := pipeline.parts()
+	return configuration
+}
 
-	var GenerateTimer, timer, QueryOptimizationTimer *time.Timer
+// GetInstance returns the database instance the pipeline currently monitors.
+func (pipeline *Pipeline) GetInstance() *models.Instance {
+	instance, _, _, _ := pipeline.parts()
+	return instance
+}
 
-	models.SampleQueries = make(map[string]string)
-	models.SampleQueriesMutex = sync.RWMutex{}
+// RunWorker runs every pipeline in its own goroutine and returns once every
+// pipeline is done, in one-shot modes, or once the agent is asked to terminate
+// by a signal or by cancelling ctx. A one-shot run started by a task is
+// refused, before any pipeline runs, unless it targets the instance of the task.
+//
+// On termination the timers stop and in-flight collections are cancelled.
+// In-flight sends get up to gracePeriod to complete before they are aborted,
+// aborted metrics being kept in the spool. Configuration apply tasks are
+// always waited for.
+func RunWorker(ctx context.Context, pipelines []*Pipeline, logger logging.Logger, Mode models.ModeType, gracePeriod time.Duration) error {
+	if isOneShotMode(Mode) {
+		for _, pipeline := range pipelines {
+			if err := tasks.CheckTaskTarget(pipeline.GetConfiguration()); err != nil {
+				return err
+			}
+		}
+	}
 	terminator := makeTerminateChannel()
+	defer signal.Stop(terminator)
+	stopCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	abortCtx, abort := context.WithCancel(context.WithoutCancel(ctx))
+	defer abort()
+	finished := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for _, pipeline := range pipelines {
+		wg.Add(1)
+		go func(pipeline *Pipeline) {
+			defer wg.Done()
+			runPipeline(stopCtx, abortCtx, pipeline, logger, Mode)
+		}(pipeline)
+	}
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-terminator:
+	case <-ctx.Done():
+	case <-finished:
+		return nil
+	}
+	logger.Info("Exiting, waiting up to ", gracePeriod, " for in-flight work")
+	stop()
+	select {
+	case <-finished:
+		return nil
+	case <-time.After(gracePeriod):
+	}
+	logger.Warning("Shutdown grace period expired, aborting in-flight sends")
+	abort()
+	for {
+		select {
+		case <-finished:
+			return nil
+		case <-time.After(5 * time.Second):
+			if !tasks.ApplyInProgress() {
+				logger.Warning("Exiting with work still in flight")
+				return nil
+			}
+			logger.Info("Waiting for the configuration apply task to finish")
+		}
+	}
+}
 
-	if (Mode.Name == "Configurations" && Mode.Type != "Default") || Mode.Name == "Event" || Mode.Name == "TaskByName" {
-		GenerateTimer = time.NewTimer(1 * time.Second)
-		timer = time.NewTimer(24 * time.Hour)
-		QueryOptimizationTimer = time.NewTimer(24 * time.Hour)
+// timerSchedule holds when each timer of a pipeline fires next, so that the
+// timers can be rescheduled when the configuration is reloaded.
+type timerSchedule struct {
+	metrics           time.Time
+	configuration     time.Time
+	queryOptimization time.Time
+	sampleQueries     time.Time
+}
+
+func (schedule *timerSchedule) reset(timer *time.Timer, next *time.Time, period time.Duration) {
+	*next = time.Now().Add(period)
+	timer.Reset(period)
+}
+
+// newTimer starts a timer firing at next, or within period if that is sooner.
+func newTimer(next *time.Time, period time.Duration) *time.Timer {
+	now := time.Now()
+	if deadline := now.Add(period); next.After(deadline) {
+		*next = deadline
+	}
+	return time.NewTimer(next.Sub(now))
+}
+
+func isOneShotMode(Mode models.ModeType) bool {
+	return (Mode.Name == "Configurations" && Mode.Type != "Default") || Mode.Name == "Event" || Mode.Name == "TaskByName"
+}
+
+// runPipeline runs the timers of a pipeline until stopCtx is done and waits
+// for the work they started. Sends are aborted when abortCtx is done. The
+// timers are rescheduled whenever the pipeline is updated with a new configuration.
+func runPipeline(stopCtx context.Context, abortCtx context.Context, pipeline *Pipeline, logger logging.Logger, Mode models.ModeType) {
+	var inflight sync.WaitGroup
+	defer inflight.Wait()
+
+	_, gatherers, repeaters, configuration := pipeline.parts()
+	if !isOneShotMode(Mode) {
+		// Tasks left over by a previous run are reconciled before the timers
+		// start, so no new task is received before they are.
+		tasks.ReconcileTasks(stopCtx, repeaters, gatherers["default"], logger, configuration)
+	}
+
+	now := time.Now()
+	var schedule timerSchedule
+	if isOneShotMode(Mode) {
+		schedule = timerSchedule{
+			metrics:           now.Add(24 * time.Hour),
+			configuration:     now.Add(1 * time.Second),
+			queryOptimization: now.Add(24 * time.Hour),
+			sampleQueries:     now.Add(1 * time.Second),
+		}
 	} else {
-		GenerateTimer = time.NewTimer(configuration.GenerateConfigPeriod * time.Second)
-		timer = time.NewTimer(1 * time.Second)
-		QueryOptimizationTimer = time.NewTimer(1 * time.Minute)
+		schedule = timerSchedule{
+			metrics:           now.Add(1 * time.Second),
+			configuration:     now.Add(configuration.GenerateConfigPeriod * time.Second),
+			queryOptimization: now.Add(1 * time.Minute),
+			sampleQueries:     now.Add(1 * time.Second),
+		}
+	}
+	oneShotDone := make(chan struct{}, 1)
+	for runTimers(stopCtx, abortCtx, pipeline, logger, Mode, &schedule, &inflight, oneShotDone) {
+		logger.Info("Configuration reloaded, timers rescheduled")
+	}
+}
+
+// runTimers runs the timers with the current pipeline parts. It returns true
+// when the pipeline was updated and the timers must be rescheduled.
+func runTimers(stopCtx context.Context, abortCtx context.Context, pipeline *Pipeline, logger logging.Logger, Mode models.ModeType, schedule *timerSchedule, inflight *sync.WaitGroup, oneShotDone chan struct{}) bool {
+	instance, gatherers, repeaters, configuration := pipeline.parts()
+	health := pipeline.Health
+	defer utils.HandlePanic(configuration, logger)
+
+	infinity := 24 * time.Hour * 365
+	metricsPeriod, generatePeriod, queryOptimizationPeriod := configuration.MetricsPeriod*time.Second, configuration.GenerateConfigPeriod*time.Second, configuration.QueryOptimizationPeriod*time.Second
+	if isOneShotMode(Mode) {
+		metricsPeriod, generatePeriod, queryOptimizationPeriod = infinity, infinity, infinity
 	}
-	CollectSampleQueries := time.NewTimer(1 * time.Second)
+	timer := newTimer(&schedule.metrics, metricsPeriod)
+	GenerateTimer := newTimer(&schedule.configuration, generatePeriod)
+	QueryOptimizationTimer := newTimer(&schedule.queryOptimization, queryOptimizationPeriod)
+	CollectSampleQueries := newTimer(&schedule.sampleQueries, configuration.CollectSampleQueriesPeriod*time.Second)
+	// Sample queries hold the query texts, only collected for Query Analytics.
 	if !configuration.QueryOptimization {
 		CollectSampleQueries.Stop()
 	}
-	utils.GetStrategyCollectionSampleQueries(configuration, logger, "0")
-loop:
+	defer func() {
+		timer.Stop()
+		GenerateTimer.Stop()
+		QueryOptimizationTimer.Stop()
+		CollectSampleQueries.Stop()
+	}()
+
+	utils.GetStrategyCollectionSampleQueries(instance, configuration, logger, "0")
 	for {
 		select {
-		case <-terminator:
-			logger.Info("Exiting")
-			break loop
+		case <-stopCtx.Done():
+			return false
+		case <-oneShotDone:
+			return false
+		case <-pipeline.updated:
+			return true
 		case <-timer.C:
 			logger.Info("* Starting to collect metrics...")
-			timer.Reset(configuration.MetricsPeriod * time.Second)
+			schedule.reset(timer, &schedule.metrics, configuration.MetricsPeriod*time.Second)
+			fired := health.timerFired("metrics")
+			inflight.Add(1)
 			go func() {
+				defer inflight.Done()
 				defer utils.HandlePanic(configuration, logger)
-				metrics := utils.CollectMetrics(append(gatherers["default"], gatherers["metrics"]...), logger, configuration)
+				metrics := utils.CollectMetricsContext(stopCtx, append(gatherers["default"], gatherers["metrics"]...), logger, configuration)
 				if metrics == nil {
 					return
 				}
-				utils.GetStrategyCollectionSampleQueries(configuration, logger, utils.ConvertUptimeToStr(metrics.DB.Metrics.Status))
-				response := utils.ProcessRepeaters(metrics, repeaters, configuration, logger, models.ModeType{Name: "Metrics", Type: ""})
+				utils.GetStrategyCollectionSampleQueries(instance, configuration, logger, utils.ConvertUptimeToStr(metrics.DB.Metrics.Status))
+				response := utils.ProcessRepeatersContext(abortCtx, metrics, repeaters, configuration, logger, models.ModeType{Name: "Metrics", Type: ""})
+				if health.sentSince(models.ModeType{Name: "Metrics", Type: ""}, fired) {
+					health.timerSucceeded("metrics", fired)
+				}
 				if response == "Task" {
 					logger.Info("* A task received by the agent...")
-					f := tasks.ProcessTaskFunc(repeaters, gatherers["default"], logger, configuration)
-					time.AfterFunc(5*time.Second, f)
+					f := tasks.ProcessTaskFunc(stopCtx, instance, repeaters, gatherers["default"], logger, configuration)
+					inflight.Add(1)
+					go func() {
+						defer inflight.Done()
+						select {
+						case <-stopCtx.Done():
+						case <-time.After(5 * time.Second):
+							f()
+						}
+					}()
 				}
+				// Restarts deferred to a maintenance window run once it opens.
+				inflight.Add(1)
+				go func() {
+					defer inflight.Done()
+					tasks.RunScheduledTasks(stopCtx, repeaters, gatherers["default"], logger, configuration)
+				}()
 
 				logger.Info("* Database Metrics are saved...")
 			}()
 		case <-GenerateTimer.C:
 			logger.Info("* Starting to collect metrics...")
-			GenerateTimer.Reset(configuration.GenerateConfigPeriod * time.Second)
+			schedule.reset(GenerateTimer, &schedule.configuration, configuration.GenerateConfigPeriod*time.Second)
+			fired := health.timerFired("configuration")
+			inflight.Add(1)
 			go func() {
+				defer inflight.Done()
 				var metrics *models.Metrics
 				logger.Info("* Collecting metrics...")
 				defer utils.HandlePanic(configuration, logger)
+				if Mode.Name == "Event" {
+					recordAppliedConfiguration(configuration, Mode, logger)
+				}
 				if Mode.Name == "TaskByName" {
 					if Mode.Type == "queries_optimization" {
-						metrics = utils.CollectMetrics(append(gatherers["default"], gatherers["query_optimization"]...), logger, configuration)
+						metrics = utils.CollectMetricsContext(stopCtx, append(gatherers["default"], gatherers["query_optimization"]...), logger, configuration)
 					} else {
-						metrics = utils.CollectMetrics(append(gatherers["default"], gatherers["configuration"]...), logger, configuration)
+						metrics = utils.CollectMetricsContext(stopCtx, append(gatherers["default"], gatherers["configuration"]...), logger, configuration)
 					}
 				} else {
-					metrics = utils.CollectMetrics(append(gatherers["default"], gatherers["configuration"]...), logger, configuration)
+					metrics = utils.CollectMetricsContext(stopCtx, append(gatherers["default"], gatherers["configuration"]...), logger, configuration)
 				}
 				if metrics == nil {
 					return
 				}
-				utils.GetStrategyCollectionSampleQueries(configuration, logger, "0")
+				utils.GetStrategyCollectionSampleQueries(instance, configuration, logger, "0")
 				logger.Info("* Sending metrics to the Releem Cloud Platform...")
-				utils.ProcessRepeaters(metrics, repeaters, configuration, logger, Mode)
+				utils.ProcessRepeatersContext(abortCtx, metrics, repeaters, configuration, logger, Mode)
+				if health.sentSince(Mode, fired) {
+					health.timerSucceeded("configuration", fired)
+				}
 				if Mode.Name == "Configurations" {
 					logger.Info("* The recommended Database configuration has been downloaded to: ", configuration.GetReleemConfDir())
 				}
 
-				if (Mode.Name == "Configurations" && Mode.Type != "Default") || Mode.Name == "Event" || Mode.Name == "TaskByName" {
-					logger.Info("Exiting")
-					os.Exit(0)
+				if isOneShotMode(Mode) {
+					oneShotDone <- struct{}{}
+					return
 				}
 				logger.Info("* Database Metrics are saved...")
 			}()
 		case <-QueryOptimizationTimer.C:
 			logger.Info("* Starting to collect Database metrics for Query Analytics...")
-			QueryOptimizationTimer.Reset(configuration.QueryOptimizationPeriod * time.Second)
+			schedule.reset(QueryOptimizationTimer, &schedule.queryOptimization, configuration.QueryOptimizationPeriod*time.Second)
+			fired := health.timerFired("query_optimization")
+			inflight.Add(1)
 			go func() {
+				defer inflight.Done()
 				defer utils.HandlePanic(configuration, logger)
-				metrics := utils.CollectMetrics(append(gatherers["default"], gatherers["query_optimization"]...), logger, configuration)
+				metrics := utils.CollectMetricsContext(stopCtx, append(gatherers["default"], gatherers["query_optimization"]...), logger, configuration)
 				if metrics == nil {
 					return
 				}
-				utils.ProcessRepeaters(metrics, repeaters, configuration, logger, models.ModeType{Name: "Metrics", Type: "Queries"})
+				utils.ProcessRepeatersContext(abortCtx, metrics, repeaters, configuration, logger, models.ModeType{Name: "Metrics", Type: "Queries"})
+				if health.sentSince(models.ModeType{Name: "Metrics", Type: "Queries"}, fired) {
+					health.timerSucceeded("query_optimization", fired)
+				}
 				logger.Info("* Database metrics for Query Analytics are saved...")
 			}()
 		case <-CollectSampleQueries.C:
-			CollectSampleQueries.Reset(configuration.CollectSampleQueriesPeriod * time.Second)
+			schedule.reset(CollectSampleQueries, &schedule.sampleQueries, configuration.CollectSampleQueriesPeriod*time.Second)
+			fired := health.timerFired("sample_queries")
+			inflight.Add(1)
 			go func() {
+				defer inflight.Done()
 				defer utils.HandlePanic(configuration, logger)
-				utils.CollectMetrics(gatherers["sample_queries"], logger, configuration)
+				if utils.CollectMetricsContext(stopCtx, gatherers["sample_queries"], logger, configuration) != nil {
+					health.timerSucceeded("sample_queries", fired)
+				}
 			}()
 		}
 	}
 }
+
+// recordAppliedConfiguration adds the configuration applied or rolled back by
+// the apply script, which reports it with an event, to the configuration
+// history.
+func recordAppliedConfiguration(configuration *config.Config, Mode models.ModeType, logger logging.Logger) {
+	source := map[string]string{"config_applied": history.SourceApply, "config_rollback": history.SourceRollback}[Mode.Type]
+	if source == "" {
+		return
+	}
+	if _, err := history.NewStore(configuration).RecordApplied(configuration, history.EnvironmentVersion(source)); err != nil {
+		logger.Error("Failed to record the applied configuration in the history: ", err)
+	}
+}
@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/models"
+)
+
+// blockingRepeater holds every send until it is aborted.
+type blockingRepeater struct {
+	started chan struct{}
+	aborted chan struct{}
+}
+
+func (repeater blockingRepeater) ProcessMetrics(metricContext models.MetricContext, metrics models.Metrics, Mode models.ModeType) (string, error) {
+	return repeater.ProcessMetricsContext(context.Background(), metricContext, metrics, Mode)
+}
+
+func (repeater blockingRepeater) ProcessMetricsContext(ctx context.Context, metricContext models.MetricContext, metrics models.Metrics, Mode models.ModeType) (string, error) {
+	select {
+	case repeater.started <- struct{}{}:
+	default:
+	}
+	<-ctx.Done()
+	close(repeater.aborted)
+	return "", ctx.Err()
+}
+
+func TestRunWorkerAbortsInFlightSendsAfterGracePeriod(t *testing.T) {
+	configuration := &config.Config{MetricsPeriod: 3600, GenerateConfigPeriod: 3600, QueryOptimizationPeriod: 3600, GathererTimeout: 1}
+	repeater := blockingRepeater{started: make(chan struct{}, 1), aborted: make(chan struct{})}
+	pipeline := NewPipeline(models.NewInstance(nil), map[string][]models.MetricsGatherer{}, repeater, configuration)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	returned := make(chan struct{})
+	go func() {
+		RunWorker(ctx, []*Pipeline{pipeline}, logger(), models.ModeType{Name: "Configurations", Type: "Default"}, 100*time.Millisecond)
+		close(returned)
+	}()
+
+	select {
+	case <-repeater.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the metrics timer did not fire")
+	}
+	cancel()
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunWorker did not return after the grace period")
+	}
+	select {
+	case <-repeater.aborted:
+	default:
+		t.Fatal("the in-flight send was not aborted")
+	}
+}
+
+// sentRepeater reports every metrics send.
+type sentRepeater chan struct{}
+
+func (repeater sentRepeater) ProcessMetrics(metricContext models.MetricContext, metrics models.Metrics, Mode models.ModeType) (string, error) {
+	if Mode.Name == "Metrics" && Mode.Type == "" {
+		repeater <- struct{}{}
+	}
+	return "", nil
+}
+
+func TestPipelineUpdateReschedulesTimers(t *testing.T) {
+	configuration := &config.Config{MetricsPeriod: 3600, GenerateConfigPeriod: 3600, QueryOptimizationPeriod: 3600, CollectSampleQueriesPeriod: 3600, GathererTimeout: 1}
+	sent := make(sentRepeater, 10)
+	pipeline := NewPipeline(models.NewInstance(nil), map[string][]models.MetricsGatherer{}, sent, configuration)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go RunWorker(ctx, []*Pipeline{pipeline}, logger(), models.ModeType{Name: "Configurations", Type: "Default"}, time.Second)
+
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the metrics timer did not fire")
+	}
+
+	reloaded := *configuration
+	reloaded.MetricsPeriod = 1
+	pipeline.Update(NewPipeline(models.NewInstance(nil), map[string][]models.MetricsGatherer{}, sent, &reloaded))
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the metrics timer was not rescheduled with the reloaded interval")
+	}
+	if pipeline.GetConfiguration().MetricsPeriod != 1 {
+		t.Fatal("the pipeline should run with the reloaded configuration")
+	}
+}
+
+// sampleGatherer reports every collection of sample queries.
+type sampleGatherer chan struct{}
+
+func (gatherer sampleGatherer) GetMetrics(ctx context.Context, metrics *models.Metrics) error {
+	gatherer <- struct{}{}
+	return nil
+}
+
+func TestSampleQueriesCollectedWithQueryOptimizationOnly(t *testing.T) {
+	// PostgreSQL skips the setup of the MySQL statement consumers.
+	configuration := &config.Config{PgUser: "postgres", PgPassword: "postgres", MetricsPeriod: 3600, GenerateConfigPeriod: 3600, QueryOptimizationPeriod: 3600, CollectSampleQueriesPeriod: 1, GathererTimeout: 1}
+	collected := make(sampleGatherer, 10)
+	gatherers := map[string][]models.MetricsGatherer{"sample_queries": {collected}}
+	pipeline := NewPipeline(models.NewInstance(nil), gatherers, make(sentRepeater, 10), configuration)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go RunWorker(ctx, []*Pipeline{pipeline}, logger(), models.ModeType{Name: "Configurations", Type: "Default"}, time.Second)
+	select {
+	case <-collected:
+		t.Fatal("sample queries were collected with query_optimization off")
+	case <-time.After(2500 * time.Millisecond):
+	}
+
+	reloaded := *configuration
+	reloaded.QueryOptimization = true
+	pipeline.Update(NewPipeline(models.NewInstance(nil), gatherers, make(sentRepeater, 10), &reloaded))
+	select {
+	case <-collected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sample queries were not collected once query_optimization was turned on")
+	}
+}
@@ -0,0 +1,200 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/models"
+	logging "github.com/google/logger"
+)
+
+var secretConfigKey = regexp.MustCompile(`(?i)password|apikey|secret|token`)
+
+type timerStatus struct {
+	LastFired     *time.Time `json:"last_fired,omitempty"`
+	LastSucceeded *time.Time `json:"last_succeeded,omitempty"`
+}
+
+type gathererStatus struct {
+	Runs           uint64     `json:"runs"`
+	Errors         uint64     `json:"errors"`
+	LastRun        *time.Time `json:"last_run,omitempty"`
+	LastDurationMs float64    `json:"last_duration_ms"`
+	LastError      string     `json:"last_error,omitempty"`
+	LastErrorAt    *time.Time `json:"last_error_at,omitempty"`
+}
+
+type sendStatus struct {
+	Attempts    uint64     `json:"attempts"`
+	Errors      uint64     `json:"errors"`
+	LastAttempt *time.Time `json:"last_attempt,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+type instanceStatus struct {
+	Name        string                    `json:"name"`
+	Healthy     bool                      `json:"healthy"`
+	Config      map[string]interface{}    `json:"config"`
+	Timers      map[string]timerStatus    `json:"timers"`
+	Gatherers   map[string]gathererStatus `json:"gatherers"`
+	Sends       map[string]sendStatus     `json:"sends"`
+	CurrentTask *models.Task              `json:"current_task"`
+	LastTask    *models.Task              `json:"last_task"`
+	SpoolDepth  int                       `json:"spool_depth"`
+}
+
+// AgentStatus is the document served by the local status API.
+type AgentStatus struct {
+	Version   string           `json:"version"`
+	StartedAt time.Time        `json:"started_at"`
+	Healthy   bool             `json:"healthy"`
+	Instances []instanceStatus `json:"instances"`
+}
+
+func timePointer(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// redactedConfig returns the configuration with every secret replaced.
+func redactedConfig(configuration *config.Config) map[string]interface{} {
+	summary := make(map[string]interface{})
+	data, err := json.Marshal(configuration)
+	if err != nil {
+		return summary
+	}
+	json.Unmarshal(data, &summary)
+	for key, value := range summary {
+		if secretConfigKey.MatchString(key) {
+			if value != "" {
+				summary[key] = "********"
+			}
+		}
+	}
+	return summary
+}
+
+// isPipelineHealthy reports whether the metrics timer of a pipeline delivered
+// a payload recently. A pipeline that has not completed three metrics periods
+// yet is healthy.
+func isPipelineHealthy(snapshot HealthSnapshot, configuration *config.Config, startedAt time.Time, now time.Time) bool {
+	grace := 3 * configuration.MetricsPeriod * time.Second
+	if now.Sub(startedAt) < grace {
+		return true
+	}
+	timer, ok := snapshot.Timers["metrics"]
+	return ok && now.Sub(timer.LastSucceeded) < grace
+}
+
+// GetAgentStatus builds the status document of the agent.
+func GetAgentStatus(pipelines []*Pipeline, startedAt time.Time) AgentStatus {
+	now := time.Now()
+	status := AgentStatus{
+		Version:   config.ReleemAgentVersion,
+		StartedAt: startedAt,
+		Healthy:   true,
+	}
+	for _, pipeline := range pipelines {
+		_, _, repeaters, configuration := pipeline.parts()
+		snapshot := pipeline.Health.Snapshot()
+		instance := instanceStatus{
+			Name:        PipelineName(pipeline),
+			Healthy:     isPipelineHealthy(snapshot, configuration, startedAt, now),
+			Config:      redactedConfig(configuration),
+			Timers:      make(map[string]timerStatus),
+			Gatherers:   make(map[string]gathererStatus),
+			Sends:       make(map[string]sendStatus),
+			CurrentTask: snapshot.CurrentTask,
+			LastTask:    snapshot.LastTask,
+			SpoolDepth:  SpoolDepth(repeaters),
+		}
+		for name, timer := range snapshot.Timers {
+			instance.Timers[name] = timerStatus{timePointer(timer.LastFired), timePointer(timer.LastSucceeded)}
+		}
+		for name, gatherer := range snapshot.Gatherers {
+			instance.Gatherers[name] = gathererStatus{
+				Runs:           gatherer.Runs,
+				Errors:         gatherer.Errors,
+				LastRun:        timePointer(gatherer.LastRun),
+				LastDurationMs: float64(gatherer.LastDuration) / float64(time.Millisecond),
+				LastError:      gatherer.LastError,
+				LastErrorAt:    timePointer(gatherer.LastErrorAt),
+			}
+		}
+		for mode, send := range snapshot.Sends {
+			instance.Sends[mode] = sendStatus{
+				Attempts:    send.Attempts,
+				Errors:      send.Errors,
+				LastAttempt: timePointer(send.LastAttempt),
+				LastSuccess: timePointer(send.LastSuccess),
+				LastError:   send.LastError,
+			}
+		}
+		status.Healthy = status.Healthy && instance.Healthy
+		status.Instances = append(status.Instances, instance)
+	}
+	return status
+}
+
+// isLoopback reports whether the host of an address is a loopback address.
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ServeStatus starts the local status API in the background. It only listens
+// on loopback addresses and refuses requests from other hosts.
+func ServeStatus(address string, pipelines []*Pipeline, logger logging.Logger) (*http.Server, error) {
+	if !isLoopback(address) {
+		return nil, errors.New("status API must listen on a loopback address, got " + address)
+	}
+	startedAt := time.Now()
+	writeStatus := func(w http.ResponseWriter, r *http.Request, healthOnly bool) {
+		if !isLoopback(r.RemoteAddr) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		status := GetAgentStatus(pipelines, startedAt)
+		w.Header().Set("Content-Type", "application/json")
+		if !status.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		var document interface{} = status
+		if healthOnly {
+			document = map[string]interface{}{"healthy": status.Healthy, "version": status.Version}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(document); err != nil {
+			logger.Error("Status API: failed to write response: ", err)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) { writeStatus(w, r, false) })
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) { writeStatus(w, r, true) })
+
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		logger.Info("Status API is served on ", address)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("Status API: listener failed: ", err)
+		}
+	}()
+	return server, nil
+}
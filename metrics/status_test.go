@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/models"
+)
+
+func TestGetAgentStatusRedactsSecretsAndReportsTasks(t *testing.T) {
+	configuration := &config.Config{InstanceName: "primary", ApiKey: "secret-key", MysqlUser: "releem", MetricsPeriod: 60}
+	pipeline := NewPipeline(models.NewInstance(nil), map[string][]models.MetricsGatherer{}, stubRepeater{}, configuration)
+
+	var metrics models.Metrics
+	metrics.ReleemAgent.Tasks = models.Task{ID: 12, TypeID: 1, Status: 3}
+	pipeline.Repeaters.ProcessMetrics(configuration, metrics, models.ModeType{Name: "Task", Type: "Status"})
+
+	status := GetAgentStatus([]*Pipeline{pipeline}, time.Now())
+	instance := status.Instances[0]
+	if instance.Config["ApiKey"] != "********" || instance.Config["MysqlUser"] != "releem" {
+		t.Fatalf("config summary = %v", instance.Config)
+	}
+	if instance.CurrentTask == nil || instance.CurrentTask.ID != 12 || instance.LastTask != nil {
+		t.Fatalf("current task = %v, last task = %v", instance.CurrentTask, instance.LastTask)
+	}
+
+	metrics.ReleemAgent.Tasks.Status = 1
+	pipeline.Repeaters.ProcessMetrics(configuration, metrics, models.ModeType{Name: "Task", Type: "Status"})
+	instance = GetAgentStatus([]*Pipeline{pipeline}, time.Now()).Instances[0]
+	if instance.CurrentTask != nil || instance.LastTask == nil || instance.LastTask.Status != 1 {
+		t.Fatalf("current task = %v, last task = %v", instance.CurrentTask, instance.LastTask)
+	}
+}
+
+func TestAgentStatusHealthFollowsMetricsTimer(t *testing.T) {
+	configuration := &config.Config{MetricsPeriod: 60}
+	startedAt := time.Now().Add(-time.Hour)
+	health := NewHealth()
+	if isPipelineHealthy(health.Snapshot(), configuration, startedAt, time.Now()) {
+		t.Fatal("a pipeline that never delivered metrics after the grace period must be unhealthy")
+	}
+	fired := health.timerFired("metrics")
+	health.timerSucceeded("metrics", fired)
+	if !isPipelineHealthy(health.Snapshot(), configuration, startedAt, time.Now()) {
+		t.Fatal("a pipeline that just delivered metrics must be healthy")
+	}
+}
+
+func TestServeStatusRejectsNonLoopbackAddress(t *testing.T) {
+	if _, err := ServeStatus("0.0.0.0:9913", nil, logger()); err == nil {
+		t.Fatal("expected an error for a non-loopback address")
+	}
+}
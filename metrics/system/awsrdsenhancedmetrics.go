@@ -194,7 +194,7 @@ func NewAWSRDSEnhancedMetricsGatherer(logger logging.Logger, dbinstance types.DB
 	}
 }
 
-func (awsrdsenhancedmetrics *AWSRDSEnhancedMetricsGatherer) GetMetrics(metrics *models.Metrics) error {
+func (awsrdsenhancedmetrics *AWSRDSEnhancedMetricsGatherer) GetMetrics(ctx context.Context, metrics *models.Metrics) error {
 	defer utils.HandlePanic(awsrdsenhancedmetrics.configuration, awsrdsenhancedmetrics.logger)
 
 	info := make(models.MetricGroupValue)
@@ -207,7 +207,7 @@ func (awsrdsenhancedmetrics *AWSRDSEnhancedMetricsGatherer) GetMetrics(metrics *
 		LogStreamName: awsrdsenhancedmetrics.dbinstance.DbiResourceId,
 	}
 
-	result, err := awsrdsenhancedmetrics.cwlogsclient.GetLogEvents(context.TODO(), &input)
+	result, err := awsrdsenhancedmetrics.cwlogsclient.GetLogEvents(ctx, &input)
 
 	if err != nil {
 		awsrdsenhancedmetrics.logger.Fatalf("failed to read log stream %s:%s: %s", rdsMetricsLogGroupName, aws.ToString(awsrdsenhancedmetrics.dbinstance.DbiResourceId), err)
@@ -108,13 +108,12 @@ func NewAzureMySQLEnhancedMetricsGatherer(logger logging.Logger, credential *azi
 	}
 }
 
-func (azuremetrics *AzureMySQLEnhancedMetricsGatherer) GetMetrics(metrics *models.Metrics) error {
+func (azuremetrics *AzureMySQLEnhancedMetricsGatherer) GetMetrics(ctx context.Context, metrics *models.Metrics) error {
 	defer utils.HandlePanic(azuremetrics.configuration, azuremetrics.logger)
 
 	info := make(models.MetricGroupValue)
 	metricsMap := make(models.MetricGroupValue)
 
-	ctx := context.Background()
 	instance, err := azuremetrics.GetServer(ctx)
 	if err != nil {
 		azuremetrics.logger.Errorf("Failed to get Azure Database for MySQL server details: %s", err)
@@ -120,7 +120,7 @@ func NewGCPCloudSQLEnhancedMetricsGatherer(logger logging.Logger, monitoringClie
 	}
 }
 
-func (gcpmetrics *GCPCloudSQLEnhancedMetricsGatherer) GetMetrics(metrics *models.Metrics) error {
+func (gcpmetrics *GCPCloudSQLEnhancedMetricsGatherer) GetMetrics(ctx context.Context, metrics *models.Metrics) error {
 	defer utils.HandlePanic(gcpmetrics.configuration, gcpmetrics.logger)
 
 	info := make(models.MetricGroupValue)
@@ -134,8 +134,6 @@ func (gcpmetrics *GCPCloudSQLEnhancedMetricsGatherer) GetMetrics(metrics *models
 	}
 	gcpmetrics.logger.V(5).Info("Cloud SQL Instance retrieved successfully")
 
-	ctx := context.Background()
-
 	// Collect metrics from Cloud Monitoring
 	gcpMetricsData, err := gcpmetrics.collectRecentMetrics(ctx)
 	if err != nil {
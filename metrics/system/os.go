@@ -1,6 +1,7 @@
 package system
 
 import (
+	"context"
 	"encoding/json"
 	"strings"
 
@@ -90,7 +91,7 @@ func StructToMap(valueStruct string) models.MetricGroupValue {
 	_ = json.Unmarshal([]byte(valueStruct), &value_map)
 	return value_map
 }
-func (OS *OSMetricsGatherer) GetMetrics(metrics *models.Metrics) error {
+func (OS *OSMetricsGatherer) GetMetrics(ctx context.Context, metrics *models.Metrics) error {
 	defer utils.HandlePanic(OS.configuration, OS.logger)
 	info := make(models.MetricGroupValue)
 	metricsMap := make(models.MetricGroupValue)
@@ -1,8 +1,12 @@
 package models
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/Releem/mysqlconfigurer/config"
 )
@@ -46,10 +50,21 @@ type Metrics struct {
 		DatabaseSchema map[string][]MetricGroupValue
 	}
 	ReleemAgent struct {
-		Info  MetricGroupValue
-		Tasks Task
-		Conf  config.Config
+		Info      MetricGroupValue
+		Tasks     Task
+		Conf      config.Config
+		Gatherers []GathererResult
 	}
+	Batch *Batch `json:",omitempty"`
+}
+
+// Batch identifies a chunk of a metrics payload whose DatabaseSchema or
+// Queries were split to be uploaded in several requests. Chunk 0 carries the
+// other sections, the following chunks a part of the split sections.
+type Batch struct {
+	ID    string
+	Index int
+	Count int
 }
 
 type Metric map[string]MetricGroupValue
@@ -69,6 +84,25 @@ type Task struct {
 	Output   string `json:"task_output"`
 	Error    string `json:"task_error"`
 	ExitCode int    `json:"task_exit_code"`
+	// Progress is reported while the task runs, and with the final state.
+	Progress *TaskProgress `json:"task_progress,omitempty"`
+}
+
+// TaskProgress describes how far a running task is: the step it is at, an
+// estimate of the share done and the end of its output.
+type TaskProgress struct {
+	Step       string `json:"step"`
+	Percent    int    `json:"percent"`
+	OutputTail string `json:"output_tail"`
+}
+
+// GathererResult reports how one gatherer did during a collection.
+type GathererResult struct {
+	Name       string  `json:"name"`
+	Required   bool    `json:"required"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+	TimedOut   bool    `json:"timed_out,omitempty"`
 }
 
 type MetricContext interface {
@@ -79,17 +113,64 @@ type MetricContext interface {
 }
 
 type MetricsGatherer interface {
-	GetMetrics(metrics *Metrics) error
+	GetMetrics(ctx context.Context, metrics *Metrics) error
+}
+
+// RequiredMetricsGatherer is implemented by gatherers without which the
+// collected metrics are useless. A failure of a required gatherer discards the
+// whole collection, failures of other gatherers are only reported.
+type RequiredMetricsGatherer interface {
+	Required() bool
+}
+
+// TimeoutMetricsGatherer is implemented by gatherers that need a deadline
+// different from the configured gatherer timeout.
+type TimeoutMetricsGatherer interface {
+	Timeout() time.Duration
+}
+
+// NamedMetricsGatherer is implemented by gatherers wrapping another one.
+type NamedMetricsGatherer interface {
+	Name() string
+}
+
+// GathererName returns the name under which a gatherer is reported.
+func GathererName(gatherer MetricsGatherer) string {
+	if named, ok := gatherer.(NamedMetricsGatherer); ok {
+		return named.Name()
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", gatherer), "*")
+}
+
+// IsRequiredGatherer reports whether a gatherer is declared as required.
+func IsRequiredGatherer(gatherer MetricsGatherer) bool {
+	required, ok := gatherer.(RequiredMetricsGatherer)
+	return ok && required.Required()
 }
 
 type MetricsRepeater interface {
 	ProcessMetrics(context MetricContext, metrics Metrics, Mode ModeType) (string, error)
 }
 
-var (
+// ContextMetricsRepeater is implemented by repeaters whose sends can be
+// aborted through ctx.
+type ContextMetricsRepeater interface {
+	ProcessMetricsContext(ctx context.Context, metricContext MetricContext, metrics Metrics, Mode ModeType) (string, error)
+}
+
+// Instance holds the database handle and the collection state of one monitored
+// database server. Every gatherer and task of a pipeline shares the same Instance.
+type Instance struct {
 	DB                      *sql.DB
 	SampleQueries           map[string]string
 	SampleQueriesMutex      sync.RWMutex
 	CountEnabledConsumers   uint64
 	PgStatStatementsEnabled bool
-)
+}
+
+func NewInstance(db *sql.DB) *Instance {
+	return &Instance{
+		DB:            db,
+		SampleQueries: make(map[string]string),
+	}
+}
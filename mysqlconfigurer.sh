@@ -615,6 +615,8 @@ function releem_apply_automatic() {
     if [ "$1" == "initial" ]; then
         printf "\n`date +%Y%m%d-%H:%M:%S`\033[37m Getting the initial configuration.\033[0m\n"
         $RELEEM_WORKDIR/releem-agent --initial >/dev/null 2>&1 || true
+    elif [ -n "$RELEEM_SKIP_CONFIG_DOWNLOAD" ]; then
+        printf "\n`date +%Y%m%d-%H:%M:%S`\033[37m Applying the configuration version restored to $DB_CONFIG_FILE.\033[0m\n"
     else
         printf "\n`date +%Y%m%d-%H:%M:%S`\033[37m Getting the latest up-to-date configuration.\033[0m\n"
         $RELEEM_WORKDIR/releem-agent -c >/dev/null 2>&1 || true
@@ -744,13 +746,56 @@ function detect_postgresql_commands() {
 }
 
 
+# releem_conf_assignments prints the top-level settings of releem.conf. The
+# instance blocks and maps, which are not shell assignments, are skipped.
+function releem_conf_assignments() {
+    awk '
+        /^[[:space:]]*#/ { next }
+        {
+            opens = gsub(/\{/, "{")
+            closes = gsub(/\}/, "}")
+            if (depth == 0 && opens == 0) print
+            depth += opens - closes
+        }
+    ' "$1"
+}
+
+# A task of a named instance of the agent passes the settings of its instance,
+# the API key and passwords of the instance coming with the credentials.
+function load_instance_config() {
+    RELEEM_CONF_DIR="${RELEEM_INSTANCE_CONF_DIR%/}/"
+    RELEEM_DB_VERSION_FILE="${RELEEM_CONF_DIR}db_version"
+    apikey=
+    instance_type=$RELEEM_INSTANCE_INSTANCE_TYPE
+    memory_limit=$RELEEM_INSTANCE_MEMORY_LIMIT
+    query_optimization=$RELEEM_INSTANCE_QUERY_OPTIMIZATION
+    releem_region=$RELEEM_INSTANCE_REGION
+    mysql_user=$RELEEM_INSTANCE_MYSQL_USER
+    mysql_password=
+    pg_user=$RELEEM_INSTANCE_PG_USER
+    pg_password=
+    mysql_host=$RELEEM_INSTANCE_MYSQL_HOST
+    mysql_port=$RELEEM_INSTANCE_MYSQL_PORT
+    mysql_cnf_dir=$RELEEM_INSTANCE_MYSQL_CNF_DIR
+    mysql_restart_service=$RELEEM_INSTANCE_MYSQL_RESTART_SERVICE
+    pg_host=$RELEEM_INSTANCE_PG_HOST
+    pg_port=$RELEEM_INSTANCE_PG_PORT
+    pg_cnf_dir=$RELEEM_INSTANCE_PG_CNF_DIR
+    pg_restart_service=$RELEEM_INSTANCE_PG_RESTART_SERVICE
+}
+
 function load_runtime_config() {
     mysql_connection_string=""
     pg_connection_string=""
     if test -f "$RELEEM_CONF_FILE" ; then
-        . "$RELEEM_CONF_FILE"
+        eval "$(releem_conf_assignments "$RELEEM_CONF_FILE")"
+        if [ -n "$RELEEM_INSTANCE" ]; then
+            load_instance_config
+        fi
 
-        if [ ! -z "$apikey" ]; then
+        # The agent passes the API key it resolved, releem.conf holding no
+        # API key when it comes from an apikey_source.
+        if [ -z "$RELEEM_API_KEY" ] && [ ! -z "$apikey" ]; then
             RELEEM_API_KEY=$apikey
         fi
         if [ ! -z "$memory_limit" ]; then
@@ -826,6 +871,21 @@ function load_runtime_config() {
         fi
     fi
 
+    # The agent passes the credentials it resolved, releem.conf holding no
+    # password when it comes from a mysql_password_source or pg_password_source.
+    if [ -n "$RELEEM_MYSQL_USER" ]; then
+        MYSQL_LOGIN=$RELEEM_MYSQL_USER
+    fi
+    if [ -n "$MYSQL_PWD" ]; then
+        MYSQL_PASSWORD=$MYSQL_PWD
+    fi
+    if [ -n "$RELEEM_PG_USER" ]; then
+        PG_LOGIN=$RELEEM_PG_USER
+    fi
+    if [ -n "$PGPASSWORD" ]; then
+        PG_PASSWORD=$PGPASSWORD
+    fi
+
     # Database Type Detection
     DATABASE_TYPE="mysql"  # Default to MySQL for backward compatibility
     if [ ! -z "$PG_LOGIN" ] && [ ! -z "$PG_PASSWORD" ]; then
@@ -0,0 +1,227 @@
+// Package redact masks the query texts of a metrics payload before it leaves the host.
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/models"
+)
+
+// Policies of a query text.
+const (
+	// Keep sends the text, only masking the matches of the redaction rules.
+	Keep = "keep"
+	// Literals replaces the literals of the text before applying the rules.
+	Literals = "literals"
+	// Drop sends an empty text.
+	Drop = "drop"
+)
+
+// Mask replaces the matches of the redaction rules.
+const Mask = "?"
+
+// Redactor applies the redaction policies of a configuration to query texts.
+type Redactor struct {
+	policy   string
+	columns  map[string]string
+	schemas  map[string]string
+	rules    []*regexp.Regexp
+	postgres bool
+}
+
+// New returns the redactor configured by query_redaction, query_redaction_rules,
+// query_redaction_columns and query_redaction_schemas.
+func New(configuration *config.Config) (*Redactor, error) {
+	redactor := &Redactor{
+		policy:   configuration.QueryRedaction,
+		columns:  configuration.QueryRedactionColumns,
+		schemas:  configuration.QueryRedactionSchemas,
+		postgres: configuration.GetDatabaseType() == "postgresql",
+	}
+	if redactor.policy == "" {
+		redactor.policy = Keep
+	}
+	for _, rule := range configuration.QueryRedactionRules {
+		pattern, err := regexp.Compile(rule)
+		if err != nil {
+			return nil, err
+		}
+		redactor.rules = append(redactor.rules, pattern)
+	}
+	return redactor, nil
+}
+
+// Enabled reports whether the redactor changes any text.
+func (redactor *Redactor) Enabled() bool {
+	if redactor.policy != Keep || len(redactor.rules) > 0 {
+		return true
+	}
+	for _, policy := range redactor.columns {
+		if policy != Keep {
+			return true
+		}
+	}
+	for _, policy := range redactor.schemas {
+		if policy != Keep {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy returns the policy of a column of a schema: the schema policy, else
+// the column policy, else the default policy.
+func (redactor *Redactor) Policy(schema string, column string) string {
+	if policy, ok := redactor.schemas[schema]; ok && schema != "" {
+		return policy
+	}
+	if policy, ok := redactor.columns[column]; ok {
+		return policy
+	}
+	return redactor.policy
+}
+
+// Text redacts a query text read from a column of a schema.
+func (redactor *Redactor) Text(text string, schema string, column string) string {
+	switch redactor.Policy(schema, column) {
+	case Drop:
+		return ""
+	case Literals:
+		text = NormalizeLiterals(text, redactor.postgres)
+	}
+	for _, rule := range redactor.rules {
+		text = rule.ReplaceAllString(text, Mask)
+	}
+	return text
+}
+
+// Metrics returns the metrics with the query texts of the digests and of the
+// process list redacted, along with the plans and explain errors of the
+// digests, which hold the literals of the queries too. The maps of the given
+// metrics are not changed, so the raw texts stay available locally, e.g. to
+// explain the queries.
+func (redactor *Redactor) Metrics(metrics models.Metrics) models.Metrics {
+	metrics.DB.Queries = redactor.rows(metrics.DB.Queries, []string{"schema_name", "datname"}, []string{"query", "query_text", "explain", "explain_error"})
+	metrics.DB.Metrics.ProcessList = redactor.rows(metrics.DB.Metrics.ProcessList, []string{"DB", "datname"}, []string{"INFO", "query"})
+	return metrics
+}
+
+// rows redacts the text columns of rows, whose schema is in the first schema
+// column present.
+func (redactor *Redactor) rows(rows []models.MetricGroupValue, schemaColumns []string, columns []string) []models.MetricGroupValue {
+	if rows == nil {
+		return nil
+	}
+	redacted := make([]models.MetricGroupValue, len(rows))
+	for i, row := range rows {
+		var schema string
+		for _, column := range schemaColumns {
+			if value, ok := row[column].(string); ok {
+				schema = value
+				break
+			}
+		}
+		redacted[i] = row
+		cloned := false
+		for _, column := range columns {
+			text, ok := row[column].(string)
+			if !ok {
+				continue
+			}
+			var masked string
+			switch column {
+			case "explain":
+				masked = redactor.Explain(text, schema)
+			case "explain_error":
+				masked = redactor.ExplainError(text, schema)
+			default:
+				masked = redactor.Text(text, schema, column)
+			}
+			if masked != text {
+				if !cloned {
+					redacted[i], cloned = maps.Clone(row), true
+				}
+				redacted[i][column] = masked
+			}
+		}
+	}
+	return redacted
+}
+
+// Explain redacts an EXPLAIN FORMAT=JSON plan of a query of a schema, whose
+// conditions hold the literals of the query. The policy of the explain column
+// applies to every string of the plan but the numbers, e.g. the costs MySQL
+// gives as strings.
+func (redactor *Redactor) Explain(plan string, schema string) string {
+	policy := redactor.Policy(schema, "explain")
+	switch {
+	case policy == Drop:
+		return ""
+	case policy == Keep && len(redactor.rules) == 0:
+		return plan
+	}
+	decoder := json.NewDecoder(strings.NewReader(plan))
+	decoder.UseNumber()
+	var decoded any
+	if err := decoder.Decode(&decoded); err != nil {
+		return redactor.Text(plan, schema, "explain")
+	}
+	var encoded bytes.Buffer
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(redactor.planValue(decoded, policy)); err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(encoded.String(), "\n")
+}
+
+// ExplainError redacts the error of the explain of a query of a schema. The
+// errors quote the query, e.g. near '...' for the syntax errors of MySQL, in
+// quotes the literals of the query may unbalance: the literals policy masks
+// the error from its first quote on.
+func (redactor *Redactor) ExplainError(text string, schema string) string {
+	switch redactor.Policy(schema, "explain_error") {
+	case Drop:
+		return ""
+	case Literals:
+		if quote := strings.IndexAny(text, "'\"`"); quote >= 0 {
+			text = text[:quote] + Mask
+		}
+	}
+	for _, rule := range redactor.rules {
+		text = rule.ReplaceAllString(text, Mask)
+	}
+	return text
+}
+
+// planValue redacts the strings of a decoded plan in place.
+func (redactor *Redactor) planValue(value any, policy string) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, item := range value {
+			value[key] = redactor.planValue(item, policy)
+		}
+	case []any:
+		for i, item := range value {
+			value[i] = redactor.planValue(item, policy)
+		}
+	case string:
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return value
+		}
+		if policy == Literals {
+			value = NormalizeLiterals(value, redactor.postgres)
+		}
+		for _, rule := range redactor.rules {
+			value = rule.ReplaceAllString(value, Mask)
+		}
+		return value
+	}
+	return value
+}
@@ -0,0 +1,136 @@
+package redact
+
+import (
+	"testing"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/models"
+)
+
+func TestNormalizeLiterals(t *testing.T) {
+	for _, test := range []struct {
+		query    string
+		postgres bool
+		want     string
+	}{
+		{"SELECT * FROM users WHERE email = 'a@b.c' AND id = 42", false, "SELECT * FROM users WHERE email = ? AND id = ?"},
+		{`SELECT "it's", 'a\'b', 'c''d' FROM t1`, false, "SELECT ?, ?, ? FROM t1"},
+		{"SELECT `col 1`, x2 FROM t WHERE id IN (1, 2, 3)", false, "SELECT `col 1`, x2 FROM t WHERE id IN (...)"},
+		{"SELECT 1.5e-3, -7, 0xFF, X'0A', N'x' # 'comment'\nFROM t", false, "SELECT ?, -?, ?, ?, ? # 'comment'\nFROM t"},
+		{"INSERT INTO t VALUES ('unterminated", false, "INSERT INTO t VALUES (?"},
+		{`SELECT "Name" FROM t WHERE a = $1 AND b = 'x' /* 'kept' */`, true, `SELECT "Name" FROM t WHERE a = $1 AND b = ? /* 'kept' */`},
+		{"SELECT $body$ it's $body$, E'a\\'b', $$x$$ -- 5\n", true, "SELECT ?, ?, ? -- 5\n"},
+	} {
+		if got := NormalizeLiterals(test.query, test.postgres); got != test.want {
+			t.Errorf("NormalizeLiterals(%q) = %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+func TestPolicyPrecedence(t *testing.T) {
+	redactor, err := New(&config.Config{
+		QueryRedaction:        Literals,
+		QueryRedactionColumns: map[string]string{"INFO": Drop},
+		QueryRedactionSchemas: map[string]string{"public_data": Keep},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := redactor.Policy("public_data", "INFO"); got != Keep {
+		t.Errorf("schema policy = %s, want keep", got)
+	}
+	if got := redactor.Policy("shop", "INFO"); got != Drop {
+		t.Errorf("column policy = %s, want drop", got)
+	}
+	if got := redactor.Policy("", "query_text"); got != Literals {
+		t.Errorf("default policy = %s, want literals", got)
+	}
+}
+
+func TestMetricsKeepsTheRawTexts(t *testing.T) {
+	redactor, err := New(&config.Config{
+		QueryRedaction:      Literals,
+		QueryRedactionRules: []string{`secret_\w+`},
+		QueryRedactionSchemas: map[string]string{
+			"public_data": Keep,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !redactor.Enabled() {
+		t.Fatal("the redactor should be enabled")
+	}
+	var metrics models.Metrics
+	metrics.DB.Queries = []models.MetricGroupValue{
+		{"schema_name": "shop", "query": "SELECT * FROM t WHERE a = ?", "query_text": "SELECT * FROM t WHERE a = 'x'"},
+		{"schema_name": "public_data", "query_text": "SELECT * FROM secret_table WHERE a = 1"},
+	}
+	metrics.DB.Metrics.ProcessList = []models.MetricGroupValue{
+		{"DB": "shop", "INFO": "UPDATE t SET token = 'abc' WHERE id = 7", "TIME": int64(3)},
+	}
+
+	redacted := redactor.Metrics(metrics)
+	if got := redacted.DB.Queries[0]["query_text"]; got != "SELECT * FROM t WHERE a = ?" {
+		t.Errorf("query_text = %q", got)
+	}
+	if got := redacted.DB.Queries[1]["query_text"]; got != "SELECT * FROM ? WHERE a = 1" {
+		t.Errorf("rules should apply to kept texts, got %q", got)
+	}
+	if got := redacted.DB.Metrics.ProcessList[0]["INFO"]; got != "UPDATE t SET token = ? WHERE id = ?" {
+		t.Errorf("INFO = %q", got)
+	}
+	if metrics.DB.Queries[0]["query_text"] != "SELECT * FROM t WHERE a = 'x'" || metrics.DB.Metrics.ProcessList[0]["INFO"] != "UPDATE t SET token = 'abc' WHERE id = 7" {
+		t.Fatal("the raw texts were changed")
+	}
+
+	dropped, err := New(&config.Config{QueryRedaction: Drop})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dropped.Metrics(metrics).DB.Metrics.ProcessList[0]["INFO"]; got != "" {
+		t.Errorf("dropped INFO = %q", got)
+	}
+
+	kept, err := New(&config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept.Enabled() {
+		t.Fatal("the default policy should not change any text")
+	}
+}
+
+func TestMetricsRedactsThePlans(t *testing.T) {
+	redactor, err := New(&config.Config{QueryRedaction: Literals})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := `{"query_block":{"cost_info":{"query_cost":"1.25"},"table":{"table_name":"users","attached_condition":"(` + "`shop`.`users`.`email` = 'a@b.c'" + `)"}}}`
+	var metrics models.Metrics
+	metrics.DB.Queries = []models.MetricGroupValue{{
+		"schema_name":   "shop",
+		"explain":       plan,
+		"explain_error": "Error 1064 (42000): You have an error in your SQL syntax; check the manual near 'token = 'abc'' at line 1",
+	}}
+
+	redacted := redactor.Metrics(metrics).DB.Queries[0]
+	want := `{"query_block":{"cost_info":{"query_cost":"1.25"},"table":{"attached_condition":"(` + "`shop`.`users`.`email` = ?" + `)","table_name":"users"}}}`
+	if got := redacted["explain"]; got != want {
+		t.Errorf("explain = %s, want %s", got, want)
+	}
+	if got := redacted["explain_error"]; got != "Error 1064 (42000): You have an error in your SQL syntax; check the manual near ?" {
+		t.Errorf("explain_error = %q, want the quoted query masked", got)
+	}
+	if metrics.DB.Queries[0]["explain"] != plan {
+		t.Fatal("the raw plan was changed")
+	}
+
+	dropped, err := New(&config.Config{QueryRedactionColumns: map[string]string{"explain": Drop}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dropped.Metrics(metrics).DB.Queries[0]["explain"]; got != "" {
+		t.Errorf("dropped explain = %q", got)
+	}
+}
@@ -0,0 +1,153 @@
+package redact
+
+import (
+	"regexp"
+	"strings"
+)
+
+var inList = regexp.MustCompile(`(?i)\bIN\s*\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+
+// NormalizeLiterals replaces the string and number literals of a query with ?
+// and collapses IN lists of literals to IN (...). Identifiers, placeholders
+// and comments are kept. In MySQL double quotes delimit strings, in PostgreSQL
+// identifiers. Truncated queries are handled: an unterminated string runs to
+// the end of the text.
+func NormalizeLiterals(query string, postgres bool) string {
+	var out strings.Builder
+	out.Grow(len(query))
+	for i := 0; i < len(query); {
+		c := query[i]
+		var next byte
+		if i+1 < len(query) {
+			next = query[i+1]
+		}
+		switch {
+		case c == '\'' || (c == '"' && !postgres):
+			i = skipQuoted(query, i, !postgres)
+			out.WriteByte('?')
+		case (c == 'E' || c == 'e') && next == '\'' && postgres && !endsWithIdentifier(&out):
+			i = skipQuoted(query, i+1, true)
+			out.WriteByte('?')
+		case (c == 'X' || c == 'x' || c == 'B' || c == 'b' || c == 'N' || c == 'n') && next == '\'' && !endsWithIdentifier(&out):
+			i = skipQuoted(query, i+1, !postgres)
+			out.WriteByte('?')
+		case c == '`' || (c == '"' && postgres):
+			end := skipQuoted(query, i, false)
+			out.WriteString(query[i:end])
+			i = end
+		case c == '$' && postgres && !endsWithIdentifier(&out) && dollarTag(query[i:]) != "":
+			tag := dollarTag(query[i:])
+			end := strings.Index(query[i+len(tag):], tag)
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += len(tag) + end + len(tag)
+			}
+			out.WriteByte('?')
+		case c == '-' && next == '-', c == '#' && !postgres:
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			out.WriteString(query[i : i+end])
+			i += end
+		case c == '/' && next == '*':
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(query)
+			} else {
+				end += i + 4
+			}
+			out.WriteString(query[i:end])
+			i = end
+		case isDigit(c) && !endsWithIdentifier(&out):
+			i = skipNumber(query, i)
+			out.WriteByte('?')
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return inList.ReplaceAllString(out.String(), "IN (...)")
+}
+
+// skipQuoted returns the index after the quoted text starting at start.
+// A doubled quote is part of the text, and so is a quote escaped by a
+// backslash when backslashEscapes is set.
+func skipQuoted(query string, start int, backslashEscapes bool) int {
+	quote := query[start]
+	for i := start + 1; i < len(query); i++ {
+		switch {
+		case query[i] == '\\' && backslashEscapes:
+			i++
+		case query[i] == quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// dollarTag returns the opening $tag$ of a PostgreSQL dollar-quoted string at
+// the start of text, or "" when text starts with a $n parameter.
+func dollarTag(text string) string {
+	for i := 1; i < len(text); i++ {
+		c := text[i]
+		if c == '$' {
+			return text[:i+1]
+		}
+		if !(isLetter(c) || c == '_' || (i > 1 && isDigit(c))) {
+			return ""
+		}
+	}
+	return ""
+}
+
+func skipNumber(query string, start int) int {
+	i := start
+	if query[i] == '0' && i+1 < len(query) && (query[i+1] == 'x' || query[i+1] == 'X' || query[i+1] == 'b' || query[i+1] == 'B') {
+		i += 2
+		for i < len(query) && (isDigit(query[i]) || isLetter(query[i])) {
+			i++
+		}
+		return i
+	}
+	for i < len(query) && (isDigit(query[i]) || query[i] == '.') {
+		i++
+	}
+	if i < len(query) && (query[i] == 'e' || query[i] == 'E') {
+		j := i + 1
+		if j < len(query) && (query[j] == '+' || query[j] == '-') {
+			j++
+		}
+		if j < len(query) && isDigit(query[j]) {
+			i = j
+			for i < len(query) && isDigit(query[i]) {
+				i++
+			}
+		}
+	}
+	return i
+}
+
+// endsWithIdentifier reports whether the text written so far ends inside an
+// identifier or a $n parameter, whose digits are not literals.
+func endsWithIdentifier(out *strings.Builder) bool {
+	text := out.String()
+	if text == "" {
+		return false
+	}
+	c := text[len(text)-1]
+	return isLetter(c) || isDigit(c) || c == '_' || c == '$' || c >= 0x80
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
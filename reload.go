@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/metrics"
+	"github.com/Releem/mysqlconfigurer/models"
+)
+
+// retireDelay is how long the connections and clients replaced by a reload
+// are kept open for the work still running with them.
+const retireDelay = 10 * time.Minute
+
+// reloader applies changes of the configuration file to the running pipelines.
+type reloader struct {
+	mutex         sync.Mutex
+	filename      string
+	configuration *config.Config
+	pipelines     []*metrics.Pipeline
+	// loaded keeps the instance configurations as read from the file, as
+	// newPipeline fills in the hosts of cloud instances.
+	loaded  []*config.Config
+	closers [][]io.Closer
+}
+
+func newReloader(filename string, configuration *config.Config) *reloader {
+	return &reloader{filename: filename, configuration: configuration}
+}
+
+func (reloader *reloader) add(pipeline *metrics.Pipeline, loaded *config.Config, closers []io.Closer) {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+	reloader.pipelines = append(reloader.pipelines, pipeline)
+	reloader.loaded = append(reloader.loaded, loaded)
+	reloader.closers = append(reloader.closers, closers)
+}
+
+func (reloader *reloader) close() {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+	for _, closers := range reloader.closers {
+		closeAll(closers)
+	}
+	reloader.closers = nil
+}
+
+func closeAll(closers []io.Closer) {
+	for _, closer := range closers {
+		closer.Close()
+	}
+}
+
+// watch reloads the configuration on SIGHUP and whenever the configuration
+// file or a secret read from a source changes, until ctx is done.
+func (reloader *reloader) watch(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	modified, _ := fileVersion(reloader.filename)
+	period := reloader.configuration.ConfigWatchPeriod * time.Second
+	poll := time.NewTimer(period)
+	defer poll.Stop()
+	refreshPeriod := reloader.configuration.SecretsRefreshPeriod * time.Second
+	refresh := time.NewTimer(refreshPeriod)
+	defer refresh.Stop()
+	for {
+		var configuration *config.Config
+		select {
+		case <-ctx.Done():
+			return
+		case <-refresh.C:
+			refresh.Reset(refreshPeriod)
+			if !reloader.configuration.HasSecretSources() {
+				continue
+			}
+			loaded, err := config.LoadConfig(reloader.filename, logger)
+			if err != nil {
+				logger.Error("The secrets were not refreshed: ", err)
+				continue
+			}
+			if !loaded.SecretsChanged(reloader.configuration) {
+				continue
+			}
+			logger.Info("A secret was rotated, reloading the configuration")
+			configuration = loaded
+		case <-hangup:
+			logger.Info("SIGHUP received, reloading the configuration")
+		case <-poll.C:
+			poll.Reset(period)
+			version, err := fileVersion(reloader.filename)
+			if err != nil || version == modified {
+				continue
+			}
+			logger.Info("The configuration file changed, reloading the configuration")
+		}
+		modified, _ = fileVersion(reloader.filename)
+		if err := reloader.reload(configuration); err != nil {
+			logger.Error("The configuration was not reloaded, the agent keeps running with the previous configuration: ", err)
+			continue
+		}
+		period = reloader.configuration.ConfigWatchPeriod * time.Second
+		refreshPeriod = reloader.configuration.SecretsRefreshPeriod * time.Second
+	}
+}
+
+// fileVersion identifies the content of a file by its modification time and size.
+func fileVersion(filename string) (string, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size()), nil
+}
+
+// reload validates the configuration, loading the configuration file unless
+// one is given, and updates every pipeline. Database connections are only
+// reopened when their settings changed. Nothing is changed when the
+// configuration is invalid or a pipeline fails to build.
+func (reloader *reloader) reload(configuration *config.Config) error {
+	if configuration == nil {
+		var err error
+		if configuration, err = config.LoadConfig(reloader.filename, logger); err != nil {
+			return err
+		}
+	}
+	if err := configuration.Validate(); err != nil {
+		return err
+	}
+
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+	instances := configuration.GetInstances()
+	if len(instances) != len(reloader.pipelines) {
+		return errors.New("the list of instances changed, restart the agent to apply it")
+	}
+	for i, instanceConfiguration := range instances {
+		if instanceConfiguration.InstanceName != reloader.loaded[i].InstanceName {
+			return errors.New("the list of instances changed, restart the agent to apply it")
+		}
+	}
+	if configuration.PrometheusListen != reloader.configuration.PrometheusListen || configuration.StatusListen != reloader.configuration.StatusListen {
+		logger.Warning("The listen addresses changed, restart the agent to apply them")
+	}
+
+	next := make([]*metrics.Pipeline, len(instances))
+	nextLoaded := make([]*config.Config, len(instances))
+	nextClosers := make([][]io.Closer, len(instances))
+	kept := make([][]io.Closer, len(instances))
+	var retired []io.Closer
+	for i, instanceConfiguration := range instances {
+		loaded := *instanceConfiguration
+		nextLoaded[i] = &loaded
+		var instance *models.Instance
+		reconnect := reloader.loaded[i].ConnectionChanged(instanceConfiguration)
+		if !reconnect {
+			instance = reloader.pipelines[i].GetInstance()
+		}
+		pipeline, closers, err := newPipeline(instanceConfiguration, instance)
+		if err != nil {
+			closeAll(closers)
+			for _, built := range nextClosers[:i] {
+				closeAll(built)
+			}
+			return fmt.Errorf("instance %s: %w", instanceConfiguration.InstanceName, err)
+		}
+		if reconnect {
+			logger.Info("Reconnecting to the database of instance ", instanceConfiguration.InstanceName)
+			retired = append(retired, reloader.closers[i]...)
+		} else {
+			for _, closer := range reloader.closers[i] {
+				if closer == io.Closer(instance.DB) {
+					kept[i] = append(kept[i], closer)
+				} else {
+					retired = append(retired, closer)
+				}
+			}
+		}
+		next[i], nextClosers[i] = pipeline, closers
+	}
+
+	for i, pipeline := range reloader.pipelines {
+		pipeline.Update(next[i])
+		nextClosers[i] = append(nextClosers[i], kept[i]...)
+	}
+	reloader.configuration, reloader.loaded, reloader.closers = configuration, nextLoaded, nextClosers
+	if configuration.Debug {
+		logger.SetLevel(10)
+	} else {
+		logger.SetLevel(1)
+	}
+	time.AfterFunc(retireDelay, func() { closeAll(retired) })
+	logger.Info("The configuration was reloaded")
+	return nil
+}
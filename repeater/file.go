@@ -0,0 +1,229 @@
+package repeater
+
+import (
+	stdcontext "context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/models"
+	"github.com/Releem/mysqlconfigurer/redact"
+	"github.com/Releem/mysqlconfigurer/utils"
+	logging "github.com/google/logger"
+)
+
+const exportFileName = "metrics.ndjson"
+
+// FileMetricsRepeater writes every payload the agent would send to NDJSON files,
+// one line per payload, rotating them by size.
+type FileMetricsRepeater struct {
+	logger        logging.Logger
+	configuration *config.Config
+	dir           string
+	maxBytes      int64
+	maxFiles      int
+	mutex         *sync.Mutex
+}
+
+type exportRecord struct {
+	Time     time.Time       `json:"time"`
+	Instance string          `json:"instance,omitempty"`
+	Mode     models.ModeType `json:"mode"`
+	Metrics  models.Metrics  `json:"metrics"`
+}
+
+func (repeater FileMetricsRepeater) ProcessMetrics(context models.MetricContext, metrics models.Metrics, Mode models.ModeType) (string, error) {
+	defer utils.HandlePanic(repeater.configuration, repeater.logger)
+	line, err := json.Marshal(exportRecord{
+		Time:     time.Now().UTC(),
+		Instance: repeater.configuration.InstanceName,
+		Mode:     Mode,
+		Metrics:  metrics,
+	})
+	if err != nil {
+		return "", errors.New("Failed to encode metrics: " + err.Error())
+	}
+	line = append(line, '\n')
+
+	repeater.mutex.Lock()
+	defer repeater.mutex.Unlock()
+	if err := repeater.rotate(int64(len(line))); err != nil {
+		repeater.logger.Error("Export: failed to rotate files: ", err)
+	}
+	file, err := os.OpenFile(filepath.Join(repeater.dir, exportFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return "", errors.New("Export: failed to open file: " + err.Error())
+	}
+	defer file.Close()
+	if _, err := file.Write(line); err != nil {
+		return "", errors.New("Export: failed to write file: " + err.Error())
+	}
+	repeater.logger.V(5).Info("Export: ", Mode.Name, " ", Mode.Type, " payload written")
+	return "", nil
+}
+
+// rotate renames the current file when the next line would exceed the size
+// limit and removes the oldest rotated files above the retention limit.
+func (repeater FileMetricsRepeater) rotate(next int64) error {
+	current := filepath.Join(repeater.dir, exportFileName)
+	info, err := os.Stat(current)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if repeater.maxBytes <= 0 || info.Size() == 0 || info.Size()+next <= repeater.maxBytes {
+		return nil
+	}
+	rotated := filepath.Join(repeater.dir, "metrics-"+time.Now().UTC().Format("20060102T150405.000000000")+".ndjson")
+	if err := os.Rename(current, rotated); err != nil {
+		return err
+	}
+
+	matches, err := filepath.Glob(filepath.Join(repeater.dir, "metrics-*.ndjson"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(matches)
+	for len(matches) > repeater.maxFiles && repeater.maxFiles > 0 {
+		if err := os.Remove(matches[0]); err != nil {
+			return err
+		}
+		matches = matches[1:]
+	}
+	return nil
+}
+
+// ExportDir returns the export directory of an instance.
+func ExportDir(configuration *config.Config) string {
+	dir := configuration.ExportDir
+	if dir == "" {
+		dir = filepath.Join(configuration.ReleemDir, "export")
+	}
+	if configuration.InstanceName != "" {
+		dir = filepath.Join(dir, configuration.InstanceName)
+	}
+	return dir
+}
+
+func NewFileMetricsRepeater(configuration *config.Config, logger logging.Logger) (FileMetricsRepeater, error) {
+	dir := ExportDir(configuration)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return FileMetricsRepeater{}, err
+	}
+	return FileMetricsRepeater{
+		logger:        logger,
+		configuration: configuration,
+		dir:           dir,
+		maxBytes:      int64(configuration.ExportMaxFileSize) * 1024 * 1024,
+		maxFiles:      configuration.ExportMaxFiles,
+		mutex:         &sync.Mutex{},
+	}, nil
+}
+
+// MultiMetricsRepeater sends every payload to all of its repeaters. The response
+// of the releem repeater, or of the first one without it, is returned whatever
+// the order of the repeaters, failures of the others are only logged.
+type MultiMetricsRepeater struct {
+	logger    logging.Logger
+	repeaters []models.MetricsRepeater
+	primary   int
+}
+
+func (repeater MultiMetricsRepeater) ProcessMetrics(context models.MetricContext, metrics models.Metrics, Mode models.ModeType) (string, error) {
+	return repeater.ProcessMetricsContext(stdcontext.Background(), context, metrics, Mode)
+}
+
+func (repeater MultiMetricsRepeater) ProcessMetricsContext(ctx stdcontext.Context, context models.MetricContext, metrics models.Metrics, Mode models.ModeType) (string, error) {
+	var result string
+	var resultErr error
+	for i, r := range repeater.repeaters {
+		var response string
+		var err error
+		if contextRepeater, ok := r.(models.ContextMetricsRepeater); ok {
+			response, err = contextRepeater.ProcessMetricsContext(ctx, context, metrics, Mode)
+		} else {
+			response, err = r.ProcessMetrics(context, metrics, Mode)
+		}
+		if i == repeater.primary {
+			result, resultErr = response, err
+		} else if err != nil {
+			repeater.logger.Error("Repeater failed ", err)
+		}
+	}
+	return result, resultErr
+}
+
+// SpoolDepth returns the number of payloads spooled by all of its repeaters.
+func (repeater MultiMetricsRepeater) SpoolDepth() int {
+	depth := 0
+	for _, r := range repeater.repeaters {
+		if spooler, ok := r.(interface{ SpoolDepth() int }); ok {
+			depth += spooler.SpoolDepth()
+		}
+	}
+	return depth
+}
+
+func NewMultiMetricsRepeater(logger logging.Logger, repeaters ...models.MetricsRepeater) MultiMetricsRepeater {
+	primary := 0
+	for i, r := range repeaters {
+		if _, ok := r.(ReleemConfigurationsRepeater); ok {
+			primary = i
+			break
+		}
+	}
+	return MultiMetricsRepeater{logger, repeaters, primary}
+}
+
+// NewMetricsRepeater builds the repeater chosen by the `repeaters` setting, a
+// comma-separated list of "releem", "file" and "log", redacting the query
+// texts when the query_redaction settings change them.
+func NewMetricsRepeater(configuration *config.Config, logger logging.Logger) (models.MetricsRepeater, error) {
+	var repeaters []models.MetricsRepeater
+	for _, name := range strings.Split(configuration.Repeaters, ",") {
+		switch strings.TrimSpace(name) {
+		case "releem":
+			releemRepeater, err := NewReleemConfigurationsRepeater(configuration, logger)
+			if err != nil {
+				return nil, err
+			}
+			repeaters = append(repeaters, releemRepeater)
+		case "file":
+			fileRepeater, err := NewFileMetricsRepeater(configuration, logger)
+			if err != nil {
+				return nil, err
+			}
+			repeaters = append(repeaters, fileRepeater)
+		case "log":
+			repeaters = append(repeaters, NewLogMetricsRepeater(logger))
+		case "":
+		default:
+			return nil, errors.New("unknown repeater " + name)
+		}
+	}
+	var repeater models.MetricsRepeater
+	switch len(repeaters) {
+	case 0:
+		return nil, errors.New("no repeater configured")
+	case 1:
+		repeater = repeaters[0]
+	default:
+		repeater = NewMultiMetricsRepeater(logger, repeaters...)
+	}
+	redactor, err := redact.New(configuration)
+	if err != nil {
+		return nil, err
+	}
+	if redactor.Enabled() {
+		repeater = NewRedactedMetricsRepeater(redactor, repeater)
+	}
+	return repeater, nil
+}
@@ -0,0 +1,99 @@
+package repeater
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/models"
+	logging "github.com/google/logger"
+)
+
+func TestFileMetricsRepeaterWritesTaggedLines(t *testing.T) {
+	logger := *logging.Init("releem-agent-test", false, false, io.Discard)
+	configuration := &config.Config{ExportDir: t.TempDir(), InstanceName: "primary", ExportMaxFileSize: 50, ExportMaxFiles: 10}
+	repeater, err := NewFileMetricsRepeater(configuration, logger)
+	if err != nil {
+		t.Fatalf("NewFileMetricsRepeater: %v", err)
+	}
+	var metrics models.Metrics
+	metrics.ReleemAgent.Tasks.ID = 7
+	if _, err := repeater.ProcessMetrics(configuration, metrics, models.ModeType{Name: "Metrics", Type: ""}); err != nil {
+		t.Fatalf("ProcessMetrics: %v", err)
+	}
+	if _, err := repeater.ProcessMetrics(configuration, metrics, models.ModeType{Name: "Metrics", Type: "Queries"}); err != nil {
+		t.Fatalf("ProcessMetrics: %v", err)
+	}
+
+	file, err := os.Open(filepath.Join(configuration.ExportDir, "primary", exportFileName))
+	if err != nil {
+		t.Fatalf("open export: %v", err)
+	}
+	defer file.Close()
+	var modes []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record exportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("line is not JSON: %v", err)
+		}
+		if record.Instance != "primary" || record.Metrics.ReleemAgent.Tasks.ID != 7 {
+			t.Fatalf("unexpected record %+v", record)
+		}
+		modes = append(modes, record.Mode.Name+"/"+record.Mode.Type)
+	}
+	if len(modes) != 2 || modes[0] != "Metrics/" || modes[1] != "Metrics/Queries" {
+		t.Fatalf("modes = %v", modes)
+	}
+}
+
+func TestFileMetricsRepeaterRotatesBySize(t *testing.T) {
+	logger := *logging.Init("releem-agent-test", false, false, io.Discard)
+	configuration := &config.Config{ExportDir: t.TempDir()}
+	repeater, err := NewFileMetricsRepeater(configuration, logger)
+	if err != nil {
+		t.Fatalf("NewFileMetricsRepeater: %v", err)
+	}
+	repeater.maxBytes = 1
+	repeater.maxFiles = 2
+	for i := 0; i < 5; i++ {
+		if _, err := repeater.ProcessMetrics(configuration, models.Metrics{}, models.ModeType{Name: "Metrics"}); err != nil {
+			t.Fatalf("ProcessMetrics: %v", err)
+		}
+	}
+	rotated, _ := filepath.Glob(filepath.Join(configuration.ExportDir, "metrics-*.ndjson"))
+	if len(rotated) != 2 {
+		t.Fatalf("rotated files = %d, want 2", len(rotated))
+	}
+}
+
+func TestNewMetricsRepeaterRejectsUnknownRepeater(t *testing.T) {
+	logger := *logging.Init("releem-agent-test", false, false, io.Discard)
+	if _, err := NewMetricsRepeater(&config.Config{Repeaters: "releem,kafka", ReleemDir: t.TempDir()}, logger); err == nil {
+		t.Fatal("expected an error for an unknown repeater")
+	}
+}
+
+func TestMultiMetricsRepeaterReturnsReleemResponse(t *testing.T) {
+	logger := *logging.Init("releem-agent-test", false, false, io.Discard)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"task_id":42}`)
+	}))
+	defer server.Close()
+	configuration := &config.Config{Repeaters: "file,releem", ExportDir: t.TempDir(), ExportMaxFileSize: 50, ExportMaxFiles: 10,
+		ApiURL: server.URL + "/v2/", ApiTimeout: 30, ApiConnectTimeout: 30, ReleemDir: t.TempDir(), ReleemConfDir: t.TempDir()}
+	repeater, err := NewMetricsRepeater(configuration, logger)
+	if err != nil {
+		t.Fatalf("NewMetricsRepeater: %v", err)
+	}
+	response, err := repeater.ProcessMetrics(configuration, models.Metrics{}, models.ModeType{Name: "Task", Type: "Get"})
+	if err != nil || response != `{"task_id":42}` {
+		t.Fatalf("ProcessMetrics = %q, %v, want the releem response", response, err)
+	}
+}
@@ -1,27 +1,26 @@
 package repeater
 
 import (
-	"io"
-	"log"
+	"encoding/json"
 
 	"github.com/Releem/mysqlconfigurer/models"
 	logging "github.com/google/logger"
 )
 
+// LogMetricsRepeater writes every payload to the agent log.
 type LogMetricsRepeater struct {
 	logger logging.Logger
 }
 
-func (lr LogMetricsRepeater) ProcessMetrics(metrics models.Metric) error {
-	for _, m := range metrics {
-		lr.logger.Infof("%s", m)
+func (lr LogMetricsRepeater) ProcessMetrics(context models.MetricContext, metrics models.Metrics, Mode models.ModeType) (string, error) {
+	data, err := json.Marshal(metrics)
+	if err != nil {
+		return "", err
 	}
-	return nil
+	lr.logger.Infof("%s %s: %s", Mode.Name, Mode.Type, data)
+	return "", nil
 }
 
-func NewLogMetricsRepeater() LogMetricsRepeater {
-	logger := *logging.Init("releem-agent", true, false, io.Discard)
-	defer logger.Close()
-	logging.SetFlags(log.LstdFlags | log.Lshortfile)
+func NewLogMetricsRepeater(logger logging.Logger) LogMetricsRepeater {
 	return LogMetricsRepeater{logger}
 }
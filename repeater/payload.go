@@ -0,0 +1,204 @@
+package repeater
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/Releem/mysqlconfigurer/models"
+	"github.com/klauspost/compress/zstd"
+)
+
+// payloadError is returned when a payload could not be encoded. Sending it
+// again would fail the same way, so it is not retried.
+type payloadError struct {
+	err error
+}
+
+func (err *payloadError) Error() string {
+	return "Failed to encode metrics: " + err.err.Error()
+}
+
+func (err *payloadError) Unwrap() error {
+	return err.err
+}
+
+// encodeJSON returns a writer of the JSON encoding of v.
+func encodeJSON(v any) func(io.Writer) error {
+	return func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(v)
+	}
+}
+
+// writeBytes returns a writer of an already encoded payload.
+func writeBytes(payload []byte) func(io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := w.Write(payload)
+		return err
+	}
+}
+
+// contentEncoding returns the Content-Encoding header of a compression, or ""
+// when payloads are sent uncompressed.
+func contentEncoding(compression string) string {
+	switch compression {
+	case "gzip", "zstd":
+		return compression
+	default:
+		return ""
+	}
+}
+
+// compress writes the payload produced by write to w, compressed with gzip or
+// zstd or as is.
+func compress(w io.Writer, compression string, write func(io.Writer) error) error {
+	var compressor io.WriteCloser
+	switch compression {
+	case "gzip":
+		compressor = gzip.NewWriter(w)
+	case "zstd":
+		encoder, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		compressor = encoder
+	default:
+		return write(w)
+	}
+	if err := write(compressor); err != nil {
+		compressor.Close()
+		return err
+	}
+	return compressor.Close()
+}
+
+// streamBody returns a request body streaming the compressed payload while it
+// is encoded, so the payload is never held in memory as a whole. wait closes
+// the body and returns the encoding error, if any.
+func streamBody(compression string, write func(io.Writer) error) (body io.ReadCloser, wait func() error) {
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := compress(writer, compression, write)
+		writer.CloseWithError(err)
+		done <- err
+	}()
+	return reader, func() error {
+		reader.Close()
+		err := <-done
+		if err == nil || errors.Is(err, io.ErrClosedPipe) {
+			return nil
+		}
+		return &payloadError{err}
+	}
+}
+
+// splitMetrics splits the DatabaseSchema and Queries sections of a metrics
+// payload into chunks of about chunkSize bytes of JSON when either of them is
+// larger. The chunks share a batch id, so the API can assemble them and the
+// ones already delivered need not be sent again when a later one fails.
+// A payload whose sections fit is returned as is.
+func splitMetrics(metrics models.Metrics, chunkSize int64) []models.Metrics {
+	if chunkSize <= 0 {
+		return []models.Metrics{metrics}
+	}
+	queries := splitRows(metrics.DB.Queries, chunkSize)
+	schemas := splitSchema(metrics.DB.DatabaseSchema, chunkSize)
+	if len(queries) <= 1 && len(schemas) <= 1 {
+		return []models.Metrics{metrics}
+	}
+
+	base := metrics
+	base.DB.Queries = nil
+	base.DB.DatabaseSchema = nil
+	chunks := []models.Metrics{base}
+	for _, rows := range queries {
+		var chunk models.Metrics
+		chunk.ReleemAgent.Info = metrics.ReleemAgent.Info
+		chunk.DB.Queries = rows
+		chunks = append(chunks, chunk)
+	}
+	for _, schema := range schemas {
+		var chunk models.Metrics
+		chunk.ReleemAgent.Info = metrics.ReleemAgent.Info
+		chunk.DB.DatabaseSchema = schema
+		chunks = append(chunks, chunk)
+	}
+	id := newBatchID()
+	for i := range chunks {
+		chunks[i].Batch = &models.Batch{ID: id, Index: i, Count: len(chunks)}
+	}
+	return chunks
+}
+
+// splitRows splits rows into consecutive parts of at most chunkSize bytes of
+// JSON, or of a single larger row.
+func splitRows(rows []models.MetricGroupValue, chunkSize int64) [][]models.MetricGroupValue {
+	var parts [][]models.MetricGroupValue
+	start, size := 0, int64(0)
+	for i, row := range rows {
+		rowSize := jsonSize(row)
+		if i > start && size+rowSize > chunkSize {
+			parts = append(parts, rows[start:i])
+			start, size = i, 0
+		}
+		size += rowSize
+	}
+	if start < len(rows) {
+		parts = append(parts, rows[start:])
+	}
+	return parts
+}
+
+// splitSchema splits the tables of a DatabaseSchema section like splitRows,
+// a table spanning several parts when it is larger than chunkSize.
+func splitSchema(schema map[string][]models.MetricGroupValue, chunkSize int64) []map[string][]models.MetricGroupValue {
+	tables := make([]string, 0, len(schema))
+	for table := range schema {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	var parts []map[string][]models.MetricGroupValue
+	part, size := make(map[string][]models.MetricGroupValue), int64(0)
+	for _, table := range tables {
+		rows := schema[table]
+		start := 0
+		for i, row := range rows {
+			rowSize := jsonSize(row)
+			if size > 0 && size+rowSize > chunkSize {
+				if i > start {
+					part[table] = rows[start:i]
+				}
+				parts = append(parts, part)
+				part, size, start = make(map[string][]models.MetricGroupValue), 0, i
+			}
+			size += rowSize
+		}
+		if start < len(rows) || len(rows) == 0 {
+			part[table] = rows[start:]
+		}
+	}
+	if len(part) > 0 {
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+func jsonSize(v any) int64 {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return int64(len(encoded)) + 1
+}
+
+func newBatchID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,107 @@
+package repeater
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/Releem/mysqlconfigurer/models"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestSplitMetricsKeepsPayloadsThatFit(t *testing.T) {
+	var metrics models.Metrics
+	metrics.DB.Queries = []models.MetricGroupValue{{"query_id": "1"}, {"query_id": "2"}}
+	for _, chunkSize := range []int64{0, 1 << 20} {
+		chunks := splitMetrics(metrics, chunkSize)
+		if len(chunks) != 1 || chunks[0].Batch != nil || len(chunks[0].DB.Queries) != 2 {
+			t.Fatalf("chunk size %d: got %d chunks", chunkSize, len(chunks))
+		}
+	}
+}
+
+func TestSplitMetricsChunksLargeSections(t *testing.T) {
+	var metrics models.Metrics
+	metrics.ReleemAgent.Info = models.MetricGroupValue{"Version": "1.0"}
+	metrics.DB.Info = models.MetricGroupValue{"Version": "8.0"}
+	for i := 0; i < 50; i++ {
+		metrics.DB.Queries = append(metrics.DB.Queries, models.MetricGroupValue{"query_id": strconv.Itoa(i), "query": "SELECT ?"})
+	}
+	metrics.DB.DatabaseSchema = map[string][]models.MetricGroupValue{"information_schema_indexes": nil}
+	for i := 0; i < 30; i++ {
+		metrics.DB.DatabaseSchema["information_schema_tables"] = append(metrics.DB.DatabaseSchema["information_schema_tables"], models.MetricGroupValue{"TABLE_NAME": "t" + strconv.Itoa(i)})
+		metrics.DB.DatabaseSchema["information_schema_columns"] = append(metrics.DB.DatabaseSchema["information_schema_columns"], models.MetricGroupValue{"COLUMN_NAME": "c" + strconv.Itoa(i)})
+	}
+
+	chunks := splitMetrics(metrics, 200)
+	if len(chunks) < 3 {
+		t.Fatalf("got %d chunks, want the sections split", len(chunks))
+	}
+	if chunks[0].DB.Info["Version"] != "8.0" || chunks[0].DB.Queries != nil || chunks[0].DB.DatabaseSchema != nil {
+		t.Fatal("the first chunk should carry the other sections only")
+	}
+	queries := 0
+	tables := make(map[string]int)
+	for i, chunk := range chunks {
+		if chunk.Batch == nil || chunk.Batch.ID != chunks[0].Batch.ID || chunk.Batch.Index != i || chunk.Batch.Count != len(chunks) {
+			t.Fatalf("chunk %d has batch %+v", i, chunk.Batch)
+		}
+		if chunk.ReleemAgent.Info["Version"] != "1.0" {
+			t.Fatalf("chunk %d misses the agent info", i)
+		}
+		if i > 0 && (len(chunk.DB.Queries) == 0) == (len(chunk.DB.DatabaseSchema) == 0) {
+			t.Fatalf("chunk %d should carry a part of a single section", i)
+		}
+		queries += len(chunk.DB.Queries)
+		for table, rows := range chunk.DB.DatabaseSchema {
+			tables[table] += len(rows)
+		}
+	}
+	if queries != 50 || tables["information_schema_tables"] != 30 || tables["information_schema_columns"] != 30 {
+		t.Fatalf("rows were lost: %d queries, tables %v", queries, tables)
+	}
+	if _, ok := tables["information_schema_indexes"]; !ok {
+		t.Fatal("empty tables should be kept")
+	}
+}
+
+func TestStreamBodyCompresses(t *testing.T) {
+	payload := map[string]string{"query": "SELECT 1"}
+	for _, compression := range []string{"none", "gzip", "zstd"} {
+		body, wait := streamBody(compression, encodeJSON(payload))
+		var reader io.Reader = body
+		switch compression {
+		case "gzip":
+			gzipReader, err := gzip.NewReader(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader = gzipReader
+		case "zstd":
+			zstdReader, err := zstd.NewReader(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer zstdReader.Close()
+			reader = zstdReader
+		}
+		var decoded map[string]string
+		if err := json.NewDecoder(reader).Decode(&decoded); err != nil {
+			t.Fatalf("%s: %v", compression, err)
+		}
+		if err := wait(); err != nil || decoded["query"] != "SELECT 1" {
+			t.Fatalf("%s: decoded %v, %v", compression, decoded, err)
+		}
+	}
+
+	body, wait := streamBody("gzip", encodeJSON(math.NaN()))
+	io.Copy(io.Discard, body)
+	var encodeErr *payloadError
+	if err := wait(); !errors.As(err, &encodeErr) || isRetryable(err) {
+		t.Fatalf("encoding errors should not be retried, got %v", err)
+	}
+}
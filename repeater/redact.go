@@ -0,0 +1,40 @@
+package repeater
+
+import (
+	stdcontext "context"
+
+	"github.com/Releem/mysqlconfigurer/models"
+	"github.com/Releem/mysqlconfigurer/redact"
+)
+
+// RedactedMetricsRepeater redacts the query texts of every payload before
+// passing it to its repeater. The gatherers keep the raw texts, so queries
+// are still explained locally.
+type RedactedMetricsRepeater struct {
+	redactor *redact.Redactor
+	repeater models.MetricsRepeater
+}
+
+func (repeater RedactedMetricsRepeater) ProcessMetrics(context models.MetricContext, metrics models.Metrics, Mode models.ModeType) (string, error) {
+	return repeater.ProcessMetricsContext(stdcontext.Background(), context, metrics, Mode)
+}
+
+func (repeater RedactedMetricsRepeater) ProcessMetricsContext(ctx stdcontext.Context, context models.MetricContext, metrics models.Metrics, Mode models.ModeType) (string, error) {
+	metrics = repeater.redactor.Metrics(metrics)
+	if contextRepeater, ok := repeater.repeater.(models.ContextMetricsRepeater); ok {
+		return contextRepeater.ProcessMetricsContext(ctx, context, metrics, Mode)
+	}
+	return repeater.repeater.ProcessMetrics(context, metrics, Mode)
+}
+
+// SpoolDepth returns the number of payloads spooled by its repeater.
+func (repeater RedactedMetricsRepeater) SpoolDepth() int {
+	if spooler, ok := repeater.repeater.(interface{ SpoolDepth() int }); ok {
+		return spooler.SpoolDepth()
+	}
+	return 0
+}
+
+func NewRedactedMetricsRepeater(redactor *redact.Redactor, repeater models.MetricsRepeater) RedactedMetricsRepeater {
+	return RedactedMetricsRepeater{redactor, repeater}
+}
@@ -1,16 +1,21 @@
 package repeater
 
 import (
-	"bytes"
+	stdcontext "context"
 	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 
+	"github.com/Releem/mysqlconfigurer/api"
 	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/history"
 	"github.com/Releem/mysqlconfigurer/models"
+	"github.com/Releem/mysqlconfigurer/signature"
 	"github.com/Releem/mysqlconfigurer/utils"
 	logging "github.com/google/logger"
 
@@ -20,36 +25,119 @@ import (
 type ReleemConfigurationsRepeater struct {
 	logger        logging.Logger
 	configuration *config.Config
+	spool         *Spool
+	breaker       *circuitBreaker
+	replaying     *sync.Mutex
+	client        *http.Client
+	verifier      *signature.Verifier
+}
+
+// responseError is returned when the API answers with an unexpected status code.
+type responseError struct {
+	StatusCode int
+	Body       string
+}
+
+func (err *responseError) Error() string {
+	return "Response: status code: " + strconv.Itoa(err.StatusCode) + " Response: body:\n" + err.Body
 }
 
 func (repeater ReleemConfigurationsRepeater) ProcessMetrics(context models.MetricContext, metrics models.Metrics, Mode models.ModeType) (string, error) {
+	return repeater.ProcessMetricsContext(stdcontext.Background(), context, metrics, Mode)
+}
+
+// ProcessMetricsContext sends the metrics, aborting the request when ctx is done.
+// Aborted metrics payloads are kept in the spool. Metrics payloads with large
+// DatabaseSchema or Queries sections are sent in chunks, and only the chunks
+// not delivered yet are spooled.
+func (repeater ReleemConfigurationsRepeater) ProcessMetricsContext(ctx stdcontext.Context, context models.MetricContext, metrics models.Metrics, Mode models.ModeType) (string, error) {
 	defer utils.HandlePanic(repeater.configuration, repeater.logger)
 	repeater.logger.V(5).Info(Mode.Name, Mode.Type)
-	var buffer bytes.Buffer
-	encoder := json.NewEncoder(&buffer)
-	if err := encoder.Encode(metrics); err != nil {
-		return "", errors.New("Failed to encode metrics: " + err.Error())
-	}
-	repeater.logger.V(5).Info("Result Send data: ", buffer.String())
-	var api_domain, subdomain, domain string
-	env := context.GetEnv()
-
-	switch env {
-	case "dev2":
-		subdomain = "dev2."
-	case "dev":
-		subdomain = "dev."
-	case "stage":
-		subdomain = "stage."
-	default:
-		subdomain = ""
-	}
-	if repeater.configuration.ReleemRegion == "EU" || repeater.configuration.ReleemRegion == "eu" {
-		domain = "eu.releem.com"
-	} else {
-		domain = "releem.com"
+	if repeater.configuration.Debug {
+		payload, _ := json.Marshal(metrics)
+		repeater.logger.V(5).Info("Result Send data: ", string(payload))
+	}
+
+	if !isSpooled(Mode) {
+		response, err := repeater.send(ctx, context, Mode, encodeJSON(metrics))
+		if err == nil && Mode.Name == "Configurations" {
+			repeater.recordConfiguration(Mode, response, downloadTaskID(metrics))
+		}
+		return response, err
+	}
+	chunks := splitMetrics(metrics, int64(repeater.configuration.PayloadChunkSize)*1024*1024)
+	if len(chunks) > 1 {
+		repeater.logger.Info("Sending ", Mode.Name, " ", Mode.Type, " payload in ", len(chunks), " chunks, batch ", chunks[0].Batch.ID)
+	}
+	var body_res string
+	for i, chunk := range chunks {
+		if repeater.spool != nil && !repeater.breaker.Allow() {
+			repeater.spoolChunks(Mode, chunks[i:])
+			return "", errors.New("Request: API unavailable, payload spooled until the next retry")
+		}
+		response, err := repeater.send(ctx, context, Mode, encodeJSON(chunk))
+		if err != nil {
+			if repeater.spool != nil && isRetryable(err) {
+				if ctx.Err() == nil {
+					repeater.breaker.Failure()
+				}
+				repeater.spoolChunks(Mode, chunks[i:])
+			}
+			return "", err
+		}
+		body_res = response
 	}
-	api_domain = "https://api.queries." + subdomain + domain + "/v2/"
+	if repeater.spool == nil {
+		return body_res, nil
+	}
+	repeater.breaker.Success()
+	go repeater.replaySpool(context)
+	return body_res, nil
+}
+
+func (repeater ReleemConfigurationsRepeater) spoolChunks(Mode models.ModeType, chunks []models.Metrics) {
+	for _, chunk := range chunks {
+		if err := repeater.spool.PushFrom(Mode, encodeJSON(chunk)); err != nil {
+			repeater.logger.Error("Spool: failed to store payload: ", err)
+			return
+		}
+	}
+	repeater.logger.Info("Spool: stored ", Mode.Name, " ", Mode.Type, " payload for retry")
+}
+
+// replaySpool delivers the spooled payloads once the API is reachable again.
+func (repeater ReleemConfigurationsRepeater) replaySpool(context models.MetricContext) {
+	defer utils.HandlePanic(repeater.configuration, repeater.logger)
+	if !repeater.replaying.TryLock() {
+		return
+	}
+	defer repeater.replaying.Unlock()
+
+	replayed, err := repeater.spool.Replay(func(Mode models.ModeType, payload []byte) error {
+		if !repeater.breaker.Allow() {
+			return errors.New("Request: API unavailable")
+		}
+		if _, err := repeater.send(stdcontext.Background(), context, Mode, writeBytes(payload)); err != nil {
+			if isRetryable(err) {
+				repeater.breaker.Failure()
+			}
+			return err
+		}
+		repeater.breaker.Success()
+		return nil
+	})
+	if replayed > 0 {
+		repeater.logger.Info("Spool: replayed ", replayed, " payloads")
+	}
+	if err != nil {
+		repeater.logger.Error("Spool: replay stopped: ", err)
+	}
+}
+
+// send posts the payload produced by write, streaming it with the configured
+// compression.
+func (repeater ReleemConfigurationsRepeater) send(ctx stdcontext.Context, context models.MetricContext, Mode models.ModeType, write func(io.Writer) error) (string, error) {
+	api_domain := api.BaseURL(repeater.configuration)
 
 	switch Mode.Name {
 	case "Configurations":
@@ -84,18 +172,27 @@ func (repeater ReleemConfigurationsRepeater) ProcessMetrics(context models.Metri
 	}
 	repeater.logger.V(5).Info(api_domain)
 
-	req, err := http.NewRequest(http.MethodPost, api_domain, &buffer)
+	body, wait := streamBody(repeater.configuration.PayloadCompression, write)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, api_domain, body)
 	if err != nil {
+		wait()
 		return "", errors.New("Request: could not create request: " + err.Error())
 	}
 	req.Header.Set("x-releem-api-key", context.GetApiKey())
+	req.Header.Set("Content-Type", "application/json")
+	if encoding := contentEncoding(repeater.configuration.PayloadCompression); encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
 	if Mode.Name == "Configurations" && Mode.Type == "GetJson" {
 		req.Header.Set("Accept", "application/json")
 	}
-	client := http.Client{
-		Timeout: 10 * time.Minute,
+	res, err := repeater.client.Do(req)
+	if encodeErr := wait(); encodeErr != nil {
+		if err == nil {
+			res.Body.Close()
+		}
+		return "", encodeErr
 	}
-	res, err := client.Do(req)
 	if err != nil {
 		return "", errors.New("Request: error making http request: " + err.Error())
 	}
@@ -106,27 +203,23 @@ func (repeater ReleemConfigurationsRepeater) ProcessMetrics(context models.Metri
 		return "", errors.New("Response: error read body request: " + err.Error())
 	}
 	if res.StatusCode != 200 && res.StatusCode != 201 {
-		return "", errors.New("Response: status code: " + strconv.Itoa(res.StatusCode) + " Response: body:\n" + string(body_res))
+		return "", &responseError{StatusCode: res.StatusCode, Body: string(body_res)}
 	}
 	repeater.logger.V(5).Info("Response: status code: ", res.StatusCode)
 	repeater.logger.V(5).Info("Response: body:\n", string(body_res))
 
-	if Mode.Name == "Configurations" {
-		var config_filename string
-		if Mode.Type == "GetInitial" {
-			config_filename = "initial_config_mysql.cnf"
-		} else {
-			db_type := repeater.configuration.GetDatabaseType()
-			switch db_type {
-			case "mysql":
-				config_filename = "z_aiops_mysql.cnf"
-			case "postgresql":
-				config_filename = "z_aiops_postgresql.conf"
-			default:
-				config_filename = "z_aiops_mysql.cnf"
+	if isSigned(Mode) && repeater.verifier.Enabled() {
+		endpoint := strings.TrimPrefix(api_domain, api.BaseURL(repeater.configuration))
+		if err := repeater.verifier.Verify(endpoint, res.Header, body_res); err != nil {
+			if repeater.verifier.Enforced() {
+				return "", err
 			}
+			repeater.logger.Warning(err)
 		}
-		err = os.WriteFile(context.GetReleemConfDir()+"/"+config_filename, body_res, 0644)
+	}
+
+	if Mode.Name == "Configurations" {
+		err = os.WriteFile(context.GetReleemConfDir()+"/"+repeater.configurationFile(Mode), body_res, 0644)
 		if err != nil {
 			return "", errors.New("WriteFile: Error write to file: " + err.Error())
 		}
@@ -134,6 +227,76 @@ func (repeater ReleemConfigurationsRepeater) ProcessMetrics(context models.Metri
 	return string(body_res), nil
 }
 
-func NewReleemConfigurationsRepeater(configuration *config.Config, logger logging.Logger) ReleemConfigurationsRepeater {
-	return ReleemConfigurationsRepeater{logger, configuration}
+// configurationFile returns the name of the file a configuration response is
+// written to under releem_cnf_dir.
+func (repeater ReleemConfigurationsRepeater) configurationFile(Mode models.ModeType) string {
+	if Mode.Type == "GetInitial" {
+		return "initial_config_mysql.cnf"
+	}
+	return history.ConfigFileName(repeater.configuration)
+}
+
+// recordConfiguration adds a downloaded configuration to the configuration
+// history. A failure is logged only, the configuration was written.
+func (repeater ReleemConfigurationsRepeater) recordConfiguration(Mode models.ModeType, body string, taskID int) {
+	_, err := history.NewStore(repeater.configuration).Record(history.Version{
+		Source:  history.SourceDownload,
+		TaskID:  taskID,
+		File:    repeater.configurationFile(Mode),
+		Content: body,
+	})
+	if err != nil {
+		repeater.logger.Error("Failed to record the configuration in the history: ", err)
+	}
+}
+
+// downloadTaskID returns the task downloading a configuration: the running
+// task of the metrics, or the task that started the agent process.
+func downloadTaskID(metrics models.Metrics) int {
+	if metrics.ReleemAgent.Tasks.Status == 3 {
+		return metrics.ReleemAgent.Tasks.ID
+	}
+	taskID, _ := strconv.Atoi(os.Getenv(history.TaskIDEnv))
+	return taskID
+}
+
+// isSigned reports whether responses of the mode are acted on, so their
+// signature is verified: tasks, and configurations applied or written to the
+// configuration directory.
+func isSigned(Mode models.ModeType) bool {
+	return Mode.Name == "Configurations" || (Mode.Name == "Task" && Mode.Type == "Get")
+}
+
+func NewReleemConfigurationsRepeater(configuration *config.Config, logger logging.Logger) (ReleemConfigurationsRepeater, error) {
+	client, err := api.NewClient(configuration)
+	if err != nil {
+		return ReleemConfigurationsRepeater{}, err
+	}
+	verifier, err := signature.NewVerifier(configuration)
+	if err != nil {
+		return ReleemConfigurationsRepeater{}, err
+	}
+	repeater := ReleemConfigurationsRepeater{
+		logger:        logger,
+		configuration: configuration,
+		breaker:       newCircuitBreaker(3, 30*time.Second, 30*time.Minute),
+		replaying:     &sync.Mutex{},
+		client:        client,
+		verifier:      verifier,
+	}
+	spool, err := NewSpool(SpoolDir(configuration), int64(configuration.SpoolMaxSize)*1024*1024, configuration.SpoolMaxAge*time.Second, logger)
+	if err != nil {
+		logger.Error("Spool: disabled, failed to create spool directory: ", err)
+	} else {
+		repeater.spool = spool
+	}
+	return repeater, nil
+}
+
+// SpoolDepth returns the number of payloads waiting to be resent.
+func (repeater ReleemConfigurationsRepeater) SpoolDepth() int {
+	if repeater.spool == nil {
+		return 0
+	}
+	return repeater.spool.Depth()
 }
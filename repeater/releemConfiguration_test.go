@@ -0,0 +1,179 @@
+package repeater
+
+import (
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/history"
+	"github.com/Releem/mysqlconfigurer/models"
+	"github.com/Releem/mysqlconfigurer/signature"
+	logging "github.com/google/logger"
+)
+
+func TestReleemRepeaterResumesChunkedUploads(t *testing.T) {
+	var mutex sync.Mutex
+	var received []int
+	failed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/db/metrics/queries" || r.Header.Get("x-releem-api-key") != "key" || r.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("unexpected request %s %v", r.URL.Path, r.Header)
+		}
+		body, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var chunk models.Metrics
+		if err := json.NewDecoder(body).Decode(&chunk); err != nil || chunk.Batch == nil {
+			t.Errorf("undecodable chunk: %v", err)
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		if chunk.Batch.Index == 2 && !failed {
+			failed = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received = append(received, chunk.Batch.Index)
+	}))
+	defer server.Close()
+
+	configuration := &config.Config{
+		ApiKey:             "key",
+		ApiURL:             server.URL + "/v2/",
+		ApiTimeout:         30,
+		ApiConnectTimeout:  30,
+		ReleemDir:          t.TempDir(),
+		PayloadCompression: "gzip",
+		PayloadChunkSize:   1,
+	}
+	repeater, err := NewReleemConfigurationsRepeater(configuration, *logging.Init("releem-agent-test", false, false, io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var metrics models.Metrics
+	for i := 0; i < 30000; i++ {
+		metrics.DB.Queries = append(metrics.DB.Queries, models.MetricGroupValue{"query_id": strconv.Itoa(i), "query": strings.Repeat("x", 60)})
+	}
+
+	if _, err := repeater.ProcessMetrics(configuration, metrics, models.ModeType{Name: "Metrics", Type: "Queries"}); err == nil {
+		t.Fatal("the failed chunk should be reported")
+	}
+	if depth := repeater.SpoolDepth(); depth != 2 {
+		t.Fatalf("SpoolDepth = %d, want the 2 chunks not delivered", depth)
+	}
+	repeater.replaySpool(configuration)
+	if depth := repeater.SpoolDepth(); depth != 0 {
+		t.Fatalf("SpoolDepth = %d after replay", depth)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if want := []int{0, 1, 2, 3}; !slices.Equal(received, want) {
+		t.Fatalf("received chunks %v, want %v", received, want)
+	}
+}
+
+func TestReleemRepeaterWritesVerifiedConfigurationsOnly(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte("[mysqld]\nmax_connections=200\n")
+	signed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if signed {
+			expires := time.Now().Add(time.Minute).Unix()
+			w.Header().Set("X-Releem-Key-Id", "releem-1")
+			w.Header().Set("X-Releem-Expires", strconv.FormatInt(expires, 10))
+			w.Header().Set("X-Releem-Nonce", "nonce-1")
+			w.Header().Set("X-Releem-Signature", base64.StdEncoding.EncodeToString(ed25519.Sign(private, signature.Message("db/config/get", expires, "nonce-1", body))))
+		}
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	configuration := &config.Config{
+		ApiURL:                server.URL + "/v2/",
+		ApiTimeout:            30,
+		ApiConnectTimeout:     30,
+		ReleemDir:             t.TempDir(),
+		ReleemConfDir:         t.TempDir(),
+		SignatureVerification: "enforce",
+		SignatureKeys:         map[string]string{"releem-1": base64.StdEncoding.EncodeToString(public)},
+		SignatureMaxValidity:  3600,
+	}
+	repeater, err := NewReleemConfigurationsRepeater(configuration, *logging.Init("releem-agent-test", false, false, io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(configuration.ReleemConfDir, "z_aiops_mysql.cnf")
+	Mode := models.ModeType{Name: "Configurations", Type: "Get"}
+
+	_, err = repeater.ProcessMetrics(configuration, models.Metrics{}, Mode)
+	var rejected *signature.Error
+	if !errors.As(err, &rejected) {
+		t.Fatalf("an unsigned configuration should be rejected, got %v", err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatal("a rejected configuration was written")
+	}
+
+	signed = true
+	if _, err := repeater.ProcessMetrics(configuration, models.Metrics{}, Mode); err != nil {
+		t.Fatal(err)
+	}
+	if written, err := os.ReadFile(file); err != nil || string(written) != string(body) {
+		t.Fatalf("the verified configuration was not written: %v", err)
+	}
+}
+
+func TestReleemRepeaterRecordsDownloadedConfigurations(t *testing.T) {
+	body := "[mysqld]\nmax_connections=200\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	defer server.Close()
+
+	configuration := &config.Config{
+		ApiURL:            server.URL + "/v2/",
+		ApiTimeout:        30,
+		ApiConnectTimeout: 30,
+		ReleemDir:         t.TempDir(),
+		ReleemConfDir:     t.TempDir(),
+	}
+	repeater, err := NewReleemConfigurationsRepeater(configuration, *logging.Init("releem-agent-test", false, false, io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var metrics models.Metrics
+	metrics.ReleemAgent.Tasks = models.Task{ID: 31, TypeID: 4, Status: 3}
+	for i := 0; i < 2; i++ {
+		if _, err := repeater.ProcessMetrics(configuration, metrics, models.ModeType{Name: "Configurations", Type: "Get"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := history.NewStore(configuration).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].Source != history.SourceDownload || versions[0].TaskID != 31 || versions[0].Content != body || versions[0].File != "z_aiops_mysql.cnf" {
+		t.Fatalf("want the download recorded once with its task, got %+v", versions)
+	}
+}
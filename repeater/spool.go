@@ -0,0 +1,277 @@
+package repeater
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/models"
+	logging "github.com/google/logger"
+)
+
+const spoolFileSuffix = ".json"
+
+// Spool is a bounded on-disk FIFO of payloads that could not be delivered to
+// the Releem API. Entries are replayed oldest first once the API is reachable.
+type Spool struct {
+	logger   logging.Logger
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	mutex    sync.Mutex
+	sequence atomic.Uint64
+	now      func() time.Time
+}
+
+type spoolEntry struct {
+	path    string
+	mode    models.ModeType
+	size    int64
+	created time.Time
+}
+
+func NewSpool(dir string, maxBytes int64, maxAge time.Duration, logger logging.Logger) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Spool{
+		logger:   logger,
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		now:      time.Now,
+	}, nil
+}
+
+// SpoolDir returns the spool directory of an instance under ReleemDir.
+func SpoolDir(configuration *config.Config) string {
+	return config.InstanceDir(configuration, "spool")
+}
+
+// isSpooled reports whether payloads of the mode are kept when sending fails.
+func isSpooled(Mode models.ModeType) bool {
+	return Mode.Name == "Metrics" && (Mode.Type == "" || Mode.Type == "Queries")
+}
+
+// Push stores a payload and drops the oldest entries exceeding the size and age limits.
+func (spool *Spool) Push(Mode models.ModeType, payload []byte) error {
+	return spool.PushFrom(Mode, writeBytes(payload))
+}
+
+// PushFrom stores the payload produced by write like Push, without holding
+// it in memory.
+func (spool *Spool) PushFrom(Mode models.ModeType, write func(io.Writer) error) error {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	name := fmt.Sprintf("%020d-%06d-%s%s", spool.now().UnixNano(), spool.sequence.Add(1)%1000000, spoolModeName(Mode), spoolFileSuffix)
+	tmp := filepath.Join(spool.dir, "."+name)
+	if err := writeFile(tmp, write); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(spool.dir, name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	spool.enforceLimits()
+	return nil
+}
+
+func writeFile(path string, write func(io.Writer) error) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Depth returns the number of payloads waiting to be replayed.
+func (spool *Spool) Depth() int {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+	return len(spool.entries())
+}
+
+// Replay sends the spooled payloads oldest first and removes the delivered ones.
+// It stops at the first payload that fails with a retryable error.
+func (spool *Spool) Replay(send func(Mode models.ModeType, payload []byte) error) (int, error) {
+	spool.mutex.Lock()
+	entries := spool.entries()
+	spool.mutex.Unlock()
+
+	replayed := 0
+	for _, entry := range entries {
+		payload, err := os.ReadFile(entry.path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return replayed, err
+		}
+		if err := send(entry.mode, payload); err != nil {
+			if !isRetryable(err) {
+				spool.logger.Error("Spool: dropping payload rejected by the API ", filepath.Base(entry.path), ": ", err)
+				os.Remove(entry.path)
+				continue
+			}
+			return replayed, err
+		}
+		os.Remove(entry.path)
+		replayed++
+	}
+	return replayed, nil
+}
+
+func (spool *Spool) enforceLimits() {
+	entries := spool.entries()
+	var total int64
+	for _, entry := range entries {
+		total += entry.size
+	}
+	for _, entry := range entries {
+		expired := spool.maxAge > 0 && spool.now().Sub(entry.created) > spool.maxAge
+		oversized := spool.maxBytes > 0 && total > spool.maxBytes
+		if !expired && !oversized {
+			break
+		}
+		if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+			spool.logger.Error("Spool: failed to remove ", entry.path, ": ", err)
+			continue
+		}
+		total -= entry.size
+		if expired {
+			spool.logger.Warning("Spool: dropped expired payload ", filepath.Base(entry.path))
+		} else {
+			spool.logger.Warning("Spool: dropped payload over the size limit ", filepath.Base(entry.path))
+		}
+	}
+}
+
+// entries lists spooled payloads, oldest first.
+func (spool *Spool) entries() []spoolEntry {
+	files, err := os.ReadDir(spool.dir)
+	if err != nil {
+		spool.logger.Error("Spool: failed to read ", spool.dir, ": ", err)
+		return nil
+	}
+	var entries []spoolEntry
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, spoolFileSuffix) {
+			continue
+		}
+		var created int64
+		var sequence int
+		var mode string
+		if _, err := fmt.Sscanf(strings.TrimSuffix(name, spoolFileSuffix), "%d-%d-%s", &created, &sequence, &mode); err != nil {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, spoolEntry{
+			path:    filepath.Join(spool.dir, name),
+			mode:    spoolMode(mode),
+			size:    info.Size(),
+			created: time.Unix(0, created),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return filepath.Base(entries[i].path) < filepath.Base(entries[j].path)
+	})
+	return entries
+}
+
+// isRetryable reports whether a failed delivery may succeed later. Payloads
+// rejected by the API are not retried, except for throttling and server errors.
+func isRetryable(err error) bool {
+	var payload *payloadError
+	if errors.As(err, &payload) {
+		return false
+	}
+	var response *responseError
+	if !errors.As(err, &response) {
+		return true
+	}
+	return response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests || response.StatusCode == http.StatusRequestTimeout
+}
+
+func spoolModeName(Mode models.ModeType) string {
+	if Mode.Type == "Queries" {
+		return "queries"
+	}
+	return "metrics"
+}
+
+func spoolMode(name string) models.ModeType {
+	if name == "queries" {
+		return models.ModeType{Name: "Metrics", Type: "Queries"}
+	}
+	return models.ModeType{Name: "Metrics", Type: ""}
+}
+
+// circuitBreaker stops delivery attempts after consecutive failures and lets
+// a single attempt through once an exponentially growing backoff has elapsed.
+type circuitBreaker struct {
+	mutex     sync.Mutex
+	failures  int
+	openUntil time.Time
+	threshold int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+	now       func() time.Time
+}
+
+func newCircuitBreaker(threshold int, baseDelay time.Duration, maxDelay time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		baseDelay: baseDelay,
+		maxDelay:  maxDelay,
+		now:       time.Now,
+	}
+}
+
+func (breaker *circuitBreaker) Allow() bool {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	return !breaker.now().Before(breaker.openUntil)
+}
+
+func (breaker *circuitBreaker) Success() {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	breaker.failures = 0
+	breaker.openUntil = time.Time{}
+}
+
+func (breaker *circuitBreaker) Failure() {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	breaker.failures++
+	if breaker.failures < breaker.threshold {
+		return
+	}
+	delay := breaker.baseDelay
+	for i := breaker.threshold; i < breaker.failures && delay < breaker.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > breaker.maxDelay {
+		delay = breaker.maxDelay
+	}
+	breaker.openUntil = breaker.now().Add(delay)
+}
@@ -0,0 +1,115 @@
+package repeater
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/models"
+	logging "github.com/google/logger"
+)
+
+func newTestSpool(t *testing.T, maxBytes int64, maxAge time.Duration) *Spool {
+	t.Helper()
+	spool, err := NewSpool(t.TempDir(), maxBytes, maxAge, *logging.Init("releem-agent-test", false, false, io.Discard))
+	if err != nil {
+		t.Fatalf("NewSpool: %v", err)
+	}
+	return spool
+}
+
+func TestSpoolReplaysPayloadsInOrder(t *testing.T) {
+	spool := newTestSpool(t, 0, 0)
+	spool.Push(models.ModeType{Name: "Metrics", Type: ""}, []byte("first"))
+	spool.Push(models.ModeType{Name: "Metrics", Type: "Queries"}, []byte("second"))
+	spool.Push(models.ModeType{Name: "Metrics", Type: ""}, []byte("third"))
+
+	var sent []string
+	replayed, err := spool.Replay(func(Mode models.ModeType, payload []byte) error {
+		sent = append(sent, Mode.Type+":"+string(payload))
+		return nil
+	})
+	if err != nil || replayed != 3 {
+		t.Fatalf("Replay = %d, %v", replayed, err)
+	}
+	want := []string{":first", "Queries:second", ":third"}
+	for i := range want {
+		if sent[i] != want[i] {
+			t.Fatalf("sent = %v, want %v", sent, want)
+		}
+	}
+	if depth := spool.Depth(); depth != 0 {
+		t.Fatalf("Depth = %d after replay, want 0", depth)
+	}
+}
+
+func TestSpoolReplayStopsOnRetryableError(t *testing.T) {
+	spool := newTestSpool(t, 0, 0)
+	spool.Push(models.ModeType{Name: "Metrics", Type: ""}, []byte("rejected"))
+	spool.Push(models.ModeType{Name: "Metrics", Type: ""}, []byte("unreachable"))
+	spool.Push(models.ModeType{Name: "Metrics", Type: ""}, []byte("pending"))
+
+	replayed, err := spool.Replay(func(Mode models.ModeType, payload []byte) error {
+		if string(payload) == "rejected" {
+			return &responseError{StatusCode: 400}
+		}
+		return errors.New("connection refused")
+	})
+	if err == nil || replayed != 0 {
+		t.Fatalf("Replay = %d, %v", replayed, err)
+	}
+	if depth := spool.Depth(); depth != 2 {
+		t.Fatalf("Depth = %d, want 2 (rejected payload dropped, others kept)", depth)
+	}
+}
+
+func TestSpoolDropsOldestOverLimits(t *testing.T) {
+	spool := newTestSpool(t, 10, time.Hour)
+	now := time.Now()
+	spool.now = func() time.Time { return now }
+	spool.Push(models.ModeType{Name: "Metrics", Type: ""}, []byte("aaaa"))
+	spool.Push(models.ModeType{Name: "Metrics", Type: ""}, []byte("bbbb"))
+	spool.Push(models.ModeType{Name: "Metrics", Type: ""}, []byte("cccc"))
+	if depth := spool.Depth(); depth != 2 {
+		t.Fatalf("Depth = %d, want 2 after size limit", depth)
+	}
+
+	now = now.Add(2 * time.Hour)
+	spool.Push(models.ModeType{Name: "Metrics", Type: ""}, []byte("dd"))
+	if depth := spool.Depth(); depth != 1 {
+		t.Fatalf("Depth = %d, want 1 after age limit", depth)
+	}
+}
+
+func TestCircuitBreakerBacksOffExponentially(t *testing.T) {
+	breaker := newCircuitBreaker(2, time.Second, 3*time.Second)
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+
+	breaker.Failure()
+	if !breaker.Allow() {
+		t.Fatal("breaker opened before reaching the threshold")
+	}
+	breaker.Failure()
+	if breaker.Allow() {
+		t.Fatal("breaker should be open after reaching the threshold")
+	}
+	now = now.Add(time.Second)
+	if !breaker.Allow() {
+		t.Fatal("breaker should allow a retry after the backoff")
+	}
+	breaker.Failure()
+	now = now.Add(time.Second)
+	if breaker.Allow() {
+		t.Fatal("backoff should double after another failure")
+	}
+	breaker.Failure()
+	if breaker.openUntil.Sub(now) != 3*time.Second {
+		t.Fatalf("backoff = %v, want capped at 3s", breaker.openUntil.Sub(now))
+	}
+	breaker.Success()
+	if !breaker.Allow() {
+		t.Fatal("breaker should close after a success")
+	}
+}
@@ -0,0 +1,72 @@
+// Package safety refuses the actions changing the database, its configuration
+// or the agent itself when read_only_agent is enabled. Every task and every
+// statement that may write goes through it.
+package safety
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/redact"
+)
+
+// ErrReadOnly is returned for the actions refused by read_only_agent.
+var ErrReadOnly = errors.New("read_only_agent is enabled")
+
+// CheckTask refuses the task types applying configuration or updating the
+// agent: 0 (apply manually), 2 (update), 4 (apply automatically),
+// 5 (apply with a restart) and 8 (roll back to a configuration version).
+func CheckTask(configuration *config.Config, typeID int) error {
+	if !configuration.ReadOnlyAgent {
+		return nil
+	}
+	switch typeID {
+	case 0, 2, 4, 5, 8:
+		return fmt.Errorf("task type %d refused: %w", typeID, ErrReadOnly)
+	}
+	return nil
+}
+
+// CheckStatement refuses the statements that are not read-only: DDL, DML,
+// procedure calls and global variable changes.
+func CheckStatement(configuration *config.Config, query string) error {
+	if !configuration.ReadOnlyAgent || IsReadOnlyStatement(query) {
+		return nil
+	}
+	statement := strings.TrimSpace(query)
+	if len(statement) > 80 {
+		statement = statement[:80] + "..."
+	}
+	return fmt.Errorf("statement %q refused: %w", statement, ErrReadOnly)
+}
+
+var (
+	leadingComments = regexp.MustCompile(`^(\s|\(|/\*.*?\*/|--[^\n]*\n|#[^\n]*\n)*`)
+	firstWord       = regexp.MustCompile(`^[A-Za-z]+`)
+	writeKeyword    = regexp.MustCompile(`(?i)\b(INSERT|UPDATE|DELETE|MERGE|CREATE|ALTER|DROP|TRUNCATE|GRANT|REVOKE|CALL|INTO\s+OUTFILE|INTO\s+DUMPFILE)\b`)
+	globalScope     = regexp.MustCompile(`(?i)\b(GLOBAL|PERSIST|PERSIST_ONLY)\b|@@(GLOBAL|PERSIST|PERSIST_ONLY)\.|^SET\s+(PASSWORD|DEFAULT\s+ROLE|RESOURCE\s+GROUP)\b`)
+	analyze         = regexp.MustCompile(`(?i)\bANALY[SZ]E\b`)
+)
+
+// IsReadOnlyStatement reports whether a statement only reads: SELECT, SHOW,
+// EXPLAIN without ANALYZE, DESCRIBE, and SET of session variables. Statements
+// that may write anywhere, e.g. a SELECT ... INTO OUTFILE or a WITH ... DELETE,
+// are not read-only. Keywords in string literals are ignored.
+func IsReadOnlyStatement(query string) bool {
+	statement := redact.NormalizeLiterals(query, false)
+	statement = strings.TrimSpace(leadingComments.ReplaceAllString(statement, ""))
+	switch strings.ToUpper(firstWord.FindString(statement)) {
+	case "SELECT", "WITH", "VALUES", "TABLE":
+		return !writeKeyword.MatchString(statement)
+	case "SHOW", "DESCRIBE", "DESC":
+		return true
+	case "EXPLAIN":
+		return !analyze.MatchString(statement)
+	case "SET":
+		return !globalScope.MatchString(statement)
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package safety
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Releem/mysqlconfigurer/config"
+)
+
+func TestCheckTaskRefusesWritingTasks(t *testing.T) {
+	readOnly := &config.Config{ReadOnlyAgent: true}
+	for typeID, refused := range map[int]bool{0: true, 1: false, 2: true, 3: false, 4: true, 5: true, 7: false, 8: true} {
+		err := CheckTask(readOnly, typeID)
+		if refused != errors.Is(err, ErrReadOnly) {
+			t.Errorf("CheckTask(%d) = %v, refused %v", typeID, err, refused)
+		}
+		if err := CheckTask(&config.Config{}, typeID); err != nil {
+			t.Errorf("CheckTask(%d) = %v without read_only_agent", typeID, err)
+		}
+	}
+}
+
+func TestIsReadOnlyStatement(t *testing.T) {
+	for query, readOnly := range map[string]bool{
+		"SELECT 1 FROM dual":                          true,
+		"  select * from t where name = 'insert'":     true,
+		"/* releem */ SHOW GLOBAL STATUS":             true,
+		"-- comment\nSELECT @@global.max_connections": true,
+		"(SELECT 1) UNION (SELECT 2)":                 true,
+		"EXPLAIN FORMAT=JSON SELECT * FROM t":         true,
+		"DESCRIBE t":                                  true,
+		"SET SESSION sql_mode = ''":                   true,
+		"SET @a = 1":                                  true,
+		"INSERT INTO t VALUES (1)":                    false,
+		"set global max_connections=200":              false,
+		"SET @@GLOBAL.max_connections = 200":          false,
+		"SET PERSIST max_connections = 200":           false,
+		"SET PASSWORD = 'secret'":                     false,
+		"CALL sys.ps_setup_enable_consumer('events')": false,
+		"EXPLAIN ANALYZE SELECT * FROM t":             false,
+		"WITH d AS (SELECT 1) DELETE FROM t":          false,
+		"SELECT * FROM t INTO OUTFILE '/tmp/t'":       false,
+		"ALTER TABLE t ADD INDEX i (a)":               false,
+		"/* SELECT */ DROP TABLE t":                   false,
+		"":                                            false,
+	} {
+		if got := IsReadOnlyStatement(query); got != readOnly {
+			t.Errorf("IsReadOnlyStatement(%q) = %v, want %v", query, got, readOnly)
+		}
+	}
+}
+
+func TestCheckStatement(t *testing.T) {
+	if err := CheckStatement(&config.Config{}, "SET GLOBAL max_connections=200"); err != nil {
+		t.Fatalf("nothing should be refused without read_only_agent: %v", err)
+	}
+	readOnly := &config.Config{ReadOnlyAgent: true}
+	if err := CheckStatement(readOnly, "SHOW GLOBAL VARIABLES"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckStatement(readOnly, "SET GLOBAL max_connections=200"); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("CheckStatement = %v, want ErrReadOnly", err)
+	}
+}
@@ -0,0 +1,222 @@
+// Package signature verifies the tasks and configurations sent by the Releem
+// platform before the agent acts on them.
+//
+// The platform signs the response body with an Ed25519 key and sends
+//
+//	X-Releem-Key-Id     the id of the key in signature_keys
+//	X-Releem-Expires    the Unix time after which the response is refused
+//	X-Releem-Nonce      a value never used twice, so a response cannot be replayed
+//	X-Releem-Signature  the base64 signature of Message
+package signature
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+)
+
+// Modes of signature_verification.
+const (
+	// Off accepts every response.
+	Off = "off"
+	// Warn logs the responses that fail verification and accepts them.
+	Warn = "warn"
+	// Enforce refuses the responses that fail verification.
+	Enforce = "enforce"
+)
+
+// clockSkew is the tolerance on expiry times for clocks out of sync.
+const clockSkew = time.Minute
+
+// Error is returned for a response whose signature is missing or invalid.
+// Body is the unverified response, e.g. to report a refused task.
+type Error struct {
+	Endpoint string
+	Reason   string
+	Body     []byte
+}
+
+func (err *Error) Error() string {
+	return "signature of " + err.Endpoint + " rejected: " + err.Reason
+}
+
+// Message returns the signed message of a response body.
+func Message(endpoint string, expires int64, nonce string, body []byte) []byte {
+	digest := sha256.Sum256(body)
+	return []byte(strings.Join([]string{"releem-signature-v1", endpoint, strconv.FormatInt(expires, 10), nonce, hex.EncodeToString(digest[:])}, "\n"))
+}
+
+// Verifier checks responses against the keys of the local trust store.
+type Verifier struct {
+	mode        string
+	keys        map[string]ed25519.PublicKey
+	maxValidity time.Duration
+	nonces      *nonceStore
+	now         func() time.Time
+}
+
+// NewVerifier returns the verifier configured by signature_verification,
+// signature_keys and signature_max_validity_seconds.
+func NewVerifier(configuration *config.Config) (*Verifier, error) {
+	verifier := &Verifier{
+		mode:        configuration.SignatureVerification,
+		keys:        make(map[string]ed25519.PublicKey),
+		maxValidity: configuration.SignatureMaxValidity * time.Second,
+		now:         time.Now,
+	}
+	if verifier.mode == "" {
+		verifier.mode = Off
+	}
+	if verifier.mode == Off {
+		return verifier, nil
+	}
+	for id, encoded := range configuration.SignatureKeys {
+		key, err := ParsePublicKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("signature_keys.%s: %w", id, err)
+		}
+		verifier.keys[id] = key
+	}
+	if len(verifier.keys) == 0 {
+		return nil, fmt.Errorf("signature_verification is %s but signature_keys is empty", verifier.mode)
+	}
+	verifier.nonces = newNonceStore(NonceFile(configuration))
+	return verifier, nil
+}
+
+// ParsePublicKey decodes a base64 Ed25519 public key.
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("an Ed25519 public key has %d bytes, got %d", ed25519.PublicKeySize, len(key))
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// NonceFile returns the file of the nonces already accepted by an instance.
+func NonceFile(configuration *config.Config) string {
+	return filepath.Join(config.InstanceDir(configuration, "signature"), "nonces.json")
+}
+
+// Enabled reports whether responses are verified.
+func (verifier *Verifier) Enabled() bool {
+	return verifier.mode != Off
+}
+
+// Enforced reports whether responses failing verification are refused.
+func (verifier *Verifier) Enforced() bool {
+	return verifier.mode == Enforce
+}
+
+// Verify checks the signature, the expiry and the nonce of a response of an
+// endpoint of the API, e.g. "tasks/get". The nonce of a valid response is
+// recorded, so the same response is refused afterwards.
+func (verifier *Verifier) Verify(endpoint string, header http.Header, body []byte) error {
+	reject := func(reason string) error {
+		return &Error{Endpoint: endpoint, Reason: reason, Body: body}
+	}
+	id, encoded := header.Get("X-Releem-Key-Id"), header.Get("X-Releem-Signature")
+	if encoded == "" {
+		return reject("the response is not signed")
+	}
+	key, ok := verifier.keys[id]
+	if !ok {
+		return reject(fmt.Sprintf("key %q is not in signature_keys", id))
+	}
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return reject("malformed signature: " + err.Error())
+	}
+	expires, err := strconv.ParseInt(header.Get("X-Releem-Expires"), 10, 64)
+	if err != nil {
+		return reject("missing or malformed expiry")
+	}
+	nonce := header.Get("X-Releem-Nonce")
+	if nonce == "" {
+		return reject("missing nonce")
+	}
+	if !ed25519.Verify(key, Message(endpoint, expires, nonce, body), signature) {
+		return reject("the signature does not match")
+	}
+	now := verifier.now()
+	expiry := time.Unix(expires, 0)
+	if now.After(expiry.Add(clockSkew)) {
+		return reject("the response expired at " + expiry.UTC().Format(time.RFC3339))
+	}
+	if verifier.maxValidity > 0 && expiry.Sub(now) > verifier.maxValidity+clockSkew {
+		return reject("the response is valid for longer than signature_max_validity_seconds")
+	}
+	if err := verifier.nonces.Use(nonce, expiry.Add(clockSkew), now); err != nil {
+		return reject(err.Error())
+	}
+	return nil
+}
+
+// nonceStore remembers the nonces accepted until they expire. It is kept on
+// disk, so responses are not replayed after a restart either.
+type nonceStore struct {
+	mutex  sync.Mutex
+	path   string
+	nonces map[string]int64
+}
+
+func newNonceStore(path string) *nonceStore {
+	store := &nonceStore{path: path, nonces: make(map[string]int64)}
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &store.nonces)
+	}
+	return store
+}
+
+// Use records a nonce valid until expiry, failing when it was already used.
+func (store *nonceStore) Use(nonce string, expiry time.Time, now time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for used, until := range store.nonces {
+		if now.Unix() > until {
+			delete(store.nonces, used)
+		}
+	}
+	if _, ok := store.nonces[nonce]; ok {
+		return fmt.Errorf("nonce %q was already used, the response is replayed", nonce)
+	}
+	store.nonces[nonce] = expiry.Unix()
+	if err := store.save(); err != nil {
+		delete(store.nonces, nonce)
+		return err
+	}
+	return nil
+}
+
+func (store *nonceStore) save() error {
+	data, err := json.Marshal(store.nonces)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(store.path), 0700); err != nil {
+		return fmt.Errorf("failed to record the nonce: %w", err)
+	}
+	tmp := store.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to record the nonce: %w", err)
+	}
+	if err := os.Rename(tmp, store.path); err != nil {
+		return fmt.Errorf("failed to record the nonce: %w", err)
+	}
+	return nil
+}
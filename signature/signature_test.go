@@ -0,0 +1,100 @@
+package signature
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+)
+
+func newTestVerifier(t *testing.T, dir string) (*Verifier, ed25519.PrivateKey) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewVerifier(&config.Config{
+		ReleemDir:             dir,
+		SignatureVerification: Enforce,
+		SignatureKeys:         map[string]string{"releem-1": base64.StdEncoding.EncodeToString(public)},
+		SignatureMaxValidity:  3600,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return verifier, private
+}
+
+func sign(key ed25519.PrivateKey, endpoint string, expires time.Time, nonce string, body []byte) http.Header {
+	header := http.Header{}
+	header.Set("X-Releem-Key-Id", "releem-1")
+	header.Set("X-Releem-Expires", strconv.FormatInt(expires.Unix(), 10))
+	header.Set("X-Releem-Nonce", nonce)
+	header.Set("X-Releem-Signature", base64.StdEncoding.EncodeToString(ed25519.Sign(key, Message(endpoint, expires.Unix(), nonce, body))))
+	return header
+}
+
+func TestVerifyAcceptsSignedResponsesOnce(t *testing.T) {
+	dir := t.TempDir()
+	verifier, key := newTestVerifier(t, dir)
+	body := []byte(`{"task_id":1,"task_type_id":4}`)
+	header := sign(key, "tasks/get", time.Now().Add(5*time.Minute), "n1", body)
+	if err := verifier.Verify("tasks/get", header, body); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Verify("tasks/get", header, body); err == nil {
+		t.Fatal("a replayed response should be rejected")
+	}
+
+	restarted, _ := newTestVerifier(t, dir)
+	restarted.keys = verifier.keys
+	if err := restarted.Verify("tasks/get", header, body); err == nil {
+		t.Fatal("a replayed response should be rejected after a restart")
+	}
+}
+
+func TestVerifyRejectsInvalidResponses(t *testing.T) {
+	verifier, key := newTestVerifier(t, t.TempDir())
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	body := []byte(`{"innodb_buffer_pool_size":"1073741824"}`)
+	now := time.Now()
+	unknownKey := sign(key, "db/config/get", now.Add(time.Minute), "n6", body)
+	unknownKey.Set("X-Releem-Key-Id", "other")
+
+	for name, test := range map[string]struct {
+		endpoint string
+		header   http.Header
+		body     []byte
+	}{
+		"unsigned":       {"db/config/get", http.Header{}, body},
+		"unknown key":    {"db/config/get", unknownKey, body},
+		"other key":      {"db/config/get", sign(otherKey, "db/config/get", now.Add(time.Minute), "n2", body), body},
+		"tampered body":  {"db/config/get", sign(key, "db/config/get", now.Add(time.Minute), "n3", body), []byte(`{"max_connections":"1"}`)},
+		"other endpoint": {"tasks/get", sign(key, "db/config/get", now.Add(time.Minute), "n4", body), body},
+		"expired":        {"db/config/get", sign(key, "db/config/get", now.Add(-time.Hour), "n5", body), body},
+		"too long":       {"db/config/get", sign(key, "db/config/get", now.Add(48*time.Hour), "n7", body), body},
+	} {
+		err := verifier.Verify(test.endpoint, test.header, test.body)
+		var rejected *Error
+		if !errors.As(err, &rejected) || string(rejected.Body) != string(test.body) {
+			t.Errorf("%s: Verify() = %v, want a rejection", name, err)
+		}
+	}
+}
+
+func TestNewVerifierRejectsInvalidKeys(t *testing.T) {
+	for _, keys := range []map[string]string{nil, {"releem-1": "c2hvcnQ="}, {"releem-1": "not base64"}} {
+		if _, err := NewVerifier(&config.Config{SignatureVerification: Enforce, SignatureKeys: keys}); err == nil {
+			t.Errorf("keys %v should be rejected", keys)
+		}
+	}
+	verifier, err := NewVerifier(&config.Config{})
+	if err != nil || verifier.Enabled() {
+		t.Fatalf("verification should be off by default: %v", err)
+	}
+}
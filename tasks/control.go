@@ -0,0 +1,98 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/models"
+	logging "github.com/google/logger"
+)
+
+var (
+	errTaskTimeout   = errors.New("the task timed out")
+	errTaskCancelled = errors.New("the task was cancelled from the Releem platform")
+)
+
+// defaultTaskTimeouts are the deadlines of the task types, overridden by
+// task_timeout_seconds. Other types get defaultTaskTimeout.
+var defaultTaskTimeouts = map[int]time.Duration{
+	0: time.Hour,
+	1: 15 * time.Minute,
+	2: 30 * time.Minute,
+	3: 30 * time.Minute,
+	4: time.Hour,
+	5: time.Hour,
+	7: 15 * time.Minute,
+	8: time.Hour,
+}
+
+const defaultTaskTimeout = 30 * time.Minute
+
+// rollbackTimeout is the deadline of the rollback run after a failed,
+// timed out or cancelled apply.
+const rollbackTimeout = 30 * time.Minute
+
+// taskTimeout returns the deadline of a task type, 0 for none.
+func taskTimeout(configuration *config.Config, typeID int) time.Duration {
+	if timeout, ok := configuration.TaskTimeouts[strconv.Itoa(typeID)]; ok {
+		return timeout * time.Second
+	}
+	if timeout, ok := defaultTaskTimeouts[typeID]; ok {
+		return timeout
+	}
+	return defaultTaskTimeout
+}
+
+// taskControl is the response to the status of a running task, sent by
+// monitorTask. The platform sets task_cancel to stop the task.
+type taskControl struct {
+	Cancel bool `json:"task_cancel"`
+}
+
+// stoppedState returns the exit code, the status and the error of a task
+// stopped by its deadline or by a cancellation, ok being false otherwise.
+func stoppedState(ctx context.Context, timeout time.Duration) (exitCode int, status int, message string, ok bool) {
+	switch cause := context.Cause(ctx); {
+	case errors.Is(cause, errTaskTimeout):
+		return exitTimeout, statusTimedOut, "The task did not complete within " + timeout.String() + " and was stopped.\n", true
+	case errors.Is(cause, errTaskCancelled):
+		return exitCancelled, statusCancelled, "The task was cancelled from the Releem platform and was stopped.\n", true
+	}
+	return 0, 0, "", false
+}
+
+// needsRollback reports whether an apply left the configuration to be rolled
+// back: it failed to apply (exit code 7), or it was stopped half-way.
+func needsRollback(ctx context.Context, task *models.Task) bool {
+	if task.ExitCode == 7 {
+		return true
+	}
+	_, _, _, stopped := stoppedState(ctx, 0)
+	return stopped && task.Status != 1
+}
+
+// rollbackTask restores the previous configuration. It runs with its own
+// deadline, as the task context may be done already.
+func rollbackTask(ctx context.Context, command taskCommand, task *models.Task, progress *taskProgress, logger logging.Logger, configuration *config.Config) {
+	rollbackCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+	progress.Step("rollback", 80)
+	rollback_exit_code, _, task_output := execTaskCommand(rollbackCtx, command, progress, logger, configuration)
+	task.Output = task.Output + task_output
+	logger.Info(" * Task rollbacked with code ", rollback_exit_code)
+}
+
+// sleepContext waits for d, returning early with the error of ctx when it is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
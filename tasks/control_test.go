@@ -0,0 +1,37 @@
+package tasks
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/models"
+)
+
+func TestStoppedState(t *testing.T) {
+	ctx, cancel := context.WithTimeoutCause(context.Background(), time.Millisecond, errTaskTimeout)
+	defer cancel()
+	<-ctx.Done()
+	if exitCode, status, _, stopped := stoppedState(ctx, time.Millisecond); !stopped || exitCode != exitTimeout || status != statusTimedOut {
+		t.Fatalf("stoppedState = %d, %d, %v after the deadline", exitCode, status, stopped)
+	}
+	if !needsRollback(ctx, &models.Task{ExitCode: -1, Status: 4}) || needsRollback(ctx, &models.Task{Status: 1}) {
+		t.Fatal("only stopped applies that did not succeed should be rolled back")
+	}
+
+	shutdown, stop := context.WithCancel(context.Background())
+	stop()
+	if _, _, _, stopped := stoppedState(shutdown, time.Minute); stopped {
+		t.Fatal("the agent shutdown is neither a timeout nor a cancellation")
+	}
+}
+
+func TestTaskTimeout(t *testing.T) {
+	configuration := &config.Config{TaskTimeouts: map[string]time.Duration{"5": 7200, "1": 0}}
+	for typeID, want := range map[int]time.Duration{5: 2 * time.Hour, 1: 0, 4: time.Hour, 42: defaultTaskTimeout} {
+		if got := taskTimeout(configuration, typeID); got != want {
+			t.Errorf("taskTimeout(%d) = %v, want %v", typeID, got, want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package tasks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/models"
+)
+
+// Phases of a task in the journal.
+const (
+	// PhaseRunning is recorded before the task starts.
+	PhaseRunning = "running"
+	// PhaseCompleted is recorded with the final state of the task, before it
+	// is reported.
+	PhaseCompleted = "completed"
+	// PhaseReported is recorded once the platform received the final state.
+	PhaseReported = "reported"
+)
+
+// journalRetention is how long the tasks are remembered after they are
+// reported, so that a redelivered task is not run twice.
+const journalRetention = 30 * 24 * time.Hour
+
+// ErrTaskJournaled is returned by Begin for a task already in the journal.
+var ErrTaskJournaled = errors.New("the task is already in the journal")
+
+// JournalEntry is the journal record of a task.
+type JournalEntry struct {
+	Task        models.Task         `json:"task"`
+	Phase       string              `json:"phase"`
+	Transitions []JournalTransition `json:"transitions"`
+}
+
+// JournalTransition records when a task entered a phase.
+type JournalTransition struct {
+	Phase string    `json:"phase"`
+	Time  time.Time `json:"time"`
+}
+
+// Journal keeps the tasks of an instance on disk, one file per task id, so
+// that their final state is reported even if the agent stops while they run.
+type Journal struct {
+	dir string
+	now func() time.Time
+}
+
+// NewJournal returns the journal of an instance under ReleemDir.
+func NewJournal(configuration *config.Config) *Journal {
+	return &Journal{dir: JournalDir(configuration), now: time.Now}
+}
+
+// JournalDir returns the task journal directory of an instance under ReleemDir.
+func JournalDir(configuration *config.Config) string {
+	return config.InstanceDir(configuration, "tasks")
+}
+
+func (journal *Journal) path(id int) string {
+	return filepath.Join(journal.dir, strconv.Itoa(id)+".json")
+}
+
+// Begin records a task as running. A task already in the journal is not
+// recorded again: its entry is returned with ErrTaskJournaled.
+func (journal *Journal) Begin(task models.Task) (*JournalEntry, error) {
+	if err := os.MkdirAll(journal.dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create the task journal: %w", err)
+	}
+	file, err := os.OpenFile(journal.path(task.ID), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		entry, err := journal.Get(task.ID)
+		if err != nil {
+			return nil, err
+		}
+		return entry, ErrTaskJournaled
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to record task %d: %w", task.ID, err)
+	}
+	file.Close()
+	entry := &JournalEntry{}
+	return entry, journal.Record(entry, PhaseRunning, task)
+}
+
+// Record moves a task to a phase with its current state.
+func (journal *Journal) Record(entry *JournalEntry, phase string, task models.Task) error {
+	entry.Task = task
+	entry.Phase = phase
+	entry.Transitions = append(entry.Transitions, JournalTransition{Phase: phase, Time: journal.now().UTC()})
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp := journal.path(task.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to record task %d: %w", task.ID, err)
+	}
+	if err := os.Rename(tmp, journal.path(task.ID)); err != nil {
+		return fmt.Errorf("failed to record task %d: %w", task.ID, err)
+	}
+	return nil
+}
+
+// Get returns the entry of a task.
+func (journal *Journal) Get(id int) (*JournalEntry, error) {
+	data, err := os.ReadFile(journal.path(id))
+	if err != nil {
+		return nil, err
+	}
+	var entry JournalEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("corrupt journal entry of task %d: %w", id, err)
+	}
+	return &entry, nil
+}
+
+// Unreported returns the tasks whose final state was not reported, and drops
+// the tasks reported longer than journalRetention ago.
+func (journal *Journal) Unreported() ([]*JournalEntry, error) {
+	files, err := os.ReadDir(journal.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*JournalEntry
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file.Name(), ".json")
+		id, err := strconv.Atoi(name)
+		if !ok || err != nil {
+			continue
+		}
+		entry, err := journal.Get(id)
+		if err != nil || len(entry.Transitions) == 0 {
+			continue
+		}
+		if entry.Phase != PhaseReported {
+			entries = append(entries, entry)
+		} else if journal.now().Sub(entry.Transitions[len(entry.Transitions)-1].Time) > journalRetention {
+			os.Remove(journal.path(id))
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,123 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/models"
+	logging "github.com/google/logger"
+)
+
+func TestJournalRefusesTheSameTaskTwice(t *testing.T) {
+	journal := NewJournal(&config.Config{ReleemDir: t.TempDir()})
+	task := models.Task{ID: 3, TypeID: 1}
+	entry, err := journal.Begin(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.Status, task.Output = 1, "done"
+	if err := journal.Record(entry, PhaseCompleted, task); err != nil {
+		t.Fatal(err)
+	}
+
+	existing, err := journal.Begin(models.Task{ID: 3, TypeID: 1})
+	if !errors.Is(err, ErrTaskJournaled) || existing.Phase != PhaseCompleted || existing.Task.Output != "done" {
+		t.Fatalf("Begin = %+v, %v, want the completed entry", existing, err)
+	}
+	if len(existing.Transitions) != 2 || existing.Transitions[0].Phase != PhaseRunning {
+		t.Fatalf("unexpected transitions %+v", existing.Transitions)
+	}
+}
+
+func TestReconcileTasksReportsInterruptedTasks(t *testing.T) {
+	configuration := &config.Config{ReleemDir: t.TempDir(), InstanceName: "db1"}
+	journal := NewJournal(configuration)
+	if _, err := journal.Begin(models.Task{ID: 5, TypeID: 4, Status: 3}); err != nil {
+		t.Fatal(err)
+	}
+	completed, err := journal.Begin(models.Task{ID: 6, TypeID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Record(completed, PhaseCompleted, models.Task{ID: 6, TypeID: 1, Status: 1}); err != nil {
+		t.Fatal(err)
+	}
+	reported, err := journal.Begin(models.Task{ID: 7, TypeID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Record(reported, PhaseReported, models.Task{ID: 7, TypeID: 1, Status: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	repeater := &taskRepeater{}
+	ReconcileTasks(context.Background(), repeater, nil, *logging.Init("releem-agent-test", false, false, io.Discard), configuration)
+
+	if len(repeater.statuses) != 2 {
+		t.Fatalf("reported %d statuses, want the unreported tasks only: %+v", len(repeater.statuses), repeater.statuses)
+	}
+	for _, status := range repeater.statuses {
+		switch status.ID {
+		case 5:
+			if status.Status != 4 || status.ExitCode != exitInterrupted || status.Error == "" {
+				t.Errorf("unexpected status of the interrupted task %+v", status)
+			}
+		case 6:
+			if status.Status != 1 {
+				t.Errorf("unexpected status of the completed task %+v", status)
+			}
+		default:
+			t.Errorf("unexpected status %+v", status)
+		}
+	}
+	if entries, err := journal.Unreported(); err != nil || len(entries) != 0 {
+		t.Fatalf("Unreported = %+v, %v after reconciliation", entries, err)
+	}
+}
+
+func TestJournalDropsOldReportedTasks(t *testing.T) {
+	journal := NewJournal(&config.Config{ReleemDir: t.TempDir()})
+	journal.now = func() time.Time { return time.Now().Add(-2 * journalRetention) }
+	entry, err := journal.Begin(models.Task{ID: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Record(entry, PhaseReported, models.Task{ID: 8}); err != nil {
+		t.Fatal(err)
+	}
+	journal.now = time.Now
+	if _, err := journal.Unreported(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := journal.Get(8); err == nil {
+		t.Fatal("a task reported long ago should be dropped")
+	}
+}
+
+func TestProcessTaskDoesNotRunRedeliveredTasks(t *testing.T) {
+	configuration := &config.Config{ReleemDir: t.TempDir()}
+	journal := NewJournal(configuration)
+	entry, err := journal.Begin(models.Task{ID: 9, TypeID: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Record(entry, PhaseCompleted, models.Task{ID: 9, TypeID: 4, Status: 1, Output: "applied"}); err != nil {
+		t.Fatal(err)
+	}
+
+	repeater := &taskRepeater{get: func() (string, error) {
+		return `{"task_id":9,"task_type_id":4}`, nil
+	}}
+	ProcessTask(context.Background(), nil, repeater, nil, *logging.Init("releem-agent-test", false, false, io.Discard), configuration)
+
+	if len(repeater.statuses) != 1 || repeater.statuses[0].Status != 1 || repeater.statuses[0].Output != "applied" {
+		t.Fatalf("want the recorded final state reported again, got %+v", repeater.statuses)
+	}
+	if entry, err := journal.Get(9); err != nil || entry.Phase != PhaseReported {
+		t.Fatalf("Get = %+v, %v, want the task reported", entry, err)
+	}
+}
@@ -0,0 +1,155 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+)
+
+// TaskLockEnv is set in the environment of the task commands. The agent runs
+// they start, e.g. releem-agent -f, do not take the lock held by their task.
+const TaskLockEnv = "RELEEM_TASK_LOCK_HELD"
+
+// errLockBusy is returned by tryLockFile for a lock held by another process.
+var errLockBusy = errors.New("the lock is held")
+
+// TaskLockHolder describes the process holding the task lock.
+type TaskLockHolder struct {
+	PID   int       `json:"pid"`
+	Owner string    `json:"owner"`
+	Since time.Time `json:"since"`
+}
+
+// LockHeldError is returned by AcquireTaskLock when another process holds
+// the task lock.
+type LockHeldError struct {
+	Path   string
+	Holder TaskLockHolder
+}
+
+func (err *LockHeldError) Error() string {
+	if err.Holder.PID == 0 {
+		return "another Releem Agent process is running a task (lock " + err.Path + ")"
+	}
+	return fmt.Sprintf("another Releem Agent process is running a task: %s, pid %d, since %s (lock %s)",
+		err.Holder.Owner, err.Holder.PID, err.Holder.Since.Format(time.RFC3339), err.Path)
+}
+
+// TaskLock is the exclusive right to run tasks on an instance, held across
+// the agent processes.
+type TaskLock struct {
+	file  *os.File
+	since time.Time
+	queue chan struct{}
+}
+
+// TaskLockFile returns the task lock file of an instance under ReleemDir.
+func TaskLockFile(configuration *config.Config) string {
+	return filepath.Join(JournalDir(configuration), "task.lock")
+}
+
+// queues serializes the tasks of each instance within the agent process.
+var queues sync.Map
+
+// AcquireTaskLock takes the task lock of an instance for owner, e.g.
+// "task 42". The tasks of the agent process are queued until the lock is
+// released or ctx is done; a lock held by another process is not waited for
+// but returned as a *LockHeldError.
+func AcquireTaskLock(ctx context.Context, configuration *config.Config, owner string) (*TaskLock, error) {
+	path := TaskLockFile(configuration)
+	value, _ := queues.LoadOrStore(path, make(chan struct{}, 1))
+	queue := value.(chan struct{})
+	select {
+	case queue <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	lock, err := lockFile(path)
+	if err != nil {
+		<-queue
+		return nil, err
+	}
+	lock.queue = queue
+	if err := lock.SetOwner(owner); err != nil {
+		lock.Release()
+		return nil, err
+	}
+	return lock, nil
+}
+
+// taskLockWait bounds the wait of a task for the lock held by another agent
+// process, taskLockPoll being the interval of its attempts.
+var taskLockWait, taskLockPoll = time.Minute, time.Second
+
+// waitTaskLock takes the task lock like AcquireTaskLock, waiting up to
+// taskLockWait for a lock held by another process before returning the
+// *LockHeldError.
+func waitTaskLock(ctx context.Context, configuration *config.Config, owner string) (*TaskLock, error) {
+	deadline := time.Now().Add(taskLockWait)
+	for {
+		lock, err := AcquireTaskLock(ctx, configuration, owner)
+		var held *LockHeldError
+		if !errors.As(err, &held) || time.Now().Add(taskLockPoll).After(deadline) {
+			return lock, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(taskLockPoll):
+		}
+	}
+}
+
+func lockFile(path string) (*TaskLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create the task lock: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the task lock: %w", err)
+	}
+	if err := tryLockFile(file); err != nil {
+		defer file.Close()
+		if errors.Is(err, errLockBusy) {
+			held := &LockHeldError{Path: path}
+			if data, err := io.ReadAll(file); err == nil {
+				json.Unmarshal(data, &held.Holder)
+			}
+			return nil, held
+		}
+		return nil, fmt.Errorf("failed to take the task lock: %w", err)
+	}
+	return &TaskLock{file: file, since: time.Now()}, nil
+}
+
+// SetOwner records what the lock is held for, reported to the processes
+// finding it held.
+func (lock *TaskLock) SetOwner(owner string) error {
+	data, err := json.Marshal(TaskLockHolder{PID: os.Getpid(), Owner: owner, Since: lock.since.UTC()})
+	if err != nil {
+		return err
+	}
+	if err := lock.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err = lock.file.WriteAt(data, 0)
+	return err
+}
+
+// Release releases the lock to the next task.
+func (lock *TaskLock) Release() {
+	lock.file.Truncate(0)
+	unlockFile(lock.file)
+	lock.file.Close()
+	if lock.queue != nil {
+		<-lock.queue
+	}
+}
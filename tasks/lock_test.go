@@ -0,0 +1,101 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	logging "github.com/google/logger"
+)
+
+func TestTaskLockReportsTheHolder(t *testing.T) {
+	configuration := &config.Config{ReleemDir: t.TempDir()}
+	lock, err := AcquireTaskLock(context.Background(), configuration, "task 1 of type 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second open of the file stands for another agent process.
+	_, err = lockFile(TaskLockFile(configuration))
+	var held *LockHeldError
+	if !errors.As(err, &held) || held.Holder.PID != os.Getpid() || held.Holder.Owner != "task 1 of type 4" {
+		t.Fatalf("lockFile = %v, want the lock held by the task", err)
+	}
+
+	lock.Release()
+	other, err := lockFile(TaskLockFile(configuration))
+	if err != nil {
+		t.Fatalf("lockFile = %v after the release", err)
+	}
+	other.Release()
+}
+
+func TestTaskLockQueuesTheTasksOfTheProcess(t *testing.T) {
+	configuration := &config.Config{ReleemDir: t.TempDir()}
+	lock, err := AcquireTaskLock(context.Background(), configuration, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := AcquireTaskLock(ctx, configuration, "second"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("AcquireTaskLock = %v, want to wait for the first task", err)
+	}
+
+	acquired := make(chan *TaskLock)
+	go func() {
+		next, err := AcquireTaskLock(context.Background(), configuration, "second")
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- next
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("the second task ran while the first held the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	lock.Release()
+	if next := <-acquired; next != nil {
+		next.Release()
+	}
+}
+
+func TestProcessTaskLeavesTheTaskQueuedWhileLocked(t *testing.T) {
+	wait, poll := taskLockWait, taskLockPoll
+	taskLockWait, taskLockPoll = 50*time.Millisecond, 10*time.Millisecond
+	t.Cleanup(func() { taskLockWait, taskLockPoll = wait, poll })
+
+	configuration := &config.Config{ReleemDir: t.TempDir()}
+	other, err := lockFile(TaskLockFile(configuration))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.SetOwner("TaskByName apply"); err != nil {
+		t.Fatal(err)
+	}
+
+	fetched := 0
+	repeater := &taskRepeater{get: func() (string, error) {
+		fetched++
+		return "null", nil
+	}}
+	logger := *logging.Init("releem-agent-test", false, false, io.Discard)
+	ProcessTask(context.Background(), nil, repeater, nil, logger, configuration)
+	if fetched != 0 || len(repeater.statuses) != 0 {
+		t.Fatalf("fetched %d tasks and reported %+v while the lock was held", fetched, repeater.statuses)
+	}
+
+	// A lock released during the wait is taken, and the task fetched.
+	taskLockWait = 10 * time.Second
+	time.AfterFunc(30*time.Millisecond, other.Release)
+	ProcessTask(context.Background(), nil, repeater, nil, logger, configuration)
+	if fetched != 1 {
+		t.Fatalf("fetched %d tasks once the lock was released, want 1", fetched)
+	}
+}
@@ -0,0 +1,335 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/history"
+	"github.com/Releem/mysqlconfigurer/maintenance"
+	"github.com/Releem/mysqlconfigurer/models"
+	"github.com/Releem/mysqlconfigurer/safety"
+	"github.com/Releem/mysqlconfigurer/utils"
+	"github.com/aws/aws-sdk-go-v2/service/rds/types"
+	logging "github.com/google/logger"
+)
+
+// ScheduledTask is an apply task whose restart waits for a maintenance
+// window. Content is the configuration applied before the window, applied
+// again with the restart.
+type ScheduledTask struct {
+	Task    models.Task `json:"task"`
+	RunAt   time.Time   `json:"run_at"`
+	File    string      `json:"file,omitempty"`
+	Content string      `json:"content"`
+}
+
+// ScheduledTasksDir returns the directory of the scheduled tasks of an
+// instance under ReleemDir.
+func ScheduledTasksDir(configuration *config.Config) string {
+	return config.InstanceDir(configuration, "scheduled_tasks")
+}
+
+func scheduledTaskPath(configuration *config.Config, id int) string {
+	return filepath.Join(ScheduledTasksDir(configuration), strconv.Itoa(id)+".json")
+}
+
+// restartSchedule returns the maintenance windows of the instance, nil when
+// restarts are not deferred.
+func restartSchedule(configuration *config.Config) *maintenance.Schedule {
+	schedule, err := maintenance.Parse(configuration.MaintenanceWindows, configuration.MaintenanceTimezone)
+	if err != nil || schedule.Empty() {
+		return nil
+	}
+	return schedule
+}
+
+// saveScheduledTask writes a scheduled task.
+func saveScheduledTask(configuration *config.Config, scheduled ScheduledTask) error {
+	if err := os.MkdirAll(ScheduledTasksDir(configuration), 0700); err != nil {
+		return fmt.Errorf("failed to schedule task %d: %w", scheduled.Task.ID, err)
+	}
+	data, err := json.Marshal(scheduled)
+	if err != nil {
+		return err
+	}
+	path := scheduledTaskPath(configuration, scheduled.Task.ID)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("failed to schedule task %d: %w", scheduled.Task.ID, err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to schedule task %d: %w", scheduled.Task.ID, err)
+	}
+	return nil
+}
+
+// ScheduledTasks returns the scheduled tasks of an instance by time.
+func ScheduledTasks(configuration *config.Config) ([]ScheduledTask, error) {
+	files, err := os.ReadDir(ScheduledTasksDir(configuration))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var scheduled []ScheduledTask
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(ScheduledTasksDir(configuration), file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var task ScheduledTask
+		if err := json.Unmarshal(data, &task); err != nil {
+			return nil, fmt.Errorf("corrupt scheduled task %s: %w", file.Name(), err)
+		}
+		scheduled = append(scheduled, task)
+	}
+	sort.Slice(scheduled, func(i, j int) bool { return scheduled[i].RunAt.Before(scheduled[j].RunAt) })
+	return scheduled, nil
+}
+
+// ApplyBeforeWindow runs an apply with a restart outside the maintenance
+// windows: the changes that do not need a restart are applied at once, like
+// an apply without restart, and the restart is scheduled for runAt. The task
+// ends with statusScheduled and scheduled_at in its details, or as the apply
+// without restart when nothing needs a restart.
+func ApplyBeforeWindow(ctx context.Context, progress *taskProgress, task *models.Task, instance *models.Instance, metrics *models.Metrics, repeaters models.MetricsRepeater,
+	gatherers []models.MetricsGatherer, logger logging.Logger, configuration *config.Config, runAt time.Time) (int, int, string) {
+	var exitCode, status int
+	var output, task_output string
+	scheduled := ScheduledTask{Task: *task, RunAt: runAt}
+
+	if history.IsCloudInstance(configuration) {
+		recommended, err := getRecommendedConfiguration(metrics, repeaters, configuration, logger)
+		if err != nil {
+			logger.Error(err)
+			return exitSignatureRejected, 4, err.Error()
+		}
+		scheduled.Content = recommended
+		versioned := versionRepeater{MetricsRepeater: repeaters, content: recommended}
+		switch configuration.InstanceType {
+		case "aws/rds":
+			exitCode, status, output = ApplyConfAwsRds(ctx, progress, versioned, gatherers, logger, configuration, types.ApplyMethodImmediate)
+			if exitCode == 0 {
+				exitCode, status, task_output = ApplyConfAwsRds(ctx, progress, versioned, gatherers, logger, configuration, types.ApplyMethodPendingReboot)
+				output = output + task_output
+			}
+		case "gcp/cloudsql":
+			exitCode, status, output = ApplyConfGcpCloudSQL(ctx, progress, versioned, gatherers, logger, configuration, false)
+		case "azure/mysql":
+			exitCode, status, output = ApplyConfAzureMySQL(ctx, progress, versioned, gatherers, logger, configuration, false)
+		}
+	} else {
+		progress.Step("apply_configuration", 10)
+		exitCode, status, output = execTaskCommand(ctx, taskApplyAutomaticCommand(runtime.GOOS, configuration.ReleemDir, false), progress, logger, configuration)
+		applied := models.Task{ExitCode: exitCode, Status: status}
+		if needsRollback(ctx, &applied) {
+			rollbackTask(ctx, taskRollbackCommand(runtime.GOOS, configuration.ReleemDir), &applied, progress, logger, configuration)
+			return exitCode, status, output + applied.Output
+		}
+		if exitCode == 0 {
+			exitCode, status, task_output = ApplyConfLocal(ctx, progress, instance, metrics, repeaters, gatherers, logger, configuration)
+			output = output + task_output
+		}
+		scheduled.File = history.ConfigFileName(configuration)
+		content, err := os.ReadFile(filepath.Join(configuration.ReleemConfDir, scheduled.File))
+		if exitCode == 10 && err != nil {
+			logger.Error(err)
+			return 8, 4, output + "Failed to read the applied configuration: " + err.Error() + "\n"
+		}
+		scheduled.Content = string(content)
+	}
+	// Exit code 10 reports the changes waiting for a restart.
+	if exitCode != 10 {
+		return exitCode, status, output
+	}
+
+	scheduledAt, _ := json.Marshal(map[string]string{"scheduled_at": runAt.UTC().Format(time.RFC3339)})
+	if details, err := utils.MergeJSONStrings(task.Details, string(scheduledAt), ""); err != nil {
+		logger.Error("Failed to merge task_details JSON: ", err)
+	} else {
+		task.Details = details
+	}
+	output = output + fmt.Sprintf("Changes requiring a restart are scheduled for the maintenance window at %s.\n", runAt.Format(time.RFC3339))
+	// The final state reported after the restart includes the output so far.
+	scheduled.Task.Details, scheduled.Task.Output = task.Details, task.Output+output
+	if err := saveScheduledTask(configuration, scheduled); err != nil {
+		logger.Error(err)
+		return 8, 4, output + err.Error() + "\n"
+	}
+	logger.Infof(" * Restart of task %d scheduled for the maintenance window at %s", task.ID, runAt)
+	progress.Step("wait_for_maintenance_window", 50)
+	progress.Logf("Restart scheduled for the maintenance window at %s", runAt.Format(time.RFC3339))
+	return 0, statusScheduled, output
+}
+
+// RunScheduledTasks restarts the database for the scheduled tasks once their
+// maintenance window is open, or at once when no window is configured anymore,
+// and reports their final state. The restarts are not interrupted by ctx.
+func RunScheduledTasks(ctx context.Context, repeaters models.MetricsRepeater, gatherers []models.MetricsGatherer, logger logging.Logger, configuration *config.Config) {
+	defer utils.HandlePanic(configuration, logger)
+	if !scheduledTasksDue(configuration, time.Now(), logger) || ctx.Err() != nil {
+		return
+	}
+	lock, err := AcquireTaskLock(ctx, configuration, "scheduled restart")
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	defer lock.Release()
+
+	// The tasks may have run while waiting for the lock.
+	scheduled, err := ScheduledTasks(configuration)
+	if err != nil {
+		logger.Error("Failed to read the scheduled tasks: ", err)
+		return
+	}
+	now := time.Now()
+	for _, entry := range scheduled {
+		if entry.RunAt.After(now) || ctx.Err() != nil {
+			continue
+		}
+		if err := lock.SetOwner(fmt.Sprintf("scheduled restart of task %d", entry.Task.ID)); err != nil {
+			logger.Error(err)
+		}
+		runScheduledTask(ctx, entry, repeaters, gatherers, logger, configuration)
+	}
+}
+
+// scheduledTasksDue reports whether a scheduled task may run at now.
+func scheduledTasksDue(configuration *config.Config, now time.Time, logger logging.Logger) bool {
+	scheduled, err := ScheduledTasks(configuration)
+	if err != nil {
+		logger.Error("Failed to read the scheduled tasks: ", err)
+		return false
+	}
+	if len(scheduled) == 0 || scheduled[0].RunAt.After(now) {
+		return false
+	}
+	schedule := restartSchedule(configuration)
+	return schedule == nil || schedule.Contains(now)
+}
+
+// runScheduledTask restarts the database for a scheduled task and reports the
+// task with its final state.
+func runScheduledTask(ctx context.Context, scheduled ScheduledTask, repeaters models.MetricsRepeater, gatherers []models.MetricsGatherer, logger logging.Logger, configuration *config.Config) {
+	task := scheduled.Task
+	metrics := utils.CollectMetricsContext(context.WithoutCancel(ctx), gatherers, logger, configuration)
+	if metrics == nil {
+		metrics = &models.Metrics{}
+	}
+	journal := NewJournal(configuration)
+	entry, err := journal.Get(task.ID)
+	if err != nil {
+		entry = &JournalEntry{}
+	}
+	if err := journal.Record(entry, PhaseRunning, task); err != nil {
+		logger.Error(err)
+	}
+	// The journal reports the task as interrupted if the agent stops now.
+	if err := os.Remove(scheduledTaskPath(configuration, task.ID)); err != nil {
+		logger.Error(err)
+	}
+
+	if err := safety.CheckTask(configuration, task.TypeID); err != nil {
+		logger.Error(err)
+		task.Status, task.ExitCode, task.Error = 4, exitReadOnly, task.Error+err.Error()
+	} else {
+		logger.Infof(" * Scheduled restart of task %d is being started...", task.ID)
+		metrics.ReleemAgent.Tasks = models.Task{ID: task.ID, TypeID: task.TypeID, Status: 3}
+		utils.ProcessRepeaters(metrics, repeaters, configuration, logger, models.ModeType{Name: "Task", Type: "Status"})
+		applying.Add(1)
+		defer applying.Add(-1)
+		task = restartScheduledTask(ctx, scheduled, task, metrics.ReleemAgent.Info, repeaters, gatherers, logger, configuration)
+	}
+
+	logger.Infof(" * Scheduled restart of task %d completed with code %d", task.ID, task.ExitCode)
+	if err := journal.Record(entry, PhaseCompleted, task); err != nil {
+		logger.Error(err)
+	}
+	metrics.ReleemAgent.Tasks = task
+	if err := reportTaskStatus(metrics, repeaters, configuration); err != nil {
+		logger.Error("Failed to report the final state of task ", task.ID, ": ", err)
+		return
+	}
+	if err := journal.Record(entry, PhaseReported, task); err != nil {
+		logger.Error(err)
+	}
+}
+
+// restartScheduledTask applies the configuration of a scheduled task with a
+// restart and returns the task with its final state.
+func restartScheduledTask(ctx context.Context, scheduled ScheduledTask, task models.Task, info models.MetricGroupValue, repeaters models.MetricsRepeater,
+	gatherers []models.MetricsGatherer, logger logging.Logger, configuration *config.Config) models.Task {
+	var task_output string
+	taskCtx := context.WithoutCancel(ctx)
+	timeout := taskTimeout(configuration, task.TypeID)
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		taskCtx, cancelTimeout = context.WithTimeoutCause(taskCtx, timeout, errTaskTimeout)
+		defer cancelTimeout()
+	}
+	taskCtx, cancelTask := context.WithCancelCause(withTaskID(taskCtx, task.ID))
+	defer cancelTask(nil)
+	progress := &taskProgress{}
+	monitoring := make(chan struct{})
+	go func() {
+		defer close(monitoring)
+		monitorTask(taskCtx, cancelTask, task, progress, info, repeaters, configuration, logger)
+	}()
+
+	versioned := versionRepeater{MetricsRepeater: repeaters, content: scheduled.Content}
+	switch configuration.InstanceType {
+	case "aws/rds":
+		task.ExitCode, task.Status, task_output = RebootAwsRds(taskCtx, progress, logger, configuration)
+	case "gcp/cloudsql":
+		task.ExitCode, task.Status, task_output = ApplyConfGcpCloudSQL(taskCtx, progress, versioned, gatherers, logger, configuration, true)
+	case "azure/mysql":
+		task.ExitCode, task.Status, task_output = ApplyConfAzureMySQL(taskCtx, progress, versioned, gatherers, logger, configuration, true)
+	default:
+		progress.Step("restore_configuration", 5)
+		if err := os.WriteFile(filepath.Join(configuration.ReleemConfDir, scheduled.File), []byte(scheduled.Content), 0644); err != nil {
+			logger.Error(err)
+			task.ExitCode, task.Status, task_output = 8, 4, "Failed to restore the scheduled configuration: "+err.Error()+"\n"
+			break
+		}
+		progress.Step("apply_configuration", 10)
+		command := taskApplyAutomaticCommand(runtime.GOOS, configuration.ReleemDir, true)
+		command.env = append(command.env, "RELEEM_SKIP_CONFIG_DOWNLOAD=1")
+		task.ExitCode, task.Status, task_output = execTaskCommand(taskCtx, command, progress, logger, configuration)
+	}
+	task.Output = task.Output + task_output
+	if !history.IsCloudInstance(configuration) && needsRollback(taskCtx, &task) {
+		rollbackTask(taskCtx, taskRollbackCommand(runtime.GOOS, configuration.ReleemDir), &task, progress, logger, configuration)
+	}
+	if task.Status == 1 && history.IsCloudInstance(configuration) {
+		version := history.Version{Source: history.SourceApply, TaskID: task.ID}
+		if _, err := history.NewStore(configuration).RecordApplied(configuration, version); err != nil {
+			logger.Error("Failed to record the applied configuration in the history: ", err)
+		}
+	}
+	if exitCode, status, message, stopped := stoppedState(taskCtx, timeout); stopped && task.Status != 1 {
+		task.ExitCode, task.Status = exitCode, status
+		task.Error = task.Error + message
+	}
+	final, _ := progress.snapshot()
+	if task.Status == 1 {
+		final.Step, final.Percent = "completed", 100
+	}
+	task.Progress = &final
+	cancelTask(nil)
+	<-monitoring
+	return task
+}
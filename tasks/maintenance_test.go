@@ -0,0 +1,76 @@
+package tasks
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/models"
+	logging "github.com/google/logger"
+)
+
+func TestScheduledTasksWaitForTheWindow(t *testing.T) {
+	configuration := &config.Config{ReleemDir: t.TempDir(), MaintenanceWindows: []string{"Sat 02:00-04:00"}, MaintenanceTimezone: "UTC"}
+	logger := *logging.Init("releem-agent-test", false, false, io.Discard)
+	// 2026-10-17 is a Saturday.
+	window := time.Date(2026, time.October, 17, 2, 0, 0, 0, time.UTC)
+	if scheduledTasksDue(configuration, window, logger) {
+		t.Fatal("scheduledTasksDue = true without scheduled tasks")
+	}
+	if err := saveScheduledTask(configuration, ScheduledTask{Task: models.Task{ID: 9, TypeID: 5}, RunAt: window}); err != nil {
+		t.Fatal(err)
+	}
+	for now, want := range map[time.Time]bool{
+		window.Add(-time.Hour):       false,
+		window.Add(30 * time.Minute): true,
+		window.Add(3 * time.Hour):    false,
+		window.AddDate(0, 0, 7):      true,
+	} {
+		if got := scheduledTasksDue(configuration, now, logger); got != want {
+			t.Errorf("scheduledTasksDue(%s) = %t, want %t", now, got, want)
+		}
+	}
+	configuration.MaintenanceWindows = nil
+	if !scheduledTasksDue(configuration, window.Add(3*time.Hour), logger) {
+		t.Error("scheduledTasksDue = false, want the restart run at once once the windows are removed")
+	}
+}
+
+func TestRunScheduledTasksReportsTheRestart(t *testing.T) {
+	configuration := &config.Config{ReleemDir: t.TempDir(), ReleemConfDir: t.TempDir()}
+	scheduled := ScheduledTask{
+		Task:    models.Task{ID: 11, TypeID: 5, Output: "applied before the window\n"},
+		RunAt:   time.Now().Add(-time.Minute),
+		File:    "z_aiops_mysql.cnf",
+		Content: "[mysqld]\ninnodb_buffer_pool_instances=4\n",
+	}
+	if err := saveScheduledTask(configuration, scheduled); err != nil {
+		t.Fatal(err)
+	}
+	repeater := &taskRepeater{}
+	RunScheduledTasks(context.Background(), repeater, nil, *logging.Init("releem-agent-test", false, false, io.Discard), configuration)
+
+	if len(repeater.statuses) != 2 || repeater.statuses[0].Status != 3 {
+		t.Fatalf("reported %+v, want the running and the final state", repeater.statuses)
+	}
+	// The apply script does not exist in the test environment.
+	final := repeater.statuses[1]
+	if final.ID != 11 || final.Status != 4 || !strings.HasPrefix(final.Output, "applied before the window\n") {
+		t.Fatalf("unexpected final state %+v", final)
+	}
+	content, err := os.ReadFile(filepath.Join(configuration.ReleemConfDir, "z_aiops_mysql.cnf"))
+	if err != nil || string(content) != scheduled.Content {
+		t.Fatalf("configuration = %q, %v, want the scheduled configuration restored", content, err)
+	}
+	if remaining, err := ScheduledTasks(configuration); err != nil || len(remaining) != 0 {
+		t.Fatalf("ScheduledTasks = %+v, %v, want the task removed", remaining, err)
+	}
+	if entry, err := NewJournal(configuration).Get(11); err != nil || entry.Phase != PhaseReported {
+		t.Fatalf("journal entry = %+v, %v, want the task reported", entry, err)
+	}
+}
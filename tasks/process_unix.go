@@ -0,0 +1,18 @@
+//go:build !windows
+
+package tasks
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup runs a task command in its own process group, killed as a
+// whole when the task is stopped, so that no child of the task scripts, e.g. a
+// hung restart, outlives it.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
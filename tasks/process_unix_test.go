@@ -0,0 +1,48 @@
+//go:build !windows
+
+package tasks
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	logging "github.com/google/logger"
+)
+
+func TestExecTaskCommandKillsTheProcessGroupOnTimeout(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	ctx, cancel := context.WithTimeoutCause(context.Background(), 500*time.Millisecond, errTaskTimeout)
+	defer cancel()
+
+	start := time.Now()
+	exitCode, status, output := execTaskCommand(ctx, shellCommand("linux", "sleep 60 & echo $! > "+pidFile+"; wait", nil), nil, *logging.Init("releem-agent-test", false, false, io.Discard), nil)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("the command ran for %v after its deadline", elapsed)
+	}
+	if exitCode == 0 || status != 4 || !strings.Contains(output, errTaskTimeout.Error()) {
+		t.Fatalf("execTaskCommand = %d, %d, %q", exitCode, status, output)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for syscall.Kill(pid, 0) == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("the child %d of the task survived it", pid)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
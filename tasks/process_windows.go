@@ -0,0 +1,19 @@
+//go:build windows
+
+package tasks
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// setProcessGroup kills the whole process tree of a task command when the
+// task is stopped, so that no child of the task scripts outlives it.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run(); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+}
@@ -0,0 +1,123 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/models"
+	"github.com/Releem/mysqlconfigurer/utils"
+	logging "github.com/google/logger"
+)
+
+// outputTailSize is how much of the end of the task output is reported with
+// the progress.
+const outputTailSize = 4096
+
+// terminalControls matches the colors and cursor moves of the task scripts.
+var terminalControls = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]|[\x08\r]`)
+
+// taskProgress is the progress of a running task, updated by the task and
+// reported by monitorTask. The methods of a nil taskProgress do nothing.
+type taskProgress struct {
+	mutex   sync.Mutex
+	step    string
+	percent int
+	tail    []byte
+	version int
+}
+
+// Step records that the task reached a step, percent being an estimate of
+// the share of the task done.
+func (progress *taskProgress) Step(step string, percent int) {
+	if progress == nil {
+		return
+	}
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	progress.step, progress.percent = step, min(max(percent, 0), 100)
+	progress.version++
+}
+
+// Write appends the output of a task command to the tail.
+func (progress *taskProgress) Write(p []byte) (int, error) {
+	if progress == nil {
+		return len(p), nil
+	}
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	progress.tail = append(progress.tail, p...)
+	// Twice the size, so that the tail is still full once cleaned.
+	if excess := len(progress.tail) - 2*outputTailSize; excess > 0 {
+		progress.tail = append(progress.tail[:0], progress.tail[excess:]...)
+	}
+	progress.version++
+	return len(p), nil
+}
+
+// Logf appends a line to the tail.
+func (progress *taskProgress) Logf(format string, args ...any) {
+	progress.Write([]byte(fmt.Sprintf(format, args...) + "\n"))
+}
+
+// snapshot returns the progress and its version, which changes on every update.
+func (progress *taskProgress) snapshot() (models.TaskProgress, int) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	tail := terminalControls.ReplaceAllString(string(progress.tail), "")
+	if len(tail) > outputTailSize {
+		tail = tail[len(tail)-outputTailSize:]
+		if newline := strings.IndexByte(tail, '\n'); newline >= 0 {
+			tail = tail[newline+1:]
+		}
+	}
+	return models.TaskProgress{Step: progress.step, Percent: progress.percent, OutputTail: tail}, progress.version
+}
+
+// monitorTask reports the progress of a running task until ctx is done. It is
+// sent when it changed, at most every interval_task_progress_seconds so that
+// chatty scripts do not flood the API, and at least every
+// interval_task_cancel_poll_seconds so that the platform can cancel the task
+// in reply: the task is then cancelled with errTaskCancelled.
+func monitorTask(ctx context.Context, cancel context.CancelCauseFunc, task models.Task, progress *taskProgress, info models.MetricGroupValue, repeaters models.MetricsRepeater, configuration *config.Config, logger logging.Logger) {
+	progressPeriod, pollPeriod := configuration.TaskProgressPeriod*time.Second, configuration.TaskCancelPollPeriod*time.Second
+	period := progressPeriod
+	if period <= 0 || (pollPeriod > 0 && pollPeriod < period) {
+		period = pollPeriod
+	}
+	if period <= 0 {
+		return
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	var metrics models.Metrics
+	metrics.ReleemAgent.Info = info
+	idle, sentVersion := 0, 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		idle++
+		current, version := progress.snapshot()
+		changed := version != sentVersion && progressPeriod > 0
+		if !changed && (pollPeriod <= 0 || time.Duration(idle)*period < pollPeriod) {
+			continue
+		}
+		idle, sentVersion = 0, version
+		metrics.ReleemAgent.Tasks = models.Task{ID: task.ID, TypeID: task.TypeID, Status: 3, Progress: &current}
+		response := utils.ProcessRepeatersContext(ctx, &metrics, repeaters, configuration, logger, models.ModeType{Name: "Task", Type: "Status"})
+		var control taskControl
+		if json.Unmarshal([]byte(response), &control) == nil && control.Cancel {
+			logger.Infof(" * Task with id - %d is cancelled from the Releem platform", task.ID)
+			cancel(errTaskCancelled)
+			return
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/models"
+	logging "github.com/google/logger"
+)
+
+func TestMonitorTaskStopsCancelledTasks(t *testing.T) {
+	polls := 0
+	repeater := &taskRepeater{status: func() string {
+		polls++
+		if polls < 2 {
+			return `{}`
+		}
+		return `{"task_cancel":true}`
+	}}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	configuration := &config.Config{TaskCancelPollPeriod: 1, TaskProgressPeriod: 1}
+
+	monitorTask(ctx, cancel, models.Task{ID: 11, TypeID: 4}, &taskProgress{}, nil, repeater, configuration, *logging.Init("releem-agent-test", false, false, io.Discard))
+
+	exitCode, status, message, stopped := stoppedState(ctx, time.Minute)
+	if !stopped || exitCode != exitCancelled || status != statusCancelled || message == "" {
+		t.Fatalf("stoppedState = %d, %d, %q, %v", exitCode, status, message, stopped)
+	}
+	for _, heartbeat := range repeater.statuses {
+		if heartbeat.ID != 11 || heartbeat.Status != 3 || heartbeat.Progress == nil {
+			t.Fatalf("unexpected heartbeat %+v", heartbeat)
+		}
+	}
+}
+
+func TestMonitorTaskThrottlesProgress(t *testing.T) {
+	var mutex sync.Mutex
+	repeater := &taskRepeater{}
+	send := &lockedRepeater{mutex: &mutex, repeater: repeater}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	progress := &taskProgress{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		monitorTask(ctx, cancel, models.Task{ID: 12, TypeID: 1}, progress, nil, send, &config.Config{TaskCancelPollPeriod: 60, TaskProgressPeriod: 1}, *logging.Init("releem-agent-test", false, false, io.Discard))
+	}()
+
+	progress.Step("generate_configuration", 10)
+	deadline := time.Now().Add(2500 * time.Millisecond)
+	for i := 0; time.Now().Before(deadline); i++ {
+		fmt.Fprintf(progress, "\x1b[32m * line %d\x1b[0m\n", i)
+		time.Sleep(time.Millisecond)
+	}
+	cancel(nil)
+	<-done
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(repeater.statuses) == 0 || len(repeater.statuses) > 3 {
+		t.Fatalf("sent %d progress updates in 2.5s, want at most one per second", len(repeater.statuses))
+	}
+	last := repeater.statuses[len(repeater.statuses)-1].Progress
+	if last.Step != "generate_configuration" || last.Percent != 10 || strings.Contains(last.OutputTail, "\x1b") || !strings.HasPrefix(last.OutputTail, " * line") || len(last.OutputTail) > outputTailSize {
+		t.Fatalf("unexpected progress %+v", last)
+	}
+}
+
+// lockedRepeater serializes the calls to a repeater read by the test.
+type lockedRepeater struct {
+	mutex    *sync.Mutex
+	repeater models.MetricsRepeater
+}
+
+func (repeater *lockedRepeater) ProcessMetrics(context models.MetricContext, metrics models.Metrics, Mode models.ModeType) (string, error) {
+	repeater.mutex.Lock()
+	defer repeater.mutex.Unlock()
+	return repeater.repeater.ProcessMetrics(context, metrics, Mode)
+}
@@ -18,7 +18,7 @@ import (
 	config_aws "github.com/aws/aws-sdk-go-v2/config"
 )
 
-func ApplyConfAwsRds(repeaters models.MetricsRepeater, gatherers []models.MetricsGatherer,
+func ApplyConfAwsRds(ctx context.Context, progress *taskProgress, repeaters models.MetricsRepeater, gatherers []models.MetricsGatherer,
 	logger logging.Logger, configuration *config.Config, apply_method types.ApplyMethod) (int, int, string) {
 
 	var task_exit_code, task_status int = 0, 1
@@ -28,8 +28,9 @@ func ApplyConfAwsRds(repeaters models.MetricsRepeater, gatherers []models.Metric
 
 	metrics := utils.CollectMetrics(gatherers, logger, configuration)
 
+	progress.Step("describe_instance", 5)
 	// Загрузите конфигурацию AWS по умолчанию
-	cfg, err := config_aws.LoadDefaultConfig(context.TODO(), config_aws.WithRegion(configuration.AwsRegion))
+	cfg, err := config_aws.LoadDefaultConfig(ctx, config_aws.WithRegion(configuration.AwsRegion))
 	if err != nil {
 		logger.Errorf("Load AWS configuration FAILED, %v", err)
 		task_output = task_output + err.Error()
@@ -44,7 +45,7 @@ func ApplyConfAwsRds(repeaters models.MetricsRepeater, gatherers []models.Metric
 	input := &rds.DescribeDBInstancesInput{
 		DBInstanceIdentifier: &configuration.AwsRDSDB,
 	}
-	result, err := rdsclient.DescribeDBInstances(context.TODO(), input)
+	result, err := rdsclient.DescribeDBInstances(ctx, input)
 	if err != nil {
 		logger.Errorf("Failed to describe DB instance: %v", err)
 		task_output = task_output + err.Error()
@@ -89,10 +90,11 @@ func ApplyConfAwsRds(repeaters models.MetricsRepeater, gatherers []models.Metric
 		// Итерируем по всем параметрам в группе и выводим ApplyType для каждого
 		paginator := rds.NewDescribeDBParametersPaginator(rdsclient, input)
 		for paginator.HasMorePages() {
-			page, err := paginator.NextPage(context.TODO())
+			page, err := paginator.NextPage(ctx)
 			if err != nil {
 				logger.Errorf("Failed to retrieve parameters: %v", err)
 				task_output = task_output + err.Error()
+				break
 			}
 			for _, param := range page.Parameters {
 				DbParametersType[*param.ParameterName] = *param.ApplyType
@@ -100,13 +102,18 @@ func ApplyConfAwsRds(repeaters models.MetricsRepeater, gatherers []models.Metric
 		}
 	}
 	result_data := models.MetricGroupValue{}
-	recommend_var := utils.ProcessRepeaters(metrics, repeaters, configuration, logger, models.ModeType{Name: "Configurations", Type: "GetJson"})
+	recommend_var, err := getRecommendedConfiguration(metrics, repeaters, configuration, logger)
+	if err != nil {
+		logger.Error(err)
+		return exitSignatureRejected, 4, task_output + err.Error()
+	}
 	err = json.Unmarshal([]byte(recommend_var), &result_data)
 	if err != nil {
 		logger.Error(err)
 		task_output = task_output + err.Error()
 	}
 
+	progress.Step("modify_parameter_group", 20)
 	var Parameters []types.Parameter
 	var value string
 	for key := range result_data {
@@ -143,7 +150,7 @@ func ApplyConfAwsRds(repeaters models.MetricsRepeater, gatherers []models.Metric
 			}
 
 			// Вызовите ModifyDBParameterGroup API для изменения параметра
-			_, err := rdsclient.ModifyDBParameterGroup(context.TODO(), input)
+			_, err := rdsclient.ModifyDBParameterGroup(ctx, input)
 			if err != nil {
 				if strings.Contains(err.Error(), "AccessDenied") {
 					task_exit_code = 9
@@ -169,7 +176,7 @@ func ApplyConfAwsRds(repeaters models.MetricsRepeater, gatherers []models.Metric
 		}
 
 		// Вызовите ModifyDBParameterGroup API для изменения параметра
-		_, err := rdsclient.ModifyDBParameterGroup(context.TODO(), input)
+		_, err := rdsclient.ModifyDBParameterGroup(ctx, input)
 		if err != nil {
 			if strings.Contains(err.Error(), "AccessDenied") {
 				task_exit_code = 9
@@ -185,7 +192,8 @@ func ApplyConfAwsRds(repeaters models.MetricsRepeater, gatherers []models.Metric
 			logger.Info("Parameter group modified successfully")
 		}
 	}
-	time.Sleep(15 * time.Second)
+	progress.Step("wait_for_apply", 30)
+	sleepContext(ctx, 15*time.Second)
 	sum := 1
 	wait_seconds := 400
 	for sum < wait_seconds {
@@ -193,10 +201,13 @@ func ApplyConfAwsRds(repeaters models.MetricsRepeater, gatherers []models.Metric
 		input = &rds.DescribeDBInstancesInput{
 			DBInstanceIdentifier: &configuration.AwsRDSDB,
 		}
-		result, err = rdsclient.DescribeDBInstances(context.TODO(), input)
+		result, err = rdsclient.DescribeDBInstances(ctx, input)
 		if err != nil {
 			logger.Errorf("Failed to describe DB instance: %v", err)
 			task_output = task_output + err.Error()
+			if ctx.Err() != nil {
+				break
+			}
 		}
 		// Проверяем статус инстанса и требуются ли изменения
 		if len(result.DBInstances) > 0 {
@@ -207,11 +218,15 @@ func ApplyConfAwsRds(repeaters models.MetricsRepeater, gatherers []models.Metric
 			task_output = task_output + "No DB instance found.\n"
 		}
 		logger.Infof("DB Instance ID: %s, DB Instance Status: %s, Parameter Group Name: %s, Parameter Group Status: %s\n", *dbInstance.DBInstanceIdentifier, *dbInstance.DBInstanceStatus, *paramGroup.DBParameterGroupName, *paramGroup.ParameterApplyStatus)
+		progress.Step("wait_for_apply", 30+60*sum/wait_seconds)
+		progress.Logf("DB Instance Status: %s, Parameter Group Status: %s", aws.ToString(dbInstance.DBInstanceStatus), aws.ToString(paramGroup.ParameterApplyStatus))
 
 		if aws.ToString(dbInstance.DBInstanceStatus) != "modifying" || aws.ToString(paramGroup.ParameterApplyStatus) != "applying" {
 			break
 		}
-		time.Sleep(3 * time.Second)
+		if sleepContext(ctx, 3*time.Second) != nil {
+			break
+		}
 		sum = sum + 1
 	}
 
@@ -227,7 +242,48 @@ func ApplyConfAwsRds(repeaters models.MetricsRepeater, gatherers []models.Metric
 		task_exit_code = 7
 		task_status = 4
 	}
-	time.Sleep(30 * time.Second)
+	progress.Step("wait_for_metrics", 95)
+	sleepContext(ctx, 30*time.Second)
 
 	return task_exit_code, task_status, task_output
 }
+
+// RebootAwsRds reboots the RDS instance so that the parameters pending reboot
+// are applied, and waits for the instance to be available again.
+func RebootAwsRds(ctx context.Context, progress *taskProgress, logger logging.Logger, configuration *config.Config) (int, int, string) {
+	progress.Step("reboot_instance", 10)
+	cfg, err := config_aws.LoadDefaultConfig(ctx, config_aws.WithRegion(configuration.AwsRegion))
+	if err != nil {
+		logger.Errorf("Load AWS configuration FAILED, %v", err)
+		return 1, 4, err.Error() + "\n"
+	}
+	rdsclient := rds.NewFromConfig(cfg)
+	_, err = rdsclient.RebootDBInstance(ctx, &rds.RebootDBInstanceInput{DBInstanceIdentifier: aws.String(configuration.AwsRDSDB)})
+	if err != nil {
+		logger.Errorf("Failed to reboot DB instance: %v", err)
+		if strings.Contains(err.Error(), "AccessDenied") {
+			return 9, 4, err.Error() + "\n"
+		}
+		return 8, 4, err.Error() + "\n"
+	}
+	logger.Info("DB instance reboot started")
+
+	progress.Step("wait_for_reboot", 30)
+	sleepContext(ctx, 30*time.Second)
+	for ctx.Err() == nil {
+		result, err := rdsclient.DescribeDBInstances(ctx, &rds.DescribeDBInstancesInput{DBInstanceIdentifier: aws.String(configuration.AwsRDSDB)})
+		if err != nil {
+			logger.Errorf("Failed to describe DB instance: %v", err)
+		} else if len(result.DBInstances) > 0 && len(result.DBInstances[0].DBParameterGroups) > 0 {
+			dbInstance := result.DBInstances[0]
+			instanceStatus, applyStatus := aws.ToString(dbInstance.DBInstanceStatus), aws.ToString(dbInstance.DBParameterGroups[0].ParameterApplyStatus)
+			progress.Logf("DB Instance Status: %s, Parameter Group Status: %s", instanceStatus, applyStatus)
+			if instanceStatus == "available" && applyStatus == "in-sync" {
+				logger.Info("DB Instance Status available, Parameter Group Status in-sync after the reboot")
+				return 0, 1, "DB instance rebooted, parameter group in-sync.\n"
+			}
+		}
+		sleepContext(ctx, 10*time.Second)
+	}
+	return 6, 4, "DB instance not available after the reboot.\n"
+}
@@ -7,7 +7,9 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/mysql/armmysqlflexibleservers"
@@ -25,7 +27,7 @@ type azureMySQLConfigurationMetadata struct {
 	pendingRestart bool
 }
 
-func ApplyConfAzureMySQL(repeaters models.MetricsRepeater, gatherers []models.MetricsGatherer,
+func ApplyConfAzureMySQL(ctx context.Context, progress *taskProgress, repeaters models.MetricsRepeater, gatherers []models.MetricsGatherer,
 	logger logging.Logger, configuration *config.Config, restart bool) (int, int, string) {
 
 	task_exit_code, task_status := 0, 1
@@ -38,7 +40,7 @@ func ApplyConfAzureMySQL(repeaters models.MetricsRepeater, gatherers []models.Me
 	}
 
 	metrics := utils.CollectMetrics(gatherers, logger, configuration)
-	ctx := context.Background()
+	progress.Step("describe_server", 5)
 
 	credential, err := azidentity.NewDefaultAzureCredential(nil)
 	if err != nil {
@@ -80,7 +82,11 @@ func ApplyConfAzureMySQL(repeaters models.MetricsRepeater, gatherers []models.Me
 	}
 
 	recommendedVars := models.MetricGroupValue{}
-	recommendVar := utils.ProcessRepeaters(metrics, repeaters, configuration, logger, models.ModeType{Name: "Configurations", Type: "GetJson"})
+	recommendVar, err := getRecommendedConfiguration(metrics, repeaters, configuration, logger)
+	if err != nil {
+		logger.Error(err)
+		return exitSignatureRejected, 4, task_output + err.Error()
+	}
 	err = json.Unmarshal([]byte(recommendVar), &recommendedVars)
 	if err != nil {
 		logger.Error(err)
@@ -152,6 +158,7 @@ func ApplyConfAzureMySQL(repeaters models.MetricsRepeater, gatherers []models.Me
 		task_output = task_output + "No Azure MySQL configuration changes to apply.\n"
 	} else {
 		logger.Infof("Applying %d Azure MySQL configuration changes", len(updates))
+		progress.Step("batch_update", 30)
 		poller, err := configurationsClient.BeginBatchUpdate(ctx, configuration.AzureResourceGroup, configuration.AzureMySQLServer, armmysqlflexibleservers.ConfigurationListForBatchUpdate{
 			Value: updates,
 		}, nil)
@@ -161,7 +168,7 @@ func ApplyConfAzureMySQL(repeaters models.MetricsRepeater, gatherers []models.Me
 			task_output = task_output + "Azure MySQL configurations update failed: " + err.Error() + "\n"
 			return task_exit_code, task_status, task_output
 		}
-		if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+		if err := pollAzureMySQL(ctx, progress, poller, "Configurations update"); err != nil {
 			task_exit_code, task_status = azureMySQLTaskErrorCode(err)
 			logger.Errorf("Azure MySQL configurations update failed: %v", err)
 			task_output = task_output + "Azure MySQL configurations update failed: " + err.Error() + "\n"
@@ -175,6 +182,7 @@ func ApplyConfAzureMySQL(repeaters models.MetricsRepeater, gatherers []models.Me
 	if restart {
 		if restartRequired {
 			logger.Info("Restarting Azure Database for MySQL server to apply static configurations")
+			progress.Step("restart", 60)
 			task_output = task_output + "Restarting Azure Database for MySQL server to apply static configurations.\n"
 			poller, err := serversClient.BeginRestart(ctx, configuration.AzureResourceGroup, configuration.AzureMySQLServer, armmysqlflexibleservers.ServerRestartParameter{}, nil)
 			if err != nil {
@@ -183,7 +191,7 @@ func ApplyConfAzureMySQL(repeaters models.MetricsRepeater, gatherers []models.Me
 				task_output = task_output + "Azure Database for MySQL server restart failed: " + err.Error() + "\n"
 				return task_exit_code, task_status, task_output
 			}
-			if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+			if err := pollAzureMySQL(ctx, progress, poller, "Server restart"); err != nil {
 				task_exit_code, task_status = azureMySQLTaskErrorCode(err)
 				logger.Errorf("Azure Database for MySQL server restart failed: %v", err)
 				task_output = task_output + "Azure Database for MySQL server restart failed: " + err.Error() + "\n"
@@ -201,6 +209,26 @@ func ApplyConfAzureMySQL(repeaters models.MetricsRepeater, gatherers []models.Me
 	return task_exit_code, task_status, task_output
 }
 
+// pollAzureMySQL waits for a long-running Azure operation, logging its status
+// to the task progress on every poll.
+func pollAzureMySQL[T any](ctx context.Context, progress *taskProgress, poller *runtime.Poller[T], operation string) error {
+	for !poller.Done() {
+		response, err := poller.Poll(ctx)
+		if err != nil {
+			return err
+		}
+		progress.Logf("%s: %s", operation, response.Status)
+		if poller.Done() {
+			break
+		}
+		if err := sleepContext(ctx, 10*time.Second); err != nil {
+			return err
+		}
+	}
+	_, err := poller.Result(ctx)
+	return err
+}
+
 func loadAzureMySQLConfigurations(ctx context.Context, configurationsClient *armmysqlflexibleservers.ConfigurationsClient,
 	resourceGroup string, serverName string) (map[string]azureMySQLConfigurationMetadata, error) {
 
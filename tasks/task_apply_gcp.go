@@ -14,17 +14,19 @@ import (
 	"google.golang.org/api/sqladmin/v1"
 )
 
-func ApplyConfGcpCloudSQL(repeaters models.MetricsRepeater, gatherers []models.MetricsGatherer,
-	logger logging.Logger, configuration *config.Config) (int, int, string) {
+// ApplyConfGcpCloudSQL sets the recommended database flags, Cloud SQL
+// restarting the instance for the flags that require it. Without restart,
+// these flags are left out and the task ends with exit code 10.
+func ApplyConfGcpCloudSQL(ctx context.Context, progress *taskProgress, repeaters models.MetricsRepeater, gatherers []models.MetricsGatherer,
+	logger logging.Logger, configuration *config.Config, restart bool) (int, int, string) {
 
 	var task_exit_code, task_status int = 0, 1
 	var task_output string
 
 	metrics := utils.CollectMetrics(gatherers, logger, configuration)
 
+	progress.Step("describe_instance", 5)
 	// Initialize GCP clients with Application Default Credentials
-	ctx := context.Background()
-
 	// Create SQL Admin client
 	sqlAdminService, err := sqladmin.NewService(ctx)
 	if err != nil {
@@ -37,7 +39,7 @@ func ApplyConfGcpCloudSQL(repeaters models.MetricsRepeater, gatherers []models.M
 	logger.Info("GSP configuration loaded SUCCESS")
 	task_output = task_output + "GSP configuration loaded SUCCESS\n"
 	// Get instance details
-	instance, err := sqlAdminService.Instances.Get(configuration.GcpProjectId, configuration.GcpCloudSqlInstance).Do()
+	instance, err := sqlAdminService.Instances.Get(configuration.GcpProjectId, configuration.GcpCloudSqlInstance).Context(ctx).Do()
 	if err != nil {
 		logger.Error("Failed to get Cloud SQL instance details", err)
 		task_output = task_output + "Failed to get Cloud SQL instance details" + err.Error()
@@ -58,8 +60,25 @@ func ApplyConfGcpCloudSQL(repeaters models.MetricsRepeater, gatherers []models.M
 		logger.Infof("Current database flags count: %d", len(currentFlags))
 	}
 
+	// Without restart, the flags requiring one are left out.
+	requiresRestart := make(map[string]bool)
+	if !restart {
+		flags, err := sqlAdminService.Flags.List().DatabaseVersion(instance.DatabaseVersion).Context(ctx).Do()
+		if err != nil {
+			logger.Error("Failed to list Cloud SQL flags", err)
+			return 1, 4, task_output + "Failed to list Cloud SQL flags" + err.Error()
+		}
+		for _, flag := range flags.Items {
+			requiresRestart[flag.Name] = flag.RequiresRestart
+		}
+	}
+
 	recommendedVars := models.MetricGroupValue{}
-	recommend_var := utils.ProcessRepeaters(metrics, repeaters, configuration, logger, models.ModeType{Name: "Configurations", Type: "GetJson"})
+	recommend_var, err := getRecommendedConfiguration(metrics, repeaters, configuration, logger)
+	if err != nil {
+		logger.Error(err)
+		return exitSignatureRejected, 4, task_output + err.Error()
+	}
 	err = json.Unmarshal([]byte(recommend_var), &recommendedVars)
 	if err != nil {
 		logger.Error(err)
@@ -73,8 +92,14 @@ func ApplyConfGcpCloudSQL(repeaters models.MetricsRepeater, gatherers []models.M
 	processedFlags := make(map[string]bool)
 
 	// First, add all recommended changes
+	var deferredFlags []string
 	for key := range recommendedVars {
 		if recommendedVars[key] != metrics.DB.Conf.Variables[key] {
+			if requiresRestart[key] {
+				logger.Infof("Flag %s requires a restart and will be skipped", key)
+				deferredFlags = append(deferredFlags, key)
+				continue
+			}
 			logger.Infof("Updating flag %s: current=%v, recommended=%v, db_current=%v",
 				key, currentFlags[key], recommendedVars[key], metrics.DB.Conf.Variables[key])
 			task_output = task_output + fmt.Sprintf("Updating flag %s: current=%v, recommended=%v, db_current=%v\n",
@@ -110,6 +135,7 @@ func ApplyConfGcpCloudSQL(repeaters models.MetricsRepeater, gatherers []models.M
 			DatabaseFlags: mergedFlags,
 		},
 	}
+	progress.Step("patch_flags", 30)
 	// A partial update (Patch) is safer than a full update (Update) because we only change the specified fields.
 	op, err := sqlAdminService.Instances.Patch(configuration.GcpProjectId, configuration.GcpCloudSqlInstance, req).Context(ctx).Do()
 	if err != nil {
@@ -128,14 +154,20 @@ func ApplyConfGcpCloudSQL(repeaters models.MetricsRepeater, gatherers []models.M
 		task_output = task_output + "Instances.Patch modified successfully.\n"
 	}
 
-	if err := waitForOp(ctx, sqlAdminService, configuration.GcpProjectId, op); err != nil {
-		logger.Fatalf("waitForOp: %v", err)
+	progress.Step("wait_for_operation", 50)
+	if err := waitForOp(ctx, progress, sqlAdminService, configuration.GcpProjectId, op); err != nil {
+		logger.Errorf("waitForOp: %v", err)
 		task_exit_code = 8
 		task_status = 4
 		task_output = task_output + err.Error()
 	} else {
 		task_output = task_output + "Cloud SQL instance updated successfully.\n"
 		logger.Info("Cloud SQL instance updated successfully.")
+		if len(deferredFlags) > 0 {
+			task_exit_code = 10
+			task_status = 4
+			task_output = task_output + fmt.Sprintf("Flags requiring a restart were not applied: %s\n", strings.Join(deferredFlags, ", "))
+		}
 	}
 
 	return task_exit_code, task_status, task_output
@@ -143,19 +175,22 @@ func ApplyConfGcpCloudSQL(repeaters models.MetricsRepeater, gatherers []models.M
 }
 
 // Waiting for long Cloud SQL operations to complete
-func waitForOp(ctx context.Context, sqlAdminService *sqladmin.Service, project string, op *sqladmin.Operation) error {
+func waitForOp(ctx context.Context, progress *taskProgress, sqlAdminService *sqladmin.Service, project string, op *sqladmin.Operation) error {
 	opName := op.Name
 	for {
 		cur, err := sqlAdminService.Operations.Get(project, opName).Context(ctx).Do()
 		if err != nil {
 			return fmt.Errorf("operations.get: %w", err)
 		}
+		progress.Logf("Operation %s is %s", opName, cur.Status)
 		if cur.Status == "DONE" {
 			if cur.Error != nil && len(cur.Error.Errors) > 0 {
 				return fmt.Errorf("cloudsql op error: %v", cur.Error.Errors[0].Message)
 			}
 			return nil
 		}
-		time.Sleep(3 * time.Second)
+		if err := sleepContext(ctx, 3*time.Second); err != nil {
+			return err
+		}
 	}
 }
@@ -1,17 +1,21 @@
 package tasks
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"strings"
 	"time"
 
 	"github.com/Releem/mysqlconfigurer/config"
 	"github.com/Releem/mysqlconfigurer/models"
-	"github.com/Releem/mysqlconfigurer/utils"
+	"github.com/Releem/mysqlconfigurer/safety"
+	"github.com/Releem/mysqlconfigurer/variables"
 	logging "github.com/google/logger"
 )
 
-func ApplyConfLocal(metrics *models.Metrics, repeaters models.MetricsRepeater, gatherers []models.MetricsGatherer, logger logging.Logger, configuration *config.Config) (int, int, string) {
+func ApplyConfLocal(ctx context.Context, progress *taskProgress, instance *models.Instance, metrics *models.Metrics, repeaters models.MetricsRepeater, gatherers []models.MetricsGatherer, logger logging.Logger, configuration *config.Config) (int, int, string) {
 	var task_exit_code, task_status int
 	var task_output string
 
@@ -22,30 +26,79 @@ func ApplyConfLocal(metrics *models.Metrics, repeaters models.MetricsRepeater, g
 	need_flush := false
 	error_exist := false
 
-	recommend_var := utils.ProcessRepeaters(metrics, repeaters, configuration, logger, models.ModeType{Name: "Configurations", Type: "GetJson"})
-	err := json.Unmarshal([]byte(recommend_var), &result_data)
+	recommend_var, err := getRecommendedConfiguration(metrics, repeaters, configuration, logger)
 	if err != nil {
 		logger.Error(err)
+		return exitSignatureRejected, 4, err.Error()
+	}
+	err = json.Unmarshal([]byte(recommend_var), &result_data)
+	if err != nil {
+		logger.Error(err)
+	}
+
+	// The health of the server is measured before the change, to be compared
+	// with its health after it.
+	readInstanceHealth := func(ctx context.Context) (healthSample, error) { return readHealth(ctx, instance.DB) }
+	verify := configuration.ApplyVerifyPeriod > 0
+	var before healthWindow
+	if verify {
+		progress.Step("measure_health", 20)
+		before, err = observeHealth(ctx, readInstanceHealth, configuration.ApplyVerifyPeriod*time.Second)
+		if ctx.Err() != nil {
+			return 8, 4, "The apply was stopped before any change.\n"
+		}
+		if err != nil {
+			logger.Error("Failed to read the health of the server, the apply is not verified: ", err)
+			task_output = task_output + "Failed to read the health of the server, the apply is not verified: " + err.Error() + "\n"
+			verify = false
+		}
 	}
 
+	progress.Step("set_variables", 60)
+	server := mysqlServer(metrics)
+	applied := 0
+	previous := make(map[string]string)
 	for key := range result_data {
+		progress.Step("set_variables", 60+30*applied/len(result_data))
+		applied++
 		logger.Infof("%s: %v -> %v", key, metrics.DB.Conf.Variables[key], result_data[key])
 
-		if result_data[key] != metrics.DB.Conf.Variables[key] {
-			query_set_var := "set global " + key + "=" + result_data[key].(string)
-			_, err := models.DB.Exec(query_set_var)
+		value := mysqlConfigValueToString(result_data[key])
+		current := mysqlConfigValueToString(metrics.DB.Conf.Variables[key])
+		if value != current {
+			statement, err := variables.Set(server, key, value, configuration.ApplySetPersist)
+			if err != nil {
+				logger.Error(err)
+				progress.Logf("%s: %v", key, err)
+				task_output = task_output + err.Error() + "\n"
+				if restartNeeded(err) {
+					need_restart = true
+				} else {
+					error_exist = true
+				}
+				continue
+			}
+			if err := safety.CheckStatement(configuration, statement.Query); err != nil {
+				logger.Error(err)
+				return exitReadOnly, 4, task_output + err.Error()
+			}
+			_, err = instance.DB.ExecContext(ctx, statement.Query)
 			if err != nil {
 				logger.Error(err)
+				progress.Logf("%s: %v", key, err)
 				task_output = task_output + err.Error()
-				if strings.Contains(err.Error(), "is a read only variable") || strings.Contains(err.Error(), "innodb_log_file_size must be at least") {
+				if restartNeeded(err) {
 					need_restart = true
 				} else if strings.Contains(err.Error(), "Access denied") {
 					need_privileges = true
 				} else {
 					error_exist = true
 				}
+			} else if statement.Restart {
+				need_restart = true
 			} else {
 				need_flush = true
+				previous[key] = current
 			}
 		}
 	}
@@ -82,7 +135,52 @@ func ApplyConfLocal(metrics *models.Metrics, repeaters models.MetricsRepeater, g
 			task_status = 1
 		}
 	}
-	time.Sleep(10 * time.Second)
+	if !verify || len(previous) == 0 {
+		sleepContext(ctx, 10*time.Second)
+		return task_exit_code, task_status, task_output
+	}
+
+	progress.Step("verify_health", 90)
+	after, err := observeHealth(ctx, readInstanceHealth, configuration.ApplyVerifyPeriod*time.Second)
+	if err != nil {
+		logger.Error("Failed to read the health of the server, the apply is not verified: ", err)
+		return task_exit_code, task_status, task_output + "Failed to read the health of the server, the apply is not verified: " + err.Error() + "\n"
+	}
+	breaches := healthBreaches(configuration, before, after)
+	if len(breaches) == 0 {
+		logger.Info("Health of the server verified after the apply")
+		return task_exit_code, task_status, task_output + fmt.Sprintf("Health of the server verified for %s after the apply.\n", configuration.ApplyVerifyPeriod*time.Second)
+	}
+
+	logger.Error("Health of the server degraded after the apply, restoring the previous values: ", strings.Join(breaches, "; "))
+	progress.Logf("Health of the server degraded after the apply: %s", strings.Join(breaches, "; "))
+	task_output = task_output + "Health of the server degraded after the apply:\n  " + strings.Join(breaches, "\n  ") + "\n"
+	progress.Step("rollback", 95)
+	restoreCtx := context.WithoutCancel(ctx)
+	if failed := restoreVariables(restoreCtx, instance.DB, server, configuration.ApplySetPersist, previous); len(failed) > 0 {
+		task_output = task_output + "Failed to restore:\n  " + strings.Join(failed, "\n  ") + "\n"
+	}
+	task_output = task_output + fmt.Sprintf("Restored the previous values of %d variables.\n", len(previous))
+	if err := restoreConfigFile(configuration); err != nil {
+		logger.Error("Failed to restore the previous configuration file: ", err)
+		task_output = task_output + "Failed to restore the previous configuration file: " + err.Error() + "\n"
+	} else {
+		task_output = task_output + "Restored the previous configuration file.\n"
+	}
+	return exitHealthCheck, 4, task_output
+}
+
+// restartNeeded reports whether a variable could not be set online but is
+// applied from the configuration file on restart: a variable static or read
+// only in the catalog, or reported read only by the server.
+func restartNeeded(err error) bool {
+	return errors.Is(err, variables.ErrStatic) || errors.Is(err, variables.ErrReadOnly) ||
+		strings.Contains(err.Error(), "is a read only variable") || strings.Contains(err.Error(), "innodb_log_file_size must be at least")
+}
 
-	return task_exit_code, task_status, task_output
+// mysqlServer returns the server the SET statements are generated for, from
+// its live variables.
+func mysqlServer(metrics *models.Metrics) variables.Server {
+	live := metrics.DB.Conf.Variables
+	return variables.ParseServer(mysqlConfigValueToString(live["version"]), mysqlConfigValueToString(live["version_comment"]), mysqlConfigValueToString(live["sql_mode"]))
 }
@@ -1,5 +1,20 @@
 package tasks
 
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Releem/mysqlconfigurer/config"
+)
+
+// InstanceEnv names the instance of the configuration a task command runs
+// for. The commands of the agent default their --instance to it, and the
+// scripts read the settings of the instance from the RELEEM_INSTANCE_*
+// variables, releem.conf holding the top-level settings only.
+const InstanceEnv = "RELEEM_INSTANCE"
+
 type taskCommand struct {
 	name string
 	args []string
@@ -22,6 +37,92 @@ func shellCommand(goos string, cmdPath string, environment []string) taskCommand
 	}
 }
 
+// credentialsEnv returns the environment passing the API key and the database
+// credentials the agent resolved to the task commands: the scripts read
+// releem.conf, which has no API key or password when they come from an
+// apikey_source, mysql_password_source or pg_password_source. The mysql and
+// psql clients read MYSQL_PWD and PGPASSWORD as well.
+func credentialsEnv(configuration *config.Config) []string {
+	if configuration == nil {
+		return nil
+	}
+	var env []string
+	if configuration.ApiKey != "" {
+		env = append(env, "RELEEM_API_KEY="+configuration.ApiKey)
+	}
+	if configuration.MysqlPassword != "" {
+		env = append(env, "RELEEM_MYSQL_USER="+configuration.MysqlUser, "MYSQL_PWD="+configuration.MysqlPassword)
+	}
+	if configuration.PgPassword != "" {
+		env = append(env, "RELEEM_PG_USER="+configuration.PgUser, "PGPASSWORD="+configuration.PgPassword)
+	}
+	return env
+}
+
+// instanceEnv returns the environment scoping a task command to the instance
+// of configuration, with every setting of the instance the scripts read. The
+// scripts skip the instance blocks of releem.conf, the API key and passwords
+// of the instance being passed by credentialsEnv. It is empty for a
+// configuration without instances.
+func instanceEnv(configuration *config.Config) []string {
+	if configuration == nil || configuration.InstanceName == "" {
+		return nil
+	}
+	return []string{
+		InstanceEnv + "=" + configuration.InstanceName,
+		"RELEEM_INSTANCE_CONF_DIR=" + configuration.ReleemConfDir,
+		"RELEEM_INSTANCE_MYSQL_HOST=" + configuration.MysqlHost,
+		"RELEEM_INSTANCE_MYSQL_PORT=" + configuration.MysqlPort,
+		"RELEEM_INSTANCE_MYSQL_CNF_DIR=" + configuration.MysqlConfDir,
+		"RELEEM_INSTANCE_MYSQL_RESTART_SERVICE=" + configuration.MysqlRestartService,
+		"RELEEM_INSTANCE_PG_HOST=" + configuration.PgHost,
+		"RELEEM_INSTANCE_PG_PORT=" + configuration.PgPort,
+		"RELEEM_INSTANCE_PG_CNF_DIR=" + configuration.PgConfDir,
+		"RELEEM_INSTANCE_PG_RESTART_SERVICE=" + configuration.PgRestartService,
+		"RELEEM_INSTANCE_MYSQL_USER=" + configuration.MysqlUser,
+		"RELEEM_INSTANCE_PG_USER=" + configuration.PgUser,
+		"RELEEM_INSTANCE_INSTANCE_TYPE=" + configuration.InstanceType,
+		"RELEEM_INSTANCE_MEMORY_LIMIT=" + strconv.Itoa(configuration.MemoryLimit),
+		"RELEEM_INSTANCE_QUERY_OPTIMIZATION=" + strconv.FormatBool(configuration.QueryOptimization),
+		"RELEEM_INSTANCE_REGION=" + configuration.ReleemRegion,
+	}
+}
+
+// CheckTaskTarget checks that a run of the agent started by a task command of
+// an instance targets the database host and the configuration directory of
+// that instance, so that a task never changes the configuration of another
+// server. Runs not started by a task are not checked.
+func CheckTaskTarget(configuration *config.Config) error {
+	name := os.Getenv(InstanceEnv)
+	if name == "" {
+		return nil
+	}
+	for _, check := range []struct{ setting, want, got string }{
+		{"instance", name, configuration.InstanceName},
+		{"releem_cnf_dir", os.Getenv("RELEEM_INSTANCE_CONF_DIR"), configuration.ReleemConfDir},
+		{"mysql_host", os.Getenv("RELEEM_INSTANCE_MYSQL_HOST"), configuration.MysqlHost},
+		{"pg_host", os.Getenv("RELEEM_INSTANCE_PG_HOST"), configuration.PgHost},
+	} {
+		if check.want != check.got {
+			return fmt.Errorf("the task targets %s %q, the agent runs for %q", check.setting, check.want, check.got)
+		}
+	}
+	return nil
+}
+
+// instanceArgs returns the arguments running the agent for an instance.
+func instanceArgs(instance string) []string {
+	if instance == "" {
+		return nil
+	}
+	return []string{"--instance=" + instance}
+}
+
+// shellQuote quotes s as a single sh word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
 func taskApplyManualCommand(goos string, releemDir string) taskCommand {
 	if goos == "windows" {
 		return powershellConfigurerCommand(releemDir, []string{"-Apply", "-NonInteractive"}, nil)
@@ -30,20 +131,26 @@ func taskApplyManualCommand(goos string, releemDir string) taskCommand {
 	return shellCommand(goos, releemDir+"/mysqlconfigurer.sh -a", []string{"RELEEM_RESTART_SERVICE=1"})
 }
 
-func taskGenerateConfigCommand(goos string, releemDir string) taskCommand {
-	if goos == "windows" {
-		return taskCommand{name: releemDir + "\\releem-agent.exe", args: []string{"-f"}}
-	}
+func taskGenerateConfigCommand(goos string, releemDir string, instance string) taskCommand {
+	return agentCommand(goos, releemDir, instance, "-f")
+}
 
-	return shellCommand(goos, releemDir+"/releem-agent -f", nil)
+func taskQueriesOptimizationCommand(goos string, releemDir string, instance string) taskCommand {
+	return agentCommand(goos, releemDir, instance, "--task=queries_optimization")
 }
 
-func taskQueriesOptimizationCommand(goos string, releemDir string) taskCommand {
+// agentCommand runs the agent with arg for an instance, or for every instance
+// when it is empty.
+func agentCommand(goos string, releemDir string, instance string, arg string) taskCommand {
 	if goos == "windows" {
-		return taskCommand{name: releemDir + "\\releem-agent.exe", args: []string{"--task=queries_optimization"}}
+		return taskCommand{name: releemDir + "\\releem-agent.exe", args: append([]string{arg}, instanceArgs(instance)...)}
 	}
 
-	return shellCommand(goos, releemDir+"/releem-agent --task=queries_optimization", nil)
+	command := releemDir + "/releem-agent " + arg
+	for _, instanceArg := range instanceArgs(instance) {
+		command += " " + shellQuote(instanceArg)
+	}
+	return shellCommand(goos, command, nil)
 }
 
 func taskUpdateCommand(goos string, releemDir string) taskCommand {
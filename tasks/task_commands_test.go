@@ -1,8 +1,16 @@
 package tasks
 
 import (
+	"context"
+	"io"
 	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	logging "github.com/google/logger"
 )
 
 func TestWindowsTaskCommands(t *testing.T) {
@@ -23,7 +31,7 @@ func TestWindowsTaskCommands(t *testing.T) {
 		},
 		{
 			name: "type 1 generates config",
-			cmd:  taskGenerateConfigCommand("windows", releemDir),
+			cmd:  taskGenerateConfigCommand("windows", releemDir, ""),
 			want: taskCommand{
 				name: `C:\Program Files\ReleemAgent\releem-agent.exe`,
 				args: []string{"-f"},
@@ -31,12 +39,20 @@ func TestWindowsTaskCommands(t *testing.T) {
 		},
 		{
 			name: "type 3 queues query optimization",
-			cmd:  taskQueriesOptimizationCommand("windows", releemDir),
+			cmd:  taskQueriesOptimizationCommand("windows", releemDir, ""),
 			want: taskCommand{
 				name: `C:\Program Files\ReleemAgent\releem-agent.exe`,
 				args: []string{"--task=queries_optimization"},
 			},
 		},
+		{
+			name: "type 3 queues query optimization for its instance",
+			cmd:  taskQueriesOptimizationCommand("windows", releemDir, "mysql-3307"),
+			want: taskCommand{
+				name: `C:\Program Files\ReleemAgent\releem-agent.exe`,
+				args: []string{"--task=queries_optimization", "--instance=mysql-3307"},
+			},
+		},
 		{
 			name: "type 4 applies config without restart",
 			cmd:  taskApplyAutomaticCommand("windows", releemDir, false),
@@ -92,15 +108,23 @@ func TestLinuxTaskCommandsKeepExistingBehavior(t *testing.T) {
 		},
 		{
 			name: "type 1 uses releem-agent -f",
-			cmd:  taskGenerateConfigCommand("linux", releemDir),
+			cmd:  taskGenerateConfigCommand("linux", releemDir, ""),
 			want: taskCommand{
 				name: "sh",
 				args: []string{"-c", "/opt/releem/releem-agent -f"},
 			},
 		},
+		{
+			name: "type 1 generates config for its instance",
+			cmd:  taskGenerateConfigCommand("linux", releemDir, "mysql-3307"),
+			want: taskCommand{
+				name: "sh",
+				args: []string{"-c", "/opt/releem/releem-agent -f '--instance=mysql-3307'"},
+			},
+		},
 		{
 			name: "type 3 uses releem-agent query optimization task",
-			cmd:  taskQueriesOptimizationCommand("linux", releemDir),
+			cmd:  taskQueriesOptimizationCommand("linux", releemDir, ""),
 			want: taskCommand{
 				name: "sh",
 				args: []string{"-c", "/opt/releem/releem-agent --task=queries_optimization"},
@@ -134,3 +158,59 @@ func TestLinuxTaskCommandsKeepExistingBehavior(t *testing.T) {
 		})
 	}
 }
+
+func TestExecTaskCommandStopsWhenContextIsCancelled(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	exitCode, status, output := execTaskCommand(ctx, shellCommand(runtime.GOOS, "sleep 10", nil), nil, *logging.Init("releem-agent-test", false, false, io.Discard), nil)
+	if time.Since(started) > 8*time.Second {
+		t.Fatal("the command was not killed")
+	}
+	if exitCode == 0 || status != 4 || !strings.Contains(output, "Task interrupted") {
+		t.Fatalf("exit code %d, status %d, output %q", exitCode, status, output)
+	}
+}
+
+func TestExecTaskCommandPassesCredentials(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	configuration := &config.Config{ApiKey: "key-from-source", MysqlUser: "releem", MysqlPassword: "from-source", PgUser: "postgres", PgPassword: "pg-secret"}
+	exitCode, _, output := execTaskCommand(context.Background(), shellCommand(runtime.GOOS, `echo "$RELEEM_API_KEY $RELEEM_MYSQL_USER:$MYSQL_PWD $RELEEM_PG_USER:$PGPASSWORD"`, nil), nil, *logging.Init("releem-agent-test", false, false, io.Discard), configuration)
+	if exitCode != 0 || output != "key-from-source releem:from-source postgres:pg-secret\n" {
+		t.Fatalf("child environment = %q (exit code %d), want the resolved credentials", output, exitCode)
+	}
+}
+
+func TestTaskCommandsTargetTheirInstance(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	configuration := &config.Config{InstanceName: "mysql-3307", ReleemConfDir: "/opt/releem/conf/mysql-3307", MysqlHost: "10.0.0.7", MysqlPort: "3307", MysqlUser: "releem", PgHost: "127.0.0.1", MemoryLimit: 2048}
+	exitCode, _, output := execTaskCommand(context.Background(), shellCommand(runtime.GOOS, `echo "$RELEEM_INSTANCE $RELEEM_INSTANCE_CONF_DIR $RELEEM_INSTANCE_MYSQL_USER@$RELEEM_INSTANCE_MYSQL_HOST:$RELEEM_INSTANCE_MYSQL_PORT $RELEEM_INSTANCE_MEMORY_LIMIT"`, nil), nil, *logging.Init("releem-agent-test", false, false, io.Discard), configuration)
+	if exitCode != 0 || output != "mysql-3307 /opt/releem/conf/mysql-3307 releem@10.0.0.7:3307 2048\n" {
+		t.Fatalf("child environment = %q (exit code %d), want the instance settings", output, exitCode)
+	}
+
+	for _, env := range instanceEnv(configuration) {
+		name, value, _ := strings.Cut(env, "=")
+		t.Setenv(name, value)
+	}
+	if err := CheckTaskTarget(configuration); err != nil {
+		t.Fatalf("CheckTaskTarget = %v for the instance of the task", err)
+	}
+	other := *configuration
+	other.InstanceName, other.MysqlHost = "", "127.0.0.1"
+	if err := CheckTaskTarget(&other); err == nil {
+		t.Fatal("CheckTaskTarget accepted a run for another instance")
+	}
+	other = *configuration
+	other.ReleemConfDir = "/opt/releem/conf"
+	if err := CheckTaskTarget(&other); err == nil {
+		t.Fatal("CheckTaskTarget accepted another configuration directory")
+	}
+}
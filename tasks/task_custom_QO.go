@@ -1,6 +1,7 @@
 package tasks
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -25,7 +26,7 @@ type QueryExplainTaskResult struct {
 	EventsStatementsHistory models.MetricGroupValue              `json:"events_statements_history"`
 }
 
-func ProcessQueryExplainTask(task_details string, logger logging.Logger, configuration *config.Config, metrics *models.Metrics) (int, int, string, string) {
+func ProcessQueryExplainTask(ctx context.Context, instance *models.Instance, task_details string, logger logging.Logger, configuration *config.Config, metrics *models.Metrics) (int, int, string, string) {
 	var task_exit_code, task_status int = 0, 1
 	var task_output, task_error string
 
@@ -94,7 +95,7 @@ func ProcessQueryExplainTask(task_details string, logger logging.Logger, configu
 		}
 		if _, ok := collectedSchemas[input.SchemaName]; !ok {
 			// Collect schema
-			err = mysql.CollectDbSchema(input.SchemaName, logger, metrics)
+			err = mysql.CollectDbSchema(ctx, instance.DB, input.SchemaName, logger, metrics)
 			if err != nil {
 				logger.Error("Failed to collect schema: ", err)
 				task_exit_code = 5
@@ -109,7 +110,7 @@ func ProcessQueryExplainTask(task_details string, logger logging.Logger, configu
 		}
 
 		// Connect to the database
-		db := utils.ConnectionDatabase(configuration, logger, input.SchemaName)
+		db := utils.ConnectionDatabaseContext(ctx, configuration, logger, input.SchemaName)
 
 		// // Get THREAD_ID before executing EXPLAIN (using the same connection)
 		// var threadID uint64
@@ -124,7 +125,7 @@ func ProcessQueryExplainTask(task_details string, logger logging.Logger, configu
 		// logger.Info("THREAD_ID: ", threadID)
 
 		// Execute EXPLAIN
-		explainResult, explain_error := mysql.ExecuteExplain(db, input.QueryText, logger)
+		explainResult, explain_error := mysql.ExecuteExplain(ctx, db, input.QueryText, logger)
 		// explainResult, err := executeExplain(db, input.QueryText, logger)
 		if explainResult != "" {
 			query_data["explain"] = explainResult
@@ -0,0 +1,333 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/mysql/armmysqlflexibleservers"
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/history"
+	"github.com/Releem/mysqlconfigurer/models"
+	"github.com/Releem/mysqlconfigurer/utils"
+	"github.com/Releem/mysqlconfigurer/variables"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	logging "github.com/google/logger"
+	"google.golang.org/api/sqladmin/v1"
+
+	config_aws "github.com/aws/aws-sdk-go-v2/config"
+)
+
+// dryRunTaskType is the type ProcessTask runs the apply tasks as when their
+// details set dry_run: the apply is planned, nothing is changed.
+const dryRunTaskType = -1
+
+// Classes of the changes of a configuration plan.
+const (
+	planDynamic         = "dynamic"
+	planRestartRequired = "restart_required"
+	planReadOnly        = "read_only"
+	planUnknown         = "unknown"
+	planNoop            = "no_op"
+)
+
+var planClasses = []string{planDynamic, planRestartRequired, planReadOnly, planUnknown, planNoop}
+
+// dryRunDetails are the details of an apply task run as a dry run.
+type dryRunDetails struct {
+	DryRun bool `json:"dry_run"`
+}
+
+// taskType returns the type a task runs as: dryRunTaskType for the apply and
+// rollback tasks with dry_run set, the type of the task otherwise.
+func taskType(task *models.Task) int {
+	switch task.TypeID {
+	case 0, 4, 5, 8:
+		var details dryRunDetails
+		if json.Unmarshal([]byte(task.Details), &details) == nil && details.DryRun {
+			return dryRunTaskType
+		}
+	}
+	return task.TypeID
+}
+
+// PlannedChange is a recommended variable and how applying it would change
+// the instance.
+type PlannedChange struct {
+	Name        string `json:"name"`
+	Current     string `json:"current"`
+	Recommended string `json:"recommended"`
+	Class       string `json:"class"`
+	Note        string `json:"note,omitempty"`
+}
+
+// ConfigurationPlan is the result of a dry run, sent in the details of the
+// task as configuration_plan.
+type ConfigurationPlan struct {
+	InstanceType string          `json:"instance_type"`
+	Changes      []PlannedChange `json:"changes"`
+	Summary      map[string]int  `json:"summary"`
+}
+
+// variableMetadata tells how a variable of the instance can be changed.
+// value is the value set on the provider side, if any.
+type variableMetadata struct {
+	dynamic  bool
+	readOnly bool
+	value    string
+}
+
+// PlanConfiguration plans the apply of the recommended configuration, or of
+// the configuration version of a rollback task, without changing anything:
+// each recommended variable is compared with the live variables and the
+// metadata of the instance. The plan is returned as the output of the task
+// and added to its details.
+func PlanConfiguration(ctx context.Context, progress *taskProgress, task *models.Task, instance *models.Instance, repeaters models.MetricsRepeater,
+	gatherers []models.MetricsGatherer, logger logging.Logger, configuration *config.Config) (int, int, string) {
+	metrics := utils.CollectMetricsContext(ctx, gatherers, logger, configuration)
+	if metrics == nil {
+		return 8, 4, "Failed to collect the metrics of the instance.\n"
+	}
+
+	progress.Step("load_recommendation", 10)
+	var recommended map[string]string
+	if task.TypeID == 8 {
+		var details rollbackVersionDetails
+		if err := json.Unmarshal([]byte(task.Details), &details); err != nil || details.Version <= 0 {
+			return exitConfigVersion, 4, "The task does not name the configuration version to roll back to.\n"
+		}
+		version, err := history.NewStore(configuration).Get(details.Version)
+		if err != nil {
+			logger.Error(err)
+			return exitConfigVersion, 4, err.Error() + "\n"
+		}
+		if recommended, err = history.Parse(version.Content); err != nil {
+			return exitConfigVersion, 4, err.Error() + "\n"
+		}
+	} else {
+		response, err := getRecommendedConfiguration(metrics, repeaters, configuration, logger)
+		if err != nil {
+			logger.Error(err)
+			return exitSignatureRejected, 4, err.Error()
+		}
+		if recommended, err = history.Parse(response); err != nil {
+			logger.Error(err)
+			return 8, 4, err.Error() + "\n"
+		}
+	}
+
+	progress.Step("load_metadata", 40)
+	metadata, err := loadVariableMetadata(ctx, instance, metrics, configuration)
+	if err != nil {
+		logger.Error("Failed to load the variables of the instance: ", err)
+		return 1, 4, "Failed to load the variables of the instance: " + err.Error() + "\n"
+	}
+
+	progress.Step("compare_variables", 80)
+	live := make(map[string]string, len(metrics.DB.Conf.Variables))
+	for name, value := range metrics.DB.Conf.Variables {
+		live[name] = mysqlConfigValueToString(value)
+	}
+	plan := buildPlan(configuration.InstanceType, recommended, live, metadata)
+
+	planJSON, err := json.Marshal(plan)
+	if err != nil {
+		logger.Error(err)
+	} else if details, err := utils.MergeJSONStrings(task.Details, string(planJSON), "configuration_plan"); err != nil {
+		logger.Error("Failed to merge task_details JSON: ", err)
+	} else {
+		task.Details = details
+	}
+	var output strings.Builder
+	writePlan(&output, plan)
+	return 0, 1, output.String()
+}
+
+// buildPlan classifies each recommended variable. Variables the instance does
+// not report are unknown.
+func buildPlan(instanceType string, recommended, live map[string]string, metadata map[string]variableMetadata) ConfigurationPlan {
+	plan := ConfigurationPlan{InstanceType: instanceType, Changes: []PlannedChange{}, Summary: make(map[string]int)}
+	for _, class := range planClasses {
+		plan.Summary[class] = 0
+	}
+	for name, value := range recommended {
+		current, inLive := live[name]
+		change := PlannedChange{Name: name, Current: current, Recommended: value}
+		variable, known := metadata[name]
+		switch {
+		case inLive && history.SameValue(current, value):
+			change.Class = planNoop
+		case !known:
+			change.Class = planUnknown
+			change.Note = "not reported by the instance"
+		case variable.readOnly:
+			change.Class = planReadOnly
+		case variable.dynamic:
+			change.Class = planDynamic
+		default:
+			change.Class = planRestartRequired
+			if variable.value != "" && history.SameValue(variable.value, value) {
+				change.Note = "already set, pending restart"
+			}
+		}
+		plan.Summary[change.Class]++
+		plan.Changes = append(plan.Changes, change)
+	}
+	sort.Slice(plan.Changes, func(i, j int) bool { return plan.Changes[i].Name < plan.Changes[j].Name })
+	return plan
+}
+
+// writePlan writes the plan as a table followed by the count of each class.
+func writePlan(output *strings.Builder, plan ConfigurationPlan) {
+	fmt.Fprintf(output, "Dry run for %s, nothing was changed.\n", plan.InstanceType)
+	fmt.Fprintf(output, "%-48s %-24s %-24s %-16s %s\n", "VARIABLE", "CURRENT", "RECOMMENDED", "CHANGE", "NOTE")
+	for _, change := range plan.Changes {
+		current := change.Current
+		if current == "" {
+			current = "-"
+		}
+		fmt.Fprintf(output, "%-48s %-24s %-24s %-16s %s\n", change.Name, current, change.Recommended, change.Class, change.Note)
+	}
+	counts := make([]string, 0, len(planClasses))
+	for _, class := range planClasses {
+		counts = append(counts, fmt.Sprintf("%d %s", plan.Summary[class], class))
+	}
+	fmt.Fprintln(output, strings.Join(counts, ", "))
+}
+
+// loadVariableMetadata returns the metadata of the variables of the instance:
+// from the provider API for cloud instances, from pg_settings for local
+// PostgreSQL and from the live variables and the variable catalog for local
+// MySQL.
+func loadVariableMetadata(ctx context.Context, instance *models.Instance, metrics *models.Metrics, configuration *config.Config) (map[string]variableMetadata, error) {
+	switch configuration.InstanceType {
+	case "aws/rds":
+		return loadAwsParameterMetadata(ctx, configuration)
+	case "gcp/cloudsql":
+		return loadGcpFlagMetadata(ctx, configuration)
+	case "azure/mysql":
+		return loadAzureMySQLMetadata(ctx, configuration)
+	}
+	if configuration.GetDatabaseType() == "postgresql" {
+		if instance == nil || instance.DB == nil {
+			return nil, fmt.Errorf("no database connection")
+		}
+		return loadPgSettingsMetadata(ctx, instance)
+	}
+	server := mysqlServer(metrics)
+	metadata := make(map[string]variableMetadata, len(metrics.DB.Conf.Variables))
+	for name := range metrics.DB.Conf.Variables {
+		// The variables missing from the catalog are taken as dynamic.
+		variable, err := variables.Lookup(server, name)
+		metadata[name] = variableMetadata{dynamic: err != nil || variable.Dynamic, readOnly: err == nil && variable.ReadOnly}
+	}
+	return metadata, nil
+}
+
+// loadPgSettingsMetadata reads the context of the PostgreSQL settings:
+// postmaster settings need a restart, internal ones cannot be changed and
+// the others are applied with a reload.
+func loadPgSettingsMetadata(ctx context.Context, instance *models.Instance) (map[string]variableMetadata, error) {
+	rows, err := instance.DB.QueryContext(ctx, "SELECT name, context FROM pg_settings")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	metadata := make(map[string]variableMetadata)
+	for rows.Next() {
+		var name, settingContext string
+		if err := rows.Scan(&name, &settingContext); err != nil {
+			return nil, err
+		}
+		metadata[strings.ToLower(name)] = variableMetadata{dynamic: settingContext != "postmaster", readOnly: settingContext == "internal"}
+	}
+	return metadata, rows.Err()
+}
+
+// loadAwsParameterMetadata reads the parameters of the DB parameter group.
+func loadAwsParameterMetadata(ctx context.Context, configuration *config.Config) (map[string]variableMetadata, error) {
+	cfg, err := config_aws.LoadDefaultConfig(ctx, config_aws.WithRegion(configuration.AwsRegion))
+	if err != nil {
+		return nil, err
+	}
+	if configuration.AwsRDSParameterGroup == "" {
+		return nil, fmt.Errorf("aws_rds_parameter_group must be set")
+	}
+	metadata := make(map[string]variableMetadata)
+	paginator := rds.NewDescribeDBParametersPaginator(rds.NewFromConfig(cfg), &rds.DescribeDBParametersInput{
+		DBParameterGroupName: aws.String(configuration.AwsRDSParameterGroup),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, param := range page.Parameters {
+			metadata[strings.ToLower(aws.ToString(param.ParameterName))] = variableMetadata{
+				dynamic:  aws.ToString(param.ApplyType) == "dynamic",
+				readOnly: !aws.ToBool(param.IsModifiable),
+				value:    aws.ToString(param.ParameterValue),
+			}
+		}
+	}
+	return metadata, nil
+}
+
+// loadGcpFlagMetadata reads the flags supported by the database version of
+// the Cloud SQL instance, with the values set on the instance.
+func loadGcpFlagMetadata(ctx context.Context, configuration *config.Config) (map[string]variableMetadata, error) {
+	sqlAdminService, err := sqladmin.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	instance, err := sqlAdminService.Instances.Get(configuration.GcpProjectId, configuration.GcpCloudSqlInstance).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	flags, err := sqlAdminService.Flags.List().DatabaseVersion(instance.DatabaseVersion).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]variableMetadata)
+	for _, flag := range flags.Items {
+		metadata[strings.ToLower(flag.Name)] = variableMetadata{dynamic: !flag.RequiresRestart}
+	}
+	if instance.Settings != nil {
+		for _, flag := range instance.Settings.DatabaseFlags {
+			if variable, ok := metadata[strings.ToLower(flag.Name)]; ok {
+				variable.value = flag.Value
+				metadata[strings.ToLower(flag.Name)] = variable
+			}
+		}
+	}
+	return metadata, nil
+}
+
+// loadAzureMySQLMetadata reads the server parameters of the Azure Database
+// for MySQL flexible server.
+func loadAzureMySQLMetadata(ctx context.Context, configuration *config.Config) (map[string]variableMetadata, error) {
+	if configuration.AzureSubscriptionID == "" || configuration.AzureResourceGroup == "" || configuration.AzureMySQLServer == "" {
+		return nil, fmt.Errorf("azure_subscription_id, azure_resource_group and azure_mysql_server must be set")
+	}
+	credential, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, err
+	}
+	clientFactory, err := armmysqlflexibleservers.NewClientFactory(configuration.AzureSubscriptionID, credential, nil)
+	if err != nil {
+		return nil, err
+	}
+	azureConfigurations, err := loadAzureMySQLConfigurations(ctx, clientFactory.NewConfigurationsClient(), configuration.AzureResourceGroup, configuration.AzureMySQLServer)
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]variableMetadata, len(azureConfigurations))
+	for name, azureConfiguration := range azureConfigurations {
+		metadata[name] = variableMetadata{dynamic: azureConfiguration.dynamic, readOnly: azureConfiguration.readOnly, value: azureConfiguration.value}
+	}
+	return metadata, nil
+}
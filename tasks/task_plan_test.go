@@ -0,0 +1,86 @@
+package tasks
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/models"
+)
+
+func TestTaskTypeOfDryRuns(t *testing.T) {
+	for task, want := range map[models.Task]int{
+		{TypeID: 4, Details: `{"dry_run":true}`}:                    dryRunTaskType,
+		{TypeID: 8, Details: `{"dry_run":true,"config_version":2}`}: dryRunTaskType,
+		{TypeID: 5, Details: `{"dry_run":false}`}:                   5,
+		{TypeID: 0}:                              0,
+		{TypeID: 3, Details: `{"dry_run":true}`}: 3,
+	} {
+		if got := taskType(&task); got != want {
+			t.Errorf("taskType(%+v) = %d, want %d", task, got, want)
+		}
+	}
+}
+
+func TestBuildPlanClassifiesChanges(t *testing.T) {
+	recommended := map[string]string{
+		"innodb_buffer_pool_size":      "1G",
+		"innodb_buffer_pool_instances": "4",
+		"max_connections":              "200",
+		"innodb_page_size":             "32768",
+		"tmp_table_size":               "16777216",
+		"query_cache_type":             "OFF",
+	}
+	live := map[string]string{
+		"innodb_buffer_pool_size":      "134217728",
+		"innodb_buffer_pool_instances": "1",
+		"max_connections":              "151",
+		"innodb_page_size":             "16384",
+		"tmp_table_size":               "16777216",
+	}
+	metrics := &models.Metrics{}
+	metrics.DB.Conf.Variables = models.MetricGroupValue{}
+	for name, value := range live {
+		metrics.DB.Conf.Variables[name] = value
+	}
+	metadata, err := loadVariableMetadata(context.Background(), nil, metrics, &config.Config{InstanceType: "local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan := buildPlan("local", recommended, live, metadata)
+	classes := make(map[string]string)
+	for _, change := range plan.Changes {
+		classes[change.Name] = change.Class
+	}
+	want := map[string]string{
+		"innodb_buffer_pool_size":      planDynamic,
+		"innodb_buffer_pool_instances": planRestartRequired,
+		"max_connections":              planDynamic,
+		"innodb_page_size":             planReadOnly,
+		"tmp_table_size":               planNoop,
+		"query_cache_type":             planUnknown,
+	}
+	if !reflect.DeepEqual(classes, want) {
+		t.Fatalf("classes = %v, want %v", classes, want)
+	}
+	if plan.Summary[planDynamic] != 2 || plan.Summary[planNoop] != 1 || plan.Changes[0].Name != "innodb_buffer_pool_instances" {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+
+	var output strings.Builder
+	writePlan(&output, plan)
+	if !strings.Contains(output.String(), "nothing was changed") || !strings.Contains(output.String(), "2 dynamic, 1 restart_required, 1 read_only, 1 unknown, 1 no_op") {
+		t.Fatalf("writePlan = %q", output.String())
+	}
+}
+
+func TestBuildPlanReportsPendingRestart(t *testing.T) {
+	metadata := map[string]variableMetadata{"innodb_log_buffer_size": {value: "67108864"}}
+	plan := buildPlan("aws/rds", map[string]string{"innodb_log_buffer_size": "64M"}, map[string]string{"innodb_log_buffer_size": "16777216"}, metadata)
+	if change := plan.Changes[0]; change.Class != planRestartRequired || change.Note == "" {
+		t.Fatalf("change = %+v, want a restart pending on the parameter group value", change)
+	}
+}
@@ -0,0 +1,99 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/history"
+	"github.com/Releem/mysqlconfigurer/models"
+	"github.com/aws/aws-sdk-go-v2/service/rds/types"
+	logging "github.com/google/logger"
+)
+
+// rollbackVersionDetails are the details of a rollback task.
+type rollbackVersionDetails struct {
+	Version int `json:"config_version"`
+}
+
+// versionRepeater answers the request for the recommended variables with the
+// variables of a configuration version, so that the cloud apply paths apply it.
+type versionRepeater struct {
+	models.MetricsRepeater
+	content string
+}
+
+func (repeater versionRepeater) ProcessMetrics(context models.MetricContext, metrics models.Metrics, Mode models.ModeType) (string, error) {
+	if Mode.Name == "Configurations" && Mode.Type == "GetJson" {
+		return repeater.content, nil
+	}
+	return repeater.MetricsRepeater.ProcessMetrics(context, metrics, Mode)
+}
+
+// RollbackToVersion applies a version of the configuration history again, the
+// version being named by the task details. Local instances apply it with the
+// apply script and a restart, cloud instances through the API of the provider.
+func RollbackToVersion(ctx context.Context, progress *taskProgress, task *models.Task, repeaters models.MetricsRepeater, gatherers []models.MetricsGatherer,
+	logger logging.Logger, configuration *config.Config) (int, int, string) {
+	var details rollbackVersionDetails
+	if err := json.Unmarshal([]byte(task.Details), &details); err != nil || details.Version <= 0 {
+		return exitConfigVersion, 4, "The task does not name the configuration version to roll back to.\n"
+	}
+	version, err := history.NewStore(configuration).Get(details.Version)
+	if err != nil {
+		logger.Error(err)
+		return exitConfigVersion, 4, err.Error() + "\n"
+	}
+	cloud := history.IsCloudInstance(configuration)
+	variables, err := history.Parse(version.Content)
+	isJSON := strings.HasPrefix(strings.TrimSpace(version.Content), "{")
+	if err != nil || len(variables) == 0 || isJSON != cloud || version.File != history.ConfigFileName(configuration) {
+		return exitConfigVersion, 4, fmt.Sprintf("Configuration version %d (%s, %s) cannot be applied to this instance.\n", version.Number, version.Source, version.File)
+	}
+	logger.Infof(" * Rolling back the configuration to version %d recorded at %s", version.Number, version.Time)
+	progress.Logf("Rolling back the configuration to version %d recorded at %s", version.Number, version.Time)
+
+	if cloud {
+		var exitCode, status int
+		var output string
+		versioned := versionRepeater{MetricsRepeater: repeaters, content: version.Content}
+		switch configuration.InstanceType {
+		case "aws/rds":
+			exitCode, status, output = ApplyConfAwsRds(ctx, progress, versioned, gatherers, logger, configuration, types.ApplyMethodPendingReboot)
+		case "gcp/cloudsql":
+			exitCode, status, output = ApplyConfGcpCloudSQL(ctx, progress, versioned, gatherers, logger, configuration, true)
+		case "azure/mysql":
+			exitCode, status, output = ApplyConfAzureMySQL(ctx, progress, versioned, gatherers, logger, configuration, true)
+		}
+		if status == 1 {
+			_, err := history.NewStore(configuration).Record(history.Version{
+				Source:     history.SourceRollback,
+				TaskID:     task.ID,
+				RollbackOf: version.Number,
+				File:       version.File,
+				Content:    version.Content,
+			})
+			if err != nil {
+				logger.Error("Failed to record the applied configuration in the history: ", err)
+			}
+		}
+		return exitCode, status, output
+	}
+
+	progress.Step("restore_version", 5)
+	if err := os.WriteFile(filepath.Join(configuration.ReleemConfDir, version.File), []byte(version.Content), 0644); err != nil {
+		logger.Error(err)
+		return exitConfigVersion, 4, "Failed to restore configuration version " + strconv.Itoa(version.Number) + ": " + err.Error() + "\n"
+	}
+	progress.Step("apply_configuration", 10)
+	// The apply script records the applied version through the config_applied event.
+	command := taskApplyAutomaticCommand(runtime.GOOS, configuration.ReleemDir, true)
+	command.env = append(command.env, "RELEEM_SKIP_CONFIG_DOWNLOAD=1", history.RollbackVersionEnv+"="+strconv.Itoa(version.Number))
+	return execTaskCommand(ctx, command, progress, logger, configuration)
+}
@@ -0,0 +1,40 @@
+package tasks
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/history"
+	"github.com/Releem/mysqlconfigurer/models"
+	logging "github.com/google/logger"
+)
+
+func TestRollbackToVersionRefusesUnknownAndForeignVersions(t *testing.T) {
+	configuration := &config.Config{ReleemDir: t.TempDir(), InstanceType: "aws/rds"}
+	if _, err := history.NewStore(configuration).Record(history.Version{Source: history.SourceApply, File: "z_aiops_mysql.cnf", Content: "[mysqld]\nmax_connections=200\n"}); err != nil {
+		t.Fatal(err)
+	}
+	logger := *logging.Init("releem-agent-test", false, false, io.Discard)
+
+	for details, reason := range map[string]string{
+		`{}`:                    "no version",
+		`{"config_version":42}`: "a version not in the history",
+		`{"config_version":1}`:  "a configuration file on a cloud instance",
+	} {
+		task := &models.Task{ID: 3, TypeID: 8, Details: details}
+		exitCode, status, output := RollbackToVersion(context.Background(), nil, task, &taskRepeater{}, nil, logger, configuration)
+		if exitCode != exitConfigVersion || status != 4 || output == "" {
+			t.Errorf("%s: RollbackToVersion = %d, %d, %q", reason, exitCode, status, output)
+		}
+	}
+}
+
+func TestVersionRepeaterAnswersWithTheVersion(t *testing.T) {
+	repeater := versionRepeater{MetricsRepeater: &taskRepeater{}, content: `{"max_connections":"200"}`}
+	response, err := repeater.ProcessMetrics(&config.Config{}, models.Metrics{}, models.ModeType{Name: "Configurations", Type: "GetJson"})
+	if err != nil || response != `{"max_connections":"200"}` {
+		t.Fatalf("ProcessMetrics = %q, %v, want the version", response, err)
+	}
+}
@@ -1,33 +1,129 @@
 package tasks
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"runtime"
+	"sync/atomic"
 	"time"
 
 	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/history"
 	"github.com/Releem/mysqlconfigurer/models"
+	"github.com/Releem/mysqlconfigurer/safety"
+	"github.com/Releem/mysqlconfigurer/signature"
 	"github.com/Releem/mysqlconfigurer/utils"
 	"github.com/aws/aws-sdk-go-v2/service/rds/types"
 	logging "github.com/google/logger"
 )
 
-func ProcessTaskFunc(repeaters models.MetricsRepeater, gatherers []models.MetricsGatherer, logger logging.Logger, configuration *config.Config) func() {
+func ProcessTaskFunc(ctx context.Context, instance *models.Instance, repeaters models.MetricsRepeater, gatherers []models.MetricsGatherer, logger logging.Logger, configuration *config.Config) func() {
 	return func() {
-		ProcessTask(repeaters, gatherers, logger, configuration)
+		ProcessTask(ctx, instance, repeaters, gatherers, logger, configuration)
 	}
 }
 
-func ProcessTask(repeaters models.MetricsRepeater, gatherers []models.MetricsGatherer, logger logging.Logger, configuration *config.Config) {
+// Exit codes of the tasks refused or stopped by the agent, distinct from the
+// exit codes of the task commands.
+const (
+	// exitSignatureRejected is reported for a task, or a configuration
+	// downloaded by a task, whose signature was rejected.
+	exitSignatureRejected = 20
+	// exitReadOnly is reported for a task, or a statement of a task, refused
+	// by read_only_agent.
+	exitReadOnly = 21
+	// exitInterrupted is reported for a task that was running when the agent
+	// or the host stopped.
+	exitInterrupted = 22
+	// exitTimeout is reported for a task stopped by its deadline.
+	exitTimeout = 23
+	// exitCancelled is reported for a task cancelled from the platform.
+	exitCancelled = 24
+	// exitConfigVersion is reported for a rollback to a configuration
+	// version that is not in the history or cannot be applied.
+	exitConfigVersion = 26
+	// exitHealthCheck is reported for an apply whose changes were reverted
+	// because the health of the database degraded after it.
+	exitHealthCheck = 27
+)
+
+// Statuses of the tasks stopped by the agent, besides 1 (succeeded),
+// 3 (running) and 4 (failed).
+const (
+	statusTimedOut  = 5
+	statusCancelled = 6
+	// statusScheduled is reported for an apply whose restart waits for a
+	// maintenance window.
+	statusScheduled = 7
+)
+
+// applying counts the tasks changing the database configuration or the agent
+// itself. They are not interrupted by the agent shutdown.
+var applying atomic.Int32
+
+// ApplyInProgress reports whether a task that must not be interrupted is running.
+func ApplyInProgress() bool {
+	return applying.Load() > 0
+}
+
+// isUninterruptibleTask reports whether stopping the task half-way could leave
+// a half-applied configuration or a half-updated agent.
+func isUninterruptibleTask(TypeID int) bool {
+	switch TypeID {
+	case 0, 2, 4, 5, 8:
+		return true
+	}
+	return false
+}
+
+// ProcessTask fetches and runs the pending task. Once started, configuration
+// apply and update tasks run to completion even if ctx is cancelled; other
+// tasks are interrupted. Every task is stopped at its deadline or when it is
+// cancelled from the platform. Tasks are recorded in the journal, and a task
+// id already in it is never run again. Apply tasks with dry_run set in their
+// details only report the plan of the apply, and outside the maintenance
+// windows the restart of an apply is scheduled for the next window. The tasks
+// of an instance run one at a time, also across agent processes: a task is
+// left queued on the platform while another process holds the task lock.
+func ProcessTask(ctx context.Context, instance *models.Instance, repeaters models.MetricsRepeater, gatherers []models.MetricsGatherer, logger logging.Logger, configuration *config.Config) {
 	defer utils.HandlePanic(configuration, logger)
 	var TaskStruct *models.Task
 	var task_output string
 
-	metrics := utils.CollectMetrics(gatherers, logger, configuration)
-	RepeaterResponse := utils.ProcessRepeaters(metrics, repeaters, configuration, logger, models.ModeType{Name: "Task", Type: "Get"})
+	if ctx.Err() != nil {
+		return
+	}
+	// Tasks wait for the task of the agent running before them. The task is
+	// only fetched once the lock is taken, a task of an instance locked by
+	// another agent process being left queued on the platform.
+	lock, err := waitTaskLock(ctx, configuration, "task")
+	var held *LockHeldError
+	if errors.As(err, &held) {
+		logger.Info(held, ", the task is left queued")
+		return
+	} else if err != nil {
+		logger.Error(err)
+		return
+	}
+	defer lock.Release()
+	metrics := utils.CollectMetricsContext(ctx, gatherers, logger, configuration)
+	if metrics == nil {
+		return
+	}
+	RepeaterResponse, err := repeaters.ProcessMetrics(configuration, *metrics, models.ModeType{Name: "Task", Type: "Get"})
+	var rejected *signature.Error
+	if errors.As(err, &rejected) {
+		reportRejectedTask(metrics, repeaters, configuration, logger, rejected)
+		return
+	}
+	if err != nil {
+		logger.Error("Repeater failed ", err)
+	}
 	logger.Infof("Task details: %s", RepeaterResponse)
 
-	err := json.Unmarshal([]byte(RepeaterResponse), &TaskStruct)
+	err = json.Unmarshal([]byte(RepeaterResponse), &TaskStruct)
 	if err != nil {
 		logger.Error("Failed to parse task description JSON: ", err)
 		return
@@ -35,90 +131,144 @@ func ProcessTask(repeaters models.MetricsRepeater, gatherers []models.MetricsGat
 	if TaskStruct == nil {
 		return
 	}
+	if err := safety.CheckTask(configuration, taskType(TaskStruct)); err != nil {
+		logger.Error(err)
+		metrics.ReleemAgent.Tasks = models.Task{ID: TaskStruct.ID, TypeID: TaskStruct.TypeID, Status: 4, ExitCode: exitReadOnly, Error: err.Error()}
+		utils.ProcessRepeaters(metrics, repeaters, configuration, logger, models.ModeType{Name: "Task", Type: "Status"})
+		return
+	}
+	if err := lock.SetOwner(fmt.Sprintf("task %d of type %d", TaskStruct.ID, TaskStruct.TypeID)); err != nil {
+		logger.Error(err)
+	}
+
+	journal := NewJournal(configuration)
+	entry, err := journal.Begin(*TaskStruct)
+	if errors.Is(err, ErrTaskJournaled) {
+		reportJournaledTask(journal, entry, metrics, repeaters, configuration, logger)
+		return
+	}
+	if err != nil {
+		logger.Error(err)
+	}
 
 	metrics.ReleemAgent.Tasks = models.Task{ID: TaskStruct.ID, TypeID: TaskStruct.TypeID, Status: 3}
 	utils.ProcessRepeaters(metrics, repeaters, configuration, logger, models.ModeType{Name: "Task", Type: "Status"})
 	logger.Infof(" * Task with id - %d and type id - %d is being started...", TaskStruct.ID, TaskStruct.TypeID)
 
-	switch TaskStruct.TypeID {
+	taskCtx := ctx
+	if isUninterruptibleTask(taskType(TaskStruct)) {
+		applying.Add(1)
+		defer applying.Add(-1)
+		taskCtx = context.WithoutCancel(ctx)
+	}
+	timeout := taskTimeout(configuration, TaskStruct.TypeID)
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		taskCtx, cancelTimeout = context.WithTimeoutCause(taskCtx, timeout, errTaskTimeout)
+		defer cancelTimeout()
+	}
+	taskCtx, cancelTask := context.WithCancelCause(withTaskID(taskCtx, TaskStruct.ID))
+	defer cancelTask(nil)
+	progress := &taskProgress{}
+	monitoring := make(chan struct{})
+	go func() {
+		defer close(monitoring)
+		monitorTask(taskCtx, cancelTask, *TaskStruct, progress, metrics.ReleemAgent.Info, repeaters, configuration, logger)
+	}()
+
+	switch taskType(TaskStruct) {
+	case dryRunTaskType:
+		TaskStruct.ExitCode, TaskStruct.Status, task_output = PlanConfiguration(taskCtx, progress, TaskStruct, instance, repeaters, gatherers, logger, configuration)
+		TaskStruct.Output = TaskStruct.Output + task_output
+
 	case 0:
-		TaskStruct.ExitCode, TaskStruct.Status, task_output = execTaskCommand(taskApplyManualCommand(runtime.GOOS, configuration.ReleemDir), logger)
+		progress.Step("apply_configuration", 10)
+		TaskStruct.ExitCode, TaskStruct.Status, task_output = execTaskCommand(taskCtx, taskApplyManualCommand(runtime.GOOS, configuration.ReleemDir), progress, logger, configuration)
 		TaskStruct.Output = TaskStruct.Output + task_output
 
-		if TaskStruct.ExitCode == 7 {
-			var rollback_exit_code int
-			rollback_exit_code, _, task_output = execTaskCommand(taskRollbackCommand(runtime.GOOS, configuration.ReleemDir), logger)
-			TaskStruct.Output = TaskStruct.Output + task_output
-			logger.Info(" * Task rollbacked with code ", rollback_exit_code)
+		if needsRollback(taskCtx, TaskStruct) {
+			rollbackTask(taskCtx, taskRollbackCommand(runtime.GOOS, configuration.ReleemDir), TaskStruct, progress, logger, configuration)
 		}
 
 	case 1:
-		TaskStruct.ExitCode, TaskStruct.Status, task_output = execTaskCommand(taskGenerateConfigCommand(runtime.GOOS, configuration.ReleemDir), logger)
+		progress.Step("generate_configuration", 10)
+		TaskStruct.ExitCode, TaskStruct.Status, task_output = execTaskCommand(taskCtx, taskGenerateConfigCommand(runtime.GOOS, configuration.ReleemDir, configuration.InstanceName), progress, logger, configuration)
 		TaskStruct.Output = TaskStruct.Output + task_output
 	case 2:
-		TaskStruct.ExitCode, TaskStruct.Status, task_output = execTaskCommand(taskUpdateCommand(runtime.GOOS, configuration.ReleemDir), logger)
+		progress.Step("update_agent", 10)
+		TaskStruct.ExitCode, TaskStruct.Status, task_output = execTaskCommand(taskCtx, taskUpdateCommand(runtime.GOOS, configuration.ReleemDir), progress, logger, configuration)
 		TaskStruct.Output = TaskStruct.Output + task_output
 	case 3:
-		TaskStruct.ExitCode, TaskStruct.Status, task_output = execTaskCommand(taskQueriesOptimizationCommand(runtime.GOOS, configuration.ReleemDir), logger)
+		progress.Step("queries_optimization", 10)
+		TaskStruct.ExitCode, TaskStruct.Status, task_output = execTaskCommand(taskCtx, taskQueriesOptimizationCommand(runtime.GOOS, configuration.ReleemDir, configuration.InstanceName), progress, logger, configuration)
 		TaskStruct.Output = TaskStruct.Output + task_output
 	case 4:
 		switch configuration.InstanceType {
 		case "aws/rds":
-			TaskStruct.ExitCode, TaskStruct.Status, task_output = ApplyConfAwsRds(repeaters, gatherers, logger, configuration, types.ApplyMethodImmediate)
+			TaskStruct.ExitCode, TaskStruct.Status, task_output = ApplyConfAwsRds(taskCtx, progress, repeaters, gatherers, logger, configuration, types.ApplyMethodImmediate)
 			TaskStruct.Output = TaskStruct.Output + task_output
 			if TaskStruct.ExitCode == 0 {
-				TaskStruct.ExitCode, TaskStruct.Status, task_output = ApplyConfAwsRds(repeaters, gatherers, logger, configuration, types.ApplyMethodPendingReboot)
+				TaskStruct.ExitCode, TaskStruct.Status, task_output = ApplyConfAwsRds(taskCtx, progress, repeaters, gatherers, logger, configuration, types.ApplyMethodPendingReboot)
 				TaskStruct.Output = TaskStruct.Output + task_output
 			}
 		case "gcp/cloudsql":
-			TaskStruct.ExitCode, TaskStruct.Status, task_output = ApplyConfGcpCloudSQL(repeaters, gatherers, logger, configuration)
+			TaskStruct.ExitCode, TaskStruct.Status, task_output = ApplyConfGcpCloudSQL(taskCtx, progress, repeaters, gatherers, logger, configuration, true)
 			TaskStruct.Output = TaskStruct.Output + task_output
 		case "azure/mysql":
-			TaskStruct.ExitCode, TaskStruct.Status, task_output = ApplyConfAzureMySQL(repeaters, gatherers, logger, configuration, false)
+			TaskStruct.ExitCode, TaskStruct.Status, task_output = ApplyConfAzureMySQL(taskCtx, progress, repeaters, gatherers, logger, configuration, false)
 			TaskStruct.Output = TaskStruct.Output + task_output
 
 		default:
-			TaskStruct.ExitCode, TaskStruct.Status, task_output = execTaskCommand(taskApplyAutomaticCommand(runtime.GOOS, configuration.ReleemDir, false), logger)
+			progress.Step("apply_configuration", 10)
+			TaskStruct.ExitCode, TaskStruct.Status, task_output = execTaskCommand(taskCtx, taskApplyAutomaticCommand(runtime.GOOS, configuration.ReleemDir, false), progress, logger, configuration)
 			TaskStruct.Output = TaskStruct.Output + task_output
-			if TaskStruct.ExitCode == 7 {
-				var rollback_exit_code int
-				rollback_exit_code, _, task_output = execTaskCommand(taskRollbackCommand(runtime.GOOS, configuration.ReleemDir), logger)
-				TaskStruct.Output = TaskStruct.Output + task_output
-				logger.Info(" * Task rollbacked with code ", rollback_exit_code)
+			if needsRollback(taskCtx, TaskStruct) {
+				rollbackTask(taskCtx, taskRollbackCommand(runtime.GOOS, configuration.ReleemDir), TaskStruct, progress, logger, configuration)
 			}
 
 			if TaskStruct.ExitCode == 0 {
-				TaskStruct.ExitCode, TaskStruct.Status, task_output = ApplyConfLocal(metrics, repeaters, gatherers, logger, configuration)
+				TaskStruct.ExitCode, TaskStruct.Status, task_output = ApplyConfLocal(taskCtx, progress, instance, metrics, repeaters, gatherers, logger, configuration)
 				TaskStruct.Output = TaskStruct.Output + task_output
 			}
 		}
 
 	case 5:
+		if schedule := restartSchedule(configuration); schedule != nil && !schedule.Contains(time.Now()) {
+			TaskStruct.ExitCode, TaskStruct.Status, task_output = ApplyBeforeWindow(taskCtx, progress, TaskStruct, instance, metrics, repeaters, gatherers, logger, configuration, schedule.Next(time.Now()))
+			TaskStruct.Output = TaskStruct.Output + task_output
+			break
+		}
 		switch configuration.InstanceType {
 		case "aws/rds":
-			TaskStruct.ExitCode, TaskStruct.Status, task_output = ApplyConfAwsRds(repeaters, gatherers, logger, configuration, types.ApplyMethodPendingReboot)
+			TaskStruct.ExitCode, TaskStruct.Status, task_output = ApplyConfAwsRds(taskCtx, progress, repeaters, gatherers, logger, configuration, types.ApplyMethodPendingReboot)
 			TaskStruct.Output = TaskStruct.Output + task_output
 		case "gcp/cloudsql":
-			TaskStruct.ExitCode, TaskStruct.Status, task_output = ApplyConfGcpCloudSQL(repeaters, gatherers, logger, configuration)
+			TaskStruct.ExitCode, TaskStruct.Status, task_output = ApplyConfGcpCloudSQL(taskCtx, progress, repeaters, gatherers, logger, configuration, true)
 			TaskStruct.Output = TaskStruct.Output + task_output
 		case "azure/mysql":
-			TaskStruct.ExitCode, TaskStruct.Status, task_output = ApplyConfAzureMySQL(repeaters, gatherers, logger, configuration, true)
+			TaskStruct.ExitCode, TaskStruct.Status, task_output = ApplyConfAzureMySQL(taskCtx, progress, repeaters, gatherers, logger, configuration, true)
 			TaskStruct.Output = TaskStruct.Output + task_output
 
 		default:
-			TaskStruct.ExitCode, TaskStruct.Status, task_output = execTaskCommand(taskApplyAutomaticCommand(runtime.GOOS, configuration.ReleemDir, true), logger)
+			progress.Step("apply_configuration", 10)
+			TaskStruct.ExitCode, TaskStruct.Status, task_output = execTaskCommand(taskCtx, taskApplyAutomaticCommand(runtime.GOOS, configuration.ReleemDir, true), progress, logger, configuration)
 			TaskStruct.Output = TaskStruct.Output + task_output
-			if TaskStruct.ExitCode == 7 {
-				var rollback_exit_code int
-				rollback_exit_code, _, task_output = execTaskCommand(taskRollbackCommand(runtime.GOOS, configuration.ReleemDir), logger)
-				TaskStruct.Output = TaskStruct.Output + task_output
-				logger.Info(" * Task rollbacked with code ", rollback_exit_code)
+			if needsRollback(taskCtx, TaskStruct) {
+				rollbackTask(taskCtx, taskRollbackCommand(runtime.GOOS, configuration.ReleemDir), TaskStruct, progress, logger, configuration)
 			}
 		}
 
+	case 8:
+		TaskStruct.ExitCode, TaskStruct.Status, task_output = RollbackToVersion(taskCtx, progress, TaskStruct, repeaters, gatherers, logger, configuration)
+		TaskStruct.Output = TaskStruct.Output + task_output
+		if !history.IsCloudInstance(configuration) && needsRollback(taskCtx, TaskStruct) {
+			rollbackTask(taskCtx, taskRollbackCommand(runtime.GOOS, configuration.ReleemDir), TaskStruct, progress, logger, configuration)
+		}
+
 	case 7:
+		progress.Step("explain_queries", 10)
 		TaskStruct.ExitCode, TaskStruct.Status, TaskStruct.Output, TaskStruct.Error = ProcessQueryExplainTask(
-			TaskStruct.Details, logger, configuration, metrics)
+			taskCtx, instance, TaskStruct.Details, logger, configuration, metrics)
 		if TaskStruct.ExitCode == 0 {
 			metrics.ReleemAgent.Tasks = *TaskStruct
 			RepeaterResponse := utils.ProcessRepeaters(metrics, repeaters, configuration, logger, models.ModeType{Name: "TaskByName", Type: "custom_queries_optimization"})
@@ -135,10 +285,143 @@ func ProcessTask(repeaters models.MetricsRepeater, gatherers []models.MetricsGat
 		TaskStruct.Status = 4
 	}
 
-	time.Sleep(10 * time.Second)
-	metrics = utils.CollectMetrics(gatherers, logger, configuration)
+	// Local applies are recorded through the config_applied event of the apply script.
+	if (taskType(TaskStruct) == 4 || taskType(TaskStruct) == 5) && TaskStruct.Status == 1 && history.IsCloudInstance(configuration) {
+		version := history.Version{Source: history.SourceApply, TaskID: TaskStruct.ID}
+		if _, err := history.NewStore(configuration).RecordApplied(configuration, version); err != nil {
+			logger.Error("Failed to record the applied configuration in the history: ", err)
+		}
+	}
+	if exitCode, status, message, stopped := stoppedState(taskCtx, timeout); stopped && TaskStruct.Status != 1 {
+		TaskStruct.ExitCode, TaskStruct.Status = exitCode, status
+		TaskStruct.Error = TaskStruct.Error + message
+	}
+	final, _ := progress.snapshot()
+	if TaskStruct.Status == 1 {
+		final.Step, final.Percent = "completed", 100
+	}
+	TaskStruct.Progress = &final
+	cancelTask(nil)
+	<-monitoring
+
+	// The final status is reported even when the agent is stopping.
+	select {
+	case <-ctx.Done():
+	case <-time.After(10 * time.Second):
+	}
+	metrics = utils.CollectMetricsContext(context.WithoutCancel(ctx), gatherers, logger, configuration)
+	if metrics == nil {
+		metrics = &models.Metrics{}
+	}
 	logger.Infof(" * Task with id - %d and type id - %d completed with code %d", TaskStruct.ID, TaskStruct.TypeID, TaskStruct.ExitCode)
 
+	if entry != nil {
+		if err := journal.Record(entry, PhaseCompleted, *TaskStruct); err != nil {
+			logger.Error(err)
+		}
+	}
 	metrics.ReleemAgent.Tasks = *TaskStruct
+	if err := reportTaskStatus(metrics, repeaters, configuration); err != nil {
+		logger.Error("Failed to report the final state of task ", TaskStruct.ID, ": ", err)
+		return
+	}
+	if entry != nil {
+		if err := journal.Record(entry, PhaseReported, *TaskStruct); err != nil {
+			logger.Error(err)
+		}
+	}
+}
+
+// reportTaskStatus sends the state of metrics.ReleemAgent.Tasks.
+func reportTaskStatus(metrics *models.Metrics, repeaters models.MetricsRepeater, configuration *config.Config) error {
+	_, err := repeaters.ProcessMetrics(configuration, *metrics, models.ModeType{Name: "Task", Type: "Status"})
+	return err
+}
+
+// reportJournaledTask handles a task delivered again: it is not run twice,
+// its final state is reported again once it has one.
+func reportJournaledTask(journal *Journal, entry *JournalEntry, metrics *models.Metrics, repeaters models.MetricsRepeater, configuration *config.Config, logger logging.Logger) {
+	if entry.Phase == PhaseRunning {
+		logger.Infof(" * Task with id - %d is already running, not starting it again", entry.Task.ID)
+		return
+	}
+	logger.Infof(" * Task with id - %d already ran, reporting its final state again", entry.Task.ID)
+	metrics.ReleemAgent.Tasks = entry.Task
+	if err := reportTaskStatus(metrics, repeaters, configuration); err != nil {
+		logger.Error("Failed to report the final state of task ", entry.Task.ID, ": ", err)
+		return
+	}
+	if entry.Phase != PhaseReported {
+		if err := journal.Record(entry, PhaseReported, entry.Task); err != nil {
+			logger.Error(err)
+		}
+	}
+}
+
+// ReconcileTasks reports the final state of the tasks of the journal that was
+// not reported before the agent stopped. Tasks still running when it stopped
+// are reported as failed with exitInterrupted. It must run before any task is
+// started.
+func ReconcileTasks(ctx context.Context, repeaters models.MetricsRepeater, gatherers []models.MetricsGatherer, logger logging.Logger, configuration *config.Config) {
+	defer utils.HandlePanic(configuration, logger)
+	journal := NewJournal(configuration)
+	entries, err := journal.Unreported()
+	if err != nil {
+		logger.Error("Failed to read the task journal: ", err)
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+	metrics := utils.CollectMetricsContext(ctx, gatherers, logger, configuration)
+	if metrics == nil {
+		metrics = &models.Metrics{}
+	}
+	for _, entry := range entries {
+		task := entry.Task
+		if entry.Phase == PhaseRunning {
+			logger.Errorf(" * Task with id - %d and type id - %d was interrupted by the agent stop", task.ID, task.TypeID)
+			task.Status = 4
+			task.ExitCode = exitInterrupted
+			task.Error = task.Error + "The agent stopped while the task was running, the task may be partially applied.\n"
+			if err := journal.Record(entry, PhaseCompleted, task); err != nil {
+				logger.Error(err)
+			}
+		}
+		metrics.ReleemAgent.Tasks = task
+		if err := reportTaskStatus(metrics, repeaters, configuration); err != nil {
+			logger.Error("Failed to report the final state of task ", task.ID, ": ", err)
+			continue
+		}
+		if err := journal.Record(entry, PhaseReported, task); err != nil {
+			logger.Error(err)
+		}
+	}
+}
+
+// reportRejectedTask reports a task whose signature was rejected as failed,
+// without running it. The task id is read from the unverified response.
+func reportRejectedTask(metrics *models.Metrics, repeaters models.MetricsRepeater, configuration *config.Config, logger logging.Logger, rejected *signature.Error) {
+	logger.Error(rejected)
+	var task *models.Task
+	if err := json.Unmarshal(rejected.Body, &task); err != nil || task == nil {
+		return
+	}
+	metrics.ReleemAgent.Tasks = models.Task{ID: task.ID, TypeID: task.TypeID, Status: 4, ExitCode: exitSignatureRejected, Error: rejected.Error()}
 	utils.ProcessRepeaters(metrics, repeaters, configuration, logger, models.ModeType{Name: "Task", Type: "Status"})
 }
+
+// getRecommendedConfiguration returns the recommended variables. A response
+// whose signature was rejected is returned as an error, so that nothing is
+// applied from it.
+func getRecommendedConfiguration(metrics *models.Metrics, repeaters models.MetricsRepeater, configuration *config.Config, logger logging.Logger) (string, error) {
+	response, err := repeaters.ProcessMetrics(configuration, *metrics, models.ModeType{Name: "Configurations", Type: "GetJson"})
+	var rejected *signature.Error
+	if errors.As(err, &rejected) {
+		return "", err
+	}
+	if err != nil {
+		logger.Error("Repeater failed ", err)
+	}
+	return response, nil
+}
@@ -0,0 +1,86 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/models"
+	"github.com/Releem/mysqlconfigurer/signature"
+	"github.com/Releem/mysqlconfigurer/variables"
+	logging "github.com/google/logger"
+)
+
+// taskRepeater answers Task/Get with a task and records the reported statuses,
+// answered with status when it is set.
+type taskRepeater struct {
+	get      func() (string, error)
+	status   func() string
+	statuses []models.Task
+}
+
+func (repeater *taskRepeater) ProcessMetrics(context models.MetricContext, metrics models.Metrics, Mode models.ModeType) (string, error) {
+	switch {
+	case Mode.Name == "Task" && Mode.Type == "Get":
+		return repeater.get()
+	case Mode.Name == "Task" && Mode.Type == "Status":
+		repeater.statuses = append(repeater.statuses, metrics.ReleemAgent.Tasks)
+		if repeater.status != nil {
+			return repeater.status(), nil
+		}
+	}
+	return "", nil
+}
+
+func TestProcessTaskReportsRejectedSignatures(t *testing.T) {
+	repeater := &taskRepeater{get: func() (string, error) {
+		return "", &signature.Error{Endpoint: "tasks/get", Reason: "the response is not signed", Body: []byte(`{"task_id":42,"task_type_id":0}`)}
+	}}
+	ProcessTask(context.Background(), nil, repeater, nil, *logging.Init("releem-agent-test", false, false, io.Discard), &config.Config{ReleemDir: t.TempDir()})
+
+	if len(repeater.statuses) != 1 {
+		t.Fatalf("reported %d statuses, want the rejection only: %+v", len(repeater.statuses), repeater.statuses)
+	}
+	status := repeater.statuses[0]
+	if status.ID != 42 || status.Status != 4 || status.ExitCode != exitSignatureRejected || status.Error == "" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+}
+
+func TestProcessTaskRefusesWritingTasksInReadOnlyMode(t *testing.T) {
+	repeater := &taskRepeater{get: func() (string, error) {
+		return `{"task_id":7,"task_type_id":4}`, nil
+	}}
+	ProcessTask(context.Background(), nil, repeater, nil, *logging.Init("releem-agent-test", false, false, io.Discard), &config.Config{ReleemDir: t.TempDir(), ReadOnlyAgent: true})
+
+	if len(repeater.statuses) != 1 {
+		t.Fatalf("reported %d statuses, want the refusal only: %+v", len(repeater.statuses), repeater.statuses)
+	}
+	status := repeater.statuses[0]
+	if status.ID != 7 || status.Status != 4 || status.ExitCode != exitReadOnly || status.Error == "" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+}
+
+func TestRestartNeeded(t *testing.T) {
+	server := variables.Server{Flavor: variables.MySQL, Version: variables.Version{8, 0, 36}}
+	for _, test := range []struct {
+		name, value string
+		restart     bool
+	}{
+		{"innodb_buffer_pool_instances", "8", true},
+		{"innodb_page_size", "65536", true},
+		{"max_connections", "0", false},
+		{"query_cache_size", "0", false},
+	} {
+		_, err := variables.Set(server, test.name, test.value, false)
+		if err == nil || restartNeeded(err) != test.restart {
+			t.Errorf("restartNeeded(%v) for %s = %v, want %v", err, test.name, !test.restart, test.restart)
+		}
+	}
+	if !restartNeeded(errors.New("Error 1238 (HY000): Variable 'innodb_log_file_size' is a read only variable")) {
+		t.Error("a variable read only on the server should need a restart")
+	}
+}
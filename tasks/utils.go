@@ -2,8 +2,14 @@ package tasks
 
 import (
 	"bytes"
+	"context"
+	"io"
 	"os/exec"
+	"strconv"
+	"time"
 
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/history"
 	logging "github.com/google/logger"
 )
 
@@ -11,19 +17,43 @@ import (
 // 	return execTaskCommand(shellCommand(runtime.GOOS, cmd_path, environment), logger)
 // }
 
-func execTaskCommand(command taskCommand, logger logging.Logger) (int, int, string) {
+// taskIDKey is the context key of the id of the running task.
+type taskIDKey struct{}
+
+// withTaskID returns a context of the task id, passed to the task commands.
+func withTaskID(ctx context.Context, id int) context.Context {
+	return context.WithValue(ctx, taskIDKey{}, id)
+}
+
+// execTaskCommand runs a task command, killing its process group when ctx is
+// done. Its output is also written to progress. The task id of ctx and the
+// credentials and instance of configuration are set in its environment.
+func execTaskCommand(ctx context.Context, command taskCommand, progress *taskProgress, logger logging.Logger, configuration *config.Config) (int, int, string) {
 	var stdout, stderr bytes.Buffer
 	var task_exit_code, task_status int
 	var task_output string
 
-	cmd := exec.Command(command.name, command.args...)
+	cmd := exec.CommandContext(ctx, command.name, command.args...)
+	setProcessGroup(cmd)
+	// Children escaping the process group may keep the output pipes open.
+	cmd.WaitDelay = 5 * time.Second
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	for _, env := range command.env {
-		cmd.Env = append(cmd.Environ(), env)
+	if progress != nil {
+		cmd.Stdout = io.MultiWriter(&stdout, progress)
+		cmd.Stderr = io.MultiWriter(&stderr, progress)
+	}
+	cmd.Env = append(append(cmd.Environ(), command.env...), TaskLockEnv+"=1")
+	cmd.Env = append(cmd.Env, credentialsEnv(configuration)...)
+	cmd.Env = append(cmd.Env, instanceEnv(configuration)...)
+	if id, ok := ctx.Value(taskIDKey{}).(int); ok {
+		cmd.Env = append(cmd.Env, history.TaskIDEnv+"="+strconv.Itoa(id))
 	}
 	err := cmd.Run()
 	if err != nil {
+		if ctx.Err() != nil {
+			task_output = task_output + "Task interrupted: " + context.Cause(ctx).Error() + "\n"
+		}
 		task_output = task_output + err.Error()
 		logger.Error(err)
 		if exiterr, ok := err.(*exec.ExitError); ok {
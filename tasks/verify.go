@@ -0,0 +1,212 @@
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/history"
+	"github.com/Releem/mysqlconfigurer/variables"
+	"github.com/shirou/gopsutil/v4/mem"
+)
+
+// defaultHealthThresholds are the limits of the health signals compared
+// before and after an apply, overridden by apply_verify_thresholds:
+// threads_running and digest_latency are ratios of the averages after and
+// before, aborted_connects and digest_errors increases of the counts over the
+// window, swap_used_mb an increase of the swap used.
+var defaultHealthThresholds = map[string]float64{
+	"threads_running":  2,
+	"aborted_connects": 10,
+	"digest_errors":    10,
+	"digest_latency":   2,
+	"swap_used_mb":     256,
+}
+
+// minThreadsRunning is the average of running threads below which the
+// threads_running ratio is not checked, a few threads being no load.
+const minThreadsRunning = 4
+
+// healthSampleInterval is the time between two readings of the health signals.
+var healthSampleInterval = 5 * time.Second
+
+// healthSample is a reading of the health signals of the database server.
+type healthSample struct {
+	threadsRunning  float64
+	abortedConnects float64
+	// The digest counters are not read when performance_schema is disabled.
+	digests    bool
+	statements float64
+	errors     float64
+	timerWait  float64
+	swapUsed   uint64
+}
+
+// healthWindow summarizes the health signals over a window.
+type healthWindow struct {
+	threadsRunning  float64 // average
+	abortedConnects float64 // increase
+	digests         bool
+	digestErrors    float64 // increase
+	digestLatency   float64 // average statement latency, in milliseconds
+	swapUsedMB      float64 // maximum
+}
+
+// readHealth reads the health signals: the status counters and the statement
+// digests of the server and the swap used by the host.
+func readHealth(ctx context.Context, db *sql.DB) (healthSample, error) {
+	var sample healthSample
+	rows, err := db.QueryContext(ctx, "SHOW GLOBAL STATUS WHERE Variable_name IN ('Threads_running', 'Aborted_connects')")
+	if err != nil {
+		return sample, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return sample, err
+		}
+		number, _ := strconv.ParseFloat(value, 64)
+		switch strings.ToLower(name) {
+		case "threads_running":
+			sample.threadsRunning = number
+		case "aborted_connects":
+			sample.abortedConnects = number
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return sample, err
+	}
+	err = db.QueryRowContext(ctx, "SELECT IFNULL(SUM(COUNT_STAR), 0), IFNULL(SUM(SUM_ERRORS), 0), IFNULL(SUM(SUM_TIMER_WAIT), 0) FROM performance_schema.events_statements_summary_by_digest").
+		Scan(&sample.statements, &sample.errors, &sample.timerWait)
+	sample.digests = err == nil
+	if swap, err := mem.SwapMemoryWithContext(ctx); err == nil {
+		sample.swapUsed = swap.Used
+	}
+	return sample, nil
+}
+
+// observeHealth reads the health signals every healthSampleInterval for
+// period and summarizes them.
+func observeHealth(ctx context.Context, read func(context.Context) (healthSample, error), period time.Duration) (healthWindow, error) {
+	var samples []healthSample
+	deadline := time.Now().Add(period)
+	for {
+		sample, err := read(ctx)
+		if err != nil {
+			return healthWindow{}, err
+		}
+		samples = append(samples, sample)
+		if !time.Now().Before(deadline) {
+			return summarizeHealth(samples), nil
+		}
+		if err := sleepContext(ctx, min(healthSampleInterval, time.Until(deadline))); err != nil {
+			return healthWindow{}, err
+		}
+	}
+}
+
+// summarizeHealth summarizes the samples of a window, from the first to the
+// last one.
+func summarizeHealth(samples []healthSample) healthWindow {
+	var window healthWindow
+	if len(samples) == 0 {
+		return window
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	var swapUsed uint64
+	for _, sample := range samples {
+		window.threadsRunning += sample.threadsRunning / float64(len(samples))
+		swapUsed = max(swapUsed, sample.swapUsed)
+	}
+	window.swapUsedMB = float64(swapUsed) / (1 << 20)
+	window.abortedConnects = max(last.abortedConnects-first.abortedConnects, 0)
+	if window.digests = first.digests && last.digests; window.digests {
+		window.digestErrors = max(last.errors-first.errors, 0)
+		if statements := last.statements - first.statements; statements > 0 {
+			// SUM_TIMER_WAIT is in picoseconds.
+			window.digestLatency = (last.timerWait - first.timerWait) / statements / 1e9
+		}
+	}
+	return window
+}
+
+// healthBreaches compares the health signals after an apply with the signals
+// before it and returns the thresholds breached.
+func healthBreaches(configuration *config.Config, before, after healthWindow) []string {
+	threshold := func(signal string) float64 {
+		if value, ok := configuration.ApplyVerifyThresholds[signal]; ok {
+			return value
+		}
+		return defaultHealthThresholds[signal]
+	}
+	var breaches []string
+	if limit := max(before.threadsRunning, minThreadsRunning) * threshold("threads_running"); after.threadsRunning > limit {
+		breaches = append(breaches, fmt.Sprintf("threads_running: %.1f on average, %.1f before", after.threadsRunning, before.threadsRunning))
+	}
+	if after.abortedConnects-before.abortedConnects > threshold("aborted_connects") {
+		breaches = append(breaches, fmt.Sprintf("aborted_connects: %.0f, %.0f before", after.abortedConnects, before.abortedConnects))
+	}
+	if before.digests && after.digests {
+		if after.digestErrors-before.digestErrors > threshold("digest_errors") {
+			breaches = append(breaches, fmt.Sprintf("digest_errors: %.0f statement errors, %.0f before", after.digestErrors, before.digestErrors))
+		}
+		if before.digestLatency > 0 && after.digestLatency > before.digestLatency*threshold("digest_latency") {
+			breaches = append(breaches, fmt.Sprintf("digest_latency: %.3f ms per statement, %.3f ms before", after.digestLatency, before.digestLatency))
+		}
+	}
+	if after.swapUsedMB-before.swapUsedMB > threshold("swap_used_mb") {
+		breaches = append(breaches, fmt.Sprintf("swap_used_mb: %.0f MB, %.0f MB before", after.swapUsedMB, before.swapUsedMB))
+	}
+	return breaches
+}
+
+// restoreVariables sets the variables back to their previous values, persisted
+// as the apply did when persist is set, and returns the ones that could not
+// be restored.
+func restoreVariables(ctx context.Context, db *sql.DB, server variables.Server, persist bool, previous map[string]string) []string {
+	names := make([]string, 0, len(previous))
+	for name := range previous {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var failed []string
+	for _, name := range names {
+		statement, err := variables.Set(server, name, previous[name], persist)
+		if err != nil {
+			failed = append(failed, err.Error())
+			continue
+		}
+		if _, err := db.ExecContext(ctx, statement.Query); err != nil {
+			failed = append(failed, name+": "+err.Error())
+		}
+	}
+	return failed
+}
+
+// restoreConfigFile restores the configuration file the apply script replaced
+// from the backup it kept in ReleemConfDir, as the rollback script does but
+// without restarting the server. The file is removed when there is no backup.
+func restoreConfigFile(configuration *config.Config) error {
+	name := history.ConfigFileName(configuration)
+	applied := history.AppliedFile(configuration)
+	backup, err := os.ReadFile(filepath.Join(configuration.ReleemConfDir, name+".bkp"))
+	if errors.Is(err, os.ErrNotExist) {
+		if err := os.Remove(applied); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(applied, backup, 0644)
+}
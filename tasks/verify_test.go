@@ -0,0 +1,98 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+)
+
+func TestObserveHealthSummarizesTheWindow(t *testing.T) {
+	defer func(interval time.Duration) { healthSampleInterval = interval }(healthSampleInterval)
+	healthSampleInterval = time.Millisecond
+
+	samples := []healthSample{
+		{threadsRunning: 2, abortedConnects: 10, digests: true, statements: 1000, errors: 5, timerWait: 1e12, swapUsed: 64 << 20},
+		{threadsRunning: 4, abortedConnects: 12, digests: true, statements: 1500, errors: 7, timerWait: 2e12, swapUsed: 128 << 20},
+		{threadsRunning: 6, abortedConnects: 15, digests: true, statements: 2000, errors: 9, timerWait: 3e12, swapUsed: 96 << 20},
+	}
+	read := 0
+	_, err := observeHealth(context.Background(), func(context.Context) (healthSample, error) {
+		sample := samples[min(read, len(samples)-1)]
+		read++
+		return sample, nil
+	}, 0)
+	if err != nil || read != 1 {
+		t.Fatalf("observeHealth read %d samples, %v, want one for an empty window", read, err)
+	}
+
+	window := summarizeHealth(samples)
+	want := healthWindow{threadsRunning: 4, abortedConnects: 5, digests: true, digestErrors: 4, digestLatency: 2, swapUsedMB: 128}
+	if window != want {
+		t.Fatalf("summarizeHealth = %+v, want %+v", window, want)
+	}
+
+	failing := errors.New("connection refused")
+	if _, err := observeHealth(context.Background(), func(context.Context) (healthSample, error) { return healthSample{}, failing }, time.Second); !errors.Is(err, failing) {
+		t.Fatalf("observeHealth = %v, want the read error", err)
+	}
+}
+
+func TestHealthBreaches(t *testing.T) {
+	before := healthWindow{threadsRunning: 3, abortedConnects: 1, digests: true, digestErrors: 2, digestLatency: 0.5, swapUsedMB: 10}
+	if breaches := healthBreaches(&config.Config{}, before, before); len(breaches) != 0 {
+		t.Fatalf("healthBreaches = %v for an unchanged server", breaches)
+	}
+
+	after := healthWindow{threadsRunning: 9, abortedConnects: 40, digests: true, digestErrors: 50, digestLatency: 2, swapUsedMB: 600}
+	breaches := healthBreaches(&config.Config{}, before, after)
+	if len(breaches) != 5 {
+		t.Fatalf("healthBreaches = %v, want every signal breached", breaches)
+	}
+	for i, signal := range []string{"threads_running", "aborted_connects", "digest_errors", "digest_latency", "swap_used_mb"} {
+		if !strings.HasPrefix(breaches[i], signal+":") {
+			t.Errorf("breach %d = %q, want %s", i, breaches[i], signal)
+		}
+	}
+
+	relaxed := &config.Config{ApplyVerifyThresholds: map[string]float64{
+		"threads_running": 10, "aborted_connects": 100, "digest_errors": 100, "digest_latency": 10, "swap_used_mb": 1024,
+	}}
+	if breaches := healthBreaches(relaxed, before, after); len(breaches) != 0 {
+		t.Fatalf("healthBreaches = %v, want the configured thresholds applied", breaches)
+	}
+	after.digests = false
+	if breaches := healthBreaches(&config.Config{ApplyVerifyThresholds: relaxed.ApplyVerifyThresholds}, before, after); len(breaches) != 0 {
+		t.Fatalf("healthBreaches = %v without digests", breaches)
+	}
+}
+
+func TestRestoreConfigFile(t *testing.T) {
+	configuration := &config.Config{ReleemConfDir: t.TempDir(), MysqlConfDir: t.TempDir()}
+	applied := filepath.Join(configuration.MysqlConfDir, "z_aiops_mysql.cnf")
+	if err := os.WriteFile(applied, []byte("[mysqld]\nmax_connections=500\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(configuration.ReleemConfDir, "z_aiops_mysql.cnf.bkp"), []byte("[mysqld]\nmax_connections=151\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := restoreConfigFile(configuration); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(applied); err != nil || string(content) != "[mysqld]\nmax_connections=151\n" {
+		t.Fatalf("configuration file = %q, %v, want the backup", content, err)
+	}
+
+	os.Remove(filepath.Join(configuration.ReleemConfDir, "z_aiops_mysql.cnf.bkp"))
+	if err := restoreConfigFile(configuration); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(applied); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Stat = %v, want the file removed without a backup", err)
+	}
+}
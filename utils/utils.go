@@ -2,16 +2,19 @@
 package utils
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Releem/mysqlconfigurer/config"
 	e "github.com/Releem/mysqlconfigurer/errors"
 	"github.com/Releem/mysqlconfigurer/models"
+	"github.com/Releem/mysqlconfigurer/safety"
 	_ "github.com/go-sql-driver/mysql"
 	logging "github.com/google/logger"
 	_ "github.com/lib/pq"
@@ -29,19 +32,131 @@ func ProcessRepeaters(metrics *models.Metrics, repeaters models.MetricsRepeater,
 	return result
 }
 
+// ProcessRepeatersContext is ProcessRepeaters with a send that is aborted when
+// ctx is done, for repeaters supporting it.
+func ProcessRepeatersContext(ctx context.Context, metrics *models.Metrics, repeaters models.MetricsRepeater,
+	configuration *config.Config, logger logging.Logger, Mode models.ModeType) string {
+	defer HandlePanic(configuration, logger)
+
+	contextRepeater, ok := repeaters.(models.ContextMetricsRepeater)
+	if !ok {
+		return ProcessRepeaters(metrics, repeaters, configuration, logger, Mode)
+	}
+	result, err := contextRepeater.ProcessMetricsContext(ctx, configuration, *metrics, Mode)
+	if err != nil {
+		logger.Error("Repeater failed ", err)
+	}
+	return result
+}
+
 func CollectMetrics(gatherers []models.MetricsGatherer, logger logging.Logger, configuration *config.Config) *models.Metrics {
+	return CollectMetricsContext(context.Background(), gatherers, logger, configuration)
+}
+
+// CollectMetricsContext runs every gatherer under its own deadline. Failures are
+// reported in ReleemAgent.Gatherers and only discard the collection, returning
+// nil, when the gatherer is required.
+func CollectMetricsContext(ctx context.Context, gatherers []models.MetricsGatherer, logger logging.Logger, configuration *config.Config) *models.Metrics {
 	defer HandlePanic(configuration, logger)
 	var metrics models.Metrics
+	var results []models.GathererResult
 	for _, g := range gatherers {
-		err := g.GetMetrics(&metrics)
-		if err != nil {
-			logger.Error("Problem getting metrics from gatherer")
+		result := runGatherer(ctx, g, &metrics, configuration.GathererTimeout*time.Second)
+		results = append(results, result)
+		if result.Error == "" {
+			continue
+		}
+		if result.Required {
+			logger.Error("Problem getting metrics from required gatherer ", result.Name, ": ", result.Error)
 			return nil
 		}
+		logger.Warning("Problem getting metrics from gatherer ", result.Name, ": ", result.Error)
 	}
+	metrics.ReleemAgent.Gatherers = results
 	return &metrics
 }
 
+// runGatherer runs a gatherer on a copy of the metrics, which replaces them
+// once the gatherer returns. A gatherer missing its deadline is abandoned and
+// its output discarded.
+func runGatherer(ctx context.Context, gatherer models.MetricsGatherer, metrics *models.Metrics, timeout time.Duration) models.GathererResult {
+	result := models.GathererResult{Name: models.GathererName(gatherer), Required: models.IsRequiredGatherer(gatherer)}
+	if custom, ok := gatherer.(models.TimeoutMetricsGatherer); ok && custom.Timeout() > 0 {
+		timeout = custom.Timeout()
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	started := time.Now()
+	scratch := cloneMetrics(*metrics)
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- gatherer.GetMetrics(ctx, &scratch)
+	}()
+
+	select {
+	case err := <-done:
+		*metrics = scratch
+		if err == nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+	case <-ctx.Done():
+		result.Error = ctx.Err().Error()
+		result.TimedOut = ctx.Err() == context.DeadlineExceeded
+	}
+	result.DurationMs = float64(time.Since(started)) / float64(time.Millisecond)
+	return result
+}
+
+// cloneMetrics copies the maps gatherers add entries to, so that an abandoned
+// gatherer cannot modify the metrics of the collection.
+func cloneMetrics(metrics models.Metrics) models.Metrics {
+	metrics.System.Info = cloneGroup(metrics.System.Info)
+	metrics.System.Conf = cloneGroup(metrics.System.Conf)
+	metrics.System.Metrics = cloneGroup(metrics.System.Metrics)
+	metrics.DB.Metrics.Status = cloneGroup(metrics.DB.Metrics.Status)
+	metrics.DB.Conf.Variables = cloneGroup(metrics.DB.Conf.Variables)
+	metrics.DB.Info = cloneGroup(metrics.DB.Info)
+	metrics.ReleemAgent.Info = cloneGroup(metrics.ReleemAgent.Info)
+	if metrics.DB.Metrics.Engine != nil {
+		engine := make(map[string]models.MetricGroupValue, len(metrics.DB.Metrics.Engine))
+		for k, v := range metrics.DB.Metrics.Engine {
+			engine[k] = v
+		}
+		metrics.DB.Metrics.Engine = engine
+	}
+	if metrics.DB.DatabaseSchema != nil {
+		schema := make(map[string][]models.MetricGroupValue, len(metrics.DB.DatabaseSchema))
+		for k, v := range metrics.DB.DatabaseSchema {
+			schema[k] = v
+		}
+		metrics.DB.DatabaseSchema = schema
+	}
+	return metrics
+}
+
+func cloneGroup(group models.MetricGroupValue) models.MetricGroupValue {
+	if group == nil {
+		return nil
+	}
+	clone := make(models.MetricGroupValue, len(group))
+	for k, v := range group {
+		clone[k] = v
+	}
+	return clone
+}
+
 func HandlePanic(configuration *config.Config, logger logging.Logger) {
 	if r := recover(); r != nil {
 		err := errors.WithStack(fmt.Errorf("%v", r))
@@ -69,6 +184,12 @@ func IsPath(path string, logger logging.Logger) bool {
 }
 
 func ConnectionDatabase(configuration *config.Config, logger logging.Logger, DBname string) *sql.DB {
+	return ConnectionDatabaseContext(context.Background(), configuration, logger, DBname)
+}
+
+// ConnectionDatabaseContext opens a connection like ConnectionDatabase, its
+// check of the connection being stopped when ctx is done.
+func ConnectionDatabaseContext(ctx context.Context, configuration *config.Config, logger logging.Logger, DBname string) *sql.DB {
 	dbType := configuration.GetDatabaseType()
 
 	switch dbType {
@@ -76,18 +197,18 @@ func ConnectionDatabase(configuration *config.Config, logger logging.Logger, DBn
 		if DBname == "" {
 			DBname = "postgres"
 		}
-		return ConnectionPostgreSQL(configuration, logger, DBname)
+		return ConnectionPostgreSQL(ctx, configuration, logger, DBname)
 	case "mysql":
 		fallthrough
 	default:
 		if DBname == "" {
 			DBname = "mysql"
 		}
-		return ConnectionMySQL(configuration, logger, DBname)
+		return ConnectionMySQL(ctx, configuration, logger, DBname)
 	}
 }
 
-func ConnectionMySQL(configuration *config.Config, logger logging.Logger, DBname string) *sql.DB {
+func ConnectionMySQL(ctx context.Context, configuration *config.Config, logger logging.Logger, DBname string) *sql.DB {
 	var db *sql.DB
 	var err error
 	var TypeConnection string
@@ -108,7 +229,7 @@ func ConnectionMySQL(configuration *config.Config, logger logging.Logger, DBname
 		logger.Error("Connection opening to failed ", err)
 	}
 
-	err = db.Ping()
+	err = db.PingContext(ctx)
 	if err != nil {
 		switch TypeConnection {
 		case "unix":
@@ -127,7 +248,7 @@ func ConnectionMySQL(configuration *config.Config, logger logging.Logger, DBname
 	return db
 }
 
-func ConnectionPostgreSQL(configuration *config.Config, logger logging.Logger, DBname string) *sql.DB {
+func ConnectionPostgreSQL(ctx context.Context, configuration *config.Config, logger logging.Logger, DBname string) *sql.DB {
 	var db *sql.DB
 	var err error
 	var sslmode string
@@ -147,7 +268,7 @@ func ConnectionPostgreSQL(configuration *config.Config, logger logging.Logger, D
 		logger.Error("PostgreSQL connection opening failed ", err)
 	}
 
-	err = db.Ping()
+	err = db.PingContext(ctx)
 	if err != nil {
 		logger.Info("PostgreSQL connection failed to DB ", DBname, " via tcp ", configuration.PgHost, ":", configuration.PgPort)
 	} else {
@@ -156,43 +277,48 @@ func ConnectionPostgreSQL(configuration *config.Config, logger logging.Logger, D
 	return db
 }
 
-func EnableEventsStatementsConsumers(configuration *config.Config, logger logging.Logger, uptime_str string) {
+func EnableEventsStatementsConsumers(instance *models.Instance, configuration *config.Config, logger logging.Logger, uptime_str string) {
 	uptime, err := strconv.Atoi(uptime_str)
 	if err != nil {
 		logger.Error(err)
 	}
 	if configuration.QueryOptimization && uptime < 120 {
-		err := models.DB.QueryRow("SELECT count(name) FROM performance_schema.setup_consumers WHERE enabled = 'YES' AND name LIKE 'events_statements_%' AND name != 'events_statements_cpu'").Scan(&models.CountEnabledConsumers)
+		err := instance.DB.QueryRow("SELECT count(name) FROM performance_schema.setup_consumers WHERE enabled = 'YES' AND name LIKE 'events_statements_%' AND name != 'events_statements_cpu'").Scan(&instance.CountEnabledConsumers)
 		if err != nil {
 			logger.Error(err)
 		}
-		logger.Info("DEBUG: Found enabled performance_schema statements consumers: ", models.CountEnabledConsumers)
-		if models.CountEnabledConsumers < 3 && configuration.InstanceType == "aws/rds" {
-			_, err := models.DB.Query("CALL releem.enable_events_statements_consumers()")
+		logger.Info("DEBUG: Found enabled performance_schema statements consumers: ", instance.CountEnabledConsumers)
+		if instance.CountEnabledConsumers < 3 && configuration.InstanceType == "aws/rds" {
+			query := "CALL releem.enable_events_statements_consumers()"
+			if err := safety.CheckStatement(configuration, query); err != nil {
+				logger.Warning("Events_statements consumers not enabled: ", err)
+				return
+			}
+			_, err := instance.DB.Query(query)
 			if err != nil {
 				logger.Error("Failed to enable events_statements consumers", err)
 			} else {
 				logger.Info("Enable events_statements_consumers")
 			}
-			err = models.DB.QueryRow("SELECT count(name) FROM performance_schema.setup_consumers WHERE enabled = 'YES' AND name LIKE 'events_statements_%' AND name != 'events_statements_cpu'").Scan(&models.CountEnabledConsumers)
+			err = instance.DB.QueryRow("SELECT count(name) FROM performance_schema.setup_consumers WHERE enabled = 'YES' AND name LIKE 'events_statements_%' AND name != 'events_statements_cpu'").Scan(&instance.CountEnabledConsumers)
 			if err != nil {
 				logger.Error(err)
 			}
-			logger.Info("DEBUG: Found enabled performance_schema statements consumers: ", models.CountEnabledConsumers)
+			logger.Info("DEBUG: Found enabled performance_schema statements consumers: ", instance.CountEnabledConsumers)
 		}
 	}
 }
 
-func GetStrategyCollectionSampleQueries(configuration *config.Config, logger logging.Logger, uptime_str string) {
+func GetStrategyCollectionSampleQueries(instance *models.Instance, configuration *config.Config, logger logging.Logger, uptime_str string) {
 	// Only applicable to MySQL
 	if configuration.GetDatabaseType() == "mysql" {
-		EnableEventsStatementsConsumers(configuration, logger, uptime_str)
+		EnableEventsStatementsConsumers(instance, configuration, logger, uptime_str)
 	}
-	if models.CountEnabledConsumers >= 2 {
+	if instance.CountEnabledConsumers >= 2 {
 		configuration.CollectSampleQueriesPeriod = 10 // 10 seconds
-	} else if models.CountEnabledConsumers > 0 {
+	} else if instance.CountEnabledConsumers > 0 {
 		configuration.CollectSampleQueriesPeriod = 1 // 1 second
-	} else if models.CountEnabledConsumers == 0 {
+	} else if instance.CountEnabledConsumers == 0 {
 		configuration.CollectSampleQueriesPeriod = 600 // 10 minutes
 	}
 }
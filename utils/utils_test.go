@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Releem/mysqlconfigurer/config"
+	"github.com/Releem/mysqlconfigurer/models"
+	logging "github.com/google/logger"
+)
+
+type testGatherer struct {
+	required bool
+	err      error
+	delay    time.Duration
+	set      func(metrics *models.Metrics)
+}
+
+func (gatherer testGatherer) GetMetrics(ctx context.Context, metrics *models.Metrics) error {
+	if gatherer.delay > 0 {
+		time.Sleep(gatherer.delay)
+	}
+	if gatherer.set != nil {
+		gatherer.set(metrics)
+	}
+	return gatherer.err
+}
+
+func (gatherer testGatherer) Required() bool {
+	return gatherer.required
+}
+
+func TestCollectMetricsKeepsMetricsWhenOptionalGathererFails(t *testing.T) {
+	logger := *logging.Init("releem-agent-test", false, false, io.Discard)
+	configuration := &config.Config{GathererTimeout: 1}
+	metrics := CollectMetrics([]models.MetricsGatherer{
+		testGatherer{required: true, set: func(metrics *models.Metrics) {
+			metrics.DB.Metrics.Status = models.MetricGroupValue{"Uptime": "10"}
+		}},
+		testGatherer{err: errors.New("no CloudWatch data yet")},
+	}, logger, configuration)
+	if metrics == nil {
+		t.Fatal("an optional gatherer failure must not discard the metrics")
+	}
+	if metrics.DB.Metrics.Status["Uptime"] != "10" {
+		t.Fatalf("status = %v", metrics.DB.Metrics.Status)
+	}
+	results := metrics.ReleemAgent.Gatherers
+	if len(results) != 2 || results[0].Error != "" || results[1].Error != "no CloudWatch data yet" {
+		t.Fatalf("gatherer results = %+v", results)
+	}
+}
+
+func TestCollectMetricsDiscardsMetricsWhenRequiredGathererFails(t *testing.T) {
+	logger := *logging.Init("releem-agent-test", false, false, io.Discard)
+	metrics := CollectMetrics([]models.MetricsGatherer{
+		testGatherer{required: true, err: errors.New("connection refused")},
+	}, logger, &config.Config{GathererTimeout: 1})
+	if metrics != nil {
+		t.Fatal("a required gatherer failure must discard the metrics")
+	}
+}
+
+func TestCollectMetricsAbandonsGathererPastDeadline(t *testing.T) {
+	logger := *logging.Init("releem-agent-test", false, false, io.Discard)
+	started := time.Now()
+	metrics := CollectMetricsContext(context.Background(), []models.MetricsGatherer{
+		testGatherer{delay: 3 * time.Second, set: func(metrics *models.Metrics) {
+			metrics.DB.Info = models.MetricGroupValue{"late": true}
+		}},
+	}, logger, &config.Config{GathererTimeout: 1})
+	if time.Since(started) > 2*time.Second {
+		t.Fatal("the collection waited for a gatherer past its deadline")
+	}
+	if metrics == nil || !metrics.ReleemAgent.Gatherers[0].TimedOut || metrics.DB.Info != nil {
+		t.Fatalf("metrics = %+v", metrics)
+	}
+}
@@ -0,0 +1,115 @@
+package variables
+
+// maxUnsigned is the maximum of the unsigned 64-bit variables.
+const maxUnsigned = 18446744073709551615
+
+var (
+	mysql   = []Flavor{MySQL, Percona}
+	mariadb = []Flavor{MariaDB}
+)
+
+var isolationLevels = []string{"READ-UNCOMMITTED", "READ-COMMITTED", "REPEATABLE-READ", "SERIALIZABLE"}
+
+// catalog describes the variables the recommended configurations set and the
+// ones fixed at startup. A variable whose type, range or dynamism changed
+// between versions has an entry per version range, the first matching entry
+// of a server being used.
+var catalog = []Variable{
+	{Name: "back_log", Type: Integer, Min: 1, Max: 65535},
+	{Name: "bind_address", Type: String},
+	{Name: "binlog_cache_size", Type: Integer, Min: 4096, Max: maxUnsigned, Dynamic: true},
+	{Name: "binlog_format", Type: Enum, Values: []string{"MIXED", "STATEMENT", "ROW"}, Dynamic: true, Scope: Both},
+	{Name: "hostname", Type: String, ReadOnly: true},
+	{Name: "innodb_adaptive_hash_index", Type: Boolean, Dynamic: true},
+	{Name: "innodb_buffer_pool_chunk_size", Type: Integer, Min: 1048576, Max: maxUnsigned},
+	{Name: "innodb_buffer_pool_instances", Type: Integer, Min: 1, Max: 64},
+	// Resized online since MySQL 5.7.5 and MariaDB 10.2.2.
+	{Name: "innodb_buffer_pool_size", Type: Integer, Min: 5242880, Max: maxUnsigned, Dynamic: true, Flavors: mysql, Since: Version{5, 7, 5}},
+	{Name: "innodb_buffer_pool_size", Type: Integer, Min: 5242880, Max: maxUnsigned, Dynamic: true, Flavors: mariadb, Since: Version{10, 2, 2}},
+	{Name: "innodb_buffer_pool_size", Type: Integer, Min: 5242880, Max: maxUnsigned},
+	{Name: "innodb_data_file_path", Type: String},
+	{Name: "innodb_doublewrite", Type: Enum, Values: []string{"OFF", "ON", "DETECT_AND_RECOVER", "DETECT_ONLY"}, Flavors: mysql, Since: Version{8, 0, 30}},
+	{Name: "innodb_doublewrite", Type: Boolean},
+	{Name: "innodb_file_per_table", Type: Boolean, Dynamic: true},
+	{Name: "innodb_flush_log_at_trx_commit", Type: Integer, Min: 0, Max: 2, Dynamic: true},
+	{Name: "innodb_flush_method", Type: Enum, Values: []string{"fsync", "O_DSYNC", "littlesync", "nosync", "O_DIRECT", "O_DIRECT_NO_FSYNC", "unbuffered", "normal", "async_unbuffered"}},
+	{Name: "innodb_io_capacity", Type: Integer, Min: 100, Max: maxUnsigned, Dynamic: true},
+	{Name: "innodb_io_capacity_max", Type: Integer, Min: 100, Max: maxUnsigned, Dynamic: true},
+	{Name: "innodb_lock_wait_timeout", Type: Integer, Min: 1, Max: 1073741824, Dynamic: true, Scope: Both},
+	// Resized online since MySQL 8.0.
+	{Name: "innodb_log_buffer_size", Type: Integer, Min: 1048576, Max: 4294967295, Dynamic: true, Flavors: mysql, Since: Version{8, 0, 0}},
+	{Name: "innodb_log_buffer_size", Type: Integer, Min: 262144, Max: 4294967295},
+	// Resized online since MariaDB 10.9.
+	{Name: "innodb_log_file_size", Type: Integer, Min: 4194304, Max: maxUnsigned, Dynamic: true, Flavors: mariadb, Since: Version{10, 9, 0}},
+	{Name: "innodb_log_file_size", Type: Integer, Min: 4194304, Max: maxUnsigned},
+	// Removed in MariaDB 10.6, a single redo log file being used.
+	{Name: "innodb_log_files_in_group", Type: Integer, Min: 1, Max: 100, Flavors: mariadb, Until: Version{10, 6, 0}},
+	{Name: "innodb_log_files_in_group", Type: Integer, Min: 2, Max: 100, Flavors: mysql},
+	{Name: "innodb_log_group_home_dir", Type: Path},
+	{Name: "innodb_max_dirty_pages_pct", Type: Float, Min: 0, Max: 99.999, Dynamic: true},
+	{Name: "innodb_numa_interleave", Type: Boolean},
+	{Name: "innodb_page_size", Type: Integer, ReadOnly: true},
+	{Name: "innodb_purge_threads", Type: Integer, Min: 1, Max: 32},
+	// Changed online since MariaDB 10.11.
+	{Name: "innodb_read_io_threads", Type: Integer, Min: 1, Max: 64, Dynamic: true, Flavors: mariadb, Since: Version{10, 11, 0}},
+	{Name: "innodb_read_io_threads", Type: Integer, Min: 1, Max: 64},
+	// Replaces innodb_log_file_size and innodb_log_files_in_group since
+	// MySQL 8.0.30.
+	{Name: "innodb_redo_log_capacity", Type: Integer, Min: 8388608, Max: 549755813888, Dynamic: true, Flavors: mysql, Since: Version{8, 0, 30}},
+	{Name: "innodb_sort_buffer_size", Type: Integer, Min: 65536, Max: 67108864},
+	{Name: "innodb_stats_on_metadata", Type: Boolean, Dynamic: true},
+	{Name: "innodb_thread_concurrency", Type: Integer, Min: 0, Max: 1000, Dynamic: true},
+	{Name: "innodb_write_io_threads", Type: Integer, Min: 1, Max: 64, Dynamic: true, Flavors: mariadb, Since: Version{10, 11, 0}},
+	{Name: "innodb_write_io_threads", Type: Integer, Min: 1, Max: 64},
+	{Name: "interactive_timeout", Type: Integer, Min: 1, Max: 31536000, Dynamic: true, Scope: Both},
+	{Name: "join_buffer_size", Type: Integer, Min: 128, Max: maxUnsigned, Dynamic: true, Scope: Both},
+	{Name: "key_buffer_size", Type: Integer, Min: 0, Max: maxUnsigned, Dynamic: true},
+	{Name: "large_pages", Type: Boolean},
+	{Name: "log_bin", Type: String},
+	{Name: "log_output", Type: List, Values: []string{"TABLE", "FILE", "NONE"}, Dynamic: true},
+	{Name: "long_query_time", Type: Float, Min: 0, Max: 31536000, Dynamic: true, Scope: Both},
+	{Name: "lower_case_table_names", Type: Integer, Min: 0, Max: 2, ReadOnly: true},
+	{Name: "max_allowed_packet", Type: Integer, Min: 1024, Max: 1073741824, Dynamic: true, Scope: Both},
+	{Name: "max_connections", Type: Integer, Min: 1, Max: 100000, Dynamic: true},
+	{Name: "max_digest_length", Type: Integer, Min: 0, Max: 1048576},
+	{Name: "max_heap_table_size", Type: Integer, Min: 16384, Max: maxUnsigned, Dynamic: true, Scope: Both},
+	{Name: "open_files_limit", Type: Integer, Min: 0, Max: maxUnsigned},
+	{Name: "performance_schema", Type: Boolean},
+	{Name: "performance_schema_digests_size", Type: Integer, Min: -1, Max: 1048576},
+	{Name: "performance_schema_max_digest_length", Type: Integer, Min: 0, Max: 1048576},
+	{Name: "performance_schema_max_sql_text_length", Type: Integer, Min: 0, Max: 1048576},
+	{Name: "port", Type: Integer, Min: 0, Max: 65535},
+	// The query cache was removed in MySQL 8.0.3.
+	{Name: "query_cache_limit", Type: Integer, Min: 0, Max: maxUnsigned, Dynamic: true, Flavors: mariadb},
+	{Name: "query_cache_limit", Type: Integer, Min: 0, Max: maxUnsigned, Dynamic: true, Flavors: mysql, Until: Version{8, 0, 3}},
+	{Name: "query_cache_size", Type: Integer, Min: 0, Max: maxUnsigned, Dynamic: true, Flavors: mariadb},
+	{Name: "query_cache_size", Type: Integer, Min: 0, Max: maxUnsigned, Dynamic: true, Flavors: mysql, Until: Version{8, 0, 3}},
+	{Name: "query_cache_type", Type: Enum, Values: []string{"OFF", "ON", "DEMAND"}, Dynamic: true, Scope: Both, Flavors: mariadb},
+	{Name: "query_cache_type", Type: Enum, Values: []string{"OFF", "ON", "DEMAND"}, Dynamic: true, Scope: Both, Flavors: mysql, Until: Version{8, 0, 3}},
+	{Name: "read_buffer_size", Type: Integer, Min: 8192, Max: 2147479552, Dynamic: true, Scope: Both},
+	{Name: "read_rnd_buffer_size", Type: Integer, Min: 1, Max: 2147483647, Dynamic: true, Scope: Both},
+	{Name: "skip_name_resolve", Type: Boolean},
+	{Name: "slow_query_log", Type: Boolean, Dynamic: true},
+	{Name: "slow_query_log_file", Type: Path, Dynamic: true},
+	{Name: "socket", Type: Path},
+	{Name: "sort_buffer_size", Type: Integer, Min: 32768, Max: maxUnsigned, Dynamic: true, Scope: Both},
+	{Name: "sql_mode", Type: List, Dynamic: true, Scope: Both},
+	{Name: "sync_binlog", Type: Integer, Min: 0, Max: 4294967295, Dynamic: true},
+	{Name: "table_definition_cache", Type: Integer, Min: 400, Max: 524288, Dynamic: true},
+	{Name: "table_open_cache", Type: Integer, Min: 1, Max: 524288, Dynamic: true},
+	{Name: "table_open_cache_instances", Type: Integer, Min: 1, Max: 64},
+	{Name: "thread_cache_size", Type: Integer, Min: 0, Max: 16384, Dynamic: true},
+	{Name: "thread_handling", Type: Enum, Values: []string{"one-thread-per-connection", "no-threads", "loaded-dynamically", "pool-of-threads"}},
+	{Name: "thread_pool_size", Type: Integer, Min: 1, Max: 100000, Dynamic: true, Flavors: []Flavor{MariaDB, Percona}},
+	{Name: "tmp_table_size", Type: Integer, Min: 1024, Max: maxUnsigned, Dynamic: true, Scope: Both},
+	{Name: "tmpdir", Type: Path},
+	// transaction_isolation replaced tx_isolation in MySQL 5.7.20 and
+	// MariaDB 11.1, tx_isolation being removed in MySQL 8.0.3.
+	{Name: "transaction_isolation", Type: Enum, Values: isolationLevels, Dynamic: true, Scope: Both, Flavors: mysql, Since: Version{5, 7, 20}},
+	{Name: "transaction_isolation", Type: Enum, Values: isolationLevels, Dynamic: true, Scope: Both, Flavors: mariadb, Since: Version{11, 1, 0}},
+	{Name: "tx_isolation", Type: Enum, Values: isolationLevels, Dynamic: true, Scope: Both, Flavors: mariadb},
+	{Name: "tx_isolation", Type: Enum, Values: isolationLevels, Dynamic: true, Scope: Both, Flavors: mysql, Until: Version{8, 0, 3}},
+	{Name: "version", Type: String, ReadOnly: true},
+	{Name: "version_comment", Type: String, ReadOnly: true},
+	{Name: "wait_timeout", Type: Integer, Min: 1, Max: 31536000, Dynamic: true, Scope: Both},
+}
@@ -0,0 +1,410 @@
+// Package variables describes the system variables of MySQL, MariaDB and
+// Percona Server by version, and generates the statements setting them from
+// the description: values are checked against the type and range of the
+// variable and quoted, and static variables are persisted rather than set.
+package variables
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Flavor is the distribution of the server.
+type Flavor int
+
+const (
+	MySQL Flavor = iota
+	MariaDB
+	Percona
+)
+
+// Version is a server version: major, minor and patch.
+type Version [3]int
+
+func (v Version) less(other Version) bool {
+	for i := range v {
+		if v[i] != other[i] {
+			return v[i] < other[i]
+		}
+	}
+	return false
+}
+
+// Server identifies the server the statements are generated for.
+type Server struct {
+	Flavor  Flavor
+	Version Version // zero when unknown, taken as the latest version
+	// NoBackslashEscapes is set when the sql_mode of the server contains
+	// NO_BACKSLASH_ESCAPES, backslashes being literal in strings then.
+	NoBackslashEscapes bool
+}
+
+var versionNumber = regexp.MustCompile(`^(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// ParseServer returns the server described by its version, version_comment
+// and sql_mode variables.
+func ParseServer(version, versionComment, sqlMode string) Server {
+	var server Server
+	switch {
+	case strings.Contains(strings.ToLower(version+" "+versionComment), "mariadb"):
+		server.Flavor = MariaDB
+		// Replication and old clients see MariaDB 10 and later as 5.5.5-10...
+		version = strings.TrimPrefix(version, "5.5.5-")
+	case strings.Contains(strings.ToLower(versionComment), "percona"):
+		server.Flavor = Percona
+	}
+	if match := versionNumber.FindStringSubmatch(version); match != nil {
+		for i := range server.Version {
+			server.Version[i], _ = strconv.Atoi(match[i+1])
+		}
+	}
+	for _, mode := range strings.Split(sqlMode, ",") {
+		if strings.EqualFold(strings.TrimSpace(mode), "NO_BACKSLASH_ESCAPES") {
+			server.NoBackslashEscapes = true
+		}
+	}
+	return server
+}
+
+// SupportsPersist reports whether the server has SET PERSIST and
+// SET PERSIST_ONLY, MySQL and Percona Server 8.0 and later.
+func (s Server) SupportsPersist() bool {
+	return s.Flavor != MariaDB && (s.Version == Version{} || !s.Version.less(Version{8, 0, 0}))
+}
+
+// Type is the type of the value of a variable.
+type Type int
+
+const (
+	Integer Type = iota // a number, with an optional K, M, G or T size suffix
+	Float
+	Boolean
+	Enum // one of Values, or its ordinal in Values
+	List // a comma-separated list of Values, or of any words without Values
+	String
+	Path
+)
+
+// Scope tells whether a variable is global, per session, or both.
+type Scope int
+
+const (
+	Global Scope = iota
+	Both
+	Session
+)
+
+// Variable describes a system variable on the servers of Flavors, from
+// version Since until version Until.
+type Variable struct {
+	Name string
+	Type Type
+	// Min and Max bound the value of numeric variables, Max being ignored
+	// when zero.
+	Min, Max float64
+	// Values lists the values of enumerations and sets in the order of the
+	// server, an enumeration accepting their ordinal too.
+	Values []string
+	// Dynamic variables can be changed with SET GLOBAL, the others only in
+	// the configuration file or with SET PERSIST_ONLY, and a restart.
+	Dynamic bool
+	// ReadOnly variables are fixed when the server is built or its data
+	// directory initialized.
+	ReadOnly bool
+	Scope    Scope
+	Flavors  []Flavor // nil for every flavor
+	Since    Version  // zero for every version
+	Until    Version  // exclusive, zero for every later version
+}
+
+func (v Variable) matches(server Server) bool {
+	if v.Flavors != nil && !contains(v.Flavors, server.Flavor) {
+		return false
+	}
+	if server.Version == (Version{}) {
+		return v.Until == Version{}
+	}
+	return !server.Version.less(v.Since) && (v.Until == Version{} || server.Version.less(v.Until))
+}
+
+func contains(flavors []Flavor, flavor Flavor) bool {
+	for _, f := range flavors {
+		if f == flavor {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// ErrUnknown is returned for the variables missing from the catalog.
+	ErrUnknown = errors.New("not in the catalog")
+	// ErrUnsupported is returned for the variables of the catalog the
+	// version of the server does not have.
+	ErrUnsupported = errors.New("not supported by this server version")
+	// ErrStatic is returned when setting a static variable without
+	// persisting it: it is changed in the configuration file with a restart.
+	ErrStatic = errors.New("not dynamic, changed with a restart")
+	// ErrReadOnly is returned when setting a read-only variable.
+	ErrReadOnly = errors.New("read-only")
+	// ErrInvalidValue is returned for values of the wrong type or out of
+	// the range of the variable.
+	ErrInvalidValue = errors.New("invalid value")
+)
+
+var byName = func() map[string][]Variable {
+	index := make(map[string][]Variable, len(catalog))
+	for _, variable := range catalog {
+		index[variable.Name] = append(index[variable.Name], variable)
+	}
+	return index
+}()
+
+// Lookup returns the description of the variable on the server.
+func Lookup(server Server, name string) (Variable, error) {
+	variables, ok := byName[strings.ToLower(name)]
+	if !ok {
+		return Variable{}, fmt.Errorf("variable %s: %w", name, ErrUnknown)
+	}
+	for _, variable := range variables {
+		if variable.matches(server) {
+			return variable, nil
+		}
+	}
+	return Variable{}, fmt.Errorf("variable %s: %w", name, ErrUnsupported)
+}
+
+// Statement is a statement setting a variable.
+type Statement struct {
+	Query string
+	// Restart is set when the value takes effect after a restart only,
+	// being persisted with SET PERSIST_ONLY.
+	Restart bool
+}
+
+var variableName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// Set returns the statement setting the variable to value on the server:
+// SET GLOBAL, or SET PERSIST and SET PERSIST_ONLY for static variables when
+// persist is set and the server supports them. The variables missing from
+// the catalog are taken as dynamic, their value typed from its format.
+func Set(server Server, name, value string, persist bool) (Statement, error) {
+	if !variableName.MatchString(name) {
+		return Statement{}, fmt.Errorf("invalid variable name %q", name)
+	}
+	name = strings.ToLower(name)
+	variable, err := Lookup(server, name)
+	if errors.Is(err, ErrUnknown) {
+		variable = Variable{Name: name, Type: guessType(value), Min: math.Inf(-1), Dynamic: true, Scope: Both}
+	} else if err != nil {
+		return Statement{}, err
+	}
+	switch {
+	case variable.ReadOnly:
+		return Statement{}, fmt.Errorf("variable %s: %w", name, ErrReadOnly)
+	case variable.Scope == Session:
+		return Statement{}, fmt.Errorf("variable %s is a session variable", name)
+	}
+	literal, err := variable.Literal(server, value)
+	if err != nil {
+		return Statement{}, err
+	}
+	persist = persist && server.SupportsPersist()
+	switch {
+	case persist && variable.Dynamic:
+		return Statement{Query: "SET PERSIST " + name + " = " + literal}, nil
+	case persist:
+		return Statement{Query: "SET PERSIST_ONLY " + name + " = " + literal, Restart: true}, nil
+	case variable.Dynamic:
+		return Statement{Query: "SET GLOBAL " + name + " = " + literal}, nil
+	}
+	return Statement{}, fmt.Errorf("variable %s: %w", name, ErrStatic)
+}
+
+// guessType types the value of a variable missing from the catalog.
+func guessType(value string) Type {
+	value = strings.TrimSpace(value)
+	if _, err := parseInteger(value); err == nil {
+		return Integer
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return Float
+	}
+	if _, err := parseBoolean(value); err == nil {
+		return Boolean
+	}
+	return String
+}
+
+// Literal returns value as an SQL literal of the type of the variable, after
+// checking it against the range or the values of the variable.
+func (v Variable) Literal(server Server, value string) (string, error) {
+	invalid := func(reason string) error {
+		return fmt.Errorf("variable %s: %w %q: %s", v.Name, ErrInvalidValue, value, reason)
+	}
+	trimmed := strings.TrimSpace(value)
+	switch v.Type {
+	case Integer:
+		number, err := parseInteger(trimmed)
+		if err != nil {
+			return "", invalid("not an integer")
+		}
+		if err := v.checkRange(number.value()); err != nil {
+			return "", invalid(err.Error())
+		}
+		return number.String(), nil
+	case Float:
+		number, err := strconv.ParseFloat(trimmed, 64)
+		if err != nil || math.IsInf(number, 0) || math.IsNaN(number) {
+			return "", invalid("not a number")
+		}
+		if err := v.checkRange(number); err != nil {
+			return "", invalid(err.Error())
+		}
+		return strconv.FormatFloat(number, 'f', -1, 64), nil
+	case Boolean:
+		on, err := parseBoolean(trimmed)
+		if err != nil {
+			return "", invalid("not ON or OFF")
+		}
+		if on {
+			return "ON", nil
+		}
+		return "OFF", nil
+	case Enum:
+		for _, allowed := range v.Values {
+			if strings.EqualFold(trimmed, allowed) {
+				return quote(server, allowed), nil
+			}
+		}
+		if n, err := strconv.Atoi(trimmed); err == nil && n >= 0 && n < len(v.Values) {
+			return quote(server, v.Values[n]), nil
+		}
+		return "", invalid("not one of " + strings.Join(v.Values, ", "))
+	case List:
+		var members []string
+		for _, member := range strings.Split(trimmed, ",") {
+			member = strings.TrimSpace(member)
+			if member == "" {
+				continue
+			}
+			canonical, ok := v.member(member)
+			if !ok {
+				return "", invalid(member + " is not one of " + strings.Join(v.Values, ", "))
+			}
+			members = append(members, canonical)
+		}
+		return quote(server, strings.Join(members, ",")), nil
+	case Path:
+		if trimmed == "" {
+			return "", invalid("empty path")
+		}
+		fallthrough
+	default:
+		if strings.ContainsAny(value, "\x00\n\r") {
+			return "", invalid("control characters")
+		}
+		return quote(server, trimmed), nil
+	}
+}
+
+// member returns the canonical spelling of a member of a List variable.
+func (v Variable) member(member string) (string, bool) {
+	if v.Values == nil {
+		return strings.ToUpper(member), variableName.MatchString(member)
+	}
+	for _, allowed := range v.Values {
+		if strings.EqualFold(member, allowed) {
+			return allowed, true
+		}
+	}
+	return "", false
+}
+
+func (v Variable) checkRange(value float64) error {
+	if value < v.Min {
+		return fmt.Errorf("below the minimum %s", strconv.FormatFloat(v.Min, 'f', -1, 64))
+	}
+	if v.Max != 0 && value > v.Max {
+		return fmt.Errorf("above the maximum %s", strconv.FormatFloat(v.Max, 'f', -1, 64))
+	}
+	return nil
+}
+
+// integer is a parsed integer value, exact over the unsigned 64-bit range
+// of the server.
+type integer struct {
+	negative bool
+	absolute uint64
+}
+
+func (i integer) value() float64 {
+	if i.negative {
+		return -float64(i.absolute)
+	}
+	return float64(i.absolute)
+}
+
+func (i integer) String() string {
+	if i.negative {
+		return "-" + strconv.FormatUint(i.absolute, 10)
+	}
+	return strconv.FormatUint(i.absolute, 10)
+}
+
+var sizeSuffixes = map[byte]uint64{'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30, 'T': 1 << 40}
+
+// parseInteger parses an integer with an optional size suffix, as written in
+// option files: SET statements take plain numbers only.
+func parseInteger(value string) (integer, error) {
+	var number integer
+	if strings.HasPrefix(value, "-") {
+		number.negative = true
+		value = value[1:]
+	}
+	multiplier := uint64(1)
+	if n := len(value); n > 1 {
+		if m, ok := sizeSuffixes[value[n-1]&^0x20]; ok {
+			multiplier = m
+			value = value[:n-1]
+		}
+	}
+	if value == "" || strings.HasPrefix(value, "+") {
+		return number, strconv.ErrSyntax
+	}
+	absolute, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return number, err
+	}
+	if absolute > math.MaxUint64/multiplier {
+		return number, strconv.ErrRange
+	}
+	number.absolute = absolute * multiplier
+	if number.negative && number.absolute == 0 {
+		number.negative = false
+	}
+	return number, nil
+}
+
+func parseBoolean(value string) (bool, error) {
+	switch strings.ToUpper(strings.TrimSpace(value)) {
+	case "ON", "TRUE", "1":
+		return true, nil
+	case "OFF", "FALSE", "0":
+		return false, nil
+	}
+	return false, strconv.ErrSyntax
+}
+
+// quote returns value as a string literal, valid whether backslashes escape
+// or not.
+func quote(server Server, value string) string {
+	if !server.NoBackslashEscapes {
+		value = strings.ReplaceAll(value, `\`, `\\`)
+	}
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
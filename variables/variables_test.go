@@ -0,0 +1,98 @@
+package variables
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseServer(t *testing.T) {
+	for _, test := range []struct {
+		version, comment, sqlMode string
+		want                      Server
+	}{
+		{"8.0.36", "MySQL Community Server - GPL", "ONLY_FULL_GROUP_BY,STRICT_TRANS_TABLES", Server{Flavor: MySQL, Version: Version{8, 0, 36}}},
+		{"8.0.35-27", "Percona Server (GPL), Release 27", "", Server{Flavor: Percona, Version: Version{8, 0, 35}}},
+		{"10.11.6-MariaDB-log", "MariaDB Server", "NO_BACKSLASH_ESCAPES", Server{Flavor: MariaDB, Version: Version{10, 11, 6}, NoBackslashEscapes: true}},
+		{"5.5.5-10.6.16-MariaDB", "", "", Server{Flavor: MariaDB, Version: Version{10, 6, 16}}},
+		{"", "", "", Server{}},
+	} {
+		if got := ParseServer(test.version, test.comment, test.sqlMode); got != test.want {
+			t.Errorf("ParseServer(%q, %q) = %+v, want %+v", test.version, test.comment, got, test.want)
+		}
+	}
+}
+
+func TestSetStatements(t *testing.T) {
+	mysql57 := Server{Flavor: MySQL, Version: Version{5, 7, 44}}
+	mysql8 := Server{Flavor: MySQL, Version: Version{8, 0, 36}}
+	mariadb := Server{Flavor: MariaDB, Version: Version{10, 11, 6}}
+	for _, test := range []struct {
+		server       Server
+		name, value  string
+		persist      bool
+		query        string
+		restart      bool
+		invalidValue bool
+		err          error
+	}{
+		{server: mysql57, name: "innodb_buffer_pool_size", value: "2G", query: "SET GLOBAL innodb_buffer_pool_size = 2147483648"},
+		{server: mysql57, name: "max_connections", value: "500", persist: true, query: "SET GLOBAL max_connections = 500"},
+		{server: mysql8, name: "max_connections", value: "500", persist: true, query: "SET PERSIST max_connections = 500"},
+		{server: mysql8, name: "innodb_buffer_pool_instances", value: "8", persist: true, query: "SET PERSIST_ONLY innodb_buffer_pool_instances = 8", restart: true},
+		{server: mysql8, name: "innodb_buffer_pool_instances", value: "8", err: ErrStatic},
+		{server: mariadb, name: "innodb_log_file_size", value: "1073741824", persist: true, query: "SET GLOBAL innodb_log_file_size = 1073741824"},
+		{server: Server{Flavor: MariaDB, Version: Version{10, 6, 16}}, name: "innodb_log_file_size", value: "1073741824", err: ErrStatic},
+		{server: mysql8, name: "query_cache_size", value: "0", err: ErrUnsupported},
+		{server: mariadb, name: "query_cache_type", value: "off", query: "SET GLOBAL query_cache_type = 'OFF'"},
+		{server: mariadb, name: "query_cache_type", value: "0", query: "SET GLOBAL query_cache_type = 'OFF'"},
+		{server: mariadb, name: "query_cache_type", value: " 2", query: "SET GLOBAL query_cache_type = 'DEMAND'"},
+		{server: mysql57, name: "query_cache_type", value: "1", query: "SET GLOBAL query_cache_type = 'ON'"},
+		{server: mysql8, name: "binlog_format", value: "2", query: "SET GLOBAL binlog_format = 'ROW'"},
+		{server: mysql8, name: "transaction_isolation", value: "1", query: "SET GLOBAL transaction_isolation = 'READ-COMMITTED'"},
+		{server: mysql8, name: "innodb_page_size", value: "65536", persist: true, err: ErrReadOnly},
+		{server: mysql8, name: "slow_query_log", value: "1", query: "SET GLOBAL slow_query_log = ON"},
+		{server: mysql8, name: "long_query_time", value: "0.5", query: "SET GLOBAL long_query_time = 0.5"},
+		{server: mysql8, name: "log_output", value: "file, table", query: "SET GLOBAL log_output = 'FILE,TABLE'"},
+		{server: mysql8, name: "slow_query_log_file", value: `C:\data\it's-slow.log`, query: `SET GLOBAL slow_query_log_file = 'C:\\data\\it''s-slow.log'`},
+		{server: Server{NoBackslashEscapes: true}, name: "slow_query_log_file", value: `C:\data\slow.log`, query: `SET GLOBAL slow_query_log_file = 'C:\data\slow.log'`},
+		{server: mysql8, name: "unknown_plugin_size", value: "16M", query: "SET GLOBAL unknown_plugin_size = 16777216"},
+		{server: mysql8, name: "unknown_plugin_mode", value: "fast'; DROP TABLE t; --", query: "SET GLOBAL unknown_plugin_mode = 'fast''; DROP TABLE t; --'"},
+		{server: mysql8, name: "max_connections", value: "0", invalidValue: true},
+		{server: mysql8, name: "max_connections", value: "500; DROP TABLE t", invalidValue: true},
+		{server: mysql8, name: "binlog_format", value: "FAST", invalidValue: true},
+		{server: mariadb, name: "query_cache_type", value: "3", invalidValue: true},
+		{server: mariadb, name: "query_cache_type", value: "-1", invalidValue: true},
+		{server: mysql8, name: "slow_query_log_file", value: "/tmp/slow.log\nSET GLOBAL x=1", invalidValue: true},
+	} {
+		statement, err := Set(test.server, test.name, test.value, test.persist)
+		switch {
+		case test.invalidValue:
+			if !errors.Is(err, ErrInvalidValue) {
+				t.Errorf("Set(%s, %q) = %q, %v, want an invalid value", test.name, test.value, statement.Query, err)
+			}
+		case test.err != nil:
+			if !errors.Is(err, test.err) {
+				t.Errorf("Set(%s, %q) = %q, %v, want %v", test.name, test.value, statement.Query, err, test.err)
+			}
+		case err != nil || statement.Query != test.query || statement.Restart != test.restart:
+			t.Errorf("Set(%s, %q) = %+v, %v, want %q", test.name, test.value, statement, err, test.query)
+		}
+	}
+
+	if _, err := Set(mysql8, "max_connections=1; --", "1", false); err == nil {
+		t.Error("Set accepted an invalid variable name")
+	}
+}
+
+func TestLookupUnknownVersion(t *testing.T) {
+	// An unknown version is taken as the latest one.
+	if variable, err := Lookup(Server{}, "innodb_buffer_pool_size"); err != nil || !variable.Dynamic {
+		t.Fatalf("Lookup = %+v, %v, want the dynamic variable", variable, err)
+	}
+	if _, err := Lookup(Server{}, "query_cache_type"); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Lookup = %v, want the query cache unsupported", err)
+	}
+	if _, err := Lookup(Server{}, "innodb_undo_tablespaces"); !errors.Is(err, ErrUnknown) {
+		t.Fatalf("Lookup = %v, want an unknown variable", err)
+	}
+}
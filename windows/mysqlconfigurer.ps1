@@ -108,9 +108,16 @@ function Read-ReleemConfig {
     if (-not (Test-Path $ConfigFilePath)) {
         return $config
     }
+    # Only the top-level settings are read, not the instance blocks and maps.
+    $depth = 0
     foreach ($line in (Get-Content -Path $ConfigFilePath)) {
         $trimmed = $line.Trim()
         if ($trimmed -eq '' -or $trimmed.StartsWith('#')) { continue }
+        $opens  = ($trimmed.ToCharArray() | Where-Object { $_ -eq '{' }).Count
+        $closes = ($trimmed.ToCharArray() | Where-Object { $_ -eq '}' }).Count
+        $inBlock = $depth -gt 0 -or $opens -gt 0
+        $depth += $opens - $closes
+        if ($inBlock) { continue }
         $idx = $trimmed.IndexOf('=')
         if ($idx -gt 0) {
             $key   = $trimmed.Substring(0, $idx).Trim()
@@ -325,8 +332,10 @@ function Invoke-ApplyConfig {
     }
     $liveCnfPath = Join-Path $mysql_cnf_dir $DbConfigFileName
 
-    Write-Log 'Getting the latest up-to-date configuration.'
-    if (Test-Path $AgentBinaryPath) {
+    if ($env:RELEEM_SKIP_CONFIG_DOWNLOAD) {
+        Write-Log "Applying the configuration version restored to $StagingCnfPath."
+    } elseif (Test-Path $AgentBinaryPath) {
+        Write-Log 'Getting the latest up-to-date configuration.'
         & $AgentBinaryPath -c
         $getConfigExitCode = $LASTEXITCODE
         Write-Log "releem-agent.exe -c exited with code: $getConfigExitCode"
@@ -448,6 +457,28 @@ $mysql_user     = if ($releemConfig.ContainsKey('mysql_user'))     { $releemConf
 $mysql_password = if ($releemConfig.ContainsKey('mysql_password')) { $releemConfig['mysql_password'] } else { '' }
 $mysql_cnf_dir  = if ($releemConfig.ContainsKey('mysql_cnf_dir'))  { $releemConfig['mysql_cnf_dir'] }  else { '' }
 
+# A task of a named instance of the agent passes the settings of its instance,
+# the API key and password of the instance coming with the credentials.
+if ($env:RELEEM_INSTANCE) {
+    $apikey            = ''
+    $mysql_user        = $env:RELEEM_INSTANCE_MYSQL_USER
+    $mysql_password    = ''
+    $mysql_host        = $env:RELEEM_INSTANCE_MYSQL_HOST
+    $mysql_port        = $env:RELEEM_INSTANCE_MYSQL_PORT
+    $mysql_cnf_dir     = $env:RELEEM_INSTANCE_MYSQL_CNF_DIR
+    $ReleemConfDir     = $env:RELEEM_INSTANCE_CONF_DIR.TrimEnd('\') + '\'
+    $StagingCnfPath    = "${ReleemConfDir}${DbConfigFileName}"
+    $BackupCnfPath     = "${ReleemConfDir}${DbConfigFileName}.bkp"
+    $DbVersionFilePath = "${ReleemConfDir}DB_Version.txt"
+    $releemConfig['mysql_restart_service'] = $env:RELEEM_INSTANCE_MYSQL_RESTART_SERVICE
+}
+
+# The agent passes the API key and the credentials it resolved, releem.conf
+# holding no API key or password when they come from a source.
+if ($env:RELEEM_API_KEY)    { $apikey = $env:RELEEM_API_KEY }
+if ($env:RELEEM_MYSQL_USER) { $mysql_user = $env:RELEEM_MYSQL_USER }
+if ($env:MYSQL_PWD)         { $mysql_password = $env:MYSQL_PWD }
+
 # -ApiKey parameter overrides releem.conf value
 if ($ApiKey) {
     $apikey = $ApiKey